@@ -0,0 +1,33 @@
+package admin
+
+type DeadLetterListCmd struct {
+	Limit int `form:"limit"`
+}
+
+type RequeueCmd struct {
+	// TaskIds to requeue, all dead-lettered tasks when empty
+	TaskIds []string `json:"task_ids"`
+}
+
+type SchedulerStateCmd struct {
+	// FailureLimit caps the recent failures, defaults to 20
+	FailureLimit int `form:"failure_limit" binding:"min=0"`
+}
+
+type TaskListCmd struct {
+	State   string `form:"state"`
+	BatchId string `form:"batch_id"`
+	// Limit caps the tasks, defaults to 100
+	Limit int `form:"limit" binding:"min=0"`
+}
+
+type TaskEventsCmd struct {
+	// After is the last seq the caller saw, 0 returns the latest events
+	After int64 `form:"after" binding:"min=0"`
+	// Limit caps the events, defaults to 100
+	Limit int `form:"limit" binding:"min=0"`
+}
+
+type ForceFailCmd struct {
+	Reason string `json:"reason"`
+}
@@ -0,0 +1,136 @@
+package admin
+
+import "time"
+
+type TaskErrorDto struct {
+	WorkerId string    `json:"worker_id"`
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
+}
+
+type DeadLetterDto struct {
+	TaskId    string        `json:"task_id"`
+	Type      string        `json:"type"`
+	SubType   string        `json:"sub_type"`
+	UserId    string        `json:"user_id"`
+	Attempts  int           `json:"attempts"`
+	LastError *TaskErrorDto `json:"last_error"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+type TaskDto struct {
+	TaskId    string    `json:"task_id"`
+	State     string    `json:"state"`
+	Type      string    `json:"type"`
+	SubType   string    `json:"sub_type"`
+	UserId    string    `json:"user_id"`
+	WorkerId  string    `json:"worker_id,omitempty"`
+	BatchId   string    `json:"batch_id,omitempty"`
+	Attempts  int       `json:"attempts"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type TaskDetailDto struct {
+	TaskId       string         `json:"task_id"`
+	State        string         `json:"state"`
+	Type         string         `json:"type"`
+	SubType      string         `json:"sub_type"`
+	UserId       string         `json:"user_id"`
+	WorkerId     string         `json:"worker_id,omitempty"`
+	WorkerTaskId string         `json:"worker_task_id,omitempty"`
+	BatchId      string         `json:"batch_id,omitempty"`
+	CacheKey     string         `json:"cache_key,omitempty"`
+	Attempts     int            `json:"attempts"`
+	Payload      interface{}    `json:"payload"`
+	Errors       []TaskErrorDto `json:"errors"`
+	TraceId      string         `json:"trace_id,omitempty"`
+	CreatedAt    time.Time      `json:"created_at"`
+}
+
+type RequeueResultDto struct {
+	TaskId string `json:"task_id"`
+	Ok     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+}
+
+// SchedulerStateDto is what this replica sees of the scheduler, workers and
+// task counts come from the shared stores, failures only from this replica.
+type SchedulerStateDto struct {
+	LoadBalancer   string             `json:"load_balancer"`
+	Leader         bool               `json:"leader"`
+	QueueDepth     []*QueueDepthDto   `json:"queue_depth"`
+	Tasks          map[string]int     `json:"tasks"`
+	Workers        []*WorkerStateDto  `json:"workers"`
+	RecentFailures []*FailureEventDto `json:"recent_failures"`
+}
+
+type QueueDepthDto struct {
+	Type     string `json:"type"`
+	Priority int    `json:"priority"`
+	Count    int    `json:"count"`
+}
+
+type WorkerStateDto struct {
+	Id             string        `json:"id"`
+	Addr           string        `json:"addr"`
+	Healthy        bool          `json:"healthy"`
+	Draining       bool          `json:"draining"`
+	Error          string        `json:"error,omitempty"`
+	LastHeartbeat  time.Time     `json:"last_heartbeat"`
+	ActiveTasks    int           `json:"active_tasks"`
+	CompletedTasks int           `json:"completed_tasks"`
+	FailedTasks    int           `json:"failed_tasks"`
+	CancelledTasks int           `json:"cancelled_tasks"`
+	Abilities      []interface{} `json:"abilities"`
+}
+
+type FailureEventDto struct {
+	TaskId       string    `json:"task_id"`
+	Type         string    `json:"type"`
+	SubType      string    `json:"sub_type"`
+	WorkerId     string    `json:"worker_id"`
+	Message      string    `json:"message"`
+	DeadLettered bool      `json:"dead_lettered"`
+	Time         time.Time `json:"time"`
+}
+
+// TaskEventsDto holds the events after the requested seq, LastSeq is the seq
+// to pass on the next call.
+type TaskEventsDto struct {
+	Events  []*TaskEventDto `json:"events"`
+	LastSeq int64           `json:"last_seq"`
+}
+
+type TaskEventDto struct {
+	Seq      int64     `json:"seq"`
+	TaskId   string    `json:"task_id"`
+	Type     string    `json:"type"`
+	SubType  string    `json:"sub_type"`
+	WorkerId string    `json:"worker_id,omitempty"`
+	Kind     string    `json:"kind"`
+	State    string    `json:"state,omitempty"`
+	Message  string    `json:"message,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// QueuedUserDto is a user whose tasks wait for room on the workers of this
+// replica.
+type QueuedUserDto struct {
+	Priority   int    `json:"priority"`
+	UserId     string `json:"user_id"`
+	Tier       string `json:"tier,omitempty"`
+	Weight     int    `json:"weight"`
+	Queued     int    `json:"queued"`
+	Dispatched int    `json:"dispatched"`
+}
+
+type DrainWorkerDto struct {
+	WorkerId string `json:"worker_id"`
+	Draining bool   `json:"draining"`
+}
+
+type EvictWorkerDto struct {
+	WorkerId string `json:"worker_id"`
+	// RequeuedTasks are the unfinished tasks of the worker
+	RequeuedTasks []string `json:"requeued_tasks"`
+}
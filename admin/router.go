@@ -0,0 +1,223 @@
+package admin
+
+import (
+	"errors"
+	"go-web/pkg/global"
+	"go-web/pkg/scheduler"
+	"io"
+
+	"github.com/gin-gonic/gin"
+)
+
+func InitRouter(admin *gin.RouterGroup) {
+	ar := newAdminRouter(NewAdminService())
+	admin.GET("/deadletters", ar.listDeadLetters)
+	admin.GET("/deadletters/:id", ar.getDeadLetter)
+	admin.POST("/deadletters/:id/requeue", ar.requeue)
+	admin.POST("/deadletters/requeue", ar.requeueBulk)
+	admin.GET("/scheduler", ar.getSchedulerState)
+	admin.GET("/scheduler/tasks", ar.listTasks)
+	admin.GET("/scheduler/tasks/:id", ar.getTask)
+	admin.GET("/scheduler/events", ar.getTaskEvents)
+	admin.GET("/scheduler/queue", ar.getQueuedUsers)
+	admin.POST("/scheduler/tasks/:id/fail", ar.forceFail)
+	admin.POST("/scheduler/tasks/:id/requeue", ar.forceRequeue)
+	admin.POST("/scheduler/tasks/:id/cancel", ar.cancel)
+	admin.DELETE("/scheduler/workers/:id", ar.evictWorker)
+	admin.POST("/scheduler/workers/:id/drain", ar.drainWorker)
+	admin.DELETE("/scheduler/workers/:id/drain", ar.undrainWorker)
+}
+
+type AdminRouter struct {
+	adminService AdminService
+}
+
+func newAdminRouter(adminService AdminService) *AdminRouter {
+	return &AdminRouter{
+		adminService: adminService,
+	}
+}
+
+func (ar *AdminRouter) listDeadLetters(c *gin.Context) {
+	var cmd DeadLetterListCmd
+	if err := c.ShouldBindQuery(&cmd); err != nil || cmd.Limit < 0 {
+		global.RequestError(c, global.NewEntity("", "limit is invalid", nil))
+		return
+	}
+
+	dtos, err := ar.adminService.ListDeadLetters(&cmd)
+	if err != nil {
+		global.InternalServerError(c, global.NewEntity("list dead letters error", err.Error(), nil))
+		return
+	}
+	global.SuccessWithData(c, dtos)
+}
+
+func (ar *AdminRouter) getDeadLetter(c *gin.Context) {
+	dto, err := ar.adminService.GetDeadLetter(c.Param("id"))
+	if err != nil {
+		taskError(c, err)
+		return
+	}
+	global.SuccessWithData(c, dto)
+}
+
+func (ar *AdminRouter) requeue(c *gin.Context) {
+	if err := ar.adminService.Requeue(c.Param("id")); err != nil {
+		taskError(c, err)
+		return
+	}
+	global.SuccessNoData(c)
+}
+
+func (ar *AdminRouter) requeueBulk(c *gin.Context) {
+	var cmd RequeueCmd
+	// an empty body requeues every dead-lettered task
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&cmd); err != nil && !errors.Is(err, io.EOF) {
+			global.RequestError(c, global.NewEntity("", "requeue parameter error", nil))
+			return
+		}
+	}
+
+	results, err := ar.adminService.RequeueBulk(&cmd)
+	if err != nil {
+		global.InternalServerError(c, global.NewEntity("requeue dead letters error", err.Error(), nil))
+		return
+	}
+	global.SuccessWithData(c, results)
+}
+
+func (ar *AdminRouter) getSchedulerState(c *gin.Context) {
+	var cmd SchedulerStateCmd
+	if err := c.ShouldBindQuery(&cmd); err != nil {
+		global.RequestError(c, global.NewEntity("", "failure_limit is invalid", nil))
+		return
+	}
+
+	dto, err := ar.adminService.GetSchedulerState(&cmd)
+	if err != nil {
+		global.InternalServerError(c, global.NewEntity("get scheduler state error", err.Error(), nil))
+		return
+	}
+	global.SuccessWithData(c, dto)
+}
+
+func (ar *AdminRouter) listTasks(c *gin.Context) {
+	var cmd TaskListCmd
+	if err := c.ShouldBindQuery(&cmd); err != nil {
+		global.RequestError(c, global.NewEntity("", "limit is invalid", nil))
+		return
+	}
+
+	dtos, err := ar.adminService.ListTasks(&cmd)
+	if err != nil {
+		if errors.Is(err, ErrInvalidTaskState) {
+			global.RequestError(c, global.NewEntity("", err.Error(), nil))
+			return
+		}
+		global.InternalServerError(c, global.NewEntity("list tasks error", err.Error(), nil))
+		return
+	}
+	global.SuccessWithData(c, dtos)
+}
+
+func (ar *AdminRouter) getTask(c *gin.Context) {
+	dto, err := ar.adminService.GetTask(c.Param("id"))
+	if err != nil {
+		taskError(c, err)
+		return
+	}
+	global.SuccessWithData(c, dto)
+}
+
+func (ar *AdminRouter) getTaskEvents(c *gin.Context) {
+	var cmd TaskEventsCmd
+	if err := c.ShouldBindQuery(&cmd); err != nil {
+		global.RequestError(c, global.NewEntity("", "after or limit is invalid", nil))
+		return
+	}
+	global.SuccessWithData(c, ar.adminService.GetTaskEvents(&cmd))
+}
+
+func (ar *AdminRouter) getQueuedUsers(c *gin.Context) {
+	global.SuccessWithData(c, ar.adminService.GetQueuedUsers())
+}
+
+func (ar *AdminRouter) forceFail(c *gin.Context) {
+	var cmd ForceFailCmd
+	// the reason is optional
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&cmd); err != nil && !errors.Is(err, io.EOF) {
+			global.RequestError(c, global.NewEntity("", "fail parameter error", nil))
+			return
+		}
+	}
+
+	if err := ar.adminService.ForceFail(c.Param("id"), &cmd); err != nil {
+		taskError(c, err)
+		return
+	}
+	global.SuccessNoData(c)
+}
+
+func (ar *AdminRouter) forceRequeue(c *gin.Context) {
+	if err := ar.adminService.ForceRequeue(c.Param("id")); err != nil {
+		taskError(c, err)
+		return
+	}
+	global.SuccessNoData(c)
+}
+
+func (ar *AdminRouter) cancel(c *gin.Context) {
+	if err := ar.adminService.Cancel(c.Param("id")); err != nil {
+		taskError(c, err)
+		return
+	}
+	global.SuccessNoData(c)
+}
+
+func (ar *AdminRouter) evictWorker(c *gin.Context) {
+	dto, err := ar.adminService.EvictWorker(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, scheduler.ErrWorkerNotFound) {
+			global.NotFoundError(c, global.NewEntity("", err.Error(), nil))
+			return
+		}
+		global.InternalServerError(c, global.NewEntity("evict worker error", err.Error(), nil))
+		return
+	}
+	global.SuccessWithData(c, dto)
+}
+
+func (ar *AdminRouter) drainWorker(c *gin.Context) {
+	ar.setDraining(c, true)
+}
+
+func (ar *AdminRouter) undrainWorker(c *gin.Context) {
+	ar.setDraining(c, false)
+}
+
+func (ar *AdminRouter) setDraining(c *gin.Context, draining bool) {
+	dto, err := ar.adminService.DrainWorker(c.Param("id"), draining)
+	if err != nil {
+		if errors.Is(err, scheduler.ErrWorkerNotFound) {
+			global.NotFoundError(c, global.NewEntity("", err.Error(), nil))
+			return
+		}
+		global.InternalServerError(c, global.NewEntity("drain worker error", err.Error(), nil))
+		return
+	}
+	global.SuccessWithData(c, dto)
+}
+
+func taskError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, scheduler.ErrTaskNotFound), errors.Is(err, scheduler.ErrTaskNotDeadLettered):
+		global.NotFoundError(c, global.NewEntity("", err.Error(), nil))
+	case errors.Is(err, scheduler.ErrInvalidStateTransition):
+		global.ConflictError(c, global.NewEntity("", err.Error(), nil))
+	default:
+		global.InternalServerError(c, global.NewEntity("", err.Error(), nil))
+	}
+}
@@ -0,0 +1,101 @@
+package admin_test
+
+import (
+	"encoding/json"
+	"go-web/admin"
+	"go-web/pkg/config"
+	"go-web/pkg/middleware"
+	"go-web/pkg/scheduler/schedulertest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupRouter() *gin.Engine {
+	config.ApplicationConfig.Admin.Token = "secret"
+	schedulertest.Shared()
+	r := gin.Default()
+	group := r.Group("/admin")
+	group.Use(middleware.MustAdmin())
+	admin.InitRouter(group)
+	return r
+}
+
+func serve(r *gin.Engine, method, path, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, nil)
+	if len(token) > 0 {
+		req.Header.Set(middleware.AdminTokenHeader, token)
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminRouter_ShouldRejectRequest_WhenTokenInvalid(t *testing.T) {
+	r := setupRouter()
+	assert.Equal(t, http.StatusUnauthorized, serve(r, "GET", "/admin/deadletters", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(r, "GET", "/admin/deadletters", "wrong").Code)
+}
+
+func TestAdminRouter_ShouldListDeadLetters_WhenTokenValid(t *testing.T) {
+	r := setupRouter()
+	w := serve(r, "GET", "/admin/deadletters?limit=10", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"err_code":"0","msg":"ok","data":[]}`, w.Body.String())
+}
+
+func TestAdminRouter_ShouldReturnNotFound_WhenTaskMissing(t *testing.T) {
+	r := setupRouter()
+	assert.Equal(t, http.StatusNotFound, serve(r, "GET", "/admin/deadletters/missing", "secret").Code)
+	assert.Equal(t, http.StatusNotFound, serve(r, "POST", "/admin/deadletters/missing/requeue", "secret").Code)
+}
+
+func TestAdminRouter_ShouldRequeueAll_WhenBodyEmpty(t *testing.T) {
+	r := setupRouter()
+	w := serve(r, "POST", "/admin/deadletters/requeue", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAdminRouter_ShouldReportSchedulerState_WhenTokenValid(t *testing.T) {
+	r := setupRouter()
+	w := serve(r, "GET", "/admin/scheduler", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var body struct {
+		Data admin.SchedulerStateDto `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "rr", body.Data.LoadBalancer)
+	assert.NotNil(t, body.Data.Workers)
+}
+
+func TestAdminRouter_ShouldReturnNotFound_WhenForceActionTargetMissing(t *testing.T) {
+	r := setupRouter()
+	assert.Equal(t, http.StatusNotFound, serve(r, "POST", "/admin/scheduler/tasks/missing/fail", "secret").Code)
+	assert.Equal(t, http.StatusNotFound, serve(r, "POST", "/admin/scheduler/tasks/missing/requeue", "secret").Code)
+	assert.Equal(t, http.StatusNotFound, serve(r, "DELETE", "/admin/scheduler/workers/missing", "secret").Code)
+}
+
+func TestAdminRouter_ShouldRejectTaskList_WhenStateUnknown(t *testing.T) {
+	r := setupRouter()
+	assert.Equal(t, http.StatusOK, serve(r, "GET", "/admin/scheduler/tasks?state=DEAD_LETTER&limit=10", "secret").Code)
+	assert.Equal(t, http.StatusBadRequest, serve(r, "GET", "/admin/scheduler/tasks?state=LOST", "secret").Code)
+	assert.Equal(t, http.StatusBadRequest, serve(r, "GET", "/admin/scheduler/events?after=-1", "secret").Code)
+}
+
+func TestAdminRouter_ShouldReturnNotFound_WhenDrainTargetMissing(t *testing.T) {
+	r := setupRouter()
+	assert.Equal(t, http.StatusNotFound, serve(r, "GET", "/admin/scheduler/tasks/missing", "secret").Code)
+	assert.Equal(t, http.StatusNotFound, serve(r, "POST", "/admin/scheduler/workers/missing/drain", "secret").Code)
+	assert.Equal(t, http.StatusNotFound, serve(r, "DELETE", "/admin/scheduler/workers/missing/drain", "secret").Code)
+}
+
+func TestAdminRouter_ShouldListQueuedUsers_WhenTokenValid(t *testing.T) {
+	r := setupRouter()
+	w := serve(r, "GET", "/admin/scheduler/queue", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"err_code":"0","msg":"ok","data":[]}`, w.Body.String())
+}
@@ -0,0 +1,333 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"go-web/pkg/config"
+	"go-web/pkg/scheduler"
+	"go-web/pkg/tracing"
+	"sort"
+	"time"
+)
+
+type AdminService interface {
+	ListDeadLetters(cmd *DeadLetterListCmd) ([]*DeadLetterDto, error)
+	GetDeadLetter(taskId string) (*TaskDetailDto, error)
+	Requeue(taskId string) error
+	RequeueBulk(cmd *RequeueCmd) ([]*RequeueResultDto, error)
+	GetSchedulerState(cmd *SchedulerStateCmd) (*SchedulerStateDto, error)
+	ForceFail(taskId string, cmd *ForceFailCmd) error
+	ForceRequeue(taskId string) error
+	Cancel(taskId string) error
+	EvictWorker(workerId string) (*EvictWorkerDto, error)
+	DrainWorker(workerId string, draining bool) (*DrainWorkerDto, error)
+	ListTasks(cmd *TaskListCmd) ([]*TaskDto, error)
+	GetTask(taskId string) (*TaskDetailDto, error)
+	GetTaskEvents(cmd *TaskEventsCmd) *TaskEventsDto
+	GetQueuedUsers() []*QueuedUserDto
+}
+
+var ErrInvalidTaskState = errors.New("task state is invalid")
+
+var taskStates = map[string]bool{
+	scheduler.TaskStateCreated:    true,
+	scheduler.TaskStateRunning:    true,
+	scheduler.TaskStateDone:       true,
+	scheduler.TaskStateFailure:    true,
+	scheduler.TaskStateCancelled:  true,
+	scheduler.TaskStateDeadLetter: true,
+}
+
+type adminServiceImpl struct {
+	scheduler *scheduler.Scheduler
+}
+
+func NewAdminService() AdminService {
+	return &adminServiceImpl{
+		scheduler: scheduler.GetScheduler(config.GetScheduler()),
+	}
+}
+
+func (s *adminServiceImpl) ListDeadLetters(cmd *DeadLetterListCmd) ([]*DeadLetterDto, error) {
+	tasks, err := s.scheduler.ListDeadLetters(cmd.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	dtos := make([]*DeadLetterDto, len(tasks))
+	for i, task := range tasks {
+		dto := &DeadLetterDto{
+			TaskId:    task.GetId(),
+			Type:      string(task.GetType()),
+			SubType:   string(task.GetSubType()),
+			UserId:    task.GetUserId(),
+			Attempts:  task.GetAttempts(),
+			CreatedAt: task.GetCreatedAt(),
+		}
+		if taskErrors := task.GetErrors(); len(taskErrors) > 0 {
+			lastError := toTaskErrorDto(taskErrors[len(taskErrors)-1])
+			dto.LastError = &lastError
+		}
+		dtos[i] = dto
+	}
+	return dtos, nil
+}
+
+func (s *adminServiceImpl) GetDeadLetter(taskId string) (*TaskDetailDto, error) {
+	task, err := s.scheduler.GetTask(taskId)
+	if err != nil {
+		return nil, err
+	}
+	if task.GetState() != scheduler.TaskStateDeadLetter {
+		return nil, scheduler.ErrTaskNotDeadLettered
+	}
+	return toTaskDetailDto(task), nil
+}
+
+func (s *adminServiceImpl) GetTask(taskId string) (*TaskDetailDto, error) {
+	task, err := s.scheduler.GetTask(taskId)
+	if err != nil {
+		return nil, err
+	}
+	return toTaskDetailDto(task), nil
+}
+
+func toTaskDetailDto(task scheduler.Task) *TaskDetailDto {
+	taskErrors := make([]TaskErrorDto, len(task.GetErrors()))
+	for i, taskErr := range task.GetErrors() {
+		taskErrors[i] = toTaskErrorDto(taskErr)
+	}
+	return &TaskDetailDto{
+		TaskId:       task.GetId(),
+		State:        string(task.GetState()),
+		Type:         string(task.GetType()),
+		SubType:      string(task.GetSubType()),
+		UserId:       task.GetUserId(),
+		WorkerId:     string(task.GetWorkerId()),
+		WorkerTaskId: task.GetWorkerTaskId(),
+		BatchId:      task.GetBatchId(),
+		CacheKey:     task.GetCacheKey(),
+		Attempts:     task.GetAttempts(),
+		Payload:      task.GetUserDef(),
+		Errors:       taskErrors,
+		TraceId:      tracing.TraceId(task.GetTraceParent()),
+		CreatedAt:    task.GetCreatedAt(),
+	}
+}
+
+// ListTasks returns the stored tasks, oldest first. Finished tasks outside of
+// batches are deleted once polled, they are not listed.
+func (s *adminServiceImpl) ListTasks(cmd *TaskListCmd) ([]*TaskDto, error) {
+	if len(cmd.State) > 0 && !taskStates[cmd.State] {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidTaskState, cmd.State)
+	}
+	limit := cmd.Limit
+	if limit == 0 {
+		limit = 100
+	}
+	tasks, err := s.scheduler.ListTasks(scheduler.TaskFilter{
+		State:   scheduler.TaskState(cmd.State),
+		BatchId: cmd.BatchId,
+		Limit:   limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dtos := make([]*TaskDto, len(tasks))
+	for i, task := range tasks {
+		dtos[i] = &TaskDto{
+			TaskId:    task.GetId(),
+			State:     string(task.GetState()),
+			Type:      string(task.GetType()),
+			SubType:   string(task.GetSubType()),
+			UserId:    task.GetUserId(),
+			WorkerId:  string(task.GetWorkerId()),
+			BatchId:   task.GetBatchId(),
+			Attempts:  task.GetAttempts(),
+			CreatedAt: task.GetCreatedAt(),
+		}
+	}
+	return dtos, nil
+}
+
+// GetTaskEvents returns the task events this replica saw, oldest first.
+func (s *adminServiceImpl) GetTaskEvents(cmd *TaskEventsCmd) *TaskEventsDto {
+	limit := cmd.Limit
+	if limit == 0 {
+		limit = 100
+	}
+	dto := &TaskEventsDto{Events: []*TaskEventDto{}, LastSeq: cmd.After}
+	for _, event := range s.scheduler.TaskEvents(cmd.After, limit) {
+		dto.Events = append(dto.Events, &TaskEventDto{
+			Seq:      event.Seq,
+			TaskId:   event.TaskId,
+			Type:     string(event.Type),
+			SubType:  string(event.SubType),
+			WorkerId: string(event.WorkerId),
+			Kind:     string(event.Kind),
+			State:    string(event.State),
+			Message:  event.Message,
+			Time:     event.Time,
+		})
+		dto.LastSeq = event.Seq
+	}
+	return dto
+}
+
+// GetQueuedUsers returns the users whose tasks this replica holds, higher
+// priorities first.
+func (s *adminServiceImpl) GetQueuedUsers() []*QueuedUserDto {
+	users := s.scheduler.QueuedUsers()
+	dtos := make([]*QueuedUserDto, len(users))
+	for i, user := range users {
+		dtos[i] = &QueuedUserDto{
+			Priority:   int(user.Priority),
+			UserId:     user.UserId,
+			Tier:       user.Tier,
+			Weight:     user.Weight,
+			Queued:     user.Queued,
+			Dispatched: user.Dispatched,
+		}
+	}
+	return dtos
+}
+
+func (s *adminServiceImpl) Requeue(taskId string) error {
+	return s.scheduler.RequeueTask(taskId)
+}
+
+func (s *adminServiceImpl) RequeueBulk(cmd *RequeueCmd) ([]*RequeueResultDto, error) {
+	taskIds := cmd.TaskIds
+	if len(taskIds) == 0 {
+		tasks, err := s.scheduler.ListDeadLetters(0)
+		if err != nil {
+			return nil, err
+		}
+		for _, task := range tasks {
+			taskIds = append(taskIds, task.GetId())
+		}
+	}
+
+	results := make([]*RequeueResultDto, len(taskIds))
+	for i, taskId := range taskIds {
+		result := &RequeueResultDto{TaskId: taskId, Ok: true}
+		if err := s.scheduler.RequeueTask(taskId); err != nil {
+			result.Ok = false
+			result.Error = err.Error()
+		}
+		results[i] = result
+	}
+	return results, nil
+}
+
+// GetSchedulerState reports queues, task counts, workers and recent failures.
+// Remote workers are asked for their status, a worker that does not answer
+// is reported unhealthy.
+func (s *adminServiceImpl) GetSchedulerState(cmd *SchedulerStateCmd) (*SchedulerStateDto, error) {
+	stats, err := s.scheduler.TaskStats()
+	if err != nil {
+		return nil, err
+	}
+
+	limit := cmd.FailureLimit
+	if limit == 0 {
+		limit = 20
+	}
+	dto := &SchedulerStateDto{
+		LoadBalancer:   s.scheduler.LoadBalancer(),
+		Leader:         s.scheduler.IsLeader(),
+		QueueDepth:     make([]*QueueDepthDto, len(stats.Queued)),
+		Tasks:          make(map[string]int, len(stats.ByState)),
+		Workers:        []*WorkerStateDto{},
+		RecentFailures: []*FailureEventDto{},
+	}
+	for i, depth := range stats.Queued {
+		dto.QueueDepth[i] = &QueueDepthDto{Type: string(depth.Type), Priority: int(depth.Priority), Count: depth.Count}
+	}
+	for state, count := range stats.ByState {
+		dto.Tasks[string(state)] = count
+	}
+	draining, err := s.scheduler.DrainingWorkers()
+	if err != nil {
+		return nil, err
+	}
+	for _, worker := range s.scheduler.GetWorkers() {
+		workerDto := toWorkerStateDto(worker)
+		workerDto.Draining = draining[worker.GetId()]
+		dto.Workers = append(dto.Workers, workerDto)
+	}
+	sort.Slice(dto.Workers, func(i, j int) bool {
+		return dto.Workers[i].Id < dto.Workers[j].Id
+	})
+	for _, event := range s.scheduler.RecentFailures(limit) {
+		dto.RecentFailures = append(dto.RecentFailures, &FailureEventDto{
+			TaskId:       event.TaskId,
+			Type:         string(event.Type),
+			SubType:      string(event.SubType),
+			WorkerId:     string(event.WorkerId),
+			Message:      event.Message,
+			DeadLettered: event.DeadLettered,
+			Time:         event.Time,
+		})
+	}
+	return dto, nil
+}
+
+func toWorkerStateDto(worker scheduler.Worker) *WorkerStateDto {
+	dto := &WorkerStateDto{
+		Id:            string(worker.GetId()),
+		Addr:          worker.GetAddr(),
+		LastHeartbeat: worker.GetLastHeartbeat(),
+		Abilities:     []interface{}{},
+	}
+	if err := worker.CheckStatus(); err != nil {
+		dto.Error = err.Error()
+	}
+	status := worker.Status()
+	dto.Healthy = len(dto.Error) == 0 && status.IsHealthy &&
+		time.Since(dto.LastHeartbeat) <= scheduler.WorkerHeartbeatTTL
+	dto.ActiveTasks = status.ActiveTasks
+	dto.CompletedTasks = status.CompletedTasks
+	dto.FailedTasks = status.FailedTasks
+	dto.CancelledTasks = status.CancelledTasks
+	for _, ability := range worker.GetAbilities() {
+		dto.Abilities = append(dto.Abilities, ability)
+	}
+	return dto
+}
+
+func (s *adminServiceImpl) ForceFail(taskId string, cmd *ForceFailCmd) error {
+	return s.scheduler.ForceFailTask(taskId, cmd.Reason)
+}
+
+func (s *adminServiceImpl) ForceRequeue(taskId string) error {
+	return s.scheduler.ForceRequeueTask(taskId)
+}
+
+func (s *adminServiceImpl) Cancel(taskId string) error {
+	return s.scheduler.CancelTask(taskId)
+}
+
+func (s *adminServiceImpl) EvictWorker(workerId string) (*EvictWorkerDto, error) {
+	requeued, err := s.scheduler.EvictWorker(scheduler.WorkerId(workerId))
+	if err != nil {
+		return nil, err
+	}
+	return &EvictWorkerDto{WorkerId: workerId, RequeuedTasks: requeued}, nil
+}
+
+func (s *adminServiceImpl) DrainWorker(workerId string, draining bool) (*DrainWorkerDto, error) {
+	if err := s.scheduler.DrainWorker(scheduler.WorkerId(workerId), draining); err != nil {
+		return nil, err
+	}
+	return &DrainWorkerDto{WorkerId: workerId, Draining: draining}, nil
+}
+
+func toTaskErrorDto(taskErr scheduler.TaskError) TaskErrorDto {
+	return TaskErrorDto{
+		WorkerId: string(taskErr.WorkerId),
+		Message:  taskErr.Message,
+		Time:     taskErr.Time,
+	}
+}
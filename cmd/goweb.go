@@ -3,16 +3,21 @@ package main
 import (
 	"context"
 	"errors"
+	"go-web/admin"
 	"go-web/auth"
 	"go-web/converter"
 	"go-web/file"
 	"go-web/index"
 	"go-web/pdf"
 	"go-web/pkg/config"
+	"go-web/pkg/logger"
+	"go-web/pkg/metrics"
 	"go-web/pkg/middleware"
 	"go-web/pkg/router"
+	"go-web/pkg/scheduler"
+	"go-web/pkg/tracing"
 	"go-web/schedule"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -26,11 +31,9 @@ import (
 )
 
 func main() {
-	log.SetOutput(os.Stdout)
-
 	cfg, err := loadConfig()
 	if err != nil {
-		log.Println("load config error:", err)
+		slog.Error("load config error", "error", err)
 		return
 	}
 
@@ -38,28 +41,58 @@ func main() {
 
 	ctx := context.Background()
 	if err := envconfig.Process(ctx, config.ApplicationConfig); err != nil {
-		log.Println("proceesing env config error:", err)
+		slog.Error("proceesing env config error", "error", err)
 		return
 	}
 
-	log.Println(cfg.Server.Addr)
+	if err := logger.Init(config.GetLogConfig()); err != nil {
+		slog.Error("init logger error", "error", err)
+		return
+	}
+
+	shutdownTracing, err := tracing.Init(config.GetTracingConfig())
+	if err != nil {
+		slog.Error("init tracing error", "error", err)
+		return
+	}
 
 	server := prepareServer()
+	metricsServer := prepareMetricsServer()
 
 	go func() {
 		startServer(server)
 	}()
+	if metricsServer != nil {
+		go func() {
+			startServer(metricsServer)
+		}()
+	}
 
-	shutodwn := make(chan os.Signal, 1)
-	signal.Notify(shutodwn, syscall.SIGINT, syscall.SIGTERM)
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
 
-	sig := <-shutodwn
-	if sig != syscall.SIGKILL {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		shutdownServer(server, ctx)
-	}
+	sig := <-shutdown
+	slog.Info("shutdown start", "signal", sig.String())
+	timeout := time.Duration(config.GetHttpServerConfig().ShutdownTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
+	// the server stops taking requests first, so no task is created while the
+	// scheduler waits for the dispatches in flight
+	failed := !shutdownServer(server, ctx)
+	if metricsServer != nil {
+		shutdownServer(metricsServer, ctx)
+	}
+	if err := scheduler.GetScheduler(config.GetScheduler()).Shutdown(ctx); err != nil {
+		slog.Error("shutdown scheduler error", "error", err)
+		failed = true
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("flush traces error", "error", err)
+	}
+	if failed {
+		os.Exit(1)
+	}
 }
 
 func loadConfig() (*config.Config, error) {
@@ -95,7 +128,7 @@ func prepareServer() *http.Server {
 	// }
 	gin.SetMode(config.GetHttpServerConfig().GinMode)
 
-	log.Println("listen on : ", config.GetHttpServerConfig().Addr)
+	slog.Info("listen on", "addr", config.GetHttpServerConfig().Addr)
 
 	r := router.CreateEngine()
 	server := &http.Server{
@@ -115,6 +148,11 @@ func prepareServer() *http.Server {
 		MaxAge:           12 * time.Hour,
 	}
 
+	// scrapers reach it on its own listener, on the api server only admins do
+	if len(config.GetMetricsConfig().Addr) == 0 {
+		r.GET("/metrics", middleware.MustAdmin(), gin.WrapH(metrics.Handler()))
+	}
+
 	public := r.Group("/")
 	public.Use(cors.New(corsCfg))
 	public.Use(middleware.ConfigContext(), middleware.OptionalToken())
@@ -130,23 +168,49 @@ func prepareServer() *http.Server {
 	file.InitRouterFile(private)
 	converter.InitRouter(private)
 
+	adminGroup := r.Group("/admin")
+	adminGroup.Use(middleware.MustAdmin())
+	admin.InitRouter(adminGroup)
+
 	return server
 }
 
+// prepareMetricsServer returns the listener for /metrics, nil when it is
+// served by the api server.
+func prepareMetricsServer() *http.Server {
+	addr := config.GetMetricsConfig().Addr
+	if len(addr) == 0 {
+		return nil
+	}
+	slog.Info("metrics listen on", "addr", addr)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+}
+
 func startServer(srv *http.Server) {
-	log.Println("start server")
+	slog.Info("start server")
 	err := srv.ListenAndServe()
-	if err != nil {
-		log.Fatal("startup http server error", err)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("startup http server error", "error", err)
+		os.Exit(1)
 	}
-	log.Println("server started")
 }
 
-func shutdownServer(srv *http.Server, ctx context.Context) {
-	log.Println("server shutdown start")
+// shutdownServer waits for the requests in progress until ctx is done, it
+// reports whether all of them finished.
+func shutdownServer(srv *http.Server, ctx context.Context) bool {
+	slog.Info("server shutdown start")
 	err := srv.Shutdown(ctx)
 	if err != nil {
-		log.Fatal("shutdown http server error", err)
+		slog.Error("shutdown http server error", "error", err)
+		return false
 	}
-	log.Println("server shutdown")
+	slog.Info("server shutdown")
+	return true
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-web/pkg/middleware"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// client calls the admin api and unwraps the entity every response is sent in.
+type client struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+type entity struct {
+	ErrCode string          `json:"err_code"`
+	Msg     string          `json:"msg"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// apiError is a response that is not a 2xx.
+type apiError struct {
+	Status int
+	Msg    string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, http.StatusText(e.Status), e.Msg)
+}
+
+// do sends body as json and decodes the data of the response into data, body
+// and data may be nil.
+func (c *client) do(ctx context.Context, method, path string, query url.Values, body, data interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	u := c.server + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(middleware.AdminTokenHeader, c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var e entity
+	decodeErr := json.NewDecoder(resp.Body).Decode(&e)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg := e.Msg
+		if len(e.ErrCode) > 0 && e.ErrCode != "0" {
+			msg = e.ErrCode + ": " + msg
+		}
+		return &apiError{Status: resp.StatusCode, Msg: msg}
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("decode response of %s %s: %w", method, path, decodeErr)
+	}
+	if data == nil || len(e.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(e.Data, data)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go-web/pkg/config"
+	"go-web/pkg/logger"
+	"go-web/pkg/objectstore"
+	"go-web/pkg/scheduler"
+	"go-web/pkg/tracing"
+	"log/slog"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// configReport is the outcome of validating one config file.
+type configReport struct {
+	File     string   `json:"file"`
+	Valid    bool     `json:"valid"`
+	Errors   []string `json:"errors"`
+	Warnings []string `json:"warnings"`
+}
+
+// validateConfigs checks config files offline, the same way go-web reads
+// them, and looks for values go-web would fail on when it starts.
+func validateConfigs(c *ctl, args []string) error {
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("%w: no config file given", errUsage)
+	}
+
+	reports := make([]*configReport, fs.NArg())
+	rows := [][]string{}
+	invalid := 0
+	for i, file := range fs.Args() {
+		report := validateConfig(file)
+		reports[i] = report
+		if !report.Valid {
+			invalid++
+		}
+		for _, msg := range report.Errors {
+			rows = append(rows, []string{file, "error", msg})
+		}
+		for _, msg := range report.Warnings {
+			rows = append(rows, []string{file, "warning", msg})
+		}
+		if report.Valid && len(report.Warnings) == 0 {
+			rows = append(rows, []string{file, "ok", "-"})
+		}
+	}
+	if err := c.out.print(reports, []string{"FILE", "LEVEL", "MESSAGE"}, rows); err != nil {
+		return err
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d config files are invalid", invalid, fs.NArg())
+	}
+	return nil
+}
+
+func validateConfig(file string) *configReport {
+	report := &configReport{File: file, Errors: []string{}, Warnings: []string{}}
+	v := viper.New()
+	v.SetConfigFile(file)
+	v.SetConfigType("yaml")
+	if err := v.ReadInConfig(); err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return report
+	}
+	var cfg config.Config
+	// unknown keys are typos go-web would silently ignore
+	if err := v.UnmarshalExact(&cfg); err != nil {
+		report.Errors = append(report.Errors, strings.ReplaceAll(err.Error(), "\n", " "))
+		return report
+	}
+
+	report.Errors = append(report.Errors, checkConfig(&cfg)...)
+	if len(cfg.Admin.Token) == 0 {
+		report.Warnings = append(report.Warnings, "admin.token is empty, the admin api is disabled")
+	}
+	if cfg.Scheduler.ResultCache.Enabled && len(cfg.ObjectStore.Type) == 0 {
+		report.Warnings = append(report.Warnings, "scheduler.resultCache is enabled without objectStore.type, input files cannot be hashed")
+	}
+	report.Valid = len(report.Errors) == 0
+	return report
+}
+
+// checkConfig returns the values go-web cannot start with.
+func checkConfig(cfg *config.Config) []string {
+	var errs []string
+	check := func(key string, ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, key+": "+fmt.Sprintf(format, args...))
+		}
+	}
+	oneOf := func(value string, values ...string) bool {
+		for _, v := range values {
+			if value == v {
+				return true
+			}
+		}
+		return false
+	}
+
+	check("server.shutdownTimeout", cfg.Server.ShutdownTimeout >= 0, "must not be negative")
+
+	s := &cfg.Scheduler
+	if len(s.WorkerConfig.LoadBalancer) > 0 {
+		_, err := scheduler.NewLB(s.WorkerConfig.LoadBalancer)
+		check("scheduler.workerConfig.loadBalancer", err == nil, "unknown load balancer %q", s.WorkerConfig.LoadBalancer)
+	}
+	check("scheduler.workerConfig.workerStore",
+		oneOf(s.WorkerConfig.WorkerStore, "", scheduler.WorkerStoreTypeMemory, scheduler.WorkerStoreTypeRedis),
+		"unknown worker store %q", s.WorkerConfig.WorkerStore)
+	check("scheduler.taskConfig.storeType",
+		oneOf(s.TaskConfig.StoreType, "", scheduler.TaskStoreTypeMemory, scheduler.TaskStoreTypeRedis, scheduler.TaskStoreTypeSql),
+		"unknown task store %q", s.TaskConfig.StoreType)
+	if s.TaskConfig.StoreType == scheduler.TaskStoreTypeSql {
+		check("scheduler.taskConfig.sql.dialect",
+			oneOf(s.TaskConfig.Sql.Dialect, scheduler.SqlDialectSqlite, scheduler.SqlDialectPostgres, scheduler.SqlDialectMysql),
+			"unknown dialect %q", s.TaskConfig.Sql.Dialect)
+		check("scheduler.taskConfig.sql.dsn", len(s.TaskConfig.Sql.DSN) > 0, "is empty")
+	}
+	check("scheduler.dispatch.mode",
+		oneOf(s.Dispatch.Mode, "", scheduler.DispatchModeDirect, scheduler.DispatchModeStream),
+		"unknown dispatch mode %q", s.Dispatch.Mode)
+	check("scheduler.retry.maxAttempts", s.Retry.MaxAttempts >= 0, "must not be negative")
+	check("scheduler.resultCache.store",
+		oneOf(s.ResultCache.Store, "", scheduler.ResultCacheStoreMemory, scheduler.ResultCacheStoreRedis),
+		"unknown result cache store %q", s.ResultCache.Store)
+	check("scheduler.resultCache.ttl", s.ResultCache.TTL >= 0, "must not be negative")
+
+	usesRedis := s.WorkerConfig.WorkerStore != scheduler.WorkerStoreTypeMemory ||
+		s.TaskConfig.StoreType == scheduler.TaskStoreTypeRedis ||
+		s.Dispatch.Mode == scheduler.DispatchModeStream ||
+		s.LeaderElection.Enabled ||
+		(s.ResultCache.Enabled && s.ResultCache.Store == scheduler.ResultCacheStoreRedis)
+	if usesRedis {
+		check("scheduler.redis.clusterMode",
+			oneOf(s.Redis.ClusterMode, scheduler.RedisClusterModeStandalone, scheduler.RedisClusterModeCluster, scheduler.RedisClusterModeSentinel),
+			"unsupported cluster mode %q", s.Redis.ClusterMode)
+		minAddrs := 1
+		if s.Redis.ClusterMode == scheduler.RedisClusterModeSentinel {
+			// the master name comes first
+			minAddrs = 2
+		}
+		check("scheduler.redis.addrs", len(s.Redis.Addrs) >= minAddrs, "needs at least %d address(es)", minAddrs)
+	}
+	err := scheduler.CheckFairnessCfg(scheduler.FairnessCfg{
+		Tiers:       s.Fairness.Tiers,
+		Users:       s.Fairness.Users,
+		DefaultTier: s.Fairness.DefaultTier,
+	})
+	if err != nil {
+		errs = append(errs, "scheduler.fairness: "+err.Error())
+	}
+	if err := scheduler.CheckTaskTypes(s.TaskTypes); err != nil {
+		errs = append(errs, "scheduler.taskTypes: "+err.Error())
+	}
+	check("scheduler.admission", s.Admission.MaxPending >= 0 && s.Admission.MaxActive >= 0 && s.Admission.RetryAfter >= 0,
+		"limits must not be negative")
+	for taskType, limit := range s.Admission.TaskTypes {
+		check("scheduler.admission.taskTypes."+taskType, limit.MaxPending >= 0 && limit.MaxActive >= 0, "limits must not be negative")
+		check("scheduler.admission.taskTypes."+taskType, isTaskType(taskType, s.TaskTypes), "unknown task type")
+	}
+
+	check("objectStore.type", oneOf(cfg.ObjectStore.Type, "", objectstore.TypeOss, objectstore.TypeMemory),
+		"unknown object store %q", cfg.ObjectStore.Type)
+	if cfg.ObjectStore.Type == objectstore.TypeOss {
+		check("aliyun.oss.endPoint", len(cfg.Aliyun.Oss.EndPoint) > 0, "is empty")
+		check("aliyun.oss.bucket", len(cfg.Aliyun.Oss.Bucket) > 0, "is empty")
+	}
+
+	check("tracing.exporter",
+		oneOf(cfg.Tracing.Exporter, "", tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterFile, tracing.ExporterOtlp),
+		"unknown exporter %q", cfg.Tracing.Exporter)
+	if cfg.Tracing.Exporter == tracing.ExporterOtlp {
+		check("tracing.endpoint", len(cfg.Tracing.Endpoint) > 0, "is empty")
+	}
+	check("tracing.sampleRatio", cfg.Tracing.SampleRatio >= 0 && cfg.Tracing.SampleRatio <= 1, "must be between 0 and 1")
+
+	if len(cfg.Log.Level) > 0 {
+		var level slog.Level
+		check("log.level", level.UnmarshalText([]byte(cfg.Log.Level)) == nil, "unknown level %q", cfg.Log.Level)
+	}
+	check("log.format", oneOf(strings.ToLower(cfg.Log.Format), "", logger.FormatJson, logger.FormatText),
+		"unknown format %q", cfg.Log.Format)
+	return errs
+}
+
+// isTaskType reports whether taskType is built in or declared in cfgs.
+func isTaskType(taskType string, cfgs []config.TaskType) bool {
+	for _, known := range scheduler.TaskTypes() {
+		if string(known) == taskType {
+			return true
+		}
+	}
+	for _, cfg := range cfgs {
+		if cfg.Type == taskType {
+			return true
+		}
+	}
+	return false
+}
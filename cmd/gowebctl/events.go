@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go-web/admin"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// eventPage is how many events a poll asks for, a full page is followed by
+// another poll right away.
+const eventPage = 500
+
+// tailEvents prints the latest task events and with -f keeps polling for new
+// ones until interrupted. Events are kept per replica, behind a load balancer
+// every poll may reach another one.
+func tailEvents(c *ctl, args []string) error {
+	fs := flag.NewFlagSet("events tail", flag.ContinueOnError)
+	n := fs.Int("n", 20, "number of latest events to print first")
+	follow := fs.Bool("f", false, "keep polling for new events")
+	interval := fs.Duration("interval", 2*time.Second, "poll interval with -f")
+	taskId := fs.String("task", "", "only events of this task")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *n <= 0 || *interval <= 0 {
+		return fmt.Errorf("%w: -n and -interval must be positive", errUsage)
+	}
+
+	tw := tabwriter.NewWriter(c.out.w, 12, 0, 2, ' ', 0)
+	if !c.out.json {
+		fmt.Fprintln(tw, "TIME\tSEQ\tKIND\tTASK\tSTATE\tWORKER\tMESSAGE")
+	}
+	var after int64
+	limit := *n
+	for {
+		var page admin.TaskEventsDto
+		query := url.Values{
+			"after": {strconv.FormatInt(after, 10)},
+			"limit": {strconv.Itoa(limit)},
+		}
+		if err := c.client.do(c.ctx, http.MethodGet, "/admin/scheduler/events", query, nil, &page); err != nil {
+			if c.ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		for _, event := range page.Events {
+			if len(*taskId) > 0 && event.TaskId != *taskId {
+				continue
+			}
+			if err := c.printEvent(tw, event); err != nil {
+				return err
+			}
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		after = page.LastSeq
+		if !*follow {
+			return nil
+		}
+		if len(page.Events) == limit && limit == eventPage {
+			continue
+		}
+		limit = eventPage
+
+		select {
+		case <-c.ctx.Done():
+			return nil
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// printEvent writes one event per line, json lines in json mode.
+func (c *ctl) printEvent(tw *tabwriter.Writer, event *admin.TaskEventDto) error {
+	if c.out.json {
+		b, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(c.out.w, string(b))
+		return err
+	}
+	row := []string{
+		formatTime(event.Time),
+		strconv.FormatInt(event.Seq, 10),
+		event.Kind,
+		event.TaskId,
+		orDash(event.State),
+		orDash(event.WorkerId),
+		orDash(event.Message),
+	}
+	_, err := fmt.Fprintln(tw, strings.Join(row, "\t"))
+	return err
+}
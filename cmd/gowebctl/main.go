@@ -0,0 +1,156 @@
+// gowebctl runs the daily operations on a go-web deployment: it lists, drains
+// and evicts workers, inspects, cancels and requeues tasks, tails task events,
+// shows the users waiting in the queue and validates config files.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const usage = `usage: gowebctl [flags] <command> <action> [args]
+
+commands:
+  workers list
+  workers drain|undrain|evict ID...
+  tasks list [-state STATE] [-batch ID] [-limit N]
+  tasks get ID
+  tasks cancel|requeue ID...
+  tasks fail [-reason TEXT] ID...
+  events tail [-n N] [-f] [-interval D]
+  queue list
+  config validate FILE...
+
+flags:
+`
+
+var errUsage = errors.New("invalid usage")
+
+type command struct {
+	run func(c *ctl, args []string) error
+}
+
+var commands = map[string]map[string]command{
+	"workers": {
+		"list":    {run: listWorkers},
+		"drain":   {run: drainWorkers},
+		"undrain": {run: undrainWorkers},
+		"evict":   {run: evictWorkers},
+	},
+	"tasks": {
+		"list":    {run: listTasks},
+		"get":     {run: getTask},
+		"cancel":  {run: cancelTasks},
+		"requeue": {run: requeueTasks},
+		"fail":    {run: failTasks},
+	},
+	"events": {
+		"tail": {run: tailEvents},
+	},
+	"queue": {
+		"list": {run: listQueue},
+	},
+	"config": {
+		"validate": {run: validateConfigs},
+	},
+}
+
+// ctl is what every command needs to talk to go-web and print results.
+type ctl struct {
+	ctx    context.Context
+	client *client
+	out    *printer
+	stderr io.Writer
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("gowebctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	server := fs.String("server", envOr("GOWEB_SERVER", "http://localhost:8080"), "go-web address, or $GOWEB_SERVER")
+	token := fs.String("token", os.Getenv("GOWEB_ADMIN_TOKEN"), "admin token, or $GOWEB_ADMIN_TOKEN")
+	output := fs.String("o", "table", "output format, table or json")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of a request")
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(stderr, "gowebctl: unknown output format %q\n", *output)
+		return 2
+	}
+
+	c := &ctl{
+		ctx: ctx,
+		client: &client{
+			server: strings.TrimRight(*server, "/"),
+			token:  *token,
+			http:   &http.Client{Timeout: *timeout},
+		},
+		out:    &printer{w: stdout, json: *output == "json"},
+		stderr: stderr,
+	}
+	if err := c.run(fs.Args()); err != nil {
+		fmt.Fprintln(stderr, "gowebctl:", err)
+		if errors.Is(err, errUsage) {
+			fs.Usage()
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+func (c *ctl) run(args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	actions, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("%w: unknown command %s", errUsage, args[0])
+	}
+	cmd, ok := actions[args[1]]
+	if !ok {
+		names := make([]string, 0, len(actions))
+		for name := range actions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("%w: %s has no action %s, use one of %s", errUsage, args[0], args[1], strings.Join(names, ", "))
+	}
+	return cmd.run(c, args[2:])
+}
+
+// parseFlags parses the flags of an action, they come before its arguments.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	return nil
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); len(value) > 0 {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"go-web/admin"
+	"go-web/pkg/config"
+	"go-web/pkg/middleware"
+	"go-web/pkg/scheduler"
+	"go-web/pkg/scheduler/schedulertest"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// setup serves the admin api of the shared scheduler with one hanging worker.
+func setup(t *testing.T) (*httptest.Server, *scheduler.Scheduler, *schedulertest.FakeWorker) {
+	config.ApplicationConfig.Admin.Token = "secret"
+	s := schedulertest.Shared()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	group := r.Group("/admin")
+	group.Use(middleware.MustAdmin())
+	admin.InitRouter(group)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	worker := schedulertest.NewFakeWorker(t, "ctl-w1")
+	worker.SetBehavior(schedulertest.Hang)
+	schedulertest.Register(t, s, worker)
+	return server, s, worker
+}
+
+func gowebctl(server *httptest.Server, args ...string) (string, string, int) {
+	var stdout, stderr bytes.Buffer
+	args = append([]string{"-server", server.URL, "-token", "secret"}, args...)
+	code := run(context.Background(), args, &stdout, &stderr)
+	return stdout.String(), stderr.String(), code
+}
+
+func newTask() scheduler.Task {
+	return scheduler.NewTaskBuilder().
+		SetType(scheduler.TaskTypePdf).
+		SetSubType(scheduler.SubTaskTypePdf2Img).
+		SetUserDef(map[string]interface{}{"file_id": "f1"}).
+		Build()
+}
+
+func TestWorkers_ShouldSkipWorker_WhenDrained(t *testing.T) {
+	server, s, worker := setup(t)
+
+	out, _, code := gowebctl(server, "workers", "drain", "ctl-w1")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out, "draining")
+
+	out, _, code = gowebctl(server, "-o", "json", "workers", "list")
+	assert.Equal(t, 0, code)
+	var workers []*admin.WorkerStateDto
+	assert.NoError(t, json.Unmarshal([]byte(out), &workers))
+	if assert.Len(t, workers, 1) {
+		assert.Equal(t, "ctl-w1", workers[0].Id)
+		assert.True(t, workers[0].Draining)
+	}
+	_, err := s.Schedule(newTask())
+	assert.ErrorIs(t, err, scheduler.ErrNoWorkerAvailable)
+
+	_, _, code = gowebctl(server, "workers", "undrain", "ctl-w1")
+	assert.Equal(t, 0, code)
+	task := newTask()
+	_, err = s.Schedule(task)
+	assert.NoError(t, err)
+	assert.NoError(t, s.CancelTask(task.GetId()))
+	schedulertest.AssertCancelled(t, worker, task.GetId())
+
+	out, _, code = gowebctl(server, "workers", "evict", "ctl-w1")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out, "evicted")
+	out, _, _ = gowebctl(server, "workers", "list")
+	assert.NotContains(t, out, "ctl-w1")
+}
+
+func TestTasks_ShouldInspectAndCancelTask_WhenTaskDispatched(t *testing.T) {
+	server, s, _ := setup(t)
+	task := newTask()
+	_, err := s.Schedule(task)
+	assert.NoError(t, err)
+
+	out, _, code := gowebctl(server, "-o", "json", "tasks", "list", "-state", scheduler.TaskStateCreated)
+	assert.Equal(t, 0, code)
+	var tasks []*admin.TaskDto
+	assert.NoError(t, json.Unmarshal([]byte(out), &tasks))
+	ids := []string{}
+	for _, dto := range tasks {
+		ids = append(ids, dto.TaskId)
+	}
+	assert.Contains(t, ids, task.GetId())
+
+	out, _, code = gowebctl(server, "tasks", "get", task.GetId())
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out, "ctl-w1")
+
+	_, _, code = gowebctl(server, "tasks", "cancel", task.GetId())
+	assert.Equal(t, 0, code)
+
+	out, _, code = gowebctl(server, "-o", "json", "events", "tail", "-task", task.GetId())
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out, `"kind":"cancelled"`)
+}
+
+func TestTasks_ShouldExitWithError_WhenTaskMissing(t *testing.T) {
+	server, _, _ := setup(t)
+
+	out, stderr, code := gowebctl(server, "tasks", "requeue", "missing")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, out, "404")
+	assert.Contains(t, stderr, "1 of 1 failed")
+
+	_, _, code = gowebctl(server, "tasks", "shred", "missing")
+	assert.Equal(t, 2, code)
+}
+
+func TestConfigValidate_ShouldReportErrors_WhenConfigInvalid(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "go-web.yaml")
+	err := os.WriteFile(file, []byte("scheduler:\n  workerConfig:\n    workerStore: memory\n  dispatch:\n    mode: fanout\nlog:\n  level: loud\n"), 0o644)
+	assert.NoError(t, err)
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{"-o", "json", "config", "validate", "../../go-web.yaml", file}, &stdout, &stderr)
+	assert.Equal(t, 1, code)
+
+	var reports []*configReport
+	assert.NoError(t, json.Unmarshal(stdout.Bytes(), &reports))
+	if assert.Len(t, reports, 2) {
+		assert.True(t, reports[0].Valid)
+		assert.False(t, reports[1].Valid)
+		assert.Equal(t, []string{
+			`scheduler.dispatch.mode: unknown dispatch mode "fanout"`,
+			`log.level: unknown level "loud"`,
+		}, reports[1].Errors)
+	}
+
+	err = os.WriteFile(file, []byte("scheduler:\n  dispach:\n    mode: direct\n"), 0o644)
+	assert.NoError(t, err)
+	assert.False(t, validateConfig(file).Valid)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"go-web/admin"
+	"net/http"
+	"strconv"
+)
+
+// listQueue prints the users whose tasks wait for room on the workers. Tasks
+// are held per replica, behind a load balancer it shows one of them.
+func listQueue(c *ctl, args []string) error {
+	if err := parseFlags(flag.NewFlagSet("queue list", flag.ContinueOnError), args); err != nil {
+		return err
+	}
+
+	var users []*admin.QueuedUserDto
+	if err := c.client.do(c.ctx, http.MethodGet, "/admin/scheduler/queue", nil, nil, &users); err != nil {
+		return err
+	}
+	rows := make([][]string, len(users))
+	for i, user := range users {
+		rows[i] = []string{
+			strconv.Itoa(user.Priority),
+			user.UserId,
+			orDash(user.Tier),
+			strconv.Itoa(user.Weight),
+			strconv.Itoa(user.Queued),
+			strconv.Itoa(user.Dispatched),
+		}
+	}
+	header := []string{"PRIORITY", "USER", "TIER", "WEIGHT", "QUEUED", "DISPATCHED"}
+	return c.out.print(users, header, rows)
+}
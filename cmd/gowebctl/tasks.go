@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go-web/admin"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+func listTasks(c *ctl, args []string) error {
+	fs := flag.NewFlagSet("tasks list", flag.ContinueOnError)
+	state := fs.String("state", "", "only tasks in this state, e.g. DEAD_LETTER")
+	batchId := fs.String("batch", "", "only tasks of this batch")
+	limit := fs.Int("limit", 100, "max number of tasks")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	query := url.Values{"limit": {strconv.Itoa(*limit)}}
+	if len(*state) > 0 {
+		query.Set("state", *state)
+	}
+	if len(*batchId) > 0 {
+		query.Set("batch_id", *batchId)
+	}
+	var tasks []*admin.TaskDto
+	if err := c.client.do(c.ctx, http.MethodGet, "/admin/scheduler/tasks", query, nil, &tasks); err != nil {
+		return err
+	}
+	rows := make([][]string, len(tasks))
+	for i, task := range tasks {
+		rows[i] = []string{
+			task.TaskId,
+			task.State,
+			task.Type,
+			task.SubType,
+			orDash(task.UserId),
+			orDash(task.WorkerId),
+			strconv.Itoa(task.Attempts),
+			formatTime(task.CreatedAt),
+		}
+	}
+	header := []string{"ID", "STATE", "TYPE", "SUB TYPE", "USER", "WORKER", "ATTEMPTS", "CREATED"}
+	return c.out.print(tasks, header, rows)
+}
+
+func getTask(c *ctl, args []string) error {
+	fs := flag.NewFlagSet("tasks get", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%w: tasks get takes one task id", errUsage)
+	}
+
+	var task admin.TaskDetailDto
+	if err := c.client.do(c.ctx, http.MethodGet, "/admin/scheduler/tasks/"+url.PathEscape(fs.Arg(0)), nil, nil, &task); err != nil {
+		return err
+	}
+	if c.out.json {
+		return c.out.printJson(&task)
+	}
+
+	fields := [][]string{
+		{"ID", task.TaskId},
+		{"STATE", task.State},
+		{"TYPE", task.Type},
+		{"SUB TYPE", task.SubType},
+		{"USER", orDash(task.UserId)},
+		{"WORKER", orDash(task.WorkerId)},
+		{"WORKER TASK", orDash(task.WorkerTaskId)},
+		{"BATCH", orDash(task.BatchId)},
+		{"CACHE KEY", orDash(task.CacheKey)},
+		{"ATTEMPTS", strconv.Itoa(task.Attempts)},
+		{"TRACE", orDash(task.TraceId)},
+		{"CREATED", formatTime(task.CreatedAt)},
+	}
+	if err := c.out.table(nil, fields); err != nil {
+		return err
+	}
+	if len(task.Errors) == 0 {
+		return nil
+	}
+	fmt.Fprintln(c.out.w)
+	rows := make([][]string, len(task.Errors))
+	for i, taskErr := range task.Errors {
+		rows[i] = []string{formatTime(taskErr.Time), orDash(taskErr.WorkerId), taskErr.Message}
+	}
+	return c.out.table([]string{"TIME", "WORKER", "ERROR"}, rows)
+}
+
+func cancelTasks(c *ctl, args []string) error {
+	fs := flag.NewFlagSet("tasks cancel", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	return c.taskAction(fs.Args(), "cancel", nil, "cancelled")
+}
+
+// requeueTasks dispatches tasks again, dead-lettered ones get a fresh set of
+// attempts.
+func requeueTasks(c *ctl, args []string) error {
+	fs := flag.NewFlagSet("tasks requeue", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	return c.taskAction(fs.Args(), "requeue", nil, "requeued")
+}
+
+func failTasks(c *ctl, args []string) error {
+	fs := flag.NewFlagSet("tasks fail", flag.ContinueOnError)
+	reason := fs.String("reason", "", "why the tasks are failed, kept in their errors")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	return c.taskAction(fs.Args(), "fail", &admin.ForceFailCmd{Reason: *reason}, "dead-lettered")
+}
+
+func (c *ctl) taskAction(ids []string, action string, body interface{}, done string) error {
+	return c.each(ids, func(id string) (interface{}, string, error) {
+		path := "/admin/scheduler/tasks/" + url.PathEscape(id) + "/" + action
+		return nil, done, c.client.do(c.ctx, http.MethodPost, path, nil, body, nil)
+	})
+}
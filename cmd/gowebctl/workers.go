@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go-web/admin"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+func listWorkers(c *ctl, args []string) error {
+	if err := parseFlags(flag.NewFlagSet("workers list", flag.ContinueOnError), args); err != nil {
+		return err
+	}
+
+	var state admin.SchedulerStateDto
+	if err := c.client.do(c.ctx, http.MethodGet, "/admin/scheduler", nil, nil, &state); err != nil {
+		return err
+	}
+	rows := make([][]string, len(state.Workers))
+	for i, worker := range state.Workers {
+		rows[i] = []string{
+			worker.Id,
+			worker.Addr,
+			strconv.FormatBool(worker.Healthy),
+			strconv.FormatBool(worker.Draining),
+			strconv.Itoa(worker.ActiveTasks),
+			strconv.Itoa(worker.CompletedTasks),
+			strconv.Itoa(worker.FailedTasks),
+			formatTime(worker.LastHeartbeat),
+			orDash(worker.Error),
+		}
+	}
+	header := []string{"ID", "ADDR", "HEALTHY", "DRAINING", "ACTIVE", "COMPLETED", "FAILED", "LAST HEARTBEAT", "ERROR"}
+	return c.out.print(state.Workers, header, rows)
+}
+
+func drainWorkers(c *ctl, args []string) error {
+	return setDraining(c, "workers drain", args, http.MethodPost, "draining")
+}
+
+func undrainWorkers(c *ctl, args []string) error {
+	return setDraining(c, "workers undrain", args, http.MethodDelete, "taking tasks")
+}
+
+func setDraining(c *ctl, name string, args []string, method string, done string) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	return c.each(fs.Args(), func(id string) (interface{}, string, error) {
+		var dto admin.DrainWorkerDto
+		err := c.client.do(c.ctx, method, "/admin/scheduler/workers/"+url.PathEscape(id)+"/drain", nil, nil, &dto)
+		return &dto, done, err
+	})
+}
+
+func evictWorkers(c *ctl, args []string) error {
+	fs := flag.NewFlagSet("workers evict", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	return c.each(fs.Args(), func(id string) (interface{}, string, error) {
+		var dto admin.EvictWorkerDto
+		err := c.client.do(c.ctx, http.MethodDelete, "/admin/scheduler/workers/"+url.PathEscape(id), nil, nil, &dto)
+		if err != nil {
+			return nil, "", err
+		}
+		message := "evicted"
+		if len(dto.RequeuedTasks) > 0 {
+			message = fmt.Sprintf("evicted, requeued %s", strings.Join(dto.RequeuedTasks, " "))
+		}
+		return &dto, message, nil
+	})
+}
+
+// actionResult is the outcome of an action on one worker or task.
+type actionResult struct {
+	Id    string      `json:"id"`
+	Ok    bool        `json:"ok"`
+	Error string      `json:"error,omitempty"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// each runs action on every id, action returns the response data and what
+// to print on success. Every outcome is printed, each fails when any action
+// failed.
+func (c *ctl) each(ids []string, action func(id string) (interface{}, string, error)) error {
+	if len(ids) == 0 {
+		return fmt.Errorf("%w: no id given", errUsage)
+	}
+
+	results := make([]*actionResult, len(ids))
+	rows := make([][]string, len(ids))
+	failed := 0
+	for i, id := range ids {
+		data, message, err := action(id)
+		results[i] = &actionResult{Id: id, Ok: err == nil}
+		if err != nil {
+			failed++
+			results[i].Error = err.Error()
+			message = "error: " + err.Error()
+		} else {
+			results[i].Data = data
+		}
+		rows[i] = []string{id, message}
+	}
+	if err := c.out.print(results, []string{"ID", "RESULT"}, rows); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d failed", failed, len(ids))
+	}
+	return nil
+}
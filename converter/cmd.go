@@ -5,13 +5,36 @@ type CreationConvertCmd struct {
 	SubType string      `json:"sub_type"`
 	FileId  string      `json:"file_id"`
 	Params  interface{} `json:"params"`
+	// QueueTimeout fails the task if no worker took it within this many
+	// seconds, 0 waits forever
+	QueueTimeout int `json:"queue_timeout" binding:"min=0"`
+	// NoCache converts the file again instead of returning a cached result
+	NoCache bool   `json:"no_cache"`
+	UserId  string `json:"-"`
 }
 
 type ConverterStatusCmd struct {
 	TaskId string `json:"task_id"`
+	UserId string `json:"-"`
 }
 
 type FileConvertUserDefCmd struct {
-	FileIds []string               `json:"file_id"`
+	FileIds []string               `json:"file_id" binding:"required,min=1,max=100,dive,required"`
 	Params  map[string]interface{} `json:"params"`
 }
+
+// CreationBatchConvertCmd converts every file with the same type and params,
+// each file becomes a task of the batch.
+type CreationBatchConvertCmd struct {
+	Type    string `json:"type"`
+	SubType string `json:"sub_type"`
+	FileConvertUserDefCmd
+	QueueTimeout int    `json:"queue_timeout" binding:"min=0"`
+	NoCache      bool   `json:"no_cache"`
+	UserId       string `json:"-"`
+}
+
+type BatchCmd struct {
+	BatchId string
+	UserId  string
+}
@@ -1,12 +1,61 @@
 package converter
 
+import (
+	"go-web/pkg/objectstore"
+	"go-web/pkg/scheduler"
+)
+
+// CreationConvertDto is DONE right away when the result came from the cache.
 type CreationConvertDto struct {
 	TaskId string `json:"task_id"`
+	Status string `json:"status"`
+}
+
+type CreationBatchConvertDto struct {
+	BatchId string          `json:"batch_id"`
+	Tasks   []*BatchTaskDto `json:"tasks"`
+}
+
+type BatchTaskDto struct {
+	FileId string `json:"file_id"`
+	TaskId string `json:"task_id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
 }
 
+// BatchStatusDto counts the tasks of a batch by state, Failed includes
+// dead-lettered tasks. Finished is set once no task is pending or running.
+type BatchStatusDto struct {
+	BatchId   string          `json:"batch_id"`
+	Total     int             `json:"total"`
+	Pending   int             `json:"pending"`
+	Running   int             `json:"running"`
+	Done      int             `json:"done"`
+	Failed    int             `json:"failed"`
+	Cancelled int             `json:"cancelled"`
+	Finished  bool            `json:"finished"`
+	Tasks     []*BatchTaskDto `json:"tasks"`
+}
+
+// ConverterStatusDto has one download per output file once the task is DONE.
 type ConverterStatusDto struct {
-	TaskId  string `json:"task_id"`
-	Status  string `json:"status"`
-	Type    string `json:"type"`
-	SubType string `json:"sub_type"`
+	TaskId    string                  `json:"task_id"`
+	Status    string                  `json:"status"`
+	Type      string                  `json:"type"`
+	SubType   string                  `json:"sub_type"`
+	Downloads []*objectstore.Download `json:"downloads,omitempty"`
+}
+
+type TaskTypeDto struct {
+	Type     string            `json:"type"`
+	Name     string            `json:"name"`
+	SubTypes []*SubTaskTypeDto `json:"sub_types"`
+}
+
+// SubTaskTypeDto describes a conversion, params is empty when the sub type
+// takes any params.
+type SubTaskTypeDto struct {
+	SubType string                 `json:"sub_type"`
+	Name    string                 `json:"name"`
+	Params  []scheduler.ParamField `json:"params"`
 }
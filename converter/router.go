@@ -1,7 +1,11 @@
 package converter
 
 import (
+	"errors"
 	"go-web/pkg/global"
+	"go-web/pkg/middleware"
+	"go-web/pkg/scheduler"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -9,7 +13,11 @@ import (
 func InitRouter(private *gin.RouterGroup) {
 	cr := newConverterRouter()
 	private.POST("/convert", cr.createConvertTask)
+	private.GET("/convert/types", cr.getTaskTypes)
 	private.GET("/convert/:id", cr.getTaskStatus)
+	private.POST("/convert/batch", cr.createBatchConvertTask)
+	private.GET("/convert/batch/:id", cr.getBatchStatus)
+	private.POST("/convert/batch/:id/cancel", cr.cancelBatch)
 }
 
 type ConverterRouter struct {
@@ -29,10 +37,11 @@ func (cr *ConverterRouter) createConvertTask(c *gin.Context) {
 		global.RequestError(c, global.NewEntity("convert task parameter error", err.Error(), nil))
 		return
 	}
+	cmd.UserId = c.MustGet("claims").(*middleware.CustomClaims).UserId
 
-	dto, err := cr.converterService.CreateConvertTask(&cmd)
+	dto, err := cr.converterService.CreateConvertTask(c.Request.Context(), &cmd)
 	if err != nil {
-		global.InternalServerError(c, global.NewEntity("create convert task error", err.Error(), nil))
+		createTaskError(c, "convert task parameter error", "create convert task error", err)
 		return
 	}
 
@@ -48,7 +57,12 @@ func (cr *ConverterRouter) getTaskStatus(c *gin.Context) {
 
 	dto, err := cr.converterService.GetTaskStatus(&ConverterStatusCmd{
 		TaskId: taskId,
+		UserId: c.MustGet("claims").(*middleware.CustomClaims).UserId,
 	})
+	if errors.Is(err, scheduler.ErrTaskNotFound) {
+		global.NotFoundError(c, global.NewEntity("get task status error", err.Error(), nil))
+		return
+	}
 	if err != nil {
 		global.InternalServerError(c, global.NewEntity("get task status error", err.Error(), nil))
 		return
@@ -56,3 +70,81 @@ func (cr *ConverterRouter) getTaskStatus(c *gin.Context) {
 
 	global.SuccessWithData(c, dto)
 }
+
+func (cr *ConverterRouter) getTaskTypes(c *gin.Context) {
+	global.SuccessWithData(c, cr.converterService.GetTaskTypes())
+}
+
+func (cr *ConverterRouter) createBatchConvertTask(c *gin.Context) {
+	var cmd CreationBatchConvertCmd
+	err := c.ShouldBindJSON(&cmd)
+	if err != nil {
+		global.RequestError(c, global.NewEntity("batch convert parameter error", err.Error(), nil))
+		return
+	}
+	cmd.UserId = c.MustGet("claims").(*middleware.CustomClaims).UserId
+
+	dto, err := cr.converterService.CreateBatchConvertTask(c.Request.Context(), &cmd)
+	if err != nil {
+		createTaskError(c, "batch convert parameter error", "create batch convert task error", err)
+		return
+	}
+
+	global.SuccessWithData(c, dto)
+}
+
+func (cr *ConverterRouter) getBatchStatus(c *gin.Context) {
+	dto, err := cr.converterService.GetBatchStatus(newBatchCmd(c))
+	if err != nil {
+		batchError(c, "get batch status error", err)
+		return
+	}
+	global.SuccessWithData(c, dto)
+}
+
+func (cr *ConverterRouter) cancelBatch(c *gin.Context) {
+	dto, err := cr.converterService.CancelBatch(newBatchCmd(c))
+	if err != nil {
+		batchError(c, "cancel batch error", err)
+		return
+	}
+	global.SuccessWithData(c, dto)
+}
+
+// createTaskError answers 400 for invalid tasks, invalid params come with
+// the error of each field. A full queue is answered with 429, busy or no
+// workers with 503, both with Retry-After.
+func createTaskError(c *gin.Context, paramCode, code string, err error) {
+	var paramErr *scheduler.ParamValidationError
+	var overloadErr *scheduler.OverloadError
+	switch {
+	case errors.As(err, &overloadErr):
+		c.Header("Retry-After", strconv.Itoa(overloadErr.RetryAfterSeconds()))
+		if errors.Is(err, scheduler.ErrQueueFull) {
+			global.TooManyRequestsError(c, global.NewEntity(code, err.Error(), nil))
+			return
+		}
+		global.ServiceUnavailableError(c, global.NewEntity(code, err.Error(), nil))
+	case errors.As(err, &paramErr):
+		global.RequestError(c, global.NewEntity(paramCode, err.Error(), paramErr.Errors))
+	case errors.Is(err, scheduler.ErrInvalidTask):
+		global.RequestError(c, global.NewEntity(paramCode, err.Error(), nil))
+	default:
+		global.InternalServerError(c, global.NewEntity(code, err.Error(), nil))
+	}
+}
+
+func newBatchCmd(c *gin.Context) *BatchCmd {
+	return &BatchCmd{
+		BatchId: c.Param("id"),
+		UserId:  c.MustGet("claims").(*middleware.CustomClaims).UserId,
+	}
+}
+
+func batchError(c *gin.Context, code string, err error) {
+	if errors.Is(err, scheduler.ErrBatchNotFound) {
+		global.NotFoundError(c, global.NewEntity(code, err.Error(), nil))
+		return
+	}
+	global.InternalServerError(c, global.NewEntity(code, err.Error(), nil))
+}
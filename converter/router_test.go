@@ -0,0 +1,124 @@
+package converter_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"go-web/converter"
+	"go-web/pkg/middleware"
+	"go-web/pkg/scheduler"
+	"go-web/pkg/scheduler/schedulertest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupRouter() *gin.Engine {
+	schedulertest.Shared()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	group := r.Group("/")
+	group.Use(func(c *gin.Context) {
+		c.Set("claims", &middleware.CustomClaims{UserId: "u1"})
+	})
+	converter.InitRouter(group)
+	return r
+}
+
+func serve(r *gin.Engine, method, path string, body interface{}) *httptest.ResponseRecorder {
+	var b []byte
+	if body != nil {
+		b, _ = json.Marshal(body)
+	}
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestConverterRouter_ShouldServeBatch_WhenFilesGiven(t *testing.T) {
+	setupWorker(t, schedulertest.Hang)
+	r := setupRouter()
+
+	w := serve(r, "POST", "/convert/batch", map[string]interface{}{
+		"type":     "pdf",
+		"sub_type": "pdf2img",
+		"file_id":  []string{"f1", "f2"},
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var created struct {
+		Data converter.CreationBatchConvertDto `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+
+	w = serve(r, "GET", "/convert/batch/"+created.Data.BatchId, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var status struct {
+		Data converter.BatchStatusDto `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, 2, status.Data.Running)
+
+	w = serve(r, "POST", "/convert/batch/"+created.Data.BatchId+"/cancel", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestConverterRouter_ShouldRejectBatch_WhenNoFileGiven(t *testing.T) {
+	r := setupRouter()
+	w := serve(r, "POST", "/convert/batch", map[string]interface{}{
+		"type":     "pdf",
+		"sub_type": "pdf2img",
+		"file_id":  []string{},
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, http.StatusNotFound, serve(r, "GET", "/convert/batch/missing", nil).Code)
+}
+
+func TestConverterRouter_ShouldReturnFieldErrors_WhenParamsInvalid(t *testing.T) {
+	worker := setupWorker(t, schedulertest.Accept)
+	r := setupRouter()
+
+	w := serve(r, "POST", "/convert", map[string]interface{}{
+		"type":     "pdf",
+		"sub_type": "pdfsplitter",
+		"params":   map[string]interface{}{"file_id": "f1", "pages_per_file": 0},
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var body struct {
+		Data []scheduler.ParamError `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, []scheduler.ParamError{{Field: "pages_per_file", Message: "is required"}}, body.Data)
+	assert.Empty(t, worker.Submissions())
+}
+
+func TestConverterRouter_ShouldListTaskTypes_WhenRequested(t *testing.T) {
+	r := setupRouter()
+
+	w := serve(r, "GET", "/convert/types", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Data []converter.TaskTypeDto `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	if assert.NotEmpty(t, body.Data) {
+		assert.Equal(t, "pdf", body.Data[0].Type)
+		assert.Equal(t, "pdf2csv", body.Data[0].SubTypes[0].SubType)
+		assert.Equal(t, "PDF to CSV", body.Data[0].SubTypes[0].Name)
+	}
+}
+
+func TestConverterRouter_ShouldAskToRetry_WhenNoWorker(t *testing.T) {
+	r := setupRouter()
+
+	w := serve(r, "POST", "/convert", map[string]interface{}{
+		"type":     "pdf",
+		"sub_type": "pdf2img",
+		"params":   map[string]interface{}{"file_id": "f1"},
+	})
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Retry-After"))
+}
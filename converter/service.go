@@ -1,59 +1,313 @@
 package converter
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"go-web/pkg/config"
+	"go-web/pkg/logger"
+	"go-web/pkg/objectstore"
 	"go-web/pkg/scheduler"
+	"go-web/pkg/tracing"
+	"log/slog"
+	"strings"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 type ConverterService interface {
-	CreateConvertTask(cmd *CreationConvertCmd) (*CreationConvertDto, error)
+	CreateConvertTask(ctx context.Context, cmd *CreationConvertCmd) (*CreationConvertDto, error)
 	GetTaskStatus(cmd *ConverterStatusCmd) (*ConverterStatusDto, error)
+	CreateBatchConvertTask(ctx context.Context, cmd *CreationBatchConvertCmd) (*CreationBatchConvertDto, error)
+	GetBatchStatus(cmd *BatchCmd) (*BatchStatusDto, error)
+	CancelBatch(cmd *BatchCmd) (*BatchStatusDto, error)
+	GetTaskTypes() []*TaskTypeDto
 }
 
 type converterServiceImpl struct {
 	scheduler *scheduler.Scheduler
+	signer    *objectstore.Signer
 }
 
 func NewConverterService() ConverterService {
+	signer, err := objectstore.NewSigner(config.GetObjectStore(), config.GetAliyunOss())
+	if err != nil {
+		slog.Error("create object store error", "error", err)
+	}
 	return &converterServiceImpl{
 		scheduler: scheduler.GetScheduler(config.GetScheduler()),
+		signer:    signer,
 	}
 }
 
-func (c *converterServiceImpl) CreateConvertTask(cmd *CreationConvertCmd) (*CreationConvertDto, error) {
+// CreateConvertTask schedules a conversion. The task keeps the trace context of
+// ctx so dispatching and status polls join the trace of this request.
+func (c *converterServiceImpl) CreateConvertTask(ctx context.Context, cmd *CreationConvertCmd) (*CreationConvertDto, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "converter.CreateConvertTask")
+	defer span.End()
+
 	isValidTask := scheduler.IsValidTask(scheduler.TaskType(cmd.Type), scheduler.SubTaskType(cmd.SubType))
 	if !isValidTask {
 		return nil, scheduler.ErrInvalidTask
 	}
+	if err := scheduler.ValidateParams(scheduler.SubTaskType(cmd.SubType), cmd.Params); err != nil {
+		return nil, err
+	}
 
-	task := scheduler.NewTaskBuilder().
-		SetType(scheduler.TaskType(cmd.Type)).
-		SetSubType(scheduler.SubTaskType(cmd.SubType)).
-		SetCreatedAt(time.Now()).
+	task := newTaskBuilder(ctx, cmd.Type, cmd.SubType, cmd.UserId, cmd.QueueTimeout).
 		SetUserDef(cmd.Params).
+		SetCacheKey(c.cacheKey(cmd.Type, cmd.SubType, cmd.Params)).
 		Build()
 
-	_, err := c.scheduler.Schedule(task)
+	span.SetAttributes(tracing.TaskAttributes(task.GetId(), cmd.Type, cmd.SubType)...)
+
+	err := c.schedule(task, cmd.NoCache)
 	if err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
 
 	return &CreationConvertDto{
 		TaskId: task.GetId(),
+		Status: string(task.GetState()),
 	}, nil
 
 }
 
+func (c *converterServiceImpl) schedule(task scheduler.Task, noCache bool) error {
+	var err error
+	if noCache {
+		_, err = c.scheduler.ScheduleNoCache(task)
+	} else {
+		_, err = c.scheduler.Schedule(task)
+	}
+	return err
+}
+
+// cacheKey is the result cache key of a conversion, empty while the cache is
+// disabled or the input file cannot be hashed.
+func (c *converterServiceImpl) cacheKey(taskType, subType string, params interface{}) string {
+	if !c.scheduler.ResultCacheEnabled() {
+		return ""
+	}
+	fields, _ := params.(map[string]interface{})
+	fileId, _ := fields["file_id"].(string)
+	if len(fileId) == 0 {
+		return ""
+	}
+	fileHash, err := c.signer.Hash(fileId)
+	if err != nil {
+		slog.Warn("hash input file error, result is not cached", "file_id", fileId, "error", err)
+		return ""
+	}
+	key, err := scheduler.ResultCacheKey(fileHash, scheduler.TaskType(taskType), scheduler.SubTaskType(subType), params)
+	if err != nil {
+		slog.Warn("compute result cache key error", "file_id", fileId, "error", err)
+		return ""
+	}
+	return key
+}
+
+func newTaskBuilder(ctx context.Context, taskType, subType, userId string, queueTimeout int) *scheduler.TaskBuilder {
+	now := time.Now()
+	builder := scheduler.NewTaskBuilder().
+		SetType(scheduler.TaskType(taskType)).
+		SetSubType(scheduler.SubTaskType(subType)).
+		SetCreatedAt(now).
+		SetUserId(userId).
+		SetTraceParent(tracing.TraceParent(ctx)).
+		SetRequestId(logger.RequestId(ctx))
+	if queueTimeout > 0 {
+		builder.SetDeadline(now.Add(time.Duration(queueTimeout) * time.Second))
+	}
+	return builder
+}
+
+// GetTaskStatus reports a task of the user, tasks of other users are not
+// found so their downloads are never signed.
 func (c *converterServiceImpl) GetTaskStatus(cmd *ConverterStatusCmd) (*ConverterStatusDto, error) {
+	task, err := c.scheduler.GetTask(cmd.TaskId)
+	if err != nil {
+		return nil, err
+	}
+	if task.GetUserId() != cmd.UserId {
+		return nil, fmt.Errorf("%w, task id: %s", scheduler.ErrTaskNotFound, cmd.TaskId)
+	}
 	taskResult, err := c.scheduler.GetTaskStatus(cmd.TaskId)
 	if err != nil {
 		return nil, err
 	}
+	// the state is still reported when the downloads cannot be signed
+	downloads, err := c.signer.Downloads(taskResult)
+	if err != nil {
+		slog.Warn("sign task downloads error", "task_id", taskResult.TaskId, "error", err)
+	}
 	return &ConverterStatusDto{
-		TaskId:  taskResult.TaskId,
-		Status:  string(taskResult.Status),
-		Type:    taskResult.Type,
-		SubType: taskResult.SubType,
+		TaskId:    taskResult.TaskId,
+		Status:    string(taskResult.Status),
+		Type:      taskResult.Type,
+		SubType:   taskResult.SubType,
+		Downloads: downloads,
 	}, nil
 }
+
+// CreateBatchConvertTask schedules one task per file under a new batch id. A
+// file whose task cannot be dispatched is reported as failed, the other files
+// are still converted.
+func (c *converterServiceImpl) CreateBatchConvertTask(ctx context.Context, cmd *CreationBatchConvertCmd) (*CreationBatchConvertDto, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "converter.CreateBatchConvertTask")
+	defer span.End()
+
+	isValidTask := scheduler.IsValidTask(scheduler.TaskType(cmd.Type), scheduler.SubTaskType(cmd.SubType))
+	if !isValidTask {
+		return nil, scheduler.ErrInvalidTask
+	}
+	// the params only differ in file_id, checking the first file covers all
+	if err := scheduler.ValidateParams(scheduler.SubTaskType(cmd.SubType), batchUserDef(cmd.Params, cmd.FileIds[0])); err != nil {
+		return nil, err
+	}
+	// a batch the queue has no room for is rejected as a whole
+	if err := c.scheduler.Admit(scheduler.TaskType(cmd.Type), len(cmd.FileIds)); err != nil {
+		return nil, err
+	}
+
+	batchId := strings.ReplaceAll(uuid.New().String(), "-", "")
+	dto := &CreationBatchConvertDto{
+		BatchId: batchId,
+		Tasks:   make([]*BatchTaskDto, len(cmd.FileIds)),
+	}
+	for i, fileId := range cmd.FileIds {
+		userDef := batchUserDef(cmd.Params, fileId)
+		task := newTaskBuilder(ctx, cmd.Type, cmd.SubType, cmd.UserId, cmd.QueueTimeout).
+			SetBatchId(batchId).
+			SetUserDef(userDef).
+			SetCacheKey(c.cacheKey(cmd.Type, cmd.SubType, userDef)).
+			Build()
+
+		taskDto := &BatchTaskDto{
+			FileId: fileId,
+			TaskId: task.GetId(),
+		}
+		if err := c.schedule(task, cmd.NoCache); err != nil {
+			taskDto.Status = string(scheduler.TaskStateFailure)
+			taskDto.Error = err.Error()
+		} else {
+			taskDto.Status = string(task.GetState())
+		}
+		dto.Tasks[i] = taskDto
+	}
+	return dto, nil
+}
+
+// batchUserDef gives every task of a batch the shared params plus its file.
+func batchUserDef(params map[string]interface{}, fileId string) map[string]interface{} {
+	userDef := make(map[string]interface{}, len(params)+1)
+	for k, v := range params {
+		userDef[k] = v
+	}
+	userDef["file_id"] = fileId
+	return userDef
+}
+
+func (c *converterServiceImpl) GetBatchStatus(cmd *BatchCmd) (*BatchStatusDto, error) {
+	tasks, err := c.listBatch(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return newBatchStatusDto(cmd.BatchId, tasks), nil
+}
+
+// CancelBatch cancels the tasks of a batch that have not finished, finished
+// tasks keep their state.
+func (c *converterServiceImpl) CancelBatch(cmd *BatchCmd) (*BatchStatusDto, error) {
+	tasks, err := c.listBatch(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, task := range tasks {
+		state := task.GetState()
+		if state != scheduler.TaskStateCreated && state != scheduler.TaskStateRunning {
+			continue
+		}
+		err := c.scheduler.CancelTask(task.GetId())
+		// the task finished in the meantime
+		if err != nil && !errors.Is(err, scheduler.ErrInvalidStateTransition) {
+			return nil, err
+		}
+	}
+	return c.GetBatchStatus(cmd)
+}
+
+// listBatch returns the tasks of a batch of the user, batches of other users
+// are not found.
+func (c *converterServiceImpl) listBatch(cmd *BatchCmd) ([]scheduler.Task, error) {
+	tasks, err := c.scheduler.ListBatch(cmd.BatchId)
+	if err != nil {
+		return nil, err
+	}
+	if tasks[0].GetUserId() != cmd.UserId {
+		return nil, fmt.Errorf("%w, batch id: %s", scheduler.ErrBatchNotFound, cmd.BatchId)
+	}
+	return tasks, nil
+}
+
+func newBatchStatusDto(batchId string, tasks []scheduler.Task) *BatchStatusDto {
+	dto := &BatchStatusDto{
+		BatchId: batchId,
+		Total:   len(tasks),
+		Tasks:   make([]*BatchTaskDto, len(tasks)),
+	}
+	for i, task := range tasks {
+		switch task.GetState() {
+		case scheduler.TaskStateCreated:
+			dto.Pending++
+		case scheduler.TaskStateRunning:
+			dto.Running++
+		case scheduler.TaskStateDone:
+			dto.Done++
+		case scheduler.TaskStateFailure, scheduler.TaskStateDeadLetter:
+			dto.Failed++
+		case scheduler.TaskStateCancelled:
+			dto.Cancelled++
+		}
+
+		taskDto := &BatchTaskDto{
+			TaskId: task.GetId(),
+			Status: string(task.GetState()),
+		}
+		if userDef, ok := task.GetUserDef().(map[string]interface{}); ok {
+			taskDto.FileId, _ = userDef["file_id"].(string)
+		}
+		if taskErrors := task.GetErrors(); len(taskErrors) > 0 && task.GetState() != scheduler.TaskStateDone {
+			taskDto.Error = taskErrors[len(taskErrors)-1].Message
+		}
+		dto.Tasks[i] = taskDto
+	}
+	dto.Finished = dto.Pending == 0 && dto.Running == 0
+	return dto
+}
+
+// GetTaskTypes lists the conversions users can start, with the params each
+// one takes.
+func (c *converterServiceImpl) GetTaskTypes() []*TaskTypeDto {
+	defs := scheduler.TaskTypeDefs()
+	dtos := make([]*TaskTypeDto, len(defs))
+	for i, def := range defs {
+		dto := &TaskTypeDto{
+			Type:     string(def.Type),
+			Name:     def.Name,
+			SubTypes: make([]*SubTaskTypeDto, len(def.SubTypes)),
+		}
+		for j, subTypeDef := range def.SubTypes {
+			dto.SubTypes[j] = &SubTaskTypeDto{
+				SubType: string(subTypeDef.SubType),
+				Name:    subTypeDef.Name,
+				Params:  subTypeDef.Params,
+			}
+		}
+		dtos[i] = dto
+	}
+	return dtos
+}
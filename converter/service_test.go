@@ -0,0 +1,171 @@
+package converter_test
+
+import (
+	"context"
+	"go-web/converter"
+	"go-web/pkg/config"
+	"go-web/pkg/scheduler"
+	"go-web/pkg/scheduler/schedulertest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupWorker(t *testing.T, behavior schedulertest.Behavior) *schedulertest.FakeWorker {
+	w := schedulertest.NewFakeWorker(t, "converter-w1")
+	w.SetBehavior(behavior)
+	schedulertest.Register(t, schedulertest.Shared(), w)
+	return w
+}
+
+func newPdf2ImgCmd() *converter.CreationConvertCmd {
+	return &converter.CreationConvertCmd{
+		Type:    string(scheduler.TaskTypePdf),
+		SubType: string(scheduler.SubTaskTypePdf2Img),
+		Params:  map[string]interface{}{"file_id": "f1"},
+		UserId:  "u1",
+	}
+}
+
+func TestCreateConvertTask_ShouldDispatchToWorker_WhenTaskValid(t *testing.T) {
+	w := setupWorker(t, schedulertest.Accept)
+	cs := converter.NewConverterService()
+
+	dto, err := cs.CreateConvertTask(context.Background(), newPdf2ImgCmd())
+	assert.NoError(t, err)
+
+	if schedulertest.AssertSubmitted(t, w, 1) {
+		submission := w.Submissions()[0]
+		assert.Equal(t, dto.TaskId, submission.TaskId)
+		assert.Equal(t, scheduler.SubTaskTypePdf2Img, submission.SubType)
+		assert.JSONEq(t, `{"file_id":"f1"}`, string(submission.Detail))
+	}
+
+	status, err := cs.GetTaskStatus(&converter.ConverterStatusCmd{TaskId: dto.TaskId, UserId: "u1"})
+	assert.NoError(t, err)
+	assert.Equal(t, scheduler.TaskStateDone, status.Status)
+}
+
+func TestGetTaskStatus_ShouldReturnRunning_WhenWorkerStillConverting(t *testing.T) {
+	setupWorker(t, schedulertest.Hang)
+	cs := converter.NewConverterService()
+
+	dto, err := cs.CreateConvertTask(context.Background(), newPdf2ImgCmd())
+	assert.NoError(t, err)
+
+	status, err := cs.GetTaskStatus(&converter.ConverterStatusCmd{TaskId: dto.TaskId, UserId: "u1"})
+	assert.NoError(t, err)
+	assert.Equal(t, scheduler.TaskStateRunning, status.Status)
+}
+
+func TestGetTaskStatus_ShouldReturnNotFound_WhenTaskOfOtherUser(t *testing.T) {
+	config.GetObjectStore().Type = "memory"
+	t.Cleanup(func() { config.GetObjectStore().Type = "" })
+	w := setupWorker(t, schedulertest.Accept)
+	w.SetArtifacts("page-1.png")
+	cs := converter.NewConverterService()
+
+	dto, err := cs.CreateConvertTask(context.Background(), newPdf2ImgCmd())
+	assert.NoError(t, err)
+
+	status, err := cs.GetTaskStatus(&converter.ConverterStatusCmd{TaskId: dto.TaskId, UserId: "u2"})
+	assert.ErrorIs(t, err, scheduler.ErrTaskNotFound)
+	assert.Nil(t, status)
+}
+
+func TestCreateConvertTask_ShouldRejectTask_WhenSubTypeUnknown(t *testing.T) {
+	w := setupWorker(t, schedulertest.Accept)
+	cs := converter.NewConverterService()
+
+	cmd := newPdf2ImgCmd()
+	cmd.SubType = "pdf2mp3"
+	_, err := cs.CreateConvertTask(context.Background(), cmd)
+	assert.ErrorIs(t, err, scheduler.ErrInvalidTask)
+	assert.Empty(t, w.Submissions())
+}
+
+func newBatchCmd(fileIds ...string) *converter.CreationBatchConvertCmd {
+	return &converter.CreationBatchConvertCmd{
+		Type:    string(scheduler.TaskTypePdf),
+		SubType: string(scheduler.SubTaskTypePdf2Img),
+		FileConvertUserDefCmd: converter.FileConvertUserDefCmd{
+			FileIds: fileIds,
+			Params:  map[string]interface{}{"dpi": 300},
+		},
+		UserId: "u1",
+	}
+}
+
+func TestCreateBatchConvertTask_ShouldCreateTaskPerFile_WhenFilesGiven(t *testing.T) {
+	w := setupWorker(t, schedulertest.Accept)
+	cs := converter.NewConverterService()
+
+	dto, err := cs.CreateBatchConvertTask(context.Background(), newBatchCmd("f1", "f2"))
+	assert.NoError(t, err)
+	if assert.Len(t, dto.Tasks, 2) {
+		assert.Equal(t, "f1", dto.Tasks[0].FileId)
+		assert.Equal(t, "f2", dto.Tasks[1].FileId)
+	}
+	if schedulertest.AssertSubmitted(t, w, 2) {
+		assert.JSONEq(t, `{"dpi":300,"file_id":"f2"}`, string(w.Submissions()[1].Detail))
+	}
+
+	status, err := cs.GetBatchStatus(&converter.BatchCmd{BatchId: dto.BatchId, UserId: "u1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, status.Total)
+	assert.Equal(t, 2, status.Done)
+	assert.True(t, status.Finished)
+
+	// finished tasks of a batch stay readable
+	status, err = cs.GetBatchStatus(&converter.BatchCmd{BatchId: dto.BatchId, UserId: "u1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, status.Done)
+}
+
+func TestCancelBatch_ShouldCancelRunningTasks_WhenBatchRunning(t *testing.T) {
+	w := setupWorker(t, schedulertest.Hang)
+	cs := converter.NewConverterService()
+
+	dto, err := cs.CreateBatchConvertTask(context.Background(), newBatchCmd("f1", "f2"))
+	assert.NoError(t, err)
+
+	status, err := cs.CancelBatch(&converter.BatchCmd{BatchId: dto.BatchId, UserId: "u1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, status.Cancelled)
+	assert.True(t, status.Finished)
+	assert.Len(t, w.Cancelled(), 2)
+}
+
+func TestGetBatchStatus_ShouldReturnNotFound_WhenBatchOfOtherUser(t *testing.T) {
+	setupWorker(t, schedulertest.Hang)
+	cs := converter.NewConverterService()
+
+	dto, err := cs.CreateBatchConvertTask(context.Background(), newBatchCmd("f1"))
+	assert.NoError(t, err)
+
+	_, err = cs.GetBatchStatus(&converter.BatchCmd{BatchId: dto.BatchId, UserId: "u2"})
+	assert.ErrorIs(t, err, scheduler.ErrBatchNotFound)
+	_, err = cs.CancelBatch(&converter.BatchCmd{BatchId: "missing", UserId: "u1"})
+	assert.ErrorIs(t, err, scheduler.ErrBatchNotFound)
+}
+
+func TestGetTaskStatus_ShouldReturnDownloads_WhenWorkerUploadedOutputs(t *testing.T) {
+	config.GetObjectStore().Type = "memory"
+	t.Cleanup(func() { config.GetObjectStore().Type = "" })
+	w := setupWorker(t, schedulertest.Accept)
+	w.SetArtifacts("page-1.png", "page-2.png")
+	cs := converter.NewConverterService()
+
+	dto, err := cs.CreateConvertTask(context.Background(), newPdf2ImgCmd())
+	assert.NoError(t, err)
+	if schedulertest.AssertSubmitted(t, w, 1) {
+		assert.Equal(t, scheduler.ArtifactPrefix(dto.TaskId), w.Submissions()[0].ArtifactPrefix)
+	}
+
+	status, err := cs.GetTaskStatus(&converter.ConverterStatusCmd{TaskId: dto.TaskId, UserId: "u1"})
+	assert.NoError(t, err)
+	if assert.Len(t, status.Downloads, 2) {
+		assert.Equal(t, "page-1.png", status.Downloads[0].Name)
+		assert.Contains(t, status.Downloads[0].Url, "artifacts/"+dto.TaskId+"/page-1.png")
+	}
+}
@@ -2,7 +2,7 @@ package index
 
 import (
 	"go-web/pkg/middleware"
-	"log"
+	"log/slog"
 	"sync"
 
 	"github.com/gin-gonic/gin"
@@ -33,7 +33,8 @@ func NewIndexRouter(is IndexService) *IndexRouter {
 }
 
 func (i *IndexRouter) Index(c *gin.Context) {
-	claims := c.MustGet("claims").(*middleware.CustomClaims)
-	log.Println("claims: ", claims.UserId)
+	// the user id is added to the record from the request context
+	_ = c.MustGet("claims").(*middleware.CustomClaims)
+	slog.DebugContext(c.Request.Context(), "index requested")
 	c.JSON(200, "Hello go-web")
 }
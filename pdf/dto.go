@@ -1,5 +1,19 @@
 package pdf
 
+import (
+	"go-web/pkg/objectstore"
+	"go-web/pkg/scheduler"
+)
+
 type PdfSumitTaskDto struct {
 	TaskId int `json:"task_id"`
 }
+
+// PdfTaskResultDto keeps the keys of the former result, downloads replace the
+// local path workers used to report.
+type PdfTaskResultDto struct {
+	TaskId    string
+	Status    scheduler.TaskState
+	Data      interface{}
+	Downloads []*objectstore.Download `json:"downloads,omitempty"`
+}
@@ -1,10 +1,10 @@
 package pdf
 
 import (
-	"go-web/pkg/config"
+	"errors"
+	"go-web/pkg/middleware"
 	"go-web/pkg/scheduler"
 	"strconv"
-	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -18,13 +18,11 @@ func InitRouter(public *gin.RouterGroup) {
 
 type PdfRouter struct {
 	pdfservice PdfService
-	scheduler  *scheduler.Scheduler
 }
 
 func NewPdfRouter(pdfservice PdfService) *PdfRouter {
 	return &PdfRouter{
 		pdfservice: pdfservice,
-		scheduler:  scheduler.GetScheduler(config.GetScheduler()),
 	}
 }
 
@@ -84,25 +82,22 @@ func (cr *PdfRouter) GetTaskResult(c *gin.Context) {
 		return
 	}
 
-	if dto, err := cr.scheduler.GetTaskStatus(taskId); err != nil {
+	// the route is public, anonymous callers only see tasks without a user
+	userId := ""
+	if claims, ok := c.Get("claims"); ok {
+		userId = claims.(*middleware.CustomClaims).UserId
+	}
+	if dto, err := cr.pdfservice.GetTaskResult(taskId, userId); errors.Is(err, scheduler.ErrTaskNotFound) {
+		c.JSON(404, gin.H{
+			"msg": err.Error(),
+		})
+		return
+	} else if err != nil {
 		c.JSON(400, gin.H{
 			"msg": err.Error(),
 		})
 		return
 	} else {
-		if dto.Data != nil {
-			path, ok := dto.Data.(string)
-			if ok {
-				newPath := strings.Replace(path, "/home/xiaosi", "", 1)
-				newDto := &scheduler.TaskResult{
-					TaskId: dto.TaskId,
-					Status: dto.Status,
-					Data:   newPath,
-				}
-				c.JSON(200, newDto)
-				return
-			}
-		}
 		c.JSON(200, dto)
 	}
 }
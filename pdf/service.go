@@ -2,16 +2,21 @@ package pdf
 
 import (
 	"context"
+	"fmt"
 	"go-web/pkg/config"
+	"go-web/pkg/objectstore"
 	"go-web/pkg/scheduler"
+	"log/slog"
 )
 
 type PdfService interface {
 	SplitPdf(filename string, filepath string, pages_per_file int) (*PdfSumitTaskDto, error)
+	GetTaskResult(taskId string, userId string) (*PdfTaskResultDto, error)
 }
 
 type pdfservice struct {
 	scheduler *scheduler.Scheduler
+	signer    *objectstore.Signer
 }
 
 type PdfSplitTask struct {
@@ -24,11 +29,49 @@ type PdfSplitTask struct {
 
 func NewPdfService() PdfService {
 	context.Background()
+	signer, err := objectstore.NewSigner(config.GetObjectStore(), config.GetAliyunOss())
+	if err != nil {
+		slog.Error("create object store error", "error", err)
+	}
 	return &pdfservice{
 		scheduler: scheduler.GetScheduler(config.GetScheduler()),
+		signer:    signer,
 	}
 }
 
 func (p *pdfservice) SplitPdf(filename, filepath string, pages_per_file int) (*PdfSumitTaskDto, error) {
 	return nil, nil
 }
+
+// GetTaskResult returns the state of a task of the user and a presigned
+// download url per file the worker uploaded. Tasks of other users are not
+// found.
+func (p *pdfservice) GetTaskResult(taskId string, userId string) (*PdfTaskResultDto, error) {
+	task, err := p.scheduler.GetTask(taskId)
+	if err != nil {
+		return nil, err
+	}
+	if task.GetUserId() != userId {
+		return nil, fmt.Errorf("%w, task id: %s", scheduler.ErrTaskNotFound, taskId)
+	}
+	taskResult, err := p.scheduler.GetTaskStatus(taskId)
+	if err != nil {
+		return nil, err
+	}
+
+	dto := &PdfTaskResultDto{
+		TaskId: taskResult.TaskId,
+		Status: taskResult.Status,
+		Data:   taskResult.Data,
+	}
+	downloads, err := p.signer.Downloads(taskResult)
+	if err != nil {
+		slog.Warn("sign task downloads error", "task_id", taskId, "error", err)
+	}
+	if len(downloads) > 0 {
+		// the object keys are only meaningful together with the urls
+		dto.Data = nil
+		dto.Downloads = downloads
+	}
+	return dto, nil
+}
@@ -38,8 +38,24 @@ type Auth struct {
 	Jwt    Jwt
 }
 
+type SqlStore struct {
+	Dialect         string `env:"SCHEDULER_SQL_DIALECT"`
+	DSN             string `env:"SCHEDULER_SQL_DSN"`
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime int
+}
+
+type Journal struct {
+	Dir              string `env:"SCHEDULER_JOURNAL_DIR"`
+	SnapshotInterval int
+	Fsync            bool `env:"SCHEDULER_JOURNAL_FSYNC"`
+}
+
 type TaskConfig struct {
-	StoreType string
+	StoreType string `env:"SCHEDULER_TASKSTORE"`
+	Sql       SqlStore
+	Journal   Journal
 }
 
 type WorkerConfig struct {
@@ -49,10 +65,116 @@ type WorkerConfig struct {
 	EvictThreshold int    `env:"SCHEDULER_EVICT_THRESHOLD"`
 }
 
+type LeaderElection struct {
+	Enabled       bool   `env:"SCHEDULER_LEADER_ENABLED"`
+	ReplicaId     string `env:"SCHEDULER_LEADER_REPLICAID"`
+	LeaseTTL      int    `env:"SCHEDULER_LEADER_LEASETTL"`
+	RenewInterval int    `env:"SCHEDULER_LEADER_RENEWINTERVAL"`
+}
+
+type Dispatch struct {
+	Mode      string `env:"SCHEDULER_DISPATCH_MODE"`
+	ClaimIdle int
+	BatchSize int
+}
+
+type Retry struct {
+	MaxAttempts int `env:"SCHEDULER_RETRY_MAXATTEMPTS"`
+}
+
+type Timeout struct {
+	// SupervisorInterval is how often overdue tasks are looked for, in seconds
+	SupervisorInterval int
+	// DefaultExecution is the max execution time in seconds, 0 for no limit
+	DefaultExecution int
+	// Execution overrides DefaultExecution per sub task type
+	Execution map[string]int
+	// BatchRetention is how long a finished batch is kept, in seconds,
+	// defaults to a day
+	BatchRetention int
+}
+
+// TaskType declares a task type in go-web.yaml, sub types are added to the
+// built-in ones of the type.
+type TaskType struct {
+	Type     string
+	Name     string
+	SubTypes []SubTaskType
+}
+
+type SubTaskType struct {
+	SubType string
+	Name    string
+	Params  []TaskParam
+}
+
+// TaskParam is a parameter of a sub type, Type is string, integer, number,
+// boolean, array or object and Validate holds validator tags.
+type TaskParam struct {
+	Name        string
+	Type        string
+	Validate    string
+	Description string
+}
+
+// AdmissionLimit caps the tasks of a task type, 0 does not limit.
+type AdmissionLimit struct {
+	// MaxPending is how many tasks may wait for a worker
+	MaxPending int
+	// MaxActive is how many dispatched tasks the workers may have at once
+	MaxActive int
+}
+
+// Admission rejects new tasks once the queue or the workers are full.
+type Admission struct {
+	MaxPending int `env:"SCHEDULER_ADMISSION_MAXPENDING"`
+	MaxActive  int `env:"SCHEDULER_ADMISSION_MAXACTIVE"`
+	// TaskTypes limits task types on top of the limits above
+	TaskTypes map[string]AdmissionLimit
+	// RetryAfter is the seconds rejected clients are asked to wait, defaults
+	// to 5
+	RetryAfter int
+	// Degraded holds tasks over MaxActive in direct mode instead of rejecting
+	// them, they are dispatched once the workers have room
+	Degraded bool `env:"SCHEDULER_ADMISSION_DEGRADED"`
+}
+
+// Fairness weighs users against each other while their tasks wait for room
+// on the workers.
+type Fairness struct {
+	// Tiers maps a tier to its weight, a user of weight 3 gets three tasks
+	// dispatched on its turn where a user of weight 1 gets one
+	Tiers map[string]int
+	// Users maps a user id to its tier
+	Users map[string]string
+	// DefaultTier is the tier of the users not in Users, they weigh 1 without
+	// one
+	DefaultTier string
+}
+
+// ResultCache serves repeated conversions of the same input with the same
+// params from the result of an earlier task.
+type ResultCache struct {
+	Enabled bool `env:"SCHEDULER_RESULTCACHE_ENABLED"`
+	// Store is memory or redis, defaults to memory
+	Store string `env:"SCHEDULER_RESULTCACHE_STORE"`
+	// TTL is how many seconds a result is served from the cache, defaults to
+	// 86400. Artifacts must stay in the object store at least as long
+	TTL int `env:"SCHEDULER_RESULTCACHE_TTL"`
+}
+
 type Scheduler struct {
-	WorkerConfig WorkerConfig
-	TaskConfig   TaskConfig
-	Redis        RedisStore
+	WorkerConfig   WorkerConfig
+	TaskConfig     TaskConfig
+	LeaderElection LeaderElection
+	Dispatch       Dispatch
+	Retry          Retry
+	Timeout        Timeout
+	Admission      Admission
+	Fairness       Fairness
+	ResultCache    ResultCache
+	Redis          RedisStore
+	TaskTypes      []TaskType
 }
 
 type Server struct {
@@ -61,6 +183,9 @@ type Server struct {
 	ReadTimeout    int    `env:"SERVER_READTIMEOUT, default=10"`
 	WriteTimeout   int    `env:"SERVER_WRITETIMEOUT, default=10"`
 	MaxHeaderBytes int    `env:"SERVER_MAXHEADERBYTES, default=1048576000"`
+	// ShutdownTimeout bounds in seconds how long requests in progress and
+	// task dispatches get to finish on SIGTERM
+	ShutdownTimeout int `env:"SERVER_SHUTDOWNTIMEOUT, default=30"`
 }
 
 type Aliyun struct {
@@ -71,13 +196,60 @@ type Oss struct {
 	EndPoint  string
 	AccessKey string
 	SecretKey string
+	Bucket    string `env:"OSS_BUCKET"`
+}
+
+type ObjectStore struct {
+	// Type is oss, or memory for tests. Task outputs get no download url
+	// while it is empty
+	Type string `env:"OBJECTSTORE_TYPE"`
+	// UrlExpires is how long download urls stay valid in seconds, defaults
+	// to 3600
+	UrlExpires int
+}
+
+type Admin struct {
+	// Token is sent in the X-Admin-Token header, the admin api is disabled
+	// while it is empty
+	Token string `env:"ADMIN_TOKEN"`
+}
+
+type Metrics struct {
+	// Addr serves /metrics on a listener of its own, without it /metrics is
+	// on the api server behind the admin token
+	Addr string `env:"METRICS_ADDR"`
+}
+
+type Tracing struct {
+	// Exporter is none, stdout, file or otlp
+	Exporter string `env:"TRACING_EXPORTER"`
+	// Endpoint is the host:port of an OTLP/HTTP collector
+	Endpoint string `env:"TRACING_ENDPOINT"`
+	Insecure bool   `env:"TRACING_INSECURE"`
+	// File is where the file exporter writes spans, one JSON per line
+	File        string
+	ServiceName string
+	// SampleRatio of the traces started here, 0 samples all of them
+	SampleRatio float64
+}
+
+type Log struct {
+	// Level is debug, info, warn or error
+	Level string `env:"LOG_LEVEL"`
+	// Format is json or text
+	Format string `env:"LOG_FORMAT"`
 }
 
 type Config struct {
-	Server    Server
-	Scheduler Scheduler
-	Aliyun    Aliyun
-	Auth      Auth
+	Server      Server
+	Scheduler   Scheduler
+	Aliyun      Aliyun
+	ObjectStore ObjectStore
+	Auth        Auth
+	Admin       Admin
+	Metrics     Metrics
+	Tracing     Tracing
+	Log         Log
 }
 
 var ApplicationConfig *Config = &Config{}
@@ -90,6 +262,10 @@ func GetAliyunOss() *Oss {
 	return &ApplicationConfig.Aliyun.Oss
 }
 
+func GetObjectStore() *ObjectStore {
+	return &ApplicationConfig.ObjectStore
+}
+
 func GetScheduler() *Scheduler {
 	return &ApplicationConfig.Scheduler
 }
@@ -98,6 +274,22 @@ func GetAuthConfig() *Auth {
 	return &ApplicationConfig.Auth
 }
 
+func GetAdminConfig() *Admin {
+	return &ApplicationConfig.Admin
+}
+
+func GetMetricsConfig() *Metrics {
+	return &ApplicationConfig.Metrics
+}
+
+func GetTracingConfig() *Tracing {
+	return &ApplicationConfig.Tracing
+}
+
+func GetLogConfig() *Log {
+	return &ApplicationConfig.Log
+}
+
 func GetWorkerConfig() *WorkerConfig {
 	return &ApplicationConfig.Scheduler.WorkerConfig
 }
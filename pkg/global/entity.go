@@ -56,3 +56,28 @@ func InternalServerError(c *gin.Context, entity *HttpEntity) {
 	c.JSON(http.StatusInternalServerError, entity)
 	c.Abort()
 }
+
+func ForbiddenError(c *gin.Context, entity *HttpEntity) {
+	c.JSON(http.StatusForbidden, entity)
+	c.Abort()
+}
+
+func NotFoundError(c *gin.Context, entity *HttpEntity) {
+	c.JSON(http.StatusNotFound, entity)
+	c.Abort()
+}
+
+func ConflictError(c *gin.Context, entity *HttpEntity) {
+	c.JSON(http.StatusConflict, entity)
+	c.Abort()
+}
+
+func TooManyRequestsError(c *gin.Context, entity *HttpEntity) {
+	c.JSON(http.StatusTooManyRequests, entity)
+	c.Abort()
+}
+
+func ServiceUnavailableError(c *gin.Context, entity *HttpEntity) {
+	c.JSON(http.StatusServiceUnavailable, entity)
+	c.Abort()
+}
@@ -0,0 +1,100 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"go-web/pkg/config"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	FormatJson = "json"
+	FormatText = "text"
+
+	// RequestIdHeader carries the request id from clients to go-web and from
+	// go-web to workers
+	RequestIdHeader = "X-Request-ID"
+)
+
+type ctxKey int
+
+const (
+	requestIdKey ctxKey = iota
+	userIdKey
+)
+
+// Init makes a JSON, or text, slog logger of the configured level the
+// default. The standard log package writes through it as well.
+func Init(cfg *config.Log) error {
+	handler, err := NewHandler(os.Stdout, cfg)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// NewHandler returns a handler that adds the request id, user id and trace id
+// of the context to every record.
+func NewHandler(w io.Writer, cfg *config.Log) (slog.Handler, error) {
+	var level slog.Level
+	if len(cfg.Level) > 0 {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, fmt.Errorf("invalid log level: %s", cfg.Level)
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(cfg.Format) {
+	case FormatJson, "":
+		return contextHandler{slog.NewJSONHandler(w, opts)}, nil
+	case FormatText:
+		return contextHandler{slog.NewTextHandler(w, opts)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported log format: %s", cfg.Format)
+	}
+}
+
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey, requestId)
+}
+
+// RequestId returns the request id of ctx, empty if there is none.
+func RequestId(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdKey).(string)
+	return requestId
+}
+
+func WithUserId(ctx context.Context, userId string) context.Context {
+	return context.WithValue(ctx, userIdKey, userId)
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if requestId := RequestId(ctx); len(requestId) > 0 {
+		r.AddAttrs(slog.String("request_id", requestId))
+	}
+	if userId, _ := ctx.Value(userIdKey).(string); len(userId) > 0 {
+		r.AddAttrs(slog.String("user_id", userId))
+	}
+	if spanCtx := trace.SpanContextFromContext(ctx); spanCtx.HasTraceID() {
+		r.AddAttrs(slog.String("trace_id", spanCtx.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
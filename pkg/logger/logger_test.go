@@ -0,0 +1,38 @@
+package logger_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"go-web/pkg/config"
+	"go-web/pkg/logger"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler_ShouldAddContextFields_WhenContextHasIds(t *testing.T) {
+	var buf bytes.Buffer
+	handler, err := logger.NewHandler(&buf, &config.Log{Level: "info"})
+	assert.NoError(t, err)
+	log := slog.New(handler)
+
+	ctx := logger.WithUserId(logger.WithRequestId(context.Background(), "r1"), "u1")
+	log.InfoContext(ctx, "task created", "task_id", "t1")
+	log.DebugContext(ctx, "dropped below the level")
+
+	var record map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "task created", record["msg"])
+	assert.Equal(t, "r1", record["request_id"])
+	assert.Equal(t, "u1", record["user_id"])
+	assert.Equal(t, "t1", record["task_id"])
+}
+
+func TestNewHandler_ShouldReturnError_WhenConfigInvalid(t *testing.T) {
+	_, err := logger.NewHandler(&bytes.Buffer{}, &config.Log{Level: "verbose"})
+	assert.Error(t, err)
+	_, err = logger.NewHandler(&bytes.Buffer{}, &config.Log{Format: "xml"})
+	assert.Error(t, err)
+}
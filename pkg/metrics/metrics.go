@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "goweb"
+
+// Registry holds every go-web metric, it is served on /metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	HttpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by gin route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HttpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by gin route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	TasksCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_created_total",
+		Help:      "Tasks created by type and sub type.",
+	}, []string{"type", "sub_type"})
+
+	TasksCompleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_completed_total",
+		Help:      "Tasks a worker finished successfully by type and sub type.",
+	}, []string{"type", "sub_type"})
+
+	TasksFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_failed_total",
+		Help:      "Failed task attempts by type and sub type.",
+	}, []string{"type", "sub_type"})
+
+	TasksRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_rejected_total",
+		Help:      "Tasks rejected by an admission limit by type and limit, pending or active.",
+	}, []string{"type", "limit"})
+
+	ResultCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "result_cache_requests_total",
+		Help:      "Result cache lookups by sub type and result, hit, miss or bypass.",
+	}, []string{"sub_type", "result"})
+
+	TaskDispatchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "task_dispatch_duration_seconds",
+		Help:      "Time to select a worker and have it accept a task, by task type.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"type"})
+
+	WorkerClientErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "worker_client_errors_total",
+		Help:      "Failed HTTP calls to workers by worker and operation.",
+	}, []string{"worker", "operation"})
+
+	RedisCommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "redis_command_duration_seconds",
+		Help:      "Redis call latency by command, pipelines are one call.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command", "status"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HttpRequests,
+		HttpRequestDuration,
+		TasksCreated,
+		TasksCompleted,
+		TasksFailed,
+		TasksRejected,
+		ResultCacheRequests,
+		TaskDispatchDuration,
+		WorkerClientErrors,
+		RedisCommandDuration,
+	)
+}
+
+// Handler serves the registry in Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// GinMiddleware counts requests per route template, so /task/:id is one
+// series no matter how many ids are requested.
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if len(route) == 0 {
+			route = "unmatched"
+		}
+		HttpRequests.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).Inc()
+		HttpRequestDuration.WithLabelValues(route, c.Request.Method).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,52 @@
+package metrics_test
+
+import (
+	"context"
+	"go-web/pkg/metrics"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGinMiddleware_ShouldLabelByRouteTemplate_WhenPathHasParams(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(metrics.GinMiddleware())
+	r.GET("/metrics-test/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, id := range []string{"1", "2"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics-test/"+id, nil))
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics-missing", nil))
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.HttpRequests.WithLabelValues("/metrics-test/:id", "GET", "200")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.HttpRequests.WithLabelValues("unmatched", "GET", "404")))
+}
+
+func TestRedisHook_ShouldObserveCommands_WhenHookAdded(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	client.AddHook(metrics.RedisHook{})
+
+	ctx := context.Background()
+	assert.NoError(t, client.Set(ctx, "k", "v", 0).Err())
+	assert.ErrorIs(t, client.Get(ctx, "missing").Err(), redis.Nil)
+
+	count, err := testutil.GatherAndCount(metrics.Registry, "goweb_redis_command_duration_seconds")
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, count, 2)
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	assert.True(t, strings.Contains(body, `goweb_redis_command_duration_seconds_count{command="set",status="ok"} 1`))
+	assert.True(t, strings.Contains(body, `goweb_redis_command_duration_seconds_count{command="get",status="ok"} 1`))
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"go-web/pkg/config"
+	"go-web/pkg/global"
+
+	"github.com/gin-gonic/gin"
+)
+
+const AdminTokenHeader = "X-Admin-Token"
+
+// MustAdmin only lets requests carrying the configured admin token through.
+// The admin api is closed while no token is configured.
+func MustAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := config.GetAdminConfig().Token
+		if len(token) == 0 {
+			global.ForbiddenError(c, global.NewEntity("", "admin api is disabled", nil))
+			return
+		}
+
+		given := c.GetHeader(AdminTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			global.AuthError(c, global.NewEntity("", "admin token is invalid", nil))
+			return
+		}
+	}
+}
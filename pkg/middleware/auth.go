@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"go-web/pkg/config"
 	"go-web/pkg/global"
+	"go-web/pkg/logger"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -51,6 +52,7 @@ func MustAuth() gin.HandlerFunc {
 
 		if claims, ok := token.Claims.(*CustomClaims); ok && token.Valid {
 			c.Set("claims", claims)
+			c.Request = c.Request.WithContext(logger.WithUserId(c.Request.Context(), claims.UserId))
 		} else {
 			global.AuthError(c, global.NewEntity("", "token is invalid", nil))
 			return
@@ -82,6 +84,7 @@ func OptionalToken() gin.HandlerFunc {
 
 			if claims, ok := token.Claims.(*CustomClaims); ok && token.Valid {
 				c.Set("claims", claims)
+				c.Request = c.Request.WithContext(logger.WithUserId(c.Request.Context(), claims.UserId))
 			} else {
 				global.AuthError(c, global.NewEntity("", "token is invalid", nil))
 				return
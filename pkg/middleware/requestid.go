@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"go-web/pkg/logger"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const maxRequestIdLength = 128
+
+// RequestId keeps the X-Request-ID of the client or generates one, echoes it
+// in the response and puts it in the request context for logging.
+func RequestId() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestId := c.GetHeader(logger.RequestIdHeader)
+		if !validRequestId(requestId) {
+			requestId = strings.ReplaceAll(uuid.New().String(), "-", "")
+		}
+		c.Header(logger.RequestIdHeader, requestId)
+		c.Request = c.Request.WithContext(logger.WithRequestId(c.Request.Context(), requestId))
+		c.Next()
+	}
+}
+
+// validRequestId accepts printable ASCII only, the id ends up in logs and
+// worker requests.
+func validRequestId(requestId string) bool {
+	if len(requestId) == 0 || len(requestId) > maxRequestIdLength {
+		return false
+	}
+	for i := 0; i < len(requestId); i++ {
+		if requestId[i] < 0x21 || requestId[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
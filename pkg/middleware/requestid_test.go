@@ -0,0 +1,52 @@
+package middleware_test
+
+import (
+	"go-web/pkg/logger"
+	"go-web/pkg/middleware"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRequestIdEngine(got *string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middleware.RequestId())
+	r.GET("/ping", func(c *gin.Context) {
+		*got = logger.RequestId(c.Request.Context())
+	})
+	return r
+}
+
+func TestRequestId_ShouldKeepClientId_WhenHeaderValid(t *testing.T) {
+	var got string
+	r := newRequestIdEngine(&got)
+
+	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	req.Header.Set(logger.RequestIdHeader, "client-req-1")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	assert.Equal(t, "client-req-1", got)
+	assert.Equal(t, "client-req-1", rec.Header().Get(logger.RequestIdHeader))
+}
+
+func TestRequestId_ShouldGenerateId_WhenHeaderMissingOrInvalid(t *testing.T) {
+	var got string
+	r := newRequestIdEngine(&got)
+
+	for _, header := range []string{"", "bad id\n"} {
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		if len(header) > 0 {
+			req.Header.Set(logger.RequestIdHeader, header)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		assert.Len(t, got, 32)
+		assert.Equal(t, got, rec.Header().Get(logger.RequestIdHeader))
+	}
+}
@@ -0,0 +1,170 @@
+// Package objectstore hands out download urls for task outputs workers
+// uploaded to the object store and content hashes of input files.
+package objectstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-web/pkg/config"
+	"go-web/pkg/scheduler"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+const (
+	TypeOss    = "oss"
+	TypeMemory = "memory"
+)
+
+var ErrNotConfigured = errors.New("object store is not configured")
+
+// Store signs time-limited urls of objects.
+type Store interface {
+	// PresignGet returns a url that downloads key until it expires
+	PresignGet(key string, expires time.Duration) (string, error)
+	// Hash returns a hash of the content of key, objects with the same hash
+	// have the same content
+	Hash(key string) (string, error)
+}
+
+// NewStore creates the store of cfg, it is nil while no type is configured.
+func NewStore(cfg *config.ObjectStore, ossCfg *config.Oss) (Store, error) {
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case TypeOss:
+		return NewOssStore(ossCfg)
+	case TypeMemory:
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown object store type: %s", cfg.Type)
+	}
+}
+
+type OssStore struct {
+	bucket *oss.Bucket
+}
+
+func NewOssStore(cfg *config.Oss) (*OssStore, error) {
+	client, err := oss.New(cfg.EndPoint, cfg.AccessKey, cfg.SecretKey)
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := client.Bucket(cfg.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	return &OssStore{bucket: bucket}, nil
+}
+
+func (s *OssStore) PresignGet(key string, expires time.Duration) (string, error) {
+	return s.bucket.SignURL(key, oss.HTTPGet, int64(expires.Seconds()))
+}
+
+// Hash is the ETag of key. The same content uploaded in different parts
+// has a different ETag, it only misses the cache.
+func (s *OssStore) Hash(key string) (string, error) {
+	meta, err := s.bucket.GetObjectDetailedMeta(key)
+	if err != nil {
+		return "", err
+	}
+	etag := strings.Trim(meta.Get(oss.HTTPHeaderEtag), `"`)
+	if len(etag) == 0 {
+		return "", fmt.Errorf("object %s has no etag", key)
+	}
+	return "etag:" + etag, nil
+}
+
+// MemoryStore signs memory:// urls without any backend, it is meant for tests
+// and local development.
+type MemoryStore struct{}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) PresignGet(key string, expires time.Duration) (string, error) {
+	u := url.URL{
+		Scheme:   "memory",
+		Path:     "/" + key,
+		RawQuery: url.Values{"Expires": {fmt.Sprint(time.Now().Add(expires).Unix())}}.Encode(),
+	}
+	return u.String(), nil
+}
+
+// Hash hashes the key itself, keys stand for content that does not change.
+func (s *MemoryStore) Hash(key string) (string, error) {
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// Download is an output of a task and where to fetch it.
+type Download struct {
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type,omitempty"`
+	Size        int64     `json:"size,omitempty"`
+	Url         string    `json:"url"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// Signer turns the artifacts of task results into downloads.
+type Signer struct {
+	store   Store
+	expires time.Duration
+}
+
+func NewSigner(cfg *config.ObjectStore, ossCfg *config.Oss) (*Signer, error) {
+	store, err := NewStore(cfg, ossCfg)
+	if err != nil {
+		return nil, err
+	}
+	expires := time.Duration(cfg.UrlExpires) * time.Second
+	if expires <= 0 {
+		expires = time.Hour
+	}
+	return &Signer{store: store, expires: expires}, nil
+}
+
+// Hash returns the content hash of an object, it fails with ErrNotConfigured
+// when no store is configured.
+func (s *Signer) Hash(key string) (string, error) {
+	if s == nil || s.store == nil {
+		return "", ErrNotConfigured
+	}
+	return s.store.Hash(key)
+}
+
+// Downloads returns one download per artifact of a DONE task result. It fails
+// with ErrNotConfigured when the task has artifacts but no store is
+// configured.
+func (s *Signer) Downloads(result *scheduler.TaskResult) ([]*Download, error) {
+	artifacts := scheduler.TaskArtifacts(result)
+	if len(artifacts) == 0 {
+		return nil, nil
+	}
+	if s == nil || s.store == nil {
+		return nil, ErrNotConfigured
+	}
+
+	expiresAt := time.Now().Add(s.expires)
+	downloads := make([]*Download, len(artifacts))
+	for i, artifact := range artifacts {
+		u, err := s.store.PresignGet(artifact.Key, s.expires)
+		if err != nil {
+			return nil, fmt.Errorf("presign %s: %w", artifact.Key, err)
+		}
+		downloads[i] = &Download{
+			Name:        artifact.Name,
+			ContentType: artifact.ContentType,
+			Size:        artifact.Size,
+			Url:         u,
+			ExpiresAt:   expiresAt.UTC().Truncate(time.Second),
+		}
+	}
+	return downloads, nil
+}
@@ -0,0 +1,73 @@
+package objectstore_test
+
+import (
+	"go-web/pkg/config"
+	"go-web/pkg/objectstore"
+	"go-web/pkg/scheduler"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newDoneResult() *scheduler.TaskResult {
+	return &scheduler.TaskResult{
+		TaskId: "t1",
+		Status: scheduler.TaskStateDone,
+		Data: scheduler.ArtifactResult{Artifacts: []scheduler.Artifact{
+			{Key: "artifacts/t1/page-1.png", Name: "page-1.png"},
+			{Key: "artifacts/t1/page-2.png", Name: "page-2.png"},
+		}},
+	}
+}
+
+func TestDownloads_ShouldPresignEachArtifact_WhenStoreConfigured(t *testing.T) {
+	signer, err := objectstore.NewSigner(&config.ObjectStore{Type: objectstore.TypeMemory, UrlExpires: 60}, &config.Oss{})
+	assert.NoError(t, err)
+
+	downloads, err := signer.Downloads(newDoneResult())
+	assert.NoError(t, err)
+	if assert.Len(t, downloads, 2) {
+		assert.Equal(t, "page-1.png", downloads[0].Name)
+		u, err := url.Parse(downloads[1].Url)
+		assert.NoError(t, err)
+		assert.Equal(t, "/artifacts/t1/page-2.png", u.Path)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), downloads[0].ExpiresAt, 2*time.Second)
+	}
+}
+
+func TestDownloads_ShouldFail_WhenStoreNotConfigured(t *testing.T) {
+	signer, err := objectstore.NewSigner(&config.ObjectStore{}, &config.Oss{})
+	assert.NoError(t, err)
+
+	_, err = signer.Downloads(newDoneResult())
+	assert.ErrorIs(t, err, objectstore.ErrNotConfigured)
+
+	downloads, err := signer.Downloads(&scheduler.TaskResult{TaskId: "t1", Status: scheduler.TaskStateDone})
+	assert.NoError(t, err)
+	assert.Empty(t, downloads)
+}
+
+func TestNewSigner_ShouldFail_WhenTypeUnknown(t *testing.T) {
+	_, err := objectstore.NewSigner(&config.ObjectStore{Type: "s4"}, &config.Oss{})
+	assert.Error(t, err)
+}
+
+func TestHash_ShouldBeStablePerKey_WhenMemoryStore(t *testing.T) {
+	signer, err := objectstore.NewSigner(&config.ObjectStore{Type: objectstore.TypeMemory}, &config.Oss{})
+	assert.NoError(t, err)
+	first, err := signer.Hash("uploads/a.pdf")
+	assert.NoError(t, err)
+	again, err := signer.Hash("uploads/a.pdf")
+	assert.NoError(t, err)
+	other, err := signer.Hash("uploads/b.pdf")
+	assert.NoError(t, err)
+	assert.Equal(t, first, again)
+	assert.NotEqual(t, first, other)
+
+	signer, err = objectstore.NewSigner(&config.ObjectStore{}, &config.Oss{})
+	assert.NoError(t, err)
+	_, err = signer.Hash("uploads/a.pdf")
+	assert.ErrorIs(t, err, objectstore.ErrNotConfigured)
+}
@@ -1,8 +1,11 @@
 package router
 
 import (
-	"fmt"
 	"go-web/pkg/global"
+	"go-web/pkg/metrics"
+	"go-web/pkg/middleware"
+	"go-web/pkg/tracing"
+	"log/slog"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -10,7 +13,7 @@ import (
 )
 
 func CreateEngine() *gin.Engine {
-	r := gin.Default()
+	r := gin.New()
 	configRouter(r)
 	return r
 }
@@ -27,17 +30,31 @@ func configRouter(r *gin.Engine) {
 	r.Use(cors.New(config))
 
 	r.Use(gin.CustomRecovery(global.GErrorHandler))
-	r.Use(gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
-		return fmt.Sprintf("%s - [%s] \"%s %s %s %d %s \"%s\" %s\"\n",
-			param.ClientIP,
-			param.TimeStamp.Format(time.RFC1123),
-			param.Method,
-			param.Path,
-			param.Request.Proto,
-			param.StatusCode,
-			param.Latency,
-			param.Request.UserAgent(),
-			param.ErrorMessage,
-		)
-	}))
+	r.Use(metrics.GinMiddleware())
+	r.Use(tracing.GinMiddleware())
+	r.Use(middleware.RequestId())
+	r.Use(accessLog())
+}
+
+// accessLog writes one record per request, the request id and user id come
+// from the request context.
+func accessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", c.Writer.Status(),
+			"latency", time.Since(start),
+			"client_ip", c.ClientIP(),
+			"user_agent", c.Request.UserAgent(),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "error", c.Errors.String())
+		}
+		slog.InfoContext(c.Request.Context(), "http request", attrs...)
+	}
 }
@@ -0,0 +1,210 @@
+package scheduler
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// QueueDepth is the number of tasks of a type and priority no worker took yet.
+type QueueDepth struct {
+	Type     TaskType
+	Priority TaskPriority
+	Count    int
+}
+
+// TaskStats counts the stored tasks. Finished tasks outside of batches are
+// deleted once polled, they are not counted.
+type TaskStats struct {
+	ByState map[TaskState]int
+	// Queued is sorted by type, higher priorities first
+	Queued []QueueDepth
+}
+
+// LoadBalancer is the name of the algorithm that selects workers.
+func (s *Scheduler) LoadBalancer() string {
+	return s.lbName
+}
+
+func (s *Scheduler) TaskStats() (*TaskStats, error) {
+	tasks, err := s.tm.ListTasks(TaskFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &TaskStats{ByState: map[TaskState]int{}}
+	queued := map[QueueDepth]int{}
+	for _, task := range tasks {
+		stats.ByState[task.GetState()]++
+		if task.GetState() == TaskStateCreated && len(task.GetWorkerId()) == 0 {
+			queued[QueueDepth{Type: task.GetType(), Priority: task.GetPriority()}]++
+		}
+	}
+	for depth, count := range queued {
+		depth.Count = count
+		stats.Queued = append(stats.Queued, depth)
+	}
+	sort.Slice(stats.Queued, func(i, j int) bool {
+		if stats.Queued[i].Type != stats.Queued[j].Type {
+			return stats.Queued[i].Type < stats.Queued[j].Type
+		}
+		return stats.Queued[i].Priority > stats.Queued[j].Priority
+	})
+	return stats, nil
+}
+
+// RecentFailures returns the latest failed attempts this replica saw, newest
+// first. limit 0 returns all it remembers.
+func (s *Scheduler) RecentFailures(limit int) []FailureEvent {
+	return s.failures.recent(limit)
+}
+
+// QueuedUsers returns the users whose tasks this replica holds until the
+// workers have room, higher priorities first.
+func (s *Scheduler) QueuedUsers() []QueuedUser {
+	return s.admission.heldUsers()
+}
+
+// ListTasks returns the stored tasks the filter selects, oldest first.
+func (s *Scheduler) ListTasks(filter TaskFilter) ([]Task, error) {
+	return s.tm.ListTasks(filter)
+}
+
+// TaskEvents returns the task events this replica saw after seq after,
+// oldest first. With after 0 it returns the latest limit events.
+func (s *Scheduler) TaskEvents(after int64, limit int) []TaskEvent {
+	return s.events.since(after, limit)
+}
+
+// ForceFailTask dead-letters a task that has not finished, no matter how many
+// attempts it has left. A dispatched task is cancelled on its worker.
+func (s *Scheduler) ForceFailTask(taskId string, reason string) error {
+	task, err := s.tm.GetTask(taskId)
+	if err != nil {
+		return err
+	}
+	state := task.GetState()
+	switch state {
+	case TaskStateCreated, TaskStateFailure:
+	case TaskStateRunning:
+		if err := s.tm.UpdateTaskState(taskId, TaskStateFailure); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: cannot fail a %s task", ErrInvalidStateTransition, state)
+	}
+
+	message := "failed by admin"
+	if len(reason) > 0 {
+		message += ": " + reason
+	}
+	err = s.tm.AddTaskError(taskId, TaskError{WorkerId: task.GetWorkerId(), Message: message, Time: time.Now()})
+	if err != nil {
+		return err
+	}
+	if err := s.tm.UpdateTaskState(taskId, TaskStateDeadLetter); err != nil {
+		return err
+	}
+	if err := s.tm.UpdateTaskWorker(taskId, "", ""); err != nil {
+		return err
+	}
+	s.recordFailure(task, task.GetWorkerId(), message, true)
+	slog.Warn("task failed by admin", append(taskLogAttrs(task), "reason", reason)...)
+
+	s.cancelOnWorker(task)
+	return nil
+}
+
+// ForceRequeueTask dispatches a task again, e.g. one stuck on a worker that
+// stopped answering. The former worker is asked to cancel it, dead-lettered
+// tasks are requeued like RequeueTask does.
+func (s *Scheduler) ForceRequeueTask(taskId string) error {
+	task, err := s.tm.GetTask(taskId)
+	if err != nil {
+		return err
+	}
+	state := task.GetState()
+	switch state {
+	case TaskStateDeadLetter:
+		return s.RequeueTask(taskId)
+	case TaskStateCreated:
+		// still queued, it is dispatched anyway
+		if len(task.GetWorkerId()) == 0 {
+			return nil
+		}
+	case TaskStateRunning:
+		// RUNNING only goes back to PENDING through FAILURE
+		if err := s.tm.UpdateTaskState(taskId, TaskStateFailure); err != nil {
+			return err
+		}
+	case TaskStateFailure:
+	default:
+		return fmt.Errorf("%w: cannot requeue a %s task", ErrInvalidStateTransition, state)
+	}
+
+	if err := s.tm.UpdateTaskState(taskId, TaskStateCreated); err != nil {
+		return err
+	}
+	s.cancelOnWorker(task)
+	if err := s.tm.UpdateTaskWorker(taskId, "", ""); err != nil {
+		return err
+	}
+	s.recordEvent(task, TaskEventRequeued, TaskStateCreated, "requeued by admin")
+	slog.Warn("task requeued by admin", append(taskLogAttrs(task), "worker_id", task.GetWorkerId())...)
+	return s.redispatch(taskId)
+}
+
+// EvictWorker removes a worker and requeues the tasks it had not finished,
+// it returns the ids of the requeued tasks. The worker is drained first so
+// its tasks can still be cancelled on it but go to other workers.
+func (s *Scheduler) EvictWorker(id WorkerId) ([]string, error) {
+	if err := s.wm.SetDraining(id, true); err != nil {
+		return nil, err
+	}
+	defer func() {
+		s.wm.DelWorker(id)
+		slog.Warn("worker evicted by admin", "worker_id", id)
+	}()
+
+	tasks, err := s.tm.ListTasks(TaskFilter{})
+	if err != nil {
+		return nil, err
+	}
+	requeued := []string{}
+	for _, task := range tasks {
+		if task.GetWorkerId() != id || (task.GetState() != TaskStateCreated && task.GetState() != TaskStateRunning) {
+			continue
+		}
+		if err := s.ForceRequeueTask(task.GetId()); err != nil {
+			slog.Error("requeue task of evicted worker error", append(taskLogAttrs(task), "worker_id", id, "error", err)...)
+			continue
+		}
+		requeued = append(requeued, task.GetId())
+	}
+	return requeued, nil
+}
+
+// DrainWorker stops dispatching tasks to a worker, the tasks it runs finish
+// as usual. draining false puts the worker back into rotation.
+func (s *Scheduler) DrainWorker(id WorkerId, draining bool) error {
+	if err := s.wm.SetDraining(id, draining); err != nil {
+		return err
+	}
+	slog.Warn("worker drain changed by admin", "worker_id", id, "draining", draining)
+	return nil
+}
+
+// DrainingWorkers returns the workers that take no new tasks.
+func (s *Scheduler) DrainingWorkers() (map[WorkerId]bool, error) {
+	return s.wm.DrainingWorkers()
+}
+
+func (s *Scheduler) cancelOnWorker(task Task) {
+	if len(task.GetWorkerId()) == 0 {
+		return
+	}
+	if err := s.executor.CancelTask(task.GetWorkerTaskId(), task.GetWorkerId()); err != nil {
+		slog.Warn("cancel task on worker error", append(taskLogAttrs(task), "worker_id", task.GetWorkerId(), "error", err)...)
+	}
+}
@@ -0,0 +1,133 @@
+package scheduler_test
+
+import (
+	"go-web/pkg/config"
+	"go-web/pkg/scheduler"
+	"go-web/pkg/scheduler/schedulertest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func scheduleRunningTask(t *testing.T, s *scheduler.Scheduler) scheduler.Task {
+	task := newDeadLetterTask()
+	_, err := s.Schedule(task)
+	assert.NoError(t, err)
+	schedulertest.WaitTaskState(t, s, task.GetId(), scheduler.TaskStateRunning)
+	return task
+}
+
+func TestForceFailTask_ShouldDeadLetterTask_WhenTaskRunning(t *testing.T) {
+	worker := newFakeWorker(t)
+	s := newTestScheduler(t, &config.Scheduler{}, worker)
+	task := scheduleRunningTask(t, s)
+
+	assert.NoError(t, s.ForceFailTask(task.GetId(), "stuck"))
+
+	got, err := s.GetTask(task.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, scheduler.TaskState(scheduler.TaskStateDeadLetter), got.GetState())
+	assert.Equal(t, "failed by admin: stuck", got.GetErrors()[0].Message)
+	schedulertest.AssertCancelled(t, worker, task.GetId())
+
+	failures := s.RecentFailures(1)
+	if assert.Len(t, failures, 1) {
+		assert.Equal(t, task.GetId(), failures[0].TaskId)
+		assert.True(t, failures[0].DeadLettered)
+	}
+
+	assert.ErrorIs(t, s.ForceFailTask(task.GetId(), ""), scheduler.ErrInvalidStateTransition)
+}
+
+func TestForceRequeueTask_ShouldDispatchAgain_WhenTaskRunning(t *testing.T) {
+	worker := newFakeWorker(t)
+	s := newTestScheduler(t, &config.Scheduler{}, worker)
+	task := scheduleRunningTask(t, s)
+
+	assert.NoError(t, s.ForceRequeueTask(task.GetId()))
+
+	schedulertest.AssertCancelled(t, worker, task.GetId())
+	if schedulertest.AssertSubmitted(t, worker, 2) {
+		got, err := s.GetTask(task.GetId())
+		assert.NoError(t, err)
+		assert.Equal(t, worker.Submissions()[1].WorkerTaskId, got.GetWorkerTaskId())
+	}
+}
+
+func TestEvictWorker_ShouldRequeueItsTasks_WhenWorkerEvicted(t *testing.T) {
+	w1 := newFakeWorker(t)
+	s := newTestScheduler(t, &config.Scheduler{}, w1)
+	task := scheduleRunningTask(t, s)
+
+	w2 := schedulertest.NewFakeWorker(t, "w2")
+	w2.SetBehavior(schedulertest.Hang)
+	schedulertest.Register(t, s, w2)
+
+	requeued, err := s.EvictWorker("w1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{task.GetId()}, requeued)
+	schedulertest.AssertSubmitted(t, w2, 1)
+
+	got, err := s.GetTask(task.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, scheduler.WorkerId("w2"), got.GetWorkerId())
+
+	_, err = s.EvictWorker("w1")
+	assert.ErrorIs(t, err, scheduler.ErrWorkerNotFound)
+}
+
+func TestTaskStats_ShouldCountTasksByState_WhenTasksStored(t *testing.T) {
+	s := newTestScheduler(t, &config.Scheduler{}, newFakeWorker(t))
+	scheduleRunningTask(t, s)
+	scheduleRunningTask(t, s)
+
+	stats, err := s.TaskStats()
+	assert.NoError(t, err)
+	assert.Equal(t, map[scheduler.TaskState]int{scheduler.TaskStateRunning: 2}, stats.ByState)
+	assert.Empty(t, stats.Queued)
+	assert.Equal(t, "rr", s.LoadBalancer())
+}
+
+func TestDrainWorker_ShouldSkipWorker_WhenDraining(t *testing.T) {
+	worker := newFakeWorker(t)
+	s := newTestScheduler(t, &config.Scheduler{}, worker)
+
+	assert.NoError(t, s.DrainWorker(worker.GetId(), true))
+	draining, err := s.DrainingWorkers()
+	assert.NoError(t, err)
+	assert.True(t, draining[worker.GetId()])
+	_, err = s.Schedule(newDeadLetterTask())
+	assert.ErrorIs(t, err, scheduler.ErrNoWorkerAvailable)
+
+	assert.NoError(t, s.DrainWorker(worker.GetId(), false))
+	_, err = s.Schedule(newDeadLetterTask())
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, s.DrainWorker("missing", true), scheduler.ErrWorkerNotFound)
+}
+
+func TestTaskEvents_ShouldFollowTask_WhenTaskCancelled(t *testing.T) {
+	worker := newFakeWorker(t)
+	s := newTestScheduler(t, &config.Scheduler{}, worker)
+	task := scheduleRunningTask(t, s)
+	assert.NoError(t, s.CancelTask(task.GetId()))
+
+	events := s.TaskEvents(0, 0)
+	kinds := []scheduler.TaskEventKind{}
+	for _, event := range events {
+		if event.TaskId == task.GetId() {
+			kinds = append(kinds, event.Kind)
+		}
+	}
+	assert.Equal(t, []scheduler.TaskEventKind{
+		scheduler.TaskEventCreated,
+		scheduler.TaskEventDispatched,
+		scheduler.TaskEventState,
+		scheduler.TaskEventCancelled,
+	}, kinds)
+
+	last := events[len(events)-1]
+	assert.Equal(t, []scheduler.TaskEvent{last}, s.TaskEvents(0, 1))
+	assert.Empty(t, s.TaskEvents(last.Seq, 0))
+	assert.Equal(t, events[1:2], s.TaskEvents(events[0].Seq, 1))
+}
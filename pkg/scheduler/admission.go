@@ -0,0 +1,421 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"go-web/pkg/config"
+	"go-web/pkg/metrics"
+	"log/slog"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRetryAfter is how long rejected clients are asked to wait
+	DefaultRetryAfter = 5 * time.Second
+	// holdInterval is how often held tasks are offered to the workers again
+	holdInterval = time.Second
+	// workerStatusInterval is how often the active tasks of the workers are
+	// refreshed while admission limits are set
+	workerStatusInterval = 5 * time.Second
+)
+
+// errTaskHeld tells the stream consumer that a task waits in the fair queue,
+// its message stays pending.
+var errTaskHeld = errors.New("task held until the workers have room")
+
+// AdmissionLimit caps the tasks of the scheduler or of a task type, 0 does
+// not limit.
+type AdmissionLimit struct {
+	// MaxPending is how many tasks may wait for a worker
+	MaxPending int
+	// MaxActive is how many dispatched tasks the workers may have at once
+	MaxActive int
+}
+
+type AdmissionCfg struct {
+	Limit AdmissionLimit
+	// TaskTypes limits task types on top of Limit
+	TaskTypes  map[TaskType]AdmissionLimit
+	RetryAfter time.Duration
+	// Degraded queues tasks over MaxActive instead of rejecting them
+	Degraded bool
+}
+
+func newAdmissionCfg(cfg *config.Scheduler) AdmissionCfg {
+	taskTypes := make(map[TaskType]AdmissionLimit, len(cfg.Admission.TaskTypes))
+	for taskType, limit := range cfg.Admission.TaskTypes {
+		taskTypes[TaskType(taskType)] = AdmissionLimit{MaxPending: limit.MaxPending, MaxActive: limit.MaxActive}
+	}
+	return AdmissionCfg{
+		Limit:      AdmissionLimit{MaxPending: cfg.Admission.MaxPending, MaxActive: cfg.Admission.MaxActive},
+		TaskTypes:  taskTypes,
+		RetryAfter: time.Duration(cfg.Admission.RetryAfter) * time.Second,
+		Degraded:   cfg.Admission.Degraded,
+	}
+}
+
+// checkAdmissionCfg fails on limits of task types that do not exist, they
+// would never apply.
+func checkAdmissionCfg(cfg AdmissionCfg) error {
+	for taskType := range cfg.TaskTypes {
+		if !slices.Contains(TaskTypes(), taskType) {
+			return fmt.Errorf("%w: admission limit of %s", ErrUnknownTaskType, taskType)
+		}
+	}
+	return nil
+}
+
+// OverloadError asks the client to submit a task again after RetryAfter. Err
+// is ErrQueueFull or ErrWorkersBusy when an admission limit was hit, otherwise
+// ErrNoWorkerAvailable or ErrSchedulerStopped.
+type OverloadError struct {
+	Err error
+	// TaskType is the type whose limit was hit, empty for the global limits
+	TaskType TaskType
+	// Limit is the limit that was hit, 0 when the task hit none
+	Limit      int
+	RetryAfter time.Duration
+}
+
+func (e *OverloadError) Error() string {
+	switch {
+	case e.Limit == 0:
+		return e.Err.Error()
+	case len(e.TaskType) == 0:
+		return fmt.Sprintf("%v, limit %d", e.Err, e.Limit)
+	default:
+		return fmt.Sprintf("%v, limit %d of task type %s", e.Err, e.Limit, e.TaskType)
+	}
+}
+
+func (e *OverloadError) Unwrap() error {
+	return e.Err
+}
+
+// RetryAfterSeconds is RetryAfter for the Retry-After header, at least 1.
+func (e *OverloadError) RetryAfterSeconds() int {
+	return max(1, int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// taskLoad is what the limits are checked against, queued tasks are pending
+// and dispatched ones active.
+type taskLoad struct {
+	pending       int
+	active        int
+	pendingByType map[TaskType]int
+	activeByType  map[TaskType]int
+}
+
+// admission checks new tasks against the limits. Tasks that wait for room on
+// the workers are held by the replica that took them, the users take turns in
+// a fair queue: with Degraded and the direct dispatcher the tasks over
+// MaxActive, in stream mode the tasks the consumers read while the workers
+// have no room or no worker is available, their messages stay pending. While
+// tasks are held, new tasks queue behind them.
+//
+// The limits are soft, tasks scheduled at the same time are checked against
+// the same load.
+type admission struct {
+	s       *Scheduler
+	cfg     AdmissionCfg
+	enabled bool
+	// hold is set when Schedule holds the tasks over MaxActive
+	hold bool
+	// queue is set when tasks may wait in the fair queue at all
+	queue bool
+	mu    sync.Mutex
+	held  *fairQueue
+	// releasing counts the tasks popped from held and still being dispatched
+	releasing int
+	wake      chan struct{}
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+func newAdmission(s *Scheduler, cfg AdmissionCfg, fairness FairnessCfg, directDispatch bool) *admission {
+	if cfg.RetryAfter <= 0 {
+		cfg.RetryAfter = DefaultRetryAfter
+	}
+	enabled := cfg.Limit != (AdmissionLimit{})
+	for _, limit := range cfg.TaskTypes {
+		enabled = enabled || limit != (AdmissionLimit{})
+	}
+	hold := enabled && cfg.Degraded && directDispatch
+	return &admission{
+		s:       s,
+		cfg:     cfg,
+		enabled: enabled,
+		hold:    hold,
+		queue:   hold || !directDispatch,
+		held:    newFairQueue(fairness),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+func (a *admission) start() {
+	if !a.enabled && !a.queue {
+		close(a.stopped)
+		return
+	}
+	go func() {
+		defer close(a.stopped)
+		release := time.NewTicker(holdInterval)
+		defer release.Stop()
+		// the reported active tasks only matter for the limits
+		var refresh <-chan time.Time
+		if a.enabled {
+			ticker := time.NewTicker(workerStatusInterval)
+			defer ticker.Stop()
+			refresh = ticker.C
+		}
+		for {
+			select {
+			case <-a.done:
+				return
+			case <-release.C:
+				a.release()
+			case <-a.wake:
+				a.release()
+			case <-refresh:
+				a.refreshWorkers()
+			}
+		}
+	}()
+}
+
+// releaseNow releases the held tasks right away instead of on the next tick,
+// e.g. when a new task queued behind them while the workers have room.
+func (a *admission) releaseNow() {
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stop waits for the release in progress. Held tasks stay pending in the
+// task store, with the journal store the recovery of the next start holds
+// them again.
+func (a *admission) stop() {
+	close(a.done)
+	<-a.stopped
+	if n := a.heldCount(); n > 0 {
+		slog.Warn("held tasks are left pending", "count", n)
+	}
+}
+
+func (a *admission) overloaded(err error) error {
+	return &OverloadError{Err: err, RetryAfter: a.cfg.RetryAfter}
+}
+
+// admit checks count new tasks of taskType against the limits: the queue
+// must have room for all of them, the workers for one more task. hold
+// reports that the tasks are over MaxActive in degraded mode, or that other
+// tasks are held already. Those wait in the fair queue, a new task would
+// otherwise take the room freed for them, it is held and released in turn.
+func (a *admission) admit(taskType TaskType, count int) (hold bool, err error) {
+	if !a.enabled {
+		return false, nil
+	}
+	load, err := a.load()
+	if err != nil {
+		return false, err
+	}
+
+	typeLimit := a.cfg.TaskTypes[taskType]
+	err = a.check(ErrQueueFull, "", load.pending+count, a.cfg.Limit.MaxPending)
+	if err == nil {
+		err = a.check(ErrQueueFull, taskType, load.pendingByType[taskType]+count, typeLimit.MaxPending)
+	}
+	if err == nil {
+		err = a.checkActive(taskType, load)
+		if err != nil && a.cfg.Degraded {
+			return true, nil
+		}
+	}
+	if err != nil {
+		limit := "pending"
+		if errors.Is(err, ErrWorkersBusy) {
+			limit = "active"
+		}
+		metrics.TasksRejected.WithLabelValues(string(taskType), limit).Inc()
+		return false, err
+	}
+	return a.cfg.Degraded && a.heldCount() > 0, nil
+}
+
+// checkActive fails when the workers have no room for another task of
+// taskType.
+func (a *admission) checkActive(taskType TaskType, load *taskLoad) error {
+	err := a.check(ErrWorkersBusy, "", load.active+1, a.cfg.Limit.MaxActive)
+	if err != nil {
+		return err
+	}
+	return a.check(ErrWorkersBusy, taskType, load.activeByType[taskType]+1, a.cfg.TaskTypes[taskType].MaxActive)
+}
+
+func (a *admission) check(err error, taskType TaskType, n int, limit int) error {
+	if limit <= 0 || n <= limit {
+		return nil
+	}
+	return &OverloadError{Err: err, TaskType: taskType, Limit: limit, RetryAfter: a.cfg.RetryAfter}
+}
+
+// ready fails when a queued task of taskType has to wait for room on the
+// workers.
+func (a *admission) ready(taskType TaskType) error {
+	if !a.enabled {
+		return nil
+	}
+	load, err := a.load()
+	if err != nil {
+		return err
+	}
+	return a.checkActive(taskType, load)
+}
+
+// load reads the pending and dispatched tasks from the counts the store
+// keeps. The workers may run tasks that were not dispatched here, the active
+// tasks they last reported count when they are more.
+func (a *admission) load() (*taskLoad, error) {
+	counts, err := a.s.tm.CountTasks()
+	if err != nil {
+		return nil, err
+	}
+	load := &taskLoad{pendingByType: map[TaskType]int{}, activeByType: map[TaskType]int{}}
+	dispatched := 0
+	for taskType, count := range counts {
+		load.pending += count.Queued
+		load.pendingByType[taskType] = count.Queued
+		dispatched += count.Dispatched
+		load.activeByType[taskType] = count.Dispatched
+	}
+
+	reported := 0
+	for _, worker := range a.s.wm.GetWorkers() {
+		reported += worker.Status().ActiveTasks
+	}
+	load.active = max(dispatched, reported)
+	return load, nil
+}
+
+func (a *admission) refreshWorkers() {
+	for _, worker := range a.s.wm.GetWorkers() {
+		if err := worker.CheckStatus(); err != nil {
+			slog.Debug("check worker status error", "worker_id", worker.GetId(), "error", err)
+		}
+	}
+}
+
+// holdTask queues a task until the workers have room, a task held already
+// keeps its place.
+func (a *admission) holdTask(task Task) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.held.has(task.GetId()) {
+		return
+	}
+	a.held.push(queuedTask{
+		id:       task.GetId(),
+		taskType: task.GetType(),
+		userId:   task.GetUserId(),
+		priority: task.GetPriority(),
+	})
+}
+
+func (a *admission) heldCount() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.held.len() + a.releasing
+}
+
+func (a *admission) heldUsers() []QueuedUser {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.held.users()
+}
+
+// release dispatches the held tasks the workers have room for in the order
+// of the fair queue. Tasks cancelled or expired in the meantime are dropped. A
+// failed dispatch counts as an attempt of the task, it is tried again on the
+// next release until it runs out of attempts.
+func (a *admission) release() {
+	if a.heldCount() == 0 {
+		return
+	}
+	load, err := a.load()
+	if err != nil {
+		slog.Error("load tasks for held tasks error", "error", err)
+		return
+	}
+	fits := func(task queuedTask) bool {
+		return a.checkActive(task.taskType, load) == nil
+	}
+
+	for {
+		a.mu.Lock()
+		next, ok := a.held.pop(fits)
+		if ok {
+			a.releasing++
+		}
+		a.mu.Unlock()
+		if !ok {
+			return
+		}
+
+		task, more := a.dispatchHeld(next)
+		a.mu.Lock()
+		a.releasing--
+		a.mu.Unlock()
+		if !more {
+			return
+		}
+		if task != nil {
+			load.active++
+			load.activeByType[task.GetType()]++
+		}
+	}
+}
+
+// dispatchHeld dispatches a task popped from held and returns it when it was
+// dispatched, more is false once the release has to wait for the next turn.
+func (a *admission) dispatchHeld(next queuedTask) (dispatched Task, more bool) {
+	task, err := a.s.tm.GetTask(next.id)
+	if err != nil {
+		if errors.Is(err, ErrTaskNotFound) {
+			return nil, true
+		}
+		a.putBack(next)
+		return nil, false
+	}
+	if task.GetState() != TaskStateCreated || len(task.GetWorkerId()) > 0 {
+		return nil, true
+	}
+
+	// the message of a task from the stream stays pending, it is claimed
+	// again once idle and accepted then
+	_, err = a.s.dispatchWithRetry(task)
+	if errors.Is(err, ErrNoWorkerAvailable) {
+		a.putBack(next)
+		return nil, false
+	}
+	if err != nil {
+		slog.Warn("dispatch held task error", append(taskLogAttrs(task), "error", err)...)
+		if a.s.retryUndispatched(task, err) {
+			a.putBack(next)
+			return nil, false
+		}
+		return nil, true
+	}
+	return task, true
+}
+
+func (a *admission) putBack(task queuedTask) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.held.pushFront(task)
+}
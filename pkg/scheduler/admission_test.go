@@ -0,0 +1,205 @@
+package scheduler_test
+
+import (
+	"go-web/pkg/config"
+	"go-web/pkg/scheduler"
+	"go-web/pkg/scheduler/schedulertest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedule_ShouldRejectTask_WhenWorkersBusy(t *testing.T) {
+	s := newTestScheduler(t, &config.Scheduler{
+		Admission: config.Admission{
+			TaskTypes:  map[string]config.AdmissionLimit{"pdf": {MaxActive: 1}},
+			RetryAfter: 10,
+		},
+	}, newFakeWorker(t))
+	scheduleRunningTask(t, s)
+
+	task := newDeadLetterTask()
+	_, err := s.Schedule(task)
+	assert.ErrorIs(t, err, scheduler.ErrWorkersBusy)
+	var overloadErr *scheduler.OverloadError
+	if assert.ErrorAs(t, err, &overloadErr) {
+		assert.Equal(t, scheduler.TaskTypePdf, overloadErr.TaskType)
+		assert.Equal(t, 10*time.Second, overloadErr.RetryAfter)
+	}
+	_, err = s.GetTask(task.GetId())
+	assert.ErrorIs(t, err, scheduler.ErrTaskNotFound)
+}
+
+func TestSchedule_ShouldHoldTask_WhenDegradedAndWorkersBusy(t *testing.T) {
+	worker := newFakeWorker(t)
+	s := newTestScheduler(t, &config.Scheduler{
+		Admission: config.Admission{MaxPending: 1, MaxActive: 1, Degraded: true},
+	}, worker)
+	running := scheduleRunningTask(t, s)
+
+	held := newDeadLetterTask()
+	_, err := s.Schedule(held)
+	assert.NoError(t, err)
+	got, err := s.GetTask(held.GetId())
+	assert.NoError(t, err)
+	assert.Empty(t, got.GetWorkerId())
+
+	_, err = s.Schedule(newDeadLetterTask())
+	assert.ErrorIs(t, err, scheduler.ErrQueueFull)
+	assert.Error(t, s.Admit(scheduler.TaskTypePdf, 1))
+
+	assert.NoError(t, s.CancelTask(running.GetId()))
+	schedulertest.AssertSubmitted(t, worker, 2)
+	schedulertest.WaitTaskState(t, s, held.GetId(), scheduler.TaskStateRunning)
+}
+
+func TestSchedule_ShouldDeadLetterHeldTask_WhenEveryAttemptRejected(t *testing.T) {
+	worker := newFakeWorker(t)
+	s := newTestScheduler(t, &config.Scheduler{
+		Admission: config.Admission{MaxActive: 1, Degraded: true},
+		Retry:     config.Retry{MaxAttempts: 2},
+	}, worker)
+	running := scheduleRunningTask(t, s)
+	held := newDeadLetterTask()
+	_, err := s.Schedule(held)
+	assert.NoError(t, err)
+
+	worker.SetBehavior(schedulertest.Reject)
+	assert.NoError(t, s.CancelTask(running.GetId()))
+	schedulertest.WaitTaskState(t, s, held.GetId(), scheduler.TaskStateDeadLetter)
+	got, err := s.GetTask(held.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, got.GetAttempts())
+	assert.Empty(t, s.QueuedUsers())
+}
+
+func TestSchedule_ShouldAskToRetry_WhenNoWorker(t *testing.T) {
+	s := newTestScheduler(t, &config.Scheduler{}, nil)
+
+	_, err := s.Schedule(newDeadLetterTask())
+	assert.ErrorIs(t, err, scheduler.ErrNoWorkerAvailable)
+	var overloadErr *scheduler.OverloadError
+	if assert.ErrorAs(t, err, &overloadErr) {
+		assert.Equal(t, 5, overloadErr.RetryAfterSeconds())
+	}
+}
+
+func TestNewScheduler_ShouldFail_WhenAdmissionLimitsUnknownType(t *testing.T) {
+	_, err := scheduler.NewScheduler(&config.Scheduler{
+		Admission: config.Admission{TaskTypes: map[string]config.AdmissionLimit{"video": {MaxActive: 1}}},
+	})
+	assert.ErrorIs(t, err, scheduler.ErrUnknownTaskType)
+}
+
+func newUserTask(userId string) scheduler.Task {
+	return scheduler.NewTaskBuilder().
+		SetType(scheduler.TaskTypePdf).
+		SetSubType(scheduler.SubTaskTypePdf2Img).
+		SetUserId(userId).
+		SetUserDef(map[string]interface{}{"file_id": "f1"}).
+		Build()
+}
+
+func TestSchedule_ShouldTakeTurnsByWeight_WhenUsersWait(t *testing.T) {
+	worker := newFakeWorker(t)
+	s := newTestScheduler(t, &config.Scheduler{
+		Admission: config.Admission{MaxActive: 1, Degraded: true},
+		Fairness: config.Fairness{
+			Tiers: map[string]int{"free": 1, "pro": 2},
+			Users: map[string]string{"a": "pro"},
+		},
+	}, worker)
+	runningId := scheduleRunningTask(t, s).GetId()
+
+	tasks := map[string]string{}
+	for _, userId := range []string{"a", "a", "a", "b"} {
+		task := newUserTask(userId)
+		_, err := s.Schedule(task)
+		assert.NoError(t, err)
+		tasks[task.GetId()] = userId
+	}
+	assert.Equal(t, []scheduler.QueuedUser{
+		{UserId: "a", Tier: "pro", Weight: 2, Queued: 3},
+		{UserId: "b", Weight: 1, Queued: 1},
+	}, s.QueuedUsers())
+
+	// a gets two tasks on its turn, b one
+	order := []string{}
+	for i := 2; i <= 4; i++ {
+		assert.NoError(t, s.CancelTask(runningId))
+		if !schedulertest.AssertSubmitted(t, worker, i) {
+			return
+		}
+		submitted := worker.Submissions()[i-1]
+		order = append(order, tasks[submitted.TaskId])
+		runningId = submitted.TaskId
+	}
+	assert.Equal(t, []string{"a", "a", "b"}, order)
+	assert.Equal(t, []scheduler.QueuedUser{
+		{UserId: "a", Tier: "pro", Weight: 2, Queued: 1, Dispatched: 2},
+	}, s.QueuedUsers())
+}
+
+func TestNewScheduler_ShouldFail_WhenTierHasNoWeight(t *testing.T) {
+	_, err := scheduler.NewScheduler(&config.Scheduler{
+		Fairness: config.Fairness{Users: map[string]string{"a": "pro"}},
+	})
+	assert.ErrorContains(t, err, "tier pro of user a has no weight")
+}
+
+func TestSchedule_ShouldQueueBehindHeldTasks_WhenWorkersFreeUp(t *testing.T) {
+	worker := newFakeWorker(t)
+	s := newTestScheduler(t, &config.Scheduler{
+		Admission: config.Admission{MaxActive: 1, Degraded: true},
+	}, worker)
+	running := scheduleRunningTask(t, s)
+	held := newUserTask("a")
+	_, err := s.Schedule(held)
+	assert.NoError(t, err)
+
+	// the room freed for the held task is not taken by a new one, the new
+	// task releases the held one without waiting for the next tick
+	assert.NoError(t, s.CancelTask(running.GetId()))
+	_, err = s.Schedule(newUserTask("b"))
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return len(worker.Submissions()) == 2
+	}, 500*time.Millisecond, 10*time.Millisecond)
+	if schedulertest.AssertSubmitted(t, worker, 2) {
+		assert.Equal(t, held.GetId(), worker.Submissions()[1].TaskId)
+	}
+}
+
+func TestSchedule_ShouldTakeTurns_WhenStreamDispatchWaitsForWorker(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s := newTestScheduler(t, &config.Scheduler{
+		Dispatch: config.Dispatch{Mode: scheduler.DispatchModeStream},
+		Redis:    config.RedisStore{ClusterMode: scheduler.RedisClusterModeStandalone, Addrs: []string{mr.Addr()}},
+	}, nil)
+
+	tasks := map[string]string{}
+	for _, userId := range []string{"a", "a", "b"} {
+		task := newUserTask(userId)
+		_, err := s.Schedule(task)
+		assert.NoError(t, err)
+		tasks[task.GetId()] = userId
+	}
+	assert.Eventually(t, func() bool {
+		users := s.QueuedUsers()
+		return len(users) == 2 && users[0].Queued == 2 && users[1].Queued == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	worker := newFakeWorker(t)
+	schedulertest.Register(t, s, worker)
+	if !schedulertest.AssertSubmitted(t, worker, 3) {
+		return
+	}
+	order := []string{}
+	for _, submitted := range worker.Submissions() {
+		order = append(order, tasks[submitted.TaskId])
+	}
+	assert.Equal(t, []string{"a", "b", "a"}, order)
+}
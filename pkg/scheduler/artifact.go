@@ -0,0 +1,74 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+)
+
+// Artifact is an output file a worker uploaded to the object store. Workers
+// report them as the data of a DONE task:
+//
+//	{"artifacts": [{"key": "artifacts/<task id>/page-1.png", "name": "page-1.png"}]}
+type Artifact struct {
+	// Key is the object key, it must start with the ArtifactPrefix of the task
+	Key         string `json:"key"`
+	Name        string `json:"name,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+}
+
+type ArtifactResult struct {
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+// ArtifactPrefix is where workers upload the outputs of a task, it is sent as
+// artifact_prefix on dispatch.
+func ArtifactPrefix(taskId string) string {
+	return "artifacts/" + taskId + "/"
+}
+
+// TaskArtifacts returns the artifacts of a DONE task result. Results of older
+// workers carry no artifacts, keys outside the prefix of the task, or of the
+// task a cached result came from, are dropped so a worker cannot hand out
+// other objects.
+func TaskArtifacts(result *TaskResult) []Artifact {
+	if result.Status != TaskStateDone || result.Data == nil {
+		return nil
+	}
+
+	var artifactResult ArtifactResult
+	switch data := result.Data.(type) {
+	case ArtifactResult:
+		artifactResult = data
+	case *ArtifactResult:
+		artifactResult = *data
+	default:
+		// results of remote workers are decoded json
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return nil
+		}
+		if err := json.Unmarshal(raw, &artifactResult); err != nil {
+			return nil
+		}
+	}
+
+	prefixTaskId := result.TaskId
+	if len(result.CachedFrom) > 0 {
+		prefixTaskId = result.CachedFrom
+	}
+	prefix := ArtifactPrefix(prefixTaskId)
+	artifacts := make([]Artifact, 0, len(artifactResult.Artifacts))
+	for _, artifact := range artifactResult.Artifacts {
+		if !strings.HasPrefix(artifact.Key, prefix) || strings.Contains(artifact.Key, "..") {
+			slog.Warn("drop artifact outside task prefix", "task_id", result.TaskId, "key", artifact.Key)
+			continue
+		}
+		if len(artifact.Name) == 0 {
+			artifact.Name = strings.TrimPrefix(artifact.Key, prefix)
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	return artifacts
+}
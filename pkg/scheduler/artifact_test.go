@@ -0,0 +1,50 @@
+package scheduler_test
+
+import (
+	"go-web/pkg/scheduler"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskArtifacts_ShouldReadDecodedResult_WhenTaskDone(t *testing.T) {
+	result := &scheduler.TaskResult{
+		TaskId: "t1",
+		Status: scheduler.TaskStateDone,
+		Data: map[string]interface{}{
+			"artifacts": []interface{}{
+				map[string]interface{}{"key": "artifacts/t1/page-1.png", "content_type": "image/png", "size": 42},
+				map[string]interface{}{"key": "artifacts/t1/page-2.png"},
+			},
+		},
+	}
+
+	artifacts := scheduler.TaskArtifacts(result)
+	if assert.Len(t, artifacts, 2) {
+		assert.Equal(t, scheduler.Artifact{Key: "artifacts/t1/page-1.png", Name: "page-1.png", ContentType: "image/png", Size: 42}, artifacts[0])
+		assert.Equal(t, "page-2.png", artifacts[1].Name)
+	}
+}
+
+func TestTaskArtifacts_ShouldDropKey_WhenOutsideTaskPrefix(t *testing.T) {
+	result := &scheduler.TaskResult{
+		TaskId: "t1",
+		Status: scheduler.TaskStateDone,
+		Data: scheduler.ArtifactResult{Artifacts: []scheduler.Artifact{
+			{Key: "artifacts/t2/page-1.png"},
+			{Key: "artifacts/t1/../t2/page-1.png"},
+			{Key: "artifacts/t1/page-1.png"},
+		}},
+	}
+
+	artifacts := scheduler.TaskArtifacts(result)
+	if assert.Len(t, artifacts, 1) {
+		assert.Equal(t, "artifacts/t1/page-1.png", artifacts[0].Key)
+	}
+}
+
+func TestTaskArtifacts_ShouldReturnNothing_WhenResultIsNotArtifacts(t *testing.T) {
+	assert.Empty(t, scheduler.TaskArtifacts(&scheduler.TaskResult{TaskId: "t1", Status: scheduler.TaskStateDone, Data: "/home/worker/t1.png"}))
+	assert.Empty(t, scheduler.TaskArtifacts(&scheduler.TaskResult{TaskId: "t1", Status: scheduler.TaskStateRunning,
+		Data: scheduler.ArtifactResult{Artifacts: []scheduler.Artifact{{Key: "artifacts/t1/page-1.png"}}}}))
+}
@@ -0,0 +1,185 @@
+package scheduler_test
+
+import (
+	"go-web/pkg/config"
+	"go-web/pkg/scheduler"
+	"go-web/pkg/scheduler/schedulertest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newFakeWorker returns worker "w1", its tasks keep running unless a test
+// says otherwise.
+func newFakeWorker(t *testing.T) *schedulertest.FakeWorker {
+	w := schedulertest.NewFakeWorker(t, "w1")
+	w.SetBehavior(schedulertest.Hang)
+	return w
+}
+
+func newDeadLetterScheduler(t *testing.T, worker *schedulertest.FakeWorker) *scheduler.Scheduler {
+	return newTestScheduler(t, &config.Scheduler{
+		Retry: config.Retry{MaxAttempts: 2},
+	}, worker)
+}
+
+func newTestScheduler(t *testing.T, cfg *config.Scheduler, worker *schedulertest.FakeWorker) *scheduler.Scheduler {
+	s := schedulertest.NewScheduler(t, cfg)
+	if worker != nil {
+		schedulertest.Register(t, s, worker)
+	}
+	return s
+}
+
+func newDeadLetterTask() scheduler.Task {
+	return scheduler.NewTaskBuilder().
+		SetType(scheduler.TaskTypePdf).
+		SetSubType(scheduler.SubTaskTypePdf2Img).
+		SetUserDef(map[string]interface{}{"file_id": "f1"}).
+		Build()
+}
+
+func TestSchedule_ShouldDeadLetterTask_WhenEveryAttemptRejected(t *testing.T) {
+	worker := newFakeWorker(t)
+	worker.SetBehavior(schedulertest.Reject)
+	s := newDeadLetterScheduler(t, worker)
+
+	task := newDeadLetterTask()
+	_, err := s.Schedule(task)
+	assert.ErrorIs(t, err, scheduler.ErrTaskDeadLettered)
+	assert.Len(t, worker.Submissions(), 2)
+
+	tasks, err := s.ListDeadLetters(0)
+	assert.NoError(t, err)
+	if assert.Len(t, tasks, 1) {
+		assert.Equal(t, task.GetId(), tasks[0].GetId())
+		assert.Equal(t, 2, tasks[0].GetAttempts())
+		assert.Len(t, tasks[0].GetErrors(), 2)
+	}
+}
+
+func TestRequeueTask_ShouldDispatchWithFreshAttempts_WhenWorkerFixed(t *testing.T) {
+	worker := newFakeWorker(t)
+	worker.SetBehavior(schedulertest.Reject)
+	s := newDeadLetterScheduler(t, worker)
+	task := newDeadLetterTask()
+	_, err := s.Schedule(task)
+	assert.ErrorIs(t, err, scheduler.ErrTaskDeadLettered)
+
+	worker.SetBehavior(schedulertest.Hang)
+	assert.NoError(t, s.RequeueTask(task.GetId()))
+
+	got, err := s.GetTask(task.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, scheduler.TaskState(scheduler.TaskStateCreated), got.GetState())
+	assert.Equal(t, scheduler.WorkerId("w1"), got.GetWorkerId())
+	assert.Equal(t, 0, got.GetAttempts())
+	assert.Len(t, got.GetErrors(), 2)
+
+	assert.ErrorIs(t, s.RequeueTask(task.GetId()), scheduler.ErrTaskNotDeadLettered)
+}
+
+func TestUpdateTaskState_ShouldRetryThenDeadLetter_WhenWorkerReportsFailure(t *testing.T) {
+	worker := newFakeWorker(t)
+	s := newDeadLetterScheduler(t, worker)
+	task := newDeadLetterTask()
+	_, err := s.Schedule(task)
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.UpdateTaskState(task.GetId(), "w1", scheduler.TaskStateFailure, "corrupt pdf"))
+	got, err := s.GetTask(task.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, scheduler.TaskState(scheduler.TaskStateCreated), got.GetState())
+	assert.Equal(t, 1, got.GetAttempts())
+	assert.Len(t, worker.Submissions(), 2)
+
+	assert.NoError(t, s.UpdateTaskState(task.GetId(), "w1", scheduler.TaskStateFailure, "corrupt pdf"))
+	got, err = s.GetTask(task.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, scheduler.TaskState(scheduler.TaskStateDeadLetter), got.GetState())
+	if assert.Len(t, got.GetErrors(), 2) {
+		assert.Equal(t, "corrupt pdf", got.GetErrors()[1].Message)
+		assert.Equal(t, scheduler.WorkerId("w1"), got.GetErrors()[1].WorkerId)
+	}
+}
+
+func TestGetTaskStatus_ShouldReportDeadLetter_WhenWorkerDroppedTask(t *testing.T) {
+	worker := newFakeWorker(t)
+	s := newTestScheduler(t, &config.Scheduler{Retry: config.Retry{MaxAttempts: 1}}, worker)
+	task := newDeadLetterTask()
+	_, err := s.Schedule(task)
+	assert.NoError(t, err)
+	assert.NoError(t, s.UpdateTaskState(task.GetId(), "w1", scheduler.TaskStateFailure, "corrupt pdf"))
+	worker.SetBehavior(schedulertest.Unhealthy)
+
+	result, err := s.GetTaskStatus(task.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, scheduler.TaskState(scheduler.TaskStateDeadLetter), result.Status)
+	got, err := s.GetTask(task.GetId())
+	assert.NoError(t, err)
+	assert.Empty(t, got.GetWorkerId())
+}
+
+func TestUpdateTaskState_ShouldRejectSchedulerStates_WhenReportedByWorker(t *testing.T) {
+	worker := newFakeWorker(t)
+	s := newDeadLetterScheduler(t, worker)
+	task := newDeadLetterTask()
+	_, err := s.Schedule(task)
+	assert.NoError(t, err)
+
+	err = s.UpdateTaskState(task.GetId(), "w1", scheduler.TaskStateDeadLetter, "")
+	assert.ErrorIs(t, err, scheduler.ErrInvalidStateTransition)
+	err = s.UpdateTaskState(task.GetId(), "w1", scheduler.TaskStateCreated, "")
+	assert.ErrorIs(t, err, scheduler.ErrInvalidStateTransition)
+}
+
+func TestUpdateTaskState_ShouldRejectReport_WhenWorkerNoLongerRunsTask(t *testing.T) {
+	worker := newFakeWorker(t)
+	s := newDeadLetterScheduler(t, worker)
+	task := newDeadLetterTask()
+	_, err := s.Schedule(task)
+	assert.NoError(t, err)
+
+	err = s.UpdateTaskState(task.GetId(), "w-old", scheduler.TaskStateFailure, "stale")
+	assert.ErrorIs(t, err, scheduler.ErrStaleTaskReport)
+	got, err := s.GetTask(task.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, scheduler.TaskState(scheduler.TaskStateCreated), got.GetState())
+	assert.Equal(t, 0, got.GetAttempts())
+}
+
+func TestUpdateTaskState_ShouldHandleFailureOnce_WhenReportedTwice(t *testing.T) {
+	worker := newFakeWorker(t)
+	s := newDeadLetterScheduler(t, worker)
+	task := newDeadLetterTask()
+	_, err := s.Schedule(task)
+	assert.NoError(t, err)
+	assert.NoError(t, s.UpdateTaskState(task.GetId(), "w1", scheduler.TaskStateRunning, ""))
+	// the retry cannot reach the worker, a late report cannot hit the next
+	// attempt
+	worker.SetBehavior(schedulertest.Unhealthy)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.UpdateTaskState(task.GetId(), "w1", scheduler.TaskStateFailure, "corrupt pdf")
+			if err != nil {
+				assert.ErrorIs(t, err, scheduler.ErrStaleTaskReport)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := s.GetTask(task.GetId())
+	assert.NoError(t, err)
+	reported := 0
+	for _, taskErr := range got.GetErrors() {
+		if taskErr.Message == "corrupt pdf" {
+			reported++
+		}
+	}
+	assert.Equal(t, 1, reported)
+}
@@ -0,0 +1,47 @@
+package scheduler
+
+const (
+	// DispatchModeDirect sends a task to a worker inside Schedule
+	DispatchModeDirect = "direct"
+	// DispatchModeStream queues a task in a redis stream, dispatcher
+	// goroutines send it to a worker later
+	DispatchModeStream = "stream"
+)
+
+// DispatchFunc sends a stored task to a worker and records the worker on the
+// task.
+type DispatchFunc func(task Task) (TaskFuture, error)
+
+// Dispatcher hands stored tasks over for execution.
+type Dispatcher interface {
+	Start() error
+	Stop() error
+	Dispatch(task Task) (TaskFuture, error)
+}
+
+// taskCompleter is a Dispatcher that keeps a task queued until it finished.
+type taskCompleter interface {
+	Complete(task Task)
+}
+
+type directDispatcher struct {
+	dispatch DispatchFunc
+}
+
+func NewDirectDispatcher(dispatch DispatchFunc) Dispatcher {
+	return &directDispatcher{
+		dispatch: dispatch,
+	}
+}
+
+func (d *directDispatcher) Start() error {
+	return nil
+}
+
+func (d *directDispatcher) Stop() error {
+	return nil
+}
+
+func (d *directDispatcher) Dispatch(task Task) (TaskFuture, error) {
+	return d.dispatch(task)
+}
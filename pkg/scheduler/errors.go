@@ -5,5 +5,22 @@ import "errors"
 var (
 	ErrInvalidTask    = errors.New("task is invalid")
 	ErrTaskNotFound   = errors.New("task not found")
+	ErrBatchNotFound  = errors.New("batch not found")
 	ErrWorkerNotFound = errors.New("worker not found")
+
+	ErrInvalidStateTransition = errors.New("invalid task state transition")
+	ErrNoWorkerAvailable      = errors.New("no worker available")
+	ErrTaskDeadLettered       = errors.New("task is dead-lettered")
+	ErrTaskNotDeadLettered    = errors.New("task is not dead-lettered")
+	ErrSchedulerStopped       = errors.New("scheduler is shutting down")
+	ErrQueueFull              = errors.New("task queue is full")
+	ErrWorkersBusy            = errors.New("workers are busy")
+	// ErrStaleFencingToken rejects a write of a leader that was replaced
+	ErrStaleFencingToken = errors.New("fencing token is stale")
+	// ErrTaskStateChanged rejects a state swap that lost against another
+	// change of the task
+	ErrTaskStateChanged = errors.New("task state changed")
+	// ErrStaleTaskReport rejects a state reported by a worker that no longer
+	// runs the task
+	ErrStaleTaskReport = errors.New("task is run by another worker")
 )
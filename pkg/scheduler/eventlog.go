@@ -0,0 +1,132 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultFailureLogSize is how many failures a replica remembers.
+	DefaultFailureLogSize = 100
+	// DefaultEventLogSize is how many task events a replica remembers.
+	DefaultEventLogSize = 1000
+)
+
+// FailureEvent is a failed attempt of a task as this replica saw it.
+type FailureEvent struct {
+	TaskId   string
+	Type     TaskType
+	SubType  SubTaskType
+	WorkerId WorkerId
+	Message  string
+	// DeadLettered is set when the attempt was the last one
+	DeadLettered bool
+	Time         time.Time
+}
+
+type TaskEventKind string
+
+const (
+	TaskEventCreated      TaskEventKind = "created"
+	TaskEventDispatched   TaskEventKind = "dispatched"
+	TaskEventState        TaskEventKind = "state"
+	TaskEventFailed       TaskEventKind = "failed"
+	TaskEventDeadLettered TaskEventKind = "dead_lettered"
+	TaskEventCancelled    TaskEventKind = "cancelled"
+	TaskEventRequeued     TaskEventKind = "requeued"
+)
+
+// TaskEvent is something that happened to a task on this replica. Seq grows
+// by one per event, readers pass the last Seq they saw to get newer events.
+type TaskEvent struct {
+	Seq      int64
+	TaskId   string
+	Type     TaskType
+	SubType  SubTaskType
+	WorkerId WorkerId
+	Kind     TaskEventKind
+	// State is the state the task moved to, if any
+	State   TaskState
+	Message string
+	Time    time.Time
+}
+
+// ringLog is a ring buffer of the latest entries, it only lives in memory so
+// every replica has its own.
+type ringLog[T any] struct {
+	mu      sync.Mutex
+	entries []T
+	next    int
+	full    bool
+}
+
+func newRingLog[T any](size int) *ringLog[T] {
+	return &ringLog[T]{entries: make([]T, size)}
+}
+
+func (l *ringLog[T]) add(entry T) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[l.next] = entry
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// recent returns up to limit entries, newest first. limit 0 returns all.
+func (l *ringLog[T]) recent(limit int) []T {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := l.next
+	if l.full {
+		n = len(l.entries)
+	}
+	if limit > 0 && limit < n {
+		n = limit
+	}
+	entries := make([]T, n)
+	for i := 0; i < n; i++ {
+		entries[i] = l.entries[(l.next-1-i+len(l.entries))%len(l.entries)]
+	}
+	return entries
+}
+
+// eventLog numbers task events and keeps the latest of them.
+type eventLog struct {
+	mu     sync.Mutex
+	seq    int64
+	events *ringLog[TaskEvent]
+}
+
+func newEventLog(size int) *eventLog {
+	return &eventLog{events: newRingLog[TaskEvent](size)}
+}
+
+func (l *eventLog) add(event TaskEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	event.Seq = l.seq
+	l.events.add(event)
+}
+
+// since returns events newer than seq after, oldest first. With after 0 it
+// returns the latest limit events, otherwise the first limit events after it.
+// limit 0 returns all of them.
+func (l *eventLog) since(after int64, limit int) []TaskEvent {
+	recent := l.events.recent(0)
+	events := make([]TaskEvent, 0, len(recent))
+	for i := len(recent) - 1; i >= 0; i-- {
+		if recent[i].Seq > after {
+			events = append(events, recent[i])
+		}
+	}
+	if limit <= 0 || limit >= len(events) {
+		return events
+	}
+	if after == 0 {
+		return events[len(events)-limit:]
+	}
+	return events[:limit]
+}
@@ -1,6 +1,7 @@
 package scheduler
 
 import (
+	"context"
 	"errors"
 	"sync/atomic"
 )
@@ -9,7 +10,8 @@ type Executor interface {
 	Start() error
 	Stop() error
 	Execute(task Task) (TaskFuture, error)
-	GetTaskStatus(taskId string, workerId WorkerId) (*WorkerTaskResult, error)
+	GetTaskStatus(ctx context.Context, taskId string, workerId WorkerId) (*WorkerTaskResult, error)
+	CancelTask(taskId string, workerId WorkerId) error
 	HandleTaskCompletion(worker Worker, task Task, err error)
 }
 
@@ -39,9 +41,9 @@ func (s *defaultScheduler) Stop() error {
 }
 
 func (s *defaultScheduler) Execute(task Task) (TaskFuture, error) {
-	w := s.wm.SelectWorker()
+	w := s.wm.SelectWorker(task.GetSubType())
 	if w == nil {
-		return nil, errors.New("no worker available")
+		return nil, ErrNoWorkerAvailable
 	}
 
 	future, err := w.Exec(task)
@@ -56,11 +58,20 @@ func (s *defaultScheduler) HandleTaskCompletion(worker Worker, task Task, err er
 
 }
 
-func (s *defaultScheduler) GetTaskStatus(taskId string, workerId WorkerId) (*WorkerTaskResult, error) {
+func (s *defaultScheduler) GetTaskStatus(ctx context.Context, taskId string, workerId WorkerId) (*WorkerTaskResult, error) {
 	worker, err := s.wm.GetWorker(workerId)
 	if err != nil {
 		return nil, err
 	}
 
-	return worker.GetTaskStatus(taskId)
+	return worker.GetTaskStatus(ctx, taskId)
+}
+
+func (s *defaultScheduler) CancelTask(taskId string, workerId WorkerId) error {
+	worker, err := s.wm.GetWorker(workerId)
+	if err != nil {
+		return err
+	}
+
+	return worker.Cancel(taskId)
 }
@@ -0,0 +1,247 @@
+package scheduler
+
+import (
+	"fmt"
+	"go-web/pkg/config"
+	"slices"
+	"sort"
+)
+
+// FairnessCfg weighs users against each other while their tasks wait for
+// room on the workers.
+type FairnessCfg struct {
+	// Tiers maps a tier to its weight
+	Tiers map[string]int
+	// Users maps a user id to its tier
+	Users map[string]string
+	// DefaultTier is the tier of the users not in Users
+	DefaultTier string
+}
+
+func newFairnessCfg(cfg *config.Scheduler) FairnessCfg {
+	return FairnessCfg{
+		Tiers:       cfg.Fairness.Tiers,
+		Users:       cfg.Fairness.Users,
+		DefaultTier: cfg.Fairness.DefaultTier,
+	}
+}
+
+// CheckFairnessCfg fails on weights below 1 and on tiers without a weight.
+func CheckFairnessCfg(cfg FairnessCfg) error {
+	for tier, weight := range cfg.Tiers {
+		if weight < 1 {
+			return fmt.Errorf("weight of tier %s must be at least 1", tier)
+		}
+	}
+	if _, ok := cfg.Tiers[cfg.DefaultTier]; len(cfg.DefaultTier) > 0 && !ok {
+		return fmt.Errorf("default tier %s has no weight", cfg.DefaultTier)
+	}
+	for userId, tier := range cfg.Users {
+		if _, ok := cfg.Tiers[tier]; !ok {
+			return fmt.Errorf("tier %s of user %s has no weight", tier, userId)
+		}
+	}
+	return nil
+}
+
+// tier returns the tier of a user and its weight, users without a tier
+// weigh 1.
+func (cfg FairnessCfg) tier(userId string) (string, int) {
+	tier, ok := cfg.Users[userId]
+	if !ok {
+		tier = cfg.DefaultTier
+	}
+	if weight, ok := cfg.Tiers[tier]; ok {
+		return tier, weight
+	}
+	return tier, 1
+}
+
+// QueuedUser is how far the waiting tasks of a user at a priority got.
+type QueuedUser struct {
+	Priority TaskPriority
+	UserId   string
+	Tier     string
+	Weight   int
+	Queued   int
+	// Dispatched counts the tasks taken from the queue since the user started
+	// waiting, cancelled ones included
+	Dispatched int
+}
+
+type queuedTask struct {
+	id       string
+	taskType TaskType
+	userId   string
+	priority TaskPriority
+}
+
+type userQueue struct {
+	tier       string
+	weight     int
+	tasks      []queuedTask
+	deficit    int
+	dispatched int
+}
+
+// fairLevel takes turns between the users waiting at one priority by
+// deficit round robin: on its turn a user earns its weight in tasks.
+type fairLevel struct {
+	users map[string]*userQueue
+	// ring holds the waiting users in turn order
+	ring   []string
+	cursor int
+	// earned is set once the user at cursor got its weight for this turn
+	earned bool
+}
+
+// fairQueue orders waiting tasks, higher priorities go first and the users of
+// a priority take turns. It is not safe for concurrent use.
+type fairQueue struct {
+	cfg    FairnessCfg
+	levels map[TaskPriority]*fairLevel
+	ids    map[string]bool
+	size   int
+}
+
+func newFairQueue(cfg FairnessCfg) *fairQueue {
+	return &fairQueue{cfg: cfg, levels: map[TaskPriority]*fairLevel{}, ids: map[string]bool{}}
+}
+
+func (q *fairQueue) len() int {
+	return q.size
+}
+
+// has reports whether a task waits in the queue.
+func (q *fairQueue) has(id string) bool {
+	return q.ids[id]
+}
+
+func (q *fairQueue) push(task queuedTask) {
+	level, ok := q.levels[task.priority]
+	if !ok {
+		level = &fairLevel{users: map[string]*userQueue{}}
+		q.levels[task.priority] = level
+	}
+	user, ok := level.users[task.userId]
+	if !ok {
+		tier, weight := q.cfg.tier(task.userId)
+		user = &userQueue{tier: tier, weight: weight}
+		level.users[task.userId] = user
+		level.ring = append(level.ring, task.userId)
+	}
+	user.tasks = append(user.tasks, task)
+	q.ids[task.id] = true
+	q.size++
+}
+
+// pushFront puts back a task pop returned, it goes first on the next turn
+// of its user.
+func (q *fairQueue) pushFront(task queuedTask) {
+	var user *userQueue
+	if level, ok := q.levels[task.priority]; ok {
+		user = level.users[task.userId]
+	}
+	q.push(task)
+	// a user whose last task it was waits anew
+	if user == nil {
+		return
+	}
+	copy(user.tasks[1:], user.tasks)
+	user.tasks[0] = task
+	user.deficit++
+	user.dispatched--
+}
+
+// pop returns the next task fits accepts, higher priorities first. A user
+// whose oldest task does not fit loses its turn to the users whose tasks do.
+func (q *fairQueue) pop(fits func(task queuedTask) bool) (queuedTask, bool) {
+	priorities := make([]TaskPriority, 0, len(q.levels))
+	for priority := range q.levels {
+		priorities = append(priorities, priority)
+	}
+	sort.Slice(priorities, func(i, j int) bool { return priorities[i] > priorities[j] })
+
+	for _, priority := range priorities {
+		level := q.levels[priority]
+		task, ok := level.pop(fits)
+		if !ok {
+			continue
+		}
+		q.size--
+		delete(q.ids, task.id)
+		if len(level.ring) == 0 {
+			delete(q.levels, priority)
+		}
+		return task, true
+	}
+	return queuedTask{}, false
+}
+
+func (l *fairLevel) pop(fits func(task queuedTask) bool) (queuedTask, bool) {
+	// nobody loses a turn while no task fits at all
+	if !slices.ContainsFunc(l.ring, func(userId string) bool { return fits(l.users[userId].tasks[0]) }) {
+		return queuedTask{}, false
+	}
+	// every user gets a turn, the one at cursor possibly a second
+	for turns := 0; turns <= len(l.ring); turns++ {
+		userId := l.ring[l.cursor]
+		user := l.users[userId]
+		if !l.earned {
+			user.deficit += user.weight
+			l.earned = true
+		}
+		if user.deficit > 0 && fits(user.tasks[0]) {
+			task := user.tasks[0]
+			user.tasks = user.tasks[1:]
+			user.deficit--
+			user.dispatched++
+			if len(user.tasks) == 0 {
+				l.remove(userId)
+			}
+			return task, true
+		}
+		user.deficit = 0
+		l.next()
+	}
+	return queuedTask{}, false
+}
+
+func (l *fairLevel) next() {
+	l.cursor = (l.cursor + 1) % len(l.ring)
+	l.earned = false
+}
+
+// remove drops a user without waiting tasks, the next user's turn begins.
+func (l *fairLevel) remove(userId string) {
+	delete(l.users, userId)
+	l.ring = append(l.ring[:l.cursor], l.ring[l.cursor+1:]...)
+	l.earned = false
+	if l.cursor >= len(l.ring) {
+		l.cursor = 0
+	}
+}
+
+// users returns the waiting users, higher priorities first and by user id.
+func (q *fairQueue) users() []QueuedUser {
+	users := []QueuedUser{}
+	for priority, level := range q.levels {
+		for userId, user := range level.users {
+			users = append(users, QueuedUser{
+				Priority:   priority,
+				UserId:     userId,
+				Tier:       user.tier,
+				Weight:     user.weight,
+				Queued:     len(user.tasks),
+				Dispatched: user.dispatched,
+			})
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Priority != users[j].Priority {
+			return users[i].Priority > users[j].Priority
+		}
+		return users[i].UserId < users[j].UserId
+	})
+	return users
+}
@@ -0,0 +1,541 @@
+package scheduler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	journalFileName  = "tasks.journal"
+	snapshotFileName = "tasks.snapshot"
+)
+
+const (
+	journalOpAdd    = "add"
+	journalOpState  = "state"
+	journalOpWorker = "worker"
+	journalOpDel    = "del"
+	journalOpError  = "error"
+	journalOpReset  = "reset"
+	// batch entries carry the batch id in Id
+	journalOpFinishBatch = "finish_batch"
+	journalOpDelBatch    = "del_batch"
+)
+
+type JournalConfig struct {
+	// Dir holds the journal and the snapshot files
+	Dir string
+	// SnapshotInterval is how often the journal is compacted into a snapshot
+	SnapshotInterval time.Duration
+	// Fsync syncs the journal after every write, otherwise only a crash of the
+	// machine, not of the process, can lose the last writes
+	Fsync bool
+}
+
+// taskRecord is the serialized form of a task in the journal and snapshot.
+type taskRecord struct {
+	Id           string      `json:"id"`
+	State        string      `json:"state"`
+	Type         string      `json:"type"`
+	SubType      string      `json:"sub_type"`
+	Priority     int         `json:"priority"`
+	UserId       string      `json:"user_id"`
+	WorkerId     string      `json:"worker_id"`
+	WorkerTaskId string      `json:"worker_task_id"`
+	UserDef      interface{} `json:"user_def"`
+	CreatedAt    time.Time   `json:"created_at"`
+	Attempts     int         `json:"attempts"`
+	Errors       []TaskError `json:"errors"`
+	Deadline     time.Time   `json:"deadline"`
+	TraceParent  string      `json:"trace_parent,omitempty"`
+	RequestId    string      `json:"request_id,omitempty"`
+	BatchId      string      `json:"batch_id,omitempty"`
+	CacheKey     string      `json:"cache_key,omitempty"`
+	DispatchedAt time.Time   `json:"dispatched_at"`
+}
+
+func newTaskRecord(task Task) *taskRecord {
+	return &taskRecord{
+		Id:           task.GetId(),
+		State:        string(task.GetState()),
+		Type:         string(task.GetType()),
+		SubType:      string(task.GetSubType()),
+		Priority:     int(task.GetPriority()),
+		UserId:       task.GetUserId(),
+		WorkerId:     string(task.GetWorkerId()),
+		WorkerTaskId: task.GetWorkerTaskId(),
+		UserDef:      task.GetUserDef(),
+		CreatedAt:    task.GetCreatedAt(),
+		Attempts:     task.GetAttempts(),
+		Errors:       task.GetErrors(),
+		Deadline:     task.GetDeadline(),
+		TraceParent:  task.GetTraceParent(),
+		RequestId:    task.GetRequestId(),
+		BatchId:      task.GetBatchId(),
+		CacheKey:     task.GetCacheKey(),
+		DispatchedAt: task.GetDispatchedAt(),
+	}
+}
+
+func (r *taskRecord) toTask() Task {
+	return NewTaskBuilder().
+		SetId(r.Id).
+		SetState(TaskState(r.State)).
+		SetType(TaskType(r.Type)).
+		SetSubType(SubTaskType(r.SubType)).
+		SetPriority(TaskPriority(r.Priority)).
+		SetUserId(r.UserId).
+		SetWorkerId(WorkerId(r.WorkerId)).
+		SetWorkerTaskId(r.WorkerTaskId).
+		SetUserDef(r.UserDef).
+		SetCreatedAt(r.CreatedAt).
+		SetAttempts(r.Attempts).
+		SetErrors(r.Errors).
+		SetDeadline(r.Deadline).
+		SetTraceParent(r.TraceParent).
+		SetRequestId(r.RequestId).
+		SetBatchId(r.BatchId).
+		SetCacheKey(r.CacheKey).
+		SetDispatchedAt(r.DispatchedAt).
+		Build()
+}
+
+type journalEntry struct {
+	Seq          uint64      `json:"seq"`
+	Op           string      `json:"op"`
+	Id           string      `json:"id"`
+	Task         *taskRecord `json:"task,omitempty"`
+	State        string      `json:"state,omitempty"`
+	WorkerId     string      `json:"worker_id,omitempty"`
+	WorkerTaskId string      `json:"worker_task_id,omitempty"`
+	DispatchedAt time.Time   `json:"dispatched_at,omitempty"`
+	Error        *TaskError  `json:"error,omitempty"`
+	FinishedAt   time.Time   `json:"finished_at,omitempty"`
+}
+
+// journalSnapshot holds every task and finished batch as of journal entry
+// Seq, replay skips the entries it already contains.
+type journalSnapshot struct {
+	Seq             uint64               `json:"seq"`
+	Tasks           []*taskRecord        `json:"tasks"`
+	FinishedBatches map[string]time.Time `json:"finished_batches,omitempty"`
+}
+
+// JournalStore is an InMemStore that survives restarts. Every change is
+// appended to a journal file before the call returns and replayed on startup.
+// The journal is compacted into a snapshot periodically and on Close.
+type JournalStore struct {
+	mem     *InMemStore
+	cfg     JournalConfig
+	mu      sync.Mutex // keeps the journal in the order changes hit memory
+	seq     uint64
+	journal *os.File
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func NewJournalStore(cfg JournalConfig) (*JournalStore, error) {
+	if len(cfg.Dir) == 0 {
+		return nil, errors.New("journal dir is empty")
+	}
+	if cfg.SnapshotInterval <= 0 {
+		cfg.SnapshotInterval = 5 * time.Minute
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &JournalStore{
+		mem:     NewInMemStore(),
+		cfg:     cfg,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+
+	go s.compactLoop()
+	return s, nil
+}
+
+func (s *JournalStore) AddTask(task Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.AddTask(task); err != nil {
+		return err
+	}
+	return s.append(&journalEntry{Op: journalOpAdd, Id: task.GetId(), Task: newTaskRecord(task)})
+}
+
+func (s *JournalStore) GetTask(id string) (Task, error) {
+	return s.mem.GetTask(id)
+}
+
+func (s *JournalStore) GetTasks(ids []string) ([]Task, error) {
+	return s.mem.GetTasks(ids)
+}
+
+func (s *JournalStore) DelTask(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.DelTask(id); err != nil {
+		return err
+	}
+	return s.append(&journalEntry{Op: journalOpDel, Id: id})
+}
+
+func (s *JournalStore) UpdateTaskState(taskId string, state string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.UpdateTaskState(taskId, state); err != nil {
+		return err
+	}
+	return s.append(&journalEntry{Op: journalOpState, Id: taskId, State: state})
+}
+
+func (s *JournalStore) SwapTaskState(taskId string, workerId WorkerId, from string, to string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.SwapTaskState(taskId, workerId, from, to); err != nil {
+		return err
+	}
+	return s.append(&journalEntry{Op: journalOpState, Id: taskId, State: to})
+}
+
+func (s *JournalStore) UpdateTaskWorker(taskId string, workerId WorkerId, workerTaskId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.UpdateTaskWorker(taskId, workerId, workerTaskId); err != nil {
+		return err
+	}
+	task, err := s.mem.GetTask(taskId)
+	if err != nil {
+		return err
+	}
+	return s.append(&journalEntry{
+		Op:           journalOpWorker,
+		Id:           taskId,
+		WorkerId:     string(workerId),
+		WorkerTaskId: workerTaskId,
+		DispatchedAt: task.GetDispatchedAt(),
+	})
+}
+
+func (s *JournalStore) AddTaskError(taskId string, taskErr TaskError) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.AddTaskError(taskId, taskErr); err != nil {
+		return err
+	}
+	return s.append(&journalEntry{Op: journalOpError, Id: taskId, Error: &taskErr})
+}
+
+func (s *JournalStore) ResetTaskAttempts(taskId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.ResetTaskAttempts(taskId); err != nil {
+		return err
+	}
+	return s.append(&journalEntry{Op: journalOpReset, Id: taskId})
+}
+
+func (s *JournalStore) ListTasks(filter TaskFilter) ([]Task, error) {
+	return s.mem.ListTasks(filter)
+}
+
+func (s *JournalStore) CountTasks() (map[TaskType]TaskCounts, error) {
+	return s.mem.CountTasks()
+}
+
+func (s *JournalStore) ListQueuedTasks(deadlineBefore time.Time, limit int) ([]Task, error) {
+	return s.mem.ListQueuedTasks(deadlineBefore, limit)
+}
+
+func (s *JournalStore) ListDispatchedTasks(dispatchedBefore time.Time, limit int) ([]Task, error) {
+	return s.mem.ListDispatchedTasks(dispatchedBefore, limit)
+}
+
+// ListBatchTaskIds returns the ids of the tasks of a batch, oldest first.
+func (s *JournalStore) ListBatchTaskIds(batchId string) ([]string, error) {
+	return s.mem.ListBatchTaskIds(batchId)
+}
+
+func (s *JournalStore) FinishBatch(batchId string, finishedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.FinishBatch(batchId, finishedAt); err != nil {
+		return err
+	}
+	return s.append(&journalEntry{Op: journalOpFinishBatch, Id: batchId, FinishedAt: finishedAt})
+}
+
+func (s *JournalStore) ListFinishedBatches(finishedBefore time.Time, limit int) ([]string, error) {
+	return s.mem.ListFinishedBatches(finishedBefore, limit)
+}
+
+func (s *JournalStore) DelBatch(batchId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.DelBatch(batchId); err != nil {
+		return err
+	}
+	return s.append(&journalEntry{Op: journalOpDelBatch, Id: batchId})
+}
+
+// Close compacts the journal so the next start only reads the snapshot.
+func (s *JournalStore) Close() error {
+	select {
+	case <-s.done:
+		return nil
+	default:
+	}
+	close(s.done)
+	<-s.stopped
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.compact()
+	if closeErr := s.journal.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (s *JournalStore) append(entry *journalEntry) error {
+	s.seq++
+	entry.Seq = s.seq
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	// one write per entry, a crash can only leave the last line incomplete
+	if _, err := s.journal.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write task journal error: %w", err)
+	}
+	if s.cfg.Fsync {
+		return s.journal.Sync()
+	}
+	return nil
+}
+
+// recover loads the snapshot, replays the journal on top of it and opens the
+// journal for appending.
+func (s *JournalStore) recover() error {
+	if err := s.loadSnapshot(); err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.cfg.Dir, journalFileName)
+	journal, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+
+	valid, replayed, err := s.replay(journal)
+	if err != nil {
+		journal.Close()
+		return err
+	}
+	s.mem.reindex()
+	// drop a half written tail, new entries must start on a fresh line
+	if err := journal.Truncate(valid); err != nil {
+		journal.Close()
+		return err
+	}
+	if _, err := journal.Seek(valid, io.SeekStart); err != nil {
+		journal.Close()
+		return err
+	}
+	s.journal = journal
+
+	if replayed > 0 {
+		slog.Info("task journal replayed", "entries", replayed, "tasks", len(s.mem.tasks))
+	}
+	return nil
+}
+
+func (s *JournalStore) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(s.cfg.Dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snapshot journalSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("read task snapshot error: %w", err)
+	}
+	for _, record := range snapshot.Tasks {
+		s.mem.tasks[record.Id] = record.toTask()
+	}
+	for batchId, finishedAt := range snapshot.FinishedBatches {
+		s.mem.finished[batchId] = finishedAt
+	}
+	s.seq = snapshot.Seq
+	return nil
+}
+
+// replay applies the journal entries newer than the snapshot to memory as
+// they were written, without checking state transitions again. It returns the
+// offset after the last complete entry.
+func (s *JournalStore) replay(r io.Reader) (int64, int, error) {
+	reader := bufio.NewReader(r)
+	var offset int64
+	replayed := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(bytes.TrimSpace(line)) > 0 {
+				slog.Warn("task journal ends with an incomplete entry, dropping it")
+			}
+			return offset, replayed, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+
+		var entry journalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			slog.Error("task journal is corrupted, dropping the rest", "offset", offset, "error", err)
+			return offset, replayed, nil
+		}
+		offset += int64(len(line))
+		if entry.Seq <= s.seq {
+			continue
+		}
+		s.apply(&entry)
+		s.seq = entry.Seq
+		replayed++
+	}
+}
+
+func (s *JournalStore) apply(entry *journalEntry) {
+	tasks := s.mem.tasks
+	switch entry.Op {
+	case journalOpAdd:
+		tasks[entry.Id] = entry.Task.toTask()
+	case journalOpDel:
+		delete(tasks, entry.Id)
+	case journalOpState:
+		if task, ok := tasks[entry.Id]; ok {
+			task.SetState(TaskState(entry.State))
+		}
+	case journalOpWorker:
+		if task, ok := tasks[entry.Id]; ok {
+			task.SetWorkerId(WorkerId(entry.WorkerId))
+			task.SetWorkerTaskId(entry.WorkerTaskId)
+			task.SetDispatchedAt(entry.DispatchedAt)
+		}
+	case journalOpError:
+		if task, ok := tasks[entry.Id]; ok && entry.Error != nil {
+			task.AddError(*entry.Error)
+			task.SetAttempts(task.GetAttempts() + 1)
+		}
+	case journalOpReset:
+		if task, ok := tasks[entry.Id]; ok {
+			task.SetAttempts(0)
+		}
+	case journalOpFinishBatch:
+		s.mem.finished[entry.Id] = entry.FinishedAt
+	case journalOpDelBatch:
+		// the batch index is rebuilt after replay, find the tasks by batch id
+		for id, task := range tasks {
+			if task.GetBatchId() == entry.Id {
+				delete(tasks, id)
+			}
+		}
+		delete(s.mem.finished, entry.Id)
+	}
+}
+
+func (s *JournalStore) compactLoop() {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(s.cfg.SnapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if err := s.compact(); err != nil {
+				slog.Error("compact task journal error", "error", err)
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// compact writes all tasks to a new snapshot and empties the journal. A crash
+// between the two steps is harmless, the snapshot records the last entry it
+// contains and replay skips the older ones. Callers hold s.mu.
+func (s *JournalStore) compact() error {
+	snapshot := journalSnapshot{Seq: s.seq}
+	s.mem.mu.RLock()
+	snapshot.Tasks = make([]*taskRecord, 0, len(s.mem.tasks))
+	for _, task := range s.mem.tasks {
+		snapshot.Tasks = append(snapshot.Tasks, newTaskRecord(task))
+	}
+	snapshot.FinishedBatches = make(map[string]time.Time, len(s.mem.finished))
+	for batchId, finishedAt := range s.mem.finished {
+		snapshot.FinishedBatches[batchId] = finishedAt
+	}
+	s.mem.mu.RUnlock()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(s.cfg.Dir, snapshotFileName+".tmp")
+	if err := writeFileSync(tmp, data); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.cfg.Dir, snapshotFileName)); err != nil {
+		return err
+	}
+
+	if err := s.journal.Truncate(0); err != nil {
+		return err
+	}
+	_, err = s.journal.Seek(0, io.SeekStart)
+	return err
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,142 @@
+package scheduler_test
+
+import (
+	"go-web/pkg/scheduler"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestJournalStore(t *testing.T, dir string) *scheduler.JournalStore {
+	store, err := scheduler.NewJournalStore(scheduler.JournalConfig{
+		Dir:              dir,
+		SnapshotInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func newJournalTestTask() scheduler.Task {
+	return scheduler.NewTaskBuilder().
+		SetType(scheduler.TaskTypePdf).
+		SetSubType(scheduler.SubTaskTypePdf2Img).
+		SetUserId("u1").
+		SetCreatedAt(time.Now()).
+		SetUserDef(map[string]interface{}{"file_id": "f1"}).
+		Build()
+}
+
+func TestJournalStore(t *testing.T) {
+	testTaskStore(t, func(t *testing.T) scheduler.TaskStore {
+		return newTestJournalStore(t, t.TempDir())
+	})
+}
+
+func TestJournalStore_ShouldRecoverTasks_WhenRestartedWithoutClose(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestJournalStore(t, dir)
+
+	running, done, deleted := newJournalTestTask(), newJournalTestTask(), newJournalTestTask()
+	for _, task := range []scheduler.Task{running, done, deleted} {
+		assert.NoError(t, store.AddTask(task))
+	}
+	assert.NoError(t, store.UpdateTaskWorker(running.GetId(), "w1", "wt1"))
+	assert.NoError(t, store.UpdateTaskState(running.GetId(), scheduler.TaskStateRunning))
+	assert.NoError(t, store.UpdateTaskState(done.GetId(), scheduler.TaskStateDone))
+	assert.NoError(t, store.DelTask(deleted.GetId()))
+
+	// a crash leaves only the journal, no snapshot
+	recovered := newTestJournalStore(t, dir)
+
+	got, err := recovered.GetTask(running.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, scheduler.TaskState(scheduler.TaskStateRunning), got.GetState())
+	assert.Equal(t, scheduler.WorkerId("w1"), got.GetWorkerId())
+	assert.Equal(t, "wt1", got.GetWorkerTaskId())
+	assert.Equal(t, "u1", got.GetUserId())
+	assert.Equal(t, map[string]interface{}{"file_id": "f1"}, got.GetUserDef())
+
+	got, err = recovered.GetTask(done.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, scheduler.TaskState(scheduler.TaskStateDone), got.GetState())
+
+	_, err = recovered.GetTask(deleted.GetId())
+	assert.ErrorIs(t, err, scheduler.ErrTaskNotFound)
+
+	counts, err := recovered.CountTasks()
+	assert.NoError(t, err)
+	assert.Equal(t, map[scheduler.TaskType]scheduler.TaskCounts{scheduler.TaskTypePdf: {Dispatched: 1}}, counts)
+}
+
+func TestJournalStore_ShouldRecoverBatches_WhenRestartedWithoutClose(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestJournalStore(t, dir)
+	kept := scheduler.NewTaskBuilder().SetType(scheduler.TaskTypePdf).SetBatchId("b1").Build()
+	deleted := scheduler.NewTaskBuilder().SetType(scheduler.TaskTypePdf).SetBatchId("b2").Build()
+	assert.NoError(t, store.AddTask(kept))
+	assert.NoError(t, store.AddTask(deleted))
+	finishedAt := time.Now().Add(-time.Hour)
+	assert.NoError(t, store.FinishBatch("b1", finishedAt))
+	assert.NoError(t, store.FinishBatch("b2", finishedAt))
+	assert.NoError(t, store.DelBatch("b2"))
+
+	recovered := newTestJournalStore(t, dir)
+
+	ids, err := recovered.ListBatchTaskIds("b1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{kept.GetId()}, ids)
+	_, err = recovered.GetTask(deleted.GetId())
+	assert.ErrorIs(t, err, scheduler.ErrTaskNotFound)
+	batchIds, err := recovered.ListFinishedBatches(time.Now(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b1"}, batchIds)
+}
+
+func TestJournalStore_ShouldCompactJournal_WhenClosed(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestJournalStore(t, dir)
+	task := newJournalTestTask()
+	assert.NoError(t, store.AddTask(task))
+	assert.NoError(t, store.UpdateTaskState(task.GetId(), scheduler.TaskStateRunning))
+	assert.NoError(t, store.Close())
+
+	info, err := os.Stat(filepath.Join(dir, "tasks.journal"))
+	assert.NoError(t, err)
+	assert.Zero(t, info.Size())
+
+	recovered := newTestJournalStore(t, dir)
+	got, err := recovered.GetTask(task.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, scheduler.TaskState(scheduler.TaskStateRunning), got.GetState())
+}
+
+func TestJournalStore_ShouldDropIncompleteEntry_WhenJournalTailIsTorn(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestJournalStore(t, dir)
+	task := newJournalTestTask()
+	assert.NoError(t, store.AddTask(task))
+
+	journal, err := os.OpenFile(filepath.Join(dir, "tasks.journal"), os.O_APPEND|os.O_WRONLY, 0o644)
+	assert.NoError(t, err)
+	_, err = journal.WriteString(`{"op":"state","id":"` + task.GetId())
+	assert.NoError(t, err)
+	journal.Close()
+
+	recovered := newTestJournalStore(t, dir)
+	got, err := recovered.GetTask(task.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, scheduler.TaskState(scheduler.TaskStateCreated), got.GetState())
+
+	// new entries must not be glued to the torn line
+	assert.NoError(t, recovered.UpdateTaskState(task.GetId(), scheduler.TaskStateRunning))
+	again := newTestJournalStore(t, dir)
+	got, err = again.GetTask(task.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, scheduler.TaskState(scheduler.TaskStateRunning), got.GetState())
+}
@@ -13,6 +13,8 @@ type rr struct {
 	idx *atomic.Int64
 }
 
+const DefaultLoadBalancer = "rr"
+
 var (
 	lbRegistry = map[string]LoadBalancer{
 		"rr": newrr(),
@@ -0,0 +1,301 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// both keys share a hash tag so the lua scripts stay valid in cluster mode
+	RedisLeaderKey      = "ktools:{leader}"
+	RedisLeaderTokenKey = "ktools:{leader}:token"
+)
+
+var (
+	ErrNotLeader = errors.New("replica is not the leader")
+)
+
+// LeaderElector decides which go-web replica runs the singleton scheduler
+// duties such as worker eviction.
+type LeaderElector interface {
+	Start() error
+	Stop() error
+	// IsLeader reports whether this replica holds an unexpired lease.
+	IsLeader() bool
+	// FencingToken returns the token handed out with the current lease. Tokens
+	// increase monotonically, so a resource can reject writes from a stale leader.
+	FencingToken() int64
+	// Guard checks with the lock owner that the lease is still ours before a
+	// duty starts. The lease can run out right after, writes to shared state
+	// carry FencingToken so the store rejects them once a newer leader wrote.
+	Guard() error
+	GetId() string
+}
+
+type LeaderElectorCfg struct {
+	ReplicaId     string
+	LeaseTTL      time.Duration
+	RenewInterval time.Duration
+}
+
+// acquire the lease and bump the fencing token in one step
+var acquireLeaseScript = redis.NewScript(`
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return redis.call('INCR', KEYS[2])
+end
+return 0
+`)
+
+// extend the lease only if we still own it
+var renewLeaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// release the lease only if we still own it
+var releaseLeaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// guard the lease: still owned by us and no newer token has been handed out
+var guardLeaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] and redis.call('GET', KEYS[2]) == ARGV[2] then
+	return 1
+end
+return 0
+`)
+
+type RedisLeaderElector struct {
+	client  redis.UniversalClient
+	cfg     LeaderElectorCfg
+	mu      sync.RWMutex
+	token   int64
+	expires time.Time
+	// runMu guards started and the channels of the current run, Start after
+	// Stop begins a new run
+	runMu   sync.Mutex
+	started bool
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func NewRedisLeaderElector(client redis.UniversalClient, cfg LeaderElectorCfg) *RedisLeaderElector {
+	if len(cfg.ReplicaId) == 0 {
+		cfg.ReplicaId = newReplicaId()
+	}
+	if cfg.LeaseTTL <= 0 {
+		cfg.LeaseTTL = 15 * time.Second
+	}
+	if cfg.RenewInterval <= 0 || cfg.RenewInterval >= cfg.LeaseTTL {
+		cfg.RenewInterval = cfg.LeaseTTL / 3
+	}
+
+	return &RedisLeaderElector{
+		client: client,
+		cfg:    cfg,
+	}
+}
+
+func newReplicaId() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "go-web"
+	}
+	return hostname + "-" + strings.ReplaceAll(uuid.New().String(), "-", "")[:8]
+}
+
+func (e *RedisLeaderElector) GetId() string {
+	return e.cfg.ReplicaId
+}
+
+func (e *RedisLeaderElector) Start() error {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+	if e.started {
+		return errors.New("leader elector has started")
+	}
+	e.started = true
+	e.done = make(chan struct{})
+	e.stopped = make(chan struct{})
+
+	go e.run(e.done, e.stopped)
+	return nil
+}
+
+func (e *RedisLeaderElector) Stop() error {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+	if !e.started {
+		return nil
+	}
+	e.started = false
+	close(e.done)
+	<-e.stopped
+
+	if !e.IsLeader() {
+		return nil
+	}
+	e.setLease(0, time.Time{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return releaseLeaseScript.Run(ctx, e.client, []string{RedisLeaderKey}, e.cfg.ReplicaId).Err()
+}
+
+func (e *RedisLeaderElector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.token > 0 && time.Now().Before(e.expires)
+}
+
+func (e *RedisLeaderElector) FencingToken() int64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.token
+}
+
+func (e *RedisLeaderElector) Guard() error {
+	if !e.IsLeader() {
+		return ErrNotLeader
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ok, err := guardLeaseScript.Run(ctx, e.client, []string{RedisLeaderKey, RedisLeaderTokenKey},
+		e.cfg.ReplicaId, e.FencingToken()).Int()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		e.setLease(0, time.Time{})
+		return ErrNotLeader
+	}
+	return nil
+}
+
+func (e *RedisLeaderElector) run(done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(e.cfg.RenewInterval)
+	defer ticker.Stop()
+
+	e.campaign()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			e.campaign()
+		}
+	}
+}
+
+// campaign renews the lease when we hold it and tries to take it otherwise.
+func (e *RedisLeaderElector) campaign() {
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.RenewInterval)
+	defer cancel()
+
+	// the lease is measured from before the round trip, so our view of it
+	// always ends no later than the one redis has
+	start := time.Now()
+	ttl := e.cfg.LeaseTTL.Milliseconds()
+	if e.IsLeader() {
+		renewed, err := renewLeaseScript.Run(ctx, e.client, []string{RedisLeaderKey}, e.cfg.ReplicaId, ttl).Int()
+		if err != nil {
+			// keep the lease until it runs out, redis may be back by then
+			slog.Error("renew leader lease error", "replica_id", e.cfg.ReplicaId, "error", err)
+			return
+		}
+		if renewed == 0 {
+			slog.Warn("replica lost leadership", "replica_id", e.cfg.ReplicaId)
+			e.setLease(0, time.Time{})
+			return
+		}
+		e.setLease(e.FencingToken(), start.Add(e.cfg.LeaseTTL))
+		return
+	}
+
+	token, err := acquireLeaseScript.Run(ctx, e.client, []string{RedisLeaderKey, RedisLeaderTokenKey}, e.cfg.ReplicaId, ttl).Int64()
+	if err != nil {
+		slog.Error("acquire leader lease error", "replica_id", e.cfg.ReplicaId, "error", err)
+		e.setLease(0, time.Time{})
+		return
+	}
+	if token == 0 {
+		e.setLease(0, time.Time{})
+		return
+	}
+	slog.Info("replica elected as leader", "replica_id", e.cfg.ReplicaId, "fencing_token", token)
+	e.setLease(token, start.Add(e.cfg.LeaseTTL))
+}
+
+func (e *RedisLeaderElector) setLease(token int64, expires time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.token = token
+	e.expires = expires
+}
+
+// standaloneElector is used when only one replica runs, it is always the leader.
+type standaloneElector struct {
+	id string
+}
+
+func NewStandaloneElector() LeaderElector {
+	return &standaloneElector{id: newReplicaId()}
+}
+
+func (e *standaloneElector) Start() error {
+	return nil
+}
+
+func (e *standaloneElector) Stop() error {
+	return nil
+}
+
+func (e *standaloneElector) IsLeader() bool {
+	return true
+}
+
+func (e *standaloneElector) FencingToken() int64 {
+	return 1
+}
+
+func (e *standaloneElector) Guard() error {
+	return nil
+}
+
+func (e *standaloneElector) GetId() string {
+	return e.id
+}
+
+// runAsLeader calls fn every interval until done is closed, skipping the
+// ticks where this replica does not hold the lease.
+func runAsLeader(leader LeaderElector, interval time.Duration, done <-chan struct{}, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := leader.Guard(); err != nil {
+				continue
+			}
+			fn()
+		}
+	}
+}
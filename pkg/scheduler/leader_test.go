@@ -0,0 +1,88 @@
+package scheduler_test
+
+import (
+	"go-web/pkg/scheduler"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestElector(t *testing.T, mr *miniredis.Miniredis, id string) *scheduler.RedisLeaderElector {
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	elector := scheduler.NewRedisLeaderElector(client, scheduler.LeaderElectorCfg{
+		ReplicaId:     id,
+		LeaseTTL:      time.Second,
+		RenewInterval: 20 * time.Millisecond,
+	})
+	if err := elector.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { elector.Stop() })
+	return elector
+}
+
+func countLeaders(electors ...scheduler.LeaderElector) int {
+	leaders := 0
+	for _, elector := range electors {
+		if elector.IsLeader() {
+			leaders++
+		}
+	}
+	return leaders
+}
+
+func TestLeaderElection_ShouldElectExactlyOneLeader_WhenReplicasCompete(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newTestElector(t, mr, "a")
+	b := newTestElector(t, mr, "b")
+	c := newTestElector(t, mr, "c")
+
+	assert.Eventually(t, func() bool { return countLeaders(a, b, c) == 1 }, time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 1, countLeaders(a, b, c))
+}
+
+func TestLeaderElection_ShouldFailover_WhenLeaderStops(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newTestElector(t, mr, "a")
+	assert.Eventually(t, a.IsLeader, time.Second, 10*time.Millisecond)
+	b := newTestElector(t, mr, "b")
+	firstToken := a.FencingToken()
+
+	assert.NoError(t, a.Stop())
+
+	assert.Eventually(t, b.IsLeader, time.Second, 10*time.Millisecond)
+	assert.False(t, a.IsLeader())
+	assert.Greater(t, b.FencingToken(), firstToken)
+}
+
+func TestLeaderElection_ShouldStepDown_WhenLeaseIsTakenOver(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newTestElector(t, mr, "a")
+	assert.Eventually(t, a.IsLeader, time.Second, 10*time.Millisecond)
+	b := newTestElector(t, mr, "b")
+
+	// the lease runs out in redis, e.g. because a was paused, and b takes it over
+	mr.FastForward(2 * time.Second)
+
+	assert.Eventually(t, b.IsLeader, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return !a.IsLeader() }, time.Second, 10*time.Millisecond)
+	assert.ErrorIs(t, a.Guard(), scheduler.ErrNotLeader)
+	assert.NoError(t, b.Guard())
+}
+
+func TestLeaderElection_ShouldLeadAgain_WhenRestartedAfterStop(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newTestElector(t, mr, "a")
+	assert.Eventually(t, a.IsLeader, time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, a.Stop())
+	assert.NoError(t, a.Start())
+	assert.Error(t, a.Start())
+	assert.Eventually(t, a.IsLeader, time.Second, 10*time.Millisecond)
+}
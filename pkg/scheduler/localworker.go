@@ -0,0 +1,350 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LocalWorkerAddr is the address of every in-process worker, they are never
+// called over http.
+const LocalWorkerAddr = "local"
+
+var ErrLocalWorkerBusy = errors.New("local worker queue is full")
+
+// LocalHandler runs a task inside the go-web process. The returned value is
+// the task result, a returned error fails the task. ctx is cancelled when the
+// task is cancelled or the worker stops.
+type LocalHandler func(ctx context.Context, task Task) (interface{}, error)
+
+type LocalWorkerCfg struct {
+	Id WorkerId
+	// Concurrency is the number of goroutines running tasks, defaults to the
+	// number of CPUs
+	Concurrency int
+	// QueueSize is the number of accepted tasks waiting for a goroutine, Exec
+	// fails once it is full. Defaults to 10 per goroutine
+	QueueSize int
+	// ResultTTL is how long results stay readable after a task ended
+	ResultTTL time.Duration
+}
+
+// TaskStateReporter records a state a worker reached, it is what remote
+// workers do through PUT /schedule/task/:id.
+type TaskStateReporter func(taskId string, workerId WorkerId, state string, message string) error
+
+// LocalWorker is a Worker that runs registered handlers on a bounded
+// goroutine pool in this process. It is registered like a remote worker but
+// only this replica can select it, its tasks cannot be polled on other
+// replicas.
+type LocalWorker struct {
+	cfg      LocalWorkerCfg
+	handlers map[SubTaskType]LocalHandler
+	queue    chan *localTask
+	report   atomic.Pointer[TaskStateReporter]
+
+	mu    sync.Mutex
+	tasks map[string]*localTask
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+
+	errCounter atomic.Int32
+	active     atomic.Int32
+	completed  atomic.Int32
+	failed     atomic.Int32
+	cancelled  atomic.Int32
+}
+
+type localTask struct {
+	id   string
+	task Task
+	ctx  context.Context
+
+	mu         sync.Mutex
+	state      TaskState
+	result     interface{}
+	errMsg     string
+	cancel     context.CancelFunc
+	cancelled  bool
+	finishedAt time.Time
+}
+
+func NewLocalWorker(cfg LocalWorkerCfg) *LocalWorker {
+	if len(cfg.Id) == 0 {
+		cfg.Id = WorkerId("local-" + newReplicaId())
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = runtime.NumCPU()
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = cfg.Concurrency * 10
+	}
+	if cfg.ResultTTL <= 0 {
+		cfg.ResultTTL = time.Hour
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &LocalWorker{
+		cfg:      cfg,
+		handlers: map[SubTaskType]LocalHandler{},
+		queue:    make(chan *localTask, cfg.QueueSize),
+		tasks:    map[string]*localTask{},
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Handle registers the handler of a sub type, it must be called before the
+// worker is registered.
+func (w *LocalWorker) Handle(subType SubTaskType, handler LocalHandler) {
+	w.handlers[subType] = handler
+}
+
+// CanHandle reports whether the worker has a handler for the sub type, it is
+// only selected for those.
+func (w *LocalWorker) CanHandle(subType SubTaskType) bool {
+	_, ok := w.handlers[subType]
+	return ok
+}
+
+// SetReporter sets where task states are reported to, the scheduler sets it
+// when the worker is registered.
+func (w *LocalWorker) SetReporter(report TaskStateReporter) {
+	w.report.Store(&report)
+}
+
+func (w *LocalWorker) start() {
+	w.once.Do(func() {
+		for i := 0; i < w.cfg.Concurrency; i++ {
+			w.wg.Add(1)
+			go w.loop()
+		}
+	})
+}
+
+// Stop cancels running tasks and waits for the pool to exit.
+func (w *LocalWorker) Stop() {
+	w.cancel()
+	w.wg.Wait()
+}
+
+func (w *LocalWorker) loop() {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case t := <-w.queue:
+			w.run(t)
+		}
+	}
+}
+
+func (w *LocalWorker) GetId() WorkerId {
+	return w.cfg.Id
+}
+
+func (w *LocalWorker) GetAddr() string {
+	return LocalWorkerAddr
+}
+
+func (w *LocalWorker) Exec(task Task) (TaskFuture, error) {
+	if !w.CanHandle(task.GetSubType()) {
+		return nil, fmt.Errorf("local worker cannot handle sub type %s", task.GetSubType())
+	}
+	w.start()
+
+	ctx, cancel := context.WithCancel(w.ctx)
+	t := &localTask{
+		id:     strings.ReplaceAll(uuid.New().String(), "-", ""),
+		task:   cloneTask(task),
+		ctx:    ctx,
+		state:  TaskStateCreated,
+		cancel: cancel,
+	}
+	w.mu.Lock()
+	w.evictFinished()
+	w.tasks[t.id] = t
+	w.mu.Unlock()
+
+	select {
+	case w.queue <- t:
+	default:
+		w.mu.Lock()
+		delete(w.tasks, t.id)
+		w.mu.Unlock()
+		cancel()
+		return nil, ErrLocalWorkerBusy
+	}
+
+	task.SetWorkerTaskId(t.id)
+	return NewTaskFuture(task), nil
+}
+
+func (w *LocalWorker) run(t *localTask) {
+	defer t.cancel()
+
+	t.mu.Lock()
+	if t.cancelled {
+		t.finishedAt = time.Now()
+		t.state = TaskStateCancelled
+		t.mu.Unlock()
+		w.cancelled.Add(1)
+		return
+	}
+	t.state = TaskStateRunning
+	t.mu.Unlock()
+
+	w.active.Add(1)
+	defer w.active.Add(-1)
+	w.reportState(t, TaskStateRunning, "")
+
+	result, err := w.handle(t.ctx, t)
+
+	t.mu.Lock()
+	t.finishedAt = time.Now()
+	switch {
+	case t.cancelled:
+		t.state = TaskStateCancelled
+	case err != nil:
+		t.state = TaskStateFailure
+		t.errMsg = err.Error()
+	default:
+		t.state = TaskStateDone
+		t.result = result
+	}
+	state := t.state
+	t.mu.Unlock()
+
+	switch state {
+	case TaskStateCancelled:
+		w.cancelled.Add(1)
+	case TaskStateFailure:
+		w.failed.Add(1)
+		w.reportState(t, state, err.Error())
+	default:
+		w.completed.Add(1)
+		w.reportState(t, state, "")
+	}
+}
+
+func (w *LocalWorker) handle(ctx context.Context, t *localTask) (result interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("handler panicked: %v", p)
+		}
+	}()
+	return w.handlers[t.task.GetSubType()](ctx, t.task)
+}
+
+func (w *LocalWorker) reportState(t *localTask, state TaskState, message string) {
+	report := w.report.Load()
+	if report == nil {
+		return
+	}
+	if err := (*report)(t.task.GetId(), w.GetId(), string(state), message); err != nil {
+		slog.Warn("report local task state error", append(taskLogAttrs(t.task), "state", state, "error", err)...)
+	}
+}
+
+// evictFinished drops tasks that ended more than ResultTTL ago, w.mu must be
+// held.
+func (w *LocalWorker) evictFinished() {
+	for id, t := range w.tasks {
+		t.mu.Lock()
+		expired := !t.finishedAt.IsZero() && time.Since(t.finishedAt) > w.cfg.ResultTTL
+		t.mu.Unlock()
+		if expired {
+			delete(w.tasks, id)
+		}
+	}
+}
+
+func (w *LocalWorker) getTask(taskId string) (*localTask, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t, ok := w.tasks[taskId]
+	return t, ok
+}
+
+func (w *LocalWorker) CheckStatus() error {
+	return nil
+}
+
+func (w *LocalWorker) GetTaskStatus(ctx context.Context, taskId string) (*WorkerTaskResult, error) {
+	t, ok := w.getTask(taskId)
+	if !ok {
+		return nil, fmt.Errorf("local task %s not found", taskId)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	result := &WorkerTaskResult{
+		TaskId:     t.id,
+		TaskStatus: t.state,
+	}
+	switch t.state {
+	case TaskStateDone:
+		result.Data = t.result
+	case TaskStateFailure:
+		result.Data = t.errMsg
+	}
+	return result, nil
+}
+
+func (w *LocalWorker) Cancel(taskId string) error {
+	t, ok := w.getTask(taskId)
+	if !ok {
+		return fmt.Errorf("local task %s not found", taskId)
+	}
+	t.mu.Lock()
+	t.cancelled = true
+	t.mu.Unlock()
+	t.cancel()
+	return nil
+}
+
+// GetLastHeartbeat is always now, the worker lives as long as the process.
+func (w *LocalWorker) GetLastHeartbeat() time.Time {
+	return time.Now()
+}
+
+func (w *LocalWorker) Heartbeat(time.Time) {
+}
+
+func (w *LocalWorker) Status() WorkerStatus {
+	return WorkerStatus{
+		IsHealthy:      w.ctx.Err() == nil,
+		ActiveTasks:    int(w.active.Load()),
+		CompletedTasks: int(w.completed.Load()),
+		FailedTasks:    int(w.failed.Load()),
+		CancelledTasks: int(w.cancelled.Load()),
+	}
+}
+
+func (w *LocalWorker) IncrErrorCounter() int32 {
+	return w.errCounter.Add(1)
+}
+
+func (w *LocalWorker) ResetErrorCounter() {
+	w.errCounter.Store(0)
+}
+
+// not support now
+func (w *LocalWorker) SetAbilities([]*WorkerAbility) {
+}
+
+// not support now
+func (w *LocalWorker) GetAbilities() []*WorkerAbility {
+	return nil
+}
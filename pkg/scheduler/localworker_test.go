@@ -0,0 +1,102 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"go-web/pkg/config"
+	"go-web/pkg/scheduler"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newLocalWorker(handler scheduler.LocalHandler) *scheduler.LocalWorker {
+	w := scheduler.NewLocalWorker(scheduler.LocalWorkerCfg{Id: "local-1", Concurrency: 1, QueueSize: 1})
+	w.Handle(scheduler.SubTaskTypePdf2Img, handler)
+	return w
+}
+
+func TestLocalWorker_ShouldCompleteTask_WhenHandlerSucceeds(t *testing.T) {
+	s := newTestScheduler(t, &config.Scheduler{}, nil)
+	s.RegisterWorker(newLocalWorker(func(ctx context.Context, task scheduler.Task) (interface{}, error) {
+		return map[string]string{"image": "f1.png"}, nil
+	}))
+
+	task := newDeadLetterTask()
+	_, err := s.Schedule(task)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		got, err := s.GetTask(task.GetId())
+		return err == nil && got.GetState() == scheduler.TaskStateDone
+	}, 2*time.Second, 10*time.Millisecond)
+
+	result, err := s.GetTaskStatus(task.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, scheduler.TaskState(scheduler.TaskStateDone), result.Status)
+	assert.Equal(t, map[string]string{"image": "f1.png"}, result.Data)
+}
+
+func TestLocalWorker_ShouldRecordError_WhenHandlerFails(t *testing.T) {
+	s := newTestScheduler(t, &config.Scheduler{}, nil)
+	s.RegisterWorker(newLocalWorker(func(ctx context.Context, task scheduler.Task) (interface{}, error) {
+		return nil, errors.New("corrupt pdf")
+	}))
+
+	task := newDeadLetterTask()
+	_, err := s.Schedule(task)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		got, err := s.GetTask(task.GetId())
+		return err == nil && len(got.GetErrors()) > 0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	got, err := s.GetTask(task.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, "corrupt pdf", got.GetErrors()[0].Message)
+}
+
+func TestLocalWorker_ShouldRejectTask_WhenQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	w := newLocalWorker(func(ctx context.Context, task scheduler.Task) (interface{}, error) {
+		started <- struct{}{}
+		<-release
+		return nil, nil
+	})
+	t.Cleanup(w.Stop)
+	defer close(release)
+
+	_, err := w.Exec(newDeadLetterTask())
+	assert.NoError(t, err)
+	<-started
+	_, err = w.Exec(newDeadLetterTask())
+	assert.NoError(t, err)
+
+	_, err = w.Exec(newDeadLetterTask())
+	assert.ErrorIs(t, err, scheduler.ErrLocalWorkerBusy)
+}
+
+func TestSelectWorker_ShouldSkipLocalWorker_WhenSubTypeNotHandled(t *testing.T) {
+	worker := newFakeWorker(t)
+	s := newTestScheduler(t, &config.Scheduler{}, worker)
+	s.RegisterWorker(newLocalWorker(func(ctx context.Context, task scheduler.Task) (interface{}, error) {
+		return nil, nil
+	}))
+
+	for i := 0; i < 3; i++ {
+		task := scheduler.NewTaskBuilder().
+			SetType(scheduler.TaskTypePdf).
+			SetSubType(scheduler.SubTaskTypePdf2Csv).
+			Build()
+		_, err := s.Schedule(task)
+		assert.NoError(t, err)
+
+		got, err := s.GetTask(task.GetId())
+		assert.NoError(t, err)
+		assert.Equal(t, scheduler.WorkerId("w1"), got.GetWorkerId())
+	}
+	assert.Len(t, worker.Submissions(), 3)
+}
@@ -0,0 +1,96 @@
+package scheduler
+
+import (
+	"go-web/pkg/metrics"
+	"go-web/pkg/tracing"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	queueDepthDesc = prometheus.NewDesc(
+		"goweb_task_queue_depth",
+		"Tasks waiting for a worker by task type.",
+		[]string{"type"}, nil,
+	)
+	workersDesc = prometheus.NewDesc(
+		"goweb_workers",
+		"Registered workers by health, a worker is unhealthy once it missed its heartbeat.",
+		[]string{"health"}, nil,
+	)
+)
+
+// schedulerCollector reads queue depth and workers from the stores on every
+// scrape, so all replicas report the same values. The queue depth comes from
+// the counts the task store keeps, no task is read.
+type schedulerCollector struct {
+	s *Scheduler
+}
+
+func (c schedulerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepthDesc
+	ch <- workersDesc
+}
+
+func (c schedulerCollector) Collect(ch chan<- prometheus.Metric) {
+	depth := map[TaskType]int{}
+	for _, taskType := range TaskTypes() {
+		depth[taskType] = 0
+	}
+	counts, err := c.s.tm.CountTasks()
+	if err != nil {
+		slog.Error("count pending tasks for metrics error", "error", err)
+	}
+	for taskType, count := range counts {
+		depth[taskType] = count.Queued
+	}
+	for taskType, n := range depth {
+		ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(n), string(taskType))
+	}
+
+	healthy, unhealthy := 0, 0
+	for _, worker := range c.s.wm.GetWorkers() {
+		if time.Since(worker.GetLastHeartbeat()) <= WorkerHeartbeatTTL {
+			healthy++
+		} else {
+			unhealthy++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(workersDesc, prometheus.GaugeValue, float64(healthy), "healthy")
+	ch <- prometheus.MustNewConstMetric(workersDesc, prometheus.GaugeValue, float64(unhealthy), "unhealthy")
+}
+
+func taskLabels(task Task) []string {
+	return []string{string(task.GetType()), string(task.GetSubType())}
+}
+
+func taskAttributes(task Task) []attribute.KeyValue {
+	return tracing.TaskAttributes(task.GetId(), string(task.GetType()), string(task.GetSubType()))
+}
+
+// taskLogAttrs identify a task and whose request created it in log records.
+func taskLogAttrs(task Task) []any {
+	attrs := []any{"task_id", task.GetId(), "type", task.GetType(), "sub_type", task.GetSubType()}
+	if len(task.GetUserId()) > 0 {
+		attrs = append(attrs, "user_id", task.GetUserId())
+	}
+	if len(task.GetRequestId()) > 0 {
+		attrs = append(attrs, "request_id", task.GetRequestId())
+	}
+	return attrs
+}
+
+func recordTaskCreated(task Task) {
+	metrics.TasksCreated.WithLabelValues(taskLabels(task)...).Inc()
+}
+
+func recordTaskCompleted(task Task) {
+	metrics.TasksCompleted.WithLabelValues(taskLabels(task)...).Inc()
+}
+
+func recordTaskFailed(task Task) {
+	metrics.TasksFailed.WithLabelValues(taskLabels(task)...).Inc()
+}
@@ -0,0 +1,48 @@
+package scheduler_test
+
+import (
+	"go-web/pkg/config"
+	"go-web/pkg/logger"
+	"go-web/pkg/scheduler"
+	"go-web/pkg/tracing"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedule_ShouldForwardTraceParent_WhenTaskTraced(t *testing.T) {
+	worker := newFakeWorker(t)
+	s := newTestScheduler(t, &config.Scheduler{}, worker)
+
+	traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	task := scheduler.NewTaskBuilder().
+		SetType(scheduler.TaskTypePdf).
+		SetSubType(scheduler.SubTaskTypePdf2Img).
+		SetTraceParent(traceParent).
+		Build()
+	_, err := s.Schedule(task)
+	assert.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", tracing.TraceId(worker.LastHeader(tracing.TraceParentHeader)))
+
+	_, err = s.GetTaskStatus(task.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", tracing.TraceId(worker.LastHeader(tracing.TraceParentHeader)))
+
+	got, err := s.GetTask(task.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, traceParent, got.GetTraceParent())
+}
+
+func TestSchedule_ShouldForwardRequestId_WhenTaskHasOne(t *testing.T) {
+	worker := newFakeWorker(t)
+	s := newTestScheduler(t, &config.Scheduler{}, worker)
+
+	task := scheduler.NewTaskBuilder().
+		SetType(scheduler.TaskTypePdf).
+		SetSubType(scheduler.SubTaskTypePdf2Img).
+		SetRequestId("req-1").
+		Build()
+	_, err := s.Schedule(task)
+	assert.NoError(t, err)
+	assert.Equal(t, "req-1", worker.LastHeader(logger.RequestIdHeader))
+}
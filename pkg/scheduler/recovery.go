@@ -0,0 +1,136 @@
+package scheduler
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// recoveryInterval is how often recovered tasks are offered to the workers
+// until they registered.
+const recoveryInterval = time.Second
+
+// recovery dispatches the PENDING tasks the journal store restores that a
+// previous run never handed to a worker. In stream mode the stream still
+// holds them, in direct mode nothing else would. It only runs with the
+// journal store, its tasks belong to this replica alone: the tasks without a
+// worker in a shared store may be dispatched or held by another replica
+// right now. The tasks are dispatched on the leader only until none is left.
+// In degraded mode they are held again instead, the held queue only lives in
+// memory.
+type recovery struct {
+	s       *Scheduler
+	enabled bool
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newRecovery(s *Scheduler, directDispatch bool) *recovery {
+	return &recovery{
+		s:       s,
+		enabled: directDispatch,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// start lists the tasks before this replica schedules any, so it is not
+// dispatching any of them already.
+func (r *recovery) start() {
+	if !r.enabled {
+		close(r.stopped)
+		return
+	}
+	ids, err := r.list()
+	if err != nil {
+		slog.Error("list recovered tasks error", "error", err)
+	}
+	if len(ids) == 0 {
+		close(r.stopped)
+		return
+	}
+	go func() {
+		defer close(r.stopped)
+		r.run(ids)
+	}()
+}
+
+func (r *recovery) stop() {
+	close(r.done)
+	<-r.stopped
+}
+
+// run dispatches the tasks whenever this replica leads until none is left.
+func (r *recovery) run(ids []string) {
+	ticker := time.NewTicker(recoveryInterval)
+	defer ticker.Stop()
+	for {
+		if r.s.leader.Guard() == nil {
+			if ids = r.dispatch(ids); len(ids) == 0 {
+				return
+			}
+		}
+
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// list returns the PENDING tasks without a worker.
+func (r *recovery) list() ([]string, error) {
+	tasks, err := r.s.tm.ListTasks(TaskFilter{State: TaskStateCreated})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0)
+	for _, task := range tasks {
+		if len(task.GetWorkerId()) == 0 {
+			ids = append(ids, task.GetId())
+		}
+	}
+	if len(ids) > 0 {
+		slog.Info("recovered tasks waiting for a worker", "count", len(ids))
+	}
+	return ids, nil
+}
+
+// dispatch sends the tasks to the workers in order and returns the ones left
+// when no worker is available or a failed dispatch is tried again.
+func (r *recovery) dispatch(ids []string) []string {
+	for i, id := range ids {
+		task, err := r.s.tm.GetTask(id)
+		if errors.Is(err, ErrTaskNotFound) {
+			continue
+		}
+		if err != nil {
+			slog.Error("get recovered task error", "task_id", id, "error", err)
+			return ids[i:]
+		}
+		// cancelled, expired or dispatched in the meantime
+		if task.GetState() != TaskStateCreated || len(task.GetWorkerId()) > 0 {
+			continue
+		}
+		if r.s.admission.hold {
+			r.s.admission.holdTask(task)
+			r.s.admission.releaseNow()
+			continue
+		}
+
+		_, err = r.s.dispatcher.Dispatch(task)
+		if errors.Is(err, ErrNoWorkerAvailable) {
+			return ids[i:]
+		}
+		if err != nil {
+			slog.Warn("dispatch recovered task error", append(taskLogAttrs(task), "error", err)...)
+			if r.s.retryUndispatched(task, err) {
+				return ids[i:]
+			}
+			continue
+		}
+		slog.Info("recovered task dispatched", append(taskLogAttrs(task), "worker_id", task.GetWorkerId())...)
+	}
+	return nil
+}
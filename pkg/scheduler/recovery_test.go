@@ -0,0 +1,80 @@
+package scheduler_test
+
+import (
+	"go-web/pkg/config"
+	"go-web/pkg/scheduler"
+	"go-web/pkg/scheduler/schedulertest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStart_ShouldDispatchRecoveredTasks_WhenWorkerRegisters(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestJournalStore(t, dir)
+	task := newJournalTestTask()
+	assert.NoError(t, store.AddTask(task))
+	assert.NoError(t, store.Close())
+
+	// no worker yet, the recovered task waits for one
+	s := schedulertest.NewScheduler(t, &config.Scheduler{
+		TaskConfig: config.TaskConfig{Journal: config.Journal{Dir: dir}},
+	})
+	worker := newFakeWorker(t)
+	schedulertest.Register(t, s, worker)
+
+	schedulertest.AssertSubmitted(t, worker, 1)
+	assert.Eventually(t, func() bool {
+		got, err := s.GetTask(task.GetId())
+		return err == nil && got.GetWorkerId() == worker.GetId()
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestStart_ShouldHoldRecoveredTasks_WhenDegradedAndWorkersBusy(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestJournalStore(t, dir)
+	running, pending := newJournalTestTask(), newJournalTestTask()
+	assert.NoError(t, store.AddTask(running))
+	assert.NoError(t, store.UpdateTaskWorker(running.GetId(), "w1", "wt1"))
+	assert.NoError(t, store.AddTask(pending))
+	assert.NoError(t, store.Close())
+
+	s := schedulertest.NewScheduler(t, &config.Scheduler{
+		TaskConfig: config.TaskConfig{Journal: config.Journal{Dir: dir}},
+		Admission:  config.Admission{MaxActive: 1, Degraded: true},
+	})
+
+	assert.Eventually(t, func() bool {
+		users := s.QueuedUsers()
+		return len(users) == 1 && users[0].UserId == "u1" && users[0].Queued == 1
+	}, 5*time.Second, 50*time.Millisecond)
+	got, err := s.GetTask(pending.GetId())
+	assert.NoError(t, err)
+	assert.Empty(t, got.GetWorkerId())
+}
+
+func TestStart_ShouldLeaveTasksOfOtherReplicas_WhenStoreShared(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := &config.Scheduler{
+		TaskConfig: config.TaskConfig{StoreType: scheduler.TaskStoreTypeRedis},
+		Redis:      config.RedisStore{ClusterMode: scheduler.RedisClusterModeStandalone, Addrs: []string{mr.Addr()}},
+		Admission:  config.Admission{MaxActive: 1, Degraded: true},
+	}
+	first := newTestScheduler(t, cfg, newFakeWorker(t))
+	scheduleRunningTask(t, first)
+	held := newUserTask("u1")
+	_, err := first.Schedule(held)
+	assert.NoError(t, err)
+
+	second := schedulertest.NewScheduler(t, cfg)
+	worker := schedulertest.NewFakeWorker(t, "w2")
+	schedulertest.Register(t, second, worker)
+
+	assert.Never(t, func() bool {
+		return len(worker.Submissions()) > 0
+	}, 2*time.Second, 50*time.Millisecond)
+	assert.Empty(t, second.QueuedUsers())
+	assert.Len(t, first.QueuedUsers(), 1)
+}
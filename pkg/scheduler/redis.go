@@ -0,0 +1,49 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"go-web/pkg/metrics"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NewRedisClient creates a redis client for the configured cluster mode and
+// makes sure the server is reachable.
+func NewRedisClient(cfg *RedisConfig) (redis.UniversalClient, error) {
+	var client redis.UniversalClient
+	if cfg.ClusterMode == RedisClusterModeStandalone {
+		client = redis.NewClient(&redis.Options{
+			Addr:     cfg.Addrs[0],
+			Password: cfg.Password,
+			DB:       cfg.DB,
+		})
+	} else if cfg.ClusterMode == RedisClusterModeCluster {
+		client = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    cfg.Addrs,
+			Password: cfg.Password,
+		})
+	} else if cfg.ClusterMode == RedisClusterModeSentinel {
+		client = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    cfg.Addrs[0],
+			SentinelAddrs: cfg.Addrs[1:],
+			Password:      cfg.Password,
+			DB:            cfg.DB,
+		})
+	} else {
+		return nil, fmt.Errorf("unsupported redis cluster mode: %s", cfg.ClusterMode)
+	}
+
+	client.AddHook(metrics.RedisHook{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := client.Ping(ctx).Result()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
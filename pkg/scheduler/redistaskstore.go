@@ -0,0 +1,726 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// The keys of the task store share the hash tag {tasks}, a redis cluster
+// keeps them on one node so the scripts update a task and its indexes at
+// once.
+const (
+	// every task lives in its own hash, RedisTaskKeyPrefix + task id
+	RedisTaskKeyPrefix = "ktools:{tasks}:task:"
+	// sorted set of all task ids scored by creation time
+	RedisTaskIndexKey = "ktools:{tasks}:index"
+	// sorted sets of the unfinished tasks of a type, + task type. Queued
+	// tasks are scored by their queue deadline, dispatched ones by their
+	// dispatch time.
+	RedisTaskQueuedKeyPrefix     = "ktools:{tasks}:queued:"
+	RedisTaskDispatchedKeyPrefix = "ktools:{tasks}:dispatched:"
+	// sorted set of the task ids of a batch scored by creation time, + batch
+	// id
+	RedisBatchKeyPrefix = "ktools:{tasks}:batch:"
+	// sorted set of the finished batch ids scored by their finish time
+	RedisBatchFinishedKey = "ktools:{tasks}:finished-batches"
+	// hash of the earlier releases, a JSON document per task id. Its tasks
+	// are moved to their own hashes when the store is created.
+	RedisLegacyTaskKey = "ktools:task"
+)
+
+// TaskRedisDto is the hash layout of a task in redis.
+type TaskRedisDto struct {
+	State        string `redis:"state"`
+	Type         string `redis:"type"`
+	SubType      string `redis:"sub_type"`
+	Priority     int    `redis:"priority"`
+	UserId       string `redis:"user_id"`
+	WorkerId     string `redis:"worker_id"`
+	WorkerTaskId string `redis:"worker_task_id"`
+	UserDef      string `redis:"user_def"`
+	CreatedAt    int64  `redis:"created_at"` // unix nano
+	UpdatedAt    int64  `redis:"updated_at"` // unix nano
+	Attempts     int    `redis:"attempts"`
+	Errors       string `redis:"errors"`        // JSON array of TaskError
+	Deadline     int64  `redis:"deadline"`      // unix nano, 0 if none
+	DispatchedAt int64  `redis:"dispatched_at"` // unix nano, 0 while queued
+	TraceParent  string `redis:"trace_parent"`
+	RequestId    string `redis:"request_id"`
+	BatchId      string `redis:"batch_id"`
+	CacheKey     string `redis:"cache_key"`
+}
+
+// redisIndexTaskLua defines index_task, which moves the task of hash key to
+// the stage set it belongs in and out of the other one, or out of both when
+// the task is dropped. Queued tasks without a deadline are scored after all
+// others.
+var redisIndexTaskLua = `
+local function task_id(key)
+	return string.sub(key, ` + strconv.Itoa(len(RedisTaskKeyPrefix)+1) + `)
+end
+
+local function index_task(key, drop)
+	local id = task_id(key)
+	local task = redis.call('HMGET', key, 'state', 'type', 'worker_id', 'deadline', 'dispatched_at')
+	local queued = '` + RedisTaskQueuedKeyPrefix + `' .. (task[2] or '')
+	local dispatched = '` + RedisTaskDispatchedKeyPrefix + `' .. (task[2] or '')
+	local assigned = task[3] and task[3] ~= ''
+	if drop then
+		redis.call('ZREM', queued, id)
+		redis.call('ZREM', dispatched, id)
+	elseif task[1] == '` + TaskStateCreated + `' and not assigned then
+		local deadline = task[4]
+		if not deadline or deadline == '0' then
+			deadline = '+inf'
+		end
+		redis.call('ZADD', queued, deadline, id)
+		redis.call('ZREM', dispatched, id)
+	elseif (task[1] == '` + TaskStateCreated + `' or task[1] == '` + TaskStateRunning + `') and assigned then
+		redis.call('ZADD', dispatched, task[5] or 0, id)
+		redis.call('ZREM', queued, id)
+	else
+		redis.call('ZREM', queued, id)
+		redis.call('ZREM', dispatched, id)
+	end
+end
+`
+
+// add the task of hash KEYS[1]. ARGV[1] is 1 to keep a task that exists
+// already, ARGV[2] the creation score, ARGV[3] the batch id and ARGV[4:] the
+// fields. Returns 0 if the task was kept.
+var addTaskScript = redis.NewScript(redisIndexTaskLua + `
+if ARGV[1] == '1' and redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+local id = task_id(KEYS[1])
+redis.call('HSET', KEYS[1], unpack(ARGV, 4))
+redis.call('ZADD', '` + RedisTaskIndexKey + `', ARGV[2], id)
+if ARGV[3] ~= '' then
+	redis.call('ZADD', '` + RedisBatchKeyPrefix + `' .. ARGV[3], ARGV[2], id)
+end
+index_task(KEYS[1])
+return 1
+`)
+
+// compare-and-set of the task state. ARGV[1] is the new state, ARGV[2] the
+// update time and ARGV[3:] the states the task may currently be in.
+// Returns -1 if the task does not exist, 0 if the transition is not allowed.
+var updateTaskStateScript = redis.NewScript(redisIndexTaskLua + `
+local current = redis.call('HGET', KEYS[1], 'state')
+if not current then
+	return -1
+end
+for i = 3, #ARGV do
+	if ARGV[i] == current then
+		redis.call('HSET', KEYS[1], 'state', ARGV[1], 'updated_at', ARGV[2])
+		index_task(KEYS[1])
+		return 1
+	end
+end
+return 0
+`)
+
+// move the task from ARGV[3] to ARGV[1] while worker ARGV[4] runs it
+var swapTaskStateScript = redis.NewScript(redisIndexTaskLua + `
+local current = redis.call('HMGET', KEYS[1], 'state', 'worker_id')
+if not current[1] then
+	return -1
+end
+if current[1] ~= ARGV[3] or (current[2] or '') ~= ARGV[4] then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'state', ARGV[1], 'updated_at', ARGV[2])
+index_task(KEYS[1])
+return 1
+`)
+
+// set fields on an existing task only, never recreate a deleted one
+var updateTaskFieldsScript = redis.NewScript(redisIndexTaskLua + `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return -1
+end
+redis.call('HSET', KEYS[1], unpack(ARGV))
+index_task(KEYS[1])
+return 1
+`)
+
+// index the task of hash KEYS[1] again, e.g. after it was listed from a
+// stage set it left
+var indexTaskScript = redis.NewScript(redisIndexTaskLua + `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return -1
+end
+index_task(KEYS[1])
+return 1
+`)
+
+// delete the task of hash KEYS[1] and its indexes but the one of its batch
+var delTaskScript = redis.NewScript(redisIndexTaskLua + `
+index_task(KEYS[1], true)
+redis.call('DEL', KEYS[1])
+redis.call('ZREM', '` + RedisTaskIndexKey + `', task_id(KEYS[1]))
+return 1
+`)
+
+// delete batch ARGV[1] with its tasks
+var delBatchScript = redis.NewScript(redisIndexTaskLua + `
+local batch = '` + RedisBatchKeyPrefix + `' .. ARGV[1]
+for _, id in ipairs(redis.call('ZRANGE', batch, 0, -1)) do
+	local key = '` + RedisTaskKeyPrefix + `' .. id
+	index_task(key, true)
+	redis.call('DEL', key)
+	redis.call('ZREM', '` + RedisTaskIndexKey + `', id)
+end
+redis.call('DEL', batch)
+redis.call('ZREM', '` + RedisBatchFinishedKey + `', ARGV[1])
+return 1
+`)
+
+// append ARGV[1], a JSON encoded TaskError, to the error history and count
+// the attempt. ARGV[2] is the update time.
+var addTaskErrorScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return -1
+end
+local errors = redis.call('HGET', KEYS[1], 'errors')
+if not errors or errors == '' or errors == '[]' then
+	errors = '[' .. ARGV[1] .. ']'
+else
+	errors = string.sub(errors, 1, -2) .. ',' .. ARGV[1] .. ']'
+end
+redis.call('HSET', KEYS[1], 'errors', errors, 'updated_at', ARGV[2])
+redis.call('HINCRBY', KEYS[1], 'attempts', 1)
+return 1
+`)
+
+// RedisTaskStore keeps every task in its own hash. State transitions run as
+// lua scripts so a worker callback and the status poller cannot overwrite
+// each other.
+type RedisTaskStore struct {
+	client redis.UniversalClient
+}
+
+func NewRedisTaskStore(redisCfg *RedisConfig) (*RedisTaskStore, error) {
+	client, err := NewRedisClient(redisCfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &RedisTaskStore{
+		client: client,
+	}
+	if err := s.migrateLegacyTasks(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("migrate redis tasks: %w", err)
+	}
+	return s, nil
+}
+
+func redisTaskKey(id string) string {
+	return RedisTaskKeyPrefix + id
+}
+
+func (s *RedisTaskStore) AddTask(task Task) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	_, err := s.addTask(ctx, task, false)
+	return err
+}
+
+// addTask writes the task with its indexes, with keep an existing task is
+// left alone and added reports false.
+func (s *RedisTaskStore) addTask(ctx context.Context, task Task, keep bool) (added bool, err error) {
+	userDef, err := json.Marshal(task.GetUserDef())
+	if err != nil {
+		return false, err
+	}
+	var taskErrors []byte
+	if len(task.GetErrors()) > 0 {
+		if taskErrors, err = json.Marshal(task.GetErrors()); err != nil {
+			return false, err
+		}
+	}
+
+	keepArg := "0"
+	if keep {
+		keepArg = "1"
+	}
+	args := []interface{}{
+		keepArg,
+		task.GetCreatedAt().UnixMilli(),
+		task.GetBatchId(),
+		"state", string(task.GetState()),
+		"type", string(task.GetType()),
+		"sub_type", string(task.GetSubType()),
+		"priority", int(task.GetPriority()),
+		"user_id", task.GetUserId(),
+		"worker_id", string(task.GetWorkerId()),
+		"worker_task_id", task.GetWorkerTaskId(),
+		"user_def", string(userDef),
+		"created_at", unixNano(task.GetCreatedAt()),
+		"updated_at", time.Now().UnixNano(),
+		"attempts", task.GetAttempts(),
+		"errors", string(taskErrors),
+		"deadline", unixNano(task.GetDeadline()),
+		"dispatched_at", unixNano(task.GetDispatchedAt()),
+		"trace_parent", task.GetTraceParent(),
+		"request_id", task.GetRequestId(),
+		"batch_id", task.GetBatchId(),
+		"cache_key", task.GetCacheKey(),
+	}
+	result, err := addTaskScript.Run(ctx, s.client, []string{redisTaskKey(task.GetId())}, args...).Int()
+	return result == 1, err
+}
+
+// TaskLegacyRedisDto is the JSON document of a task in RedisLegacyTaskKey.
+type TaskLegacyRedisDto struct {
+	State     string      `json:"task_state"`
+	Type      string      `json:"type"`
+	SubType   string      `json:"sub_type"`
+	Priority  int         `json:"priority"`
+	WorkerId  string      `json:"worker_id"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+	UserDef   interface{} `json:"user_def"`
+}
+
+// migrateLegacyTasks moves the tasks of RedisLegacyTaskKey to hashes of their
+// own and drops the old hash once it is empty. Replicas starting at the same
+// time add every task once, a task that is added already is not overwritten.
+func (s *RedisTaskStore) migrateLegacyTasks() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var cursor uint64
+	for {
+		values, next, err := s.client.HScan(ctx, RedisLegacyTaskKey, cursor, "", 100).Result()
+		if err != nil {
+			return err
+		}
+		for i := 0; i+1 < len(values); i += 2 {
+			id := values[i]
+			dto := &TaskLegacyRedisDto{}
+			if err := json.Unmarshal([]byte(values[i+1]), dto); err != nil {
+				slog.Warn("skip legacy redis task", "task_id", id, "error", err)
+				continue
+			}
+			task := NewTaskBuilder().
+				SetId(id).
+				SetState(TaskState(dto.State)).
+				SetType(TaskType(dto.Type)).
+				SetSubType(SubTaskType(dto.SubType)).
+				SetPriority(TaskPriority(dto.Priority)).
+				SetWorkerId(WorkerId(dto.WorkerId)).
+				SetUserDef(dto.UserDef).
+				SetCreatedAt(dto.CreatedAt).
+				Build()
+			added, err := s.addTask(ctx, task, true)
+			if err != nil {
+				return err
+			}
+			if err := s.client.HDel(ctx, RedisLegacyTaskKey, id).Err(); err != nil {
+				return err
+			}
+			if added {
+				slog.Info("legacy redis task migrated", "task_id", id)
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// index moves a task to the stage set it belongs in, e.g. after it was
+// listed from a set it left.
+func (s *RedisTaskStore) index(ctx context.Context, id string) error {
+	return indexTaskScript.Run(ctx, s.client, []string{redisTaskKey(id)}).Err()
+}
+
+func (s *RedisTaskStore) GetTask(id string) (Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	cmd := s.client.HGetAll(ctx, redisTaskKey(id))
+	return s.toTask(id, cmd)
+}
+
+func (s *RedisTaskStore) GetTasks(ids []string) ([]Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	cmds := make([]*redis.MapStringStringCmd, len(ids))
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.HGetAll(ctx, redisTaskKey(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make([]Task, 0, len(ids))
+	for i, id := range ids {
+		task, err := s.toTask(id, cmds[i])
+		if errors.Is(err, ErrTaskNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+func (s *RedisTaskStore) toTask(id string, cmd *redis.MapStringStringCmd) (Task, error) {
+	if cmd.Err() != nil {
+		return nil, cmd.Err()
+	}
+	if len(cmd.Val()) == 0 {
+		return nil, taskNotFound(id)
+	}
+
+	taskRedisDto := &TaskRedisDto{}
+	if err := cmd.Scan(taskRedisDto); err != nil {
+		return nil, err
+	}
+
+	var userDef interface{}
+	if len(taskRedisDto.UserDef) > 0 {
+		if err := json.Unmarshal([]byte(taskRedisDto.UserDef), &userDef); err != nil {
+			return nil, err
+		}
+	}
+	var taskErrors []TaskError
+	if len(taskRedisDto.Errors) > 0 {
+		if err := json.Unmarshal([]byte(taskRedisDto.Errors), &taskErrors); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewTaskBuilder().
+		SetId(id).
+		SetWorkerTaskId(taskRedisDto.WorkerTaskId).
+		SetState(TaskState(taskRedisDto.State)).
+		SetType(TaskType(taskRedisDto.Type)).
+		SetSubType(SubTaskType(taskRedisDto.SubType)).
+		SetPriority(TaskPriority(taskRedisDto.Priority)).
+		SetWorkerId(WorkerId(taskRedisDto.WorkerId)).
+		SetUserId(taskRedisDto.UserId).
+		SetUserDef(userDef).
+		SetCreatedAt(fromUnixNano(taskRedisDto.CreatedAt)).
+		SetAttempts(taskRedisDto.Attempts).
+		SetErrors(taskErrors).
+		SetDeadline(fromUnixNano(taskRedisDto.Deadline)).
+		SetTraceParent(taskRedisDto.TraceParent).
+		SetRequestId(taskRedisDto.RequestId).
+		SetBatchId(taskRedisDto.BatchId).
+		SetCacheKey(taskRedisDto.CacheKey).
+		SetDispatchedAt(fromUnixNano(taskRedisDto.DispatchedAt)).
+		Build(), nil
+}
+
+// unixNano stores the zero time as 0, its UnixNano does not fit an int64.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+func (s *RedisTaskStore) DelTask(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	return delTaskScript.Run(ctx, s.client, []string{redisTaskKey(id)}).Err()
+}
+
+func (s *RedisTaskStore) UpdateTaskState(taskId string, state string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	from := statesBefore(TaskState(state))
+	args := make([]interface{}, 0, len(from)+2)
+	args = append(args, state, time.Now().UnixNano())
+	for _, s := range from {
+		args = append(args, string(s))
+	}
+
+	result, err := updateTaskStateScript.Run(ctx, s.client, []string{redisTaskKey(taskId)}, args...).Int()
+	if err != nil {
+		return err
+	}
+	switch result {
+	case -1:
+		return taskNotFound(taskId)
+	case 0:
+		current, err := s.client.HGet(ctx, redisTaskKey(taskId), "state").Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		return invalidTransition(taskId, TaskState(current), TaskState(state))
+	}
+	return nil
+}
+
+func (s *RedisTaskStore) SwapTaskState(taskId string, workerId WorkerId, from string, to string) error {
+	if !CanTransition(TaskState(from), TaskState(to)) {
+		return invalidTransition(taskId, TaskState(from), TaskState(to))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	result, err := swapTaskStateScript.Run(ctx, s.client, []string{redisTaskKey(taskId)},
+		to, time.Now().UnixNano(), from, string(workerId)).Int()
+	if err != nil {
+		return err
+	}
+	switch result {
+	case -1:
+		return taskNotFound(taskId)
+	case 0:
+		return stateChanged(taskId, TaskState(from))
+	}
+	return nil
+}
+
+func (s *RedisTaskStore) UpdateTaskWorker(taskId string, workerId WorkerId, workerTaskId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	result, err := updateTaskFieldsScript.Run(ctx, s.client, []string{redisTaskKey(taskId)},
+		"worker_id", string(workerId),
+		"worker_task_id", workerTaskId,
+		"dispatched_at", unixNano(dispatchTime(workerId)),
+		"updated_at", time.Now().UnixNano()).Int()
+	if err != nil {
+		return err
+	}
+	if result == -1 {
+		return taskNotFound(taskId)
+	}
+	return nil
+}
+
+func (s *RedisTaskStore) AddTaskError(taskId string, taskErr TaskError) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	data, err := json.Marshal(taskErr)
+	if err != nil {
+		return err
+	}
+	result, err := addTaskErrorScript.Run(ctx, s.client, []string{redisTaskKey(taskId)}, string(data), time.Now().UnixNano()).Int()
+	if err != nil {
+		return err
+	}
+	if result == -1 {
+		return taskNotFound(taskId)
+	}
+	return nil
+}
+
+func (s *RedisTaskStore) ResetTaskAttempts(taskId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	result, err := updateTaskFieldsScript.Run(ctx, s.client, []string{redisTaskKey(taskId)},
+		"attempts", 0,
+		"updated_at", time.Now().UnixNano()).Int()
+	if err != nil {
+		return err
+	}
+	if result == -1 {
+		return taskNotFound(taskId)
+	}
+	return nil
+}
+
+// ListTasks walks the creation index, or the index of the batch when the
+// filter selects one, and reads the state of every task. It is meant for
+// admin pages rather than hot paths.
+func (s *RedisTaskStore) ListTasks(filter TaskFilter) ([]Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	indexKey := RedisTaskIndexKey
+	if len(filter.BatchId) > 0 {
+		indexKey = RedisBatchKeyPrefix + filter.BatchId
+	}
+	ids, err := s.client.ZRange(ctx, indexKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]*redis.SliceCmd, len(ids))
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			fields[i] = pipe.HMGet(ctx, redisTaskKey(id), "state", "batch_id")
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	matched := make([]string, 0)
+	for i, id := range ids {
+		values := fields[i].Val()
+		if len(values) != 2 || values[0] == nil {
+			continue
+		}
+		batchId, _ := values[1].(string)
+		if !filter.match(TaskState(values[0].(string)), batchId) {
+			continue
+		}
+		matched = append(matched, id)
+		if filter.Limit > 0 && len(matched) == filter.Limit {
+			break
+		}
+	}
+	return s.GetTasks(matched)
+}
+
+func (s *RedisTaskStore) CountTasks() (map[TaskType]TaskCounts, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	taskTypes := TaskTypes()
+	queued := make([]*redis.IntCmd, len(taskTypes))
+	dispatched := make([]*redis.IntCmd, len(taskTypes))
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, taskType := range taskTypes {
+			queued[i] = pipe.ZCard(ctx, RedisTaskQueuedKeyPrefix+string(taskType))
+			dispatched[i] = pipe.ZCard(ctx, RedisTaskDispatchedKeyPrefix+string(taskType))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[TaskType]TaskCounts, len(taskTypes))
+	for i, taskType := range taskTypes {
+		if queued[i].Val() > 0 || dispatched[i].Val() > 0 {
+			counts[taskType] = TaskCounts{Queued: int(queued[i].Val()), Dispatched: int(dispatched[i].Val())}
+		}
+	}
+	return counts, nil
+}
+
+func (s *RedisTaskStore) ListQueuedTasks(deadlineBefore time.Time, limit int) ([]Task, error) {
+	return s.listStage(RedisTaskQueuedKeyPrefix, taskStageQueued, deadlineBefore, limit)
+}
+
+func (s *RedisTaskStore) ListDispatchedTasks(dispatchedBefore time.Time, limit int) ([]Task, error) {
+	return s.listStage(RedisTaskDispatchedKeyPrefix, taskStageDispatched, dispatchedBefore, limit)
+}
+
+// listStage reads the stage sets of every task type up to before and merges
+// them by score.
+func (s *RedisTaskStore) listStage(keyPrefix string, stage taskStage, before time.Time, limit int) ([]Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	taskTypes := TaskTypes()
+	cmds := make([]*redis.ZSliceCmd, len(taskTypes))
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, taskType := range taskTypes {
+			cmds[i] = pipe.ZRangeByScoreWithScores(ctx, keyPrefix+string(taskType), &redis.ZRangeBy{
+				Min:   "-inf",
+				Max:   "(" + strconv.FormatInt(before.UnixNano(), 10),
+				Count: int64(limit),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]redis.Z, 0)
+	for _, cmd := range cmds {
+		members = append(members, cmd.Val()...)
+	}
+	sort.SliceStable(members, func(i, j int) bool { return members[i].Score < members[j].Score })
+	ids := make([]string, 0, len(members))
+	for _, member := range members {
+		ids = append(ids, member.Member.(string))
+	}
+
+	tasks, err := s.GetTasks(ids)
+	if err != nil {
+		return nil, err
+	}
+	listed := make([]Task, 0, len(tasks))
+	for _, task := range tasks {
+		if stageOf(task.GetState(), task.GetWorkerId()) != stage {
+			if err := s.index(ctx, task.GetId()); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		listed = append(listed, task)
+		if limit > 0 && len(listed) == limit {
+			break
+		}
+	}
+	return listed, nil
+}
+
+func (s *RedisTaskStore) ListBatchTaskIds(batchId string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	return s.client.ZRange(ctx, RedisBatchKeyPrefix+batchId, 0, -1).Result()
+}
+
+func (s *RedisTaskStore) FinishBatch(batchId string, finishedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	return s.client.ZAdd(ctx, RedisBatchFinishedKey, redis.Z{
+		Score:  float64(finishedAt.UnixNano()),
+		Member: batchId,
+	}).Err()
+}
+
+func (s *RedisTaskStore) ListFinishedBatches(finishedBefore time.Time, limit int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	return s.client.ZRangeByScore(ctx, RedisBatchFinishedKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   "(" + strconv.FormatInt(finishedBefore.UnixNano(), 10),
+		Count: int64(limit),
+	}).Result()
+}
+
+func (s *RedisTaskStore) DelBatch(batchId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	return delBatchScript.Run(ctx, s.client, []string{RedisBatchKeyPrefix + batchId}, batchId).Err()
+}
+
+func (s *RedisTaskStore) Close() error {
+	return s.client.Close()
+}
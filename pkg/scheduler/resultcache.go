@@ -0,0 +1,282 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-web/pkg/config"
+	"go-web/pkg/metrics"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	ResultCacheStoreMemory = "memory"
+	ResultCacheStoreRedis  = "redis"
+
+	RedisResultCacheKeyPrefix = "ktools:cache:result:"
+
+	// DefaultResultCacheTTL is how long a result is served from the cache
+	DefaultResultCacheTTL = 24 * time.Hour
+	// resultCacheGrace keeps entries past their TTL, tasks served from an
+	// entry shortly before it expired still find their result
+	resultCacheGrace = time.Hour
+)
+
+// CachedResult is the result of a DONE task, tasks with the same cache key
+// are served from it.
+type CachedResult struct {
+	// TaskId is the task that produced the result, the artifacts are under
+	// its prefix
+	TaskId    string          `json:"task_id"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+	ExpiresAt time.Time       `json:"expires_at"`
+}
+
+func (r *CachedResult) expired() bool {
+	return !time.Now().Before(r.ExpiresAt)
+}
+
+// ResultCacheStore keeps cached results by cache key.
+type ResultCacheStore interface {
+	// Get returns the entry of key, nil if there is none. Entries are kept a
+	// while past their ExpiresAt.
+	Get(key string) (*CachedResult, error)
+	Put(key string, result *CachedResult) error
+}
+
+// InMemResultCacheStore keeps results in process memory, it is meant for
+// single node setups.
+type InMemResultCacheStore struct {
+	mu        sync.Mutex
+	entries   map[string]*CachedResult
+	lastSweep time.Time
+}
+
+func NewInMemResultCacheStore() ResultCacheStore {
+	return &InMemResultCacheStore{entries: map[string]*CachedResult{}, lastSweep: time.Now()}
+}
+
+func (s *InMemResultCacheStore) Get(key string) (*CachedResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result, ok := s.entries[key]
+	if !ok || time.Now().After(result.ExpiresAt.Add(resultCacheGrace)) {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// Put drops the entries past their grace at most once a minute.
+func (s *InMemResultCacheStore) Put(key string, result *CachedResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = result
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) < time.Minute {
+		return nil
+	}
+	s.lastSweep = now
+	for key, entry := range s.entries {
+		if now.After(entry.ExpiresAt.Add(resultCacheGrace)) {
+			delete(s.entries, key)
+		}
+	}
+	return nil
+}
+
+// RedisResultCacheStore keeps results as json strings that redis expires
+// after their grace.
+type RedisResultCacheStore struct {
+	client redis.UniversalClient
+}
+
+func NewRedisResultCacheStore(client redis.UniversalClient) ResultCacheStore {
+	return &RedisResultCacheStore{client: client}
+}
+
+func (s *RedisResultCacheStore) Get(key string) (*CachedResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	data, err := s.client.Get(ctx, RedisResultCacheKeyPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var result CachedResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (s *RedisResultCacheStore) Put(key string, result *CachedResult) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(result.ExpiresAt) + resultCacheGrace
+	return s.client.Set(ctx, RedisResultCacheKeyPrefix+key, data, ttl).Err()
+}
+
+// ResultCacheKey identifies a conversion by the content hash of its input
+// file, its type and sub type and its params. The params are compared as
+// canonical json without file_id, the hash stands for the file.
+func ResultCacheKey(fileHash string, taskType TaskType, subType SubTaskType, params interface{}) (string, error) {
+	if len(fileHash) == 0 {
+		return "", errors.New("file hash is empty")
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+	var canonical interface{}
+	if err := json.Unmarshal(data, &canonical); err != nil {
+		return "", err
+	}
+	if fields, ok := canonical.(map[string]interface{}); ok {
+		delete(fields, "file_id")
+	}
+	// maps marshal with sorted keys
+	data, err = json.Marshal(canonical)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", fileHash, taskType, subType)
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// resultCache stores the results of DONE tasks that have a cache key, store
+// is nil while the cache is disabled.
+type resultCache struct {
+	store ResultCacheStore
+	ttl   time.Duration
+}
+
+func newResultCache(cfg *config.Scheduler, redisCfg *RedisConfig, clients *[]io.Closer) (*resultCache, error) {
+	if !cfg.ResultCache.Enabled {
+		return &resultCache{}, nil
+	}
+	ttl := time.Duration(cfg.ResultCache.TTL) * time.Second
+	if ttl <= 0 {
+		ttl = DefaultResultCacheTTL
+	}
+
+	switch cfg.ResultCache.Store {
+	case ResultCacheStoreMemory, "":
+		return &resultCache{store: NewInMemResultCacheStore(), ttl: ttl}, nil
+	case ResultCacheStoreRedis:
+		client, err := NewRedisClient(redisCfg)
+		if err != nil {
+			return nil, err
+		}
+		*clients = append(*clients, client)
+		return &resultCache{store: NewRedisResultCacheStore(client), ttl: ttl}, nil
+	default:
+		return nil, fmt.Errorf("unsupported result cache store: %s", cfg.ResultCache.Store)
+	}
+}
+
+func (c *resultCache) enabled() bool {
+	return c.store != nil
+}
+
+// lookup returns the unexpired result for task, nil on a miss or without
+// lookup. A cache that cannot be read misses.
+func (c *resultCache) lookup(task Task, lookup bool) *CachedResult {
+	if !c.enabled() || len(task.GetCacheKey()) == 0 {
+		return nil
+	}
+	count := func(result string) {
+		metrics.ResultCacheRequests.WithLabelValues(string(task.GetSubType()), result).Inc()
+	}
+	if !lookup {
+		count("bypass")
+		return nil
+	}
+
+	result, err := c.store.Get(task.GetCacheKey())
+	if err != nil {
+		slog.Warn("get cached result error", append(taskLogAttrs(task), "error", err)...)
+	}
+	if result == nil || result.expired() {
+		count("miss")
+		return nil
+	}
+	count("hit")
+	return result
+}
+
+// put caches the result of a DONE task, a result is stored once per task.
+func (c *resultCache) put(task Task, data interface{}) {
+	if !c.enabled() || len(task.GetCacheKey()) == 0 || data == nil {
+		return
+	}
+	cached, err := c.store.Get(task.GetCacheKey())
+	if err == nil && cached != nil && cached.TaskId == task.GetId() {
+		return
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		slog.Warn("marshal task result error", append(taskLogAttrs(task), "error", err)...)
+		return
+	}
+	now := time.Now()
+	err = c.store.Put(task.GetCacheKey(), &CachedResult{
+		TaskId:    task.GetId(),
+		Data:      raw,
+		CreatedAt: now,
+		ExpiresAt: now.Add(c.ttl),
+	})
+	if err != nil {
+		slog.Warn("put cached result error", append(taskLogAttrs(task), "error", err)...)
+	}
+}
+
+// result is the status of a task served from the cache. It is DONE without
+// data once the entry is gone.
+func (c *resultCache) result(task Task) *TaskResult {
+	result := &TaskResult{
+		TaskId:  task.GetId(),
+		Type:    string(task.GetType()),
+		SubType: string(task.GetSubType()),
+		Status:  TaskStateDone,
+	}
+	var cached *CachedResult
+	var err error
+	if c.enabled() {
+		cached, err = c.store.Get(task.GetCacheKey())
+	}
+	if err != nil || cached == nil {
+		slog.Warn("cached result of task is gone", append(taskLogAttrs(task), "error", err)...)
+		return result
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(cached.Data, &data); err != nil {
+		slog.Warn("unmarshal cached result error", append(taskLogAttrs(task), "error", err)...)
+		return result
+	}
+	result.Data = data
+	result.CachedFrom = cached.TaskId
+	return result
+}
@@ -0,0 +1,88 @@
+package scheduler_test
+
+import (
+	"go-web/pkg/config"
+	"go-web/pkg/scheduler"
+	"go-web/pkg/scheduler/schedulertest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newCachedTask(t *testing.T, params map[string]interface{}) scheduler.Task {
+	key, err := scheduler.ResultCacheKey("sha256:f1", scheduler.TaskTypePdf, scheduler.SubTaskTypePdf2Img, params)
+	assert.NoError(t, err)
+	return scheduler.NewTaskBuilder().
+		SetType(scheduler.TaskTypePdf).
+		SetSubType(scheduler.SubTaskTypePdf2Img).
+		SetUserDef(params).
+		SetCacheKey(key).
+		Build()
+}
+
+func newCacheScheduler(t *testing.T) (*scheduler.Scheduler, *schedulertest.FakeWorker) {
+	worker := schedulertest.NewFakeWorker(t, "w1")
+	worker.SetArtifacts("page-1.png")
+	s := newTestScheduler(t, &config.Scheduler{ResultCache: config.ResultCache{Enabled: true}}, worker)
+	return s, worker
+}
+
+func TestSchedule_ShouldServeCachedResult_WhenSameConversionDone(t *testing.T) {
+	s, worker := newCacheScheduler(t)
+	first := newCachedTask(t, map[string]interface{}{"file_id": "f1", "dpi": 300})
+	_, err := s.Schedule(first)
+	assert.NoError(t, err)
+	schedulertest.WaitTaskState(t, s, first.GetId(), scheduler.TaskStateDone)
+
+	second := newCachedTask(t, map[string]interface{}{"dpi": 300, "file_id": "f1"})
+	_, err = s.Schedule(second)
+	assert.NoError(t, err)
+	assert.Equal(t, scheduler.TaskState(scheduler.TaskStateDone), second.GetState())
+	assert.Len(t, worker.Submissions(), 1)
+
+	result, err := s.GetTaskStatus(second.GetId())
+	if assert.NoError(t, err) {
+		assert.Equal(t, first.GetId(), result.CachedFrom)
+		assert.Equal(t, []scheduler.Artifact{
+			{Key: scheduler.ArtifactPrefix(first.GetId()) + "page-1.png", Name: "page-1.png"},
+		}, scheduler.TaskArtifacts(result))
+	}
+}
+
+func TestScheduleNoCache_ShouldDispatchTask_WhenResultCached(t *testing.T) {
+	s, worker := newCacheScheduler(t)
+	first := newCachedTask(t, map[string]interface{}{"file_id": "f1"})
+	_, err := s.Schedule(first)
+	assert.NoError(t, err)
+	schedulertest.WaitTaskState(t, s, first.GetId(), scheduler.TaskStateDone)
+
+	fresh := newCachedTask(t, map[string]interface{}{"file_id": "f1"})
+	_, err = s.ScheduleNoCache(fresh)
+	assert.NoError(t, err)
+	assert.Len(t, worker.Submissions(), 2)
+	schedulertest.WaitTaskState(t, s, fresh.GetId(), scheduler.TaskStateDone)
+
+	// the fresh result replaced the cached one
+	cached := newCachedTask(t, map[string]interface{}{"file_id": "f1"})
+	_, err = s.Schedule(cached)
+	assert.NoError(t, err)
+	result, err := s.GetTaskStatus(cached.GetId())
+	if assert.NoError(t, err) {
+		assert.Equal(t, fresh.GetId(), result.CachedFrom)
+	}
+}
+
+func TestResultCacheKey_ShouldIgnoreFileIdAndKeyOrder_WhenHashesEqual(t *testing.T) {
+	key := func(fileHash string, params map[string]interface{}) string {
+		k, err := scheduler.ResultCacheKey(fileHash, scheduler.TaskTypePdf, scheduler.SubTaskTypePdf2Img, params)
+		assert.NoError(t, err)
+		return k
+	}
+	base := key("sha256:a", map[string]interface{}{"file_id": "f1", "dpi": 300, "format": "png"})
+
+	assert.Equal(t, base, key("sha256:a", map[string]interface{}{"format": "png", "dpi": 300.0, "file_id": "f2"}))
+	assert.NotEqual(t, base, key("sha256:b", map[string]interface{}{"file_id": "f1", "dpi": 300, "format": "png"}))
+	assert.NotEqual(t, base, key("sha256:a", map[string]interface{}{"file_id": "f1", "dpi": 150, "format": "png"}))
+	_, err := scheduler.ResultCacheKey("", scheduler.TaskTypePdf, scheduler.SubTaskTypePdf2Img, nil)
+	assert.Error(t, err)
+}
@@ -1,19 +1,44 @@
 package scheduler
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"go-web/pkg/config"
+	"go-web/pkg/metrics"
+	"go-web/pkg/tracing"
+	"io"
+	"log/slog"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
-const ()
+// DefaultMaxAttempts is how often a task is tried before it is dead-lettered.
+const DefaultMaxAttempts = 3
 
 type Scheduler struct {
-	wm       *WorkerManager
-	started  atomic.Bool
-	executor Executor
-	store    TaskStore
+	wm          *WorkerManager
+	started     atomic.Bool
+	executor    Executor
+	tm          *TaskManager
+	leader      LeaderElector
+	dispatcher  Dispatcher
+	supervisor  *supervisor
+	admission   *admission
+	recovery    *recovery
+	cache       *resultCache
+	maxAttempts int
+	lbName      string
+	failures    *ringLog[FailureEvent]
+	events      *eventLog
+	inflight    *inflight
+	stopped     atomic.Bool
+	// clients are the redis clients opened besides the stores
+	clients []io.Closer
 }
 
 var (
@@ -28,12 +53,34 @@ func GetScheduler(cfg *config.Scheduler) *Scheduler {
 		if err != nil {
 			panic(err)
 		}
+		err = scheduler.Start()
+		if err != nil {
+			panic(err)
+		}
+		metrics.Registry.MustRegister(schedulerCollector{s: scheduler})
 	})
 	return scheduler
 }
 
 func NewScheduler(cfg *config.Scheduler) (*Scheduler, error) {
-	lb, err := NewLB(cfg.WorkerConfig.LoadBalancer)
+	// before the stream dispatcher creates a stream per task type
+	if err := LoadTaskTypes(cfg.TaskTypes); err != nil {
+		return nil, err
+	}
+	admissionCfg := newAdmissionCfg(cfg)
+	if err := checkAdmissionCfg(admissionCfg); err != nil {
+		return nil, err
+	}
+	fairnessCfg := newFairnessCfg(cfg)
+	if err := CheckFairnessCfg(fairnessCfg); err != nil {
+		return nil, err
+	}
+
+	lbName := cfg.WorkerConfig.LoadBalancer
+	if len(lbName) == 0 {
+		lbName = DefaultLoadBalancer
+	}
+	lb, err := NewLB(lbName)
 	if err != nil {
 		return nil, err
 	}
@@ -50,21 +97,138 @@ func NewScheduler(cfg *config.Scheduler) (*Scheduler, error) {
 		PoolTimeout:  time.Duration(cfg.Redis.Pool.IdleTimeout) * time.Second,
 		Password:     cfg.Redis.Password,
 	}
-	wmCfg := WorkerManagerCfg{RedisConfig: redisCfg}
+	var clients []io.Closer
+	leader, err := newLeaderElector(cfg, &redisCfg, &clients)
+	if err != nil {
+		return nil, err
+	}
+	wmCfg := WorkerManagerCfg{
+		RedisConfig: redisCfg,
+		StoreType:   cfg.WorkerConfig.WorkerStore,
+		Leader:      leader,
+	}
 
-	wm := NewWorkerManager(lb, wmCfg)
+	wm, err := NewWorkerManager(lb, wmCfg)
+	if err != nil {
+		return nil, err
+	}
 
 	executor := NewExecutor(wm)
 
-	return &Scheduler{
-		started:  atomic.Bool{},
-		wm:       wm,
-		executor: executor,
-		store:    NewInMemStore(),
-	}, nil
+	tm, err := NewTaskManager(TaskManagerConfig{
+		StoreType:   cfg.TaskConfig.StoreType,
+		RedisConfig: redisCfg,
+		SqlConfig: SqlConfig{
+			Dialect:         cfg.TaskConfig.Sql.Dialect,
+			DSN:             cfg.TaskConfig.Sql.DSN,
+			MaxOpenConns:    cfg.TaskConfig.Sql.MaxOpenConns,
+			MaxIdleConns:    cfg.TaskConfig.Sql.MaxIdleConns,
+			ConnMaxLifetime: time.Duration(cfg.TaskConfig.Sql.ConnMaxLifetime) * time.Second,
+		},
+		JournalConfig: JournalConfig{
+			Dir:              cfg.TaskConfig.Journal.Dir,
+			SnapshotInterval: time.Duration(cfg.TaskConfig.Journal.SnapshotInterval) * time.Second,
+			Fsync:            cfg.TaskConfig.Journal.Fsync,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	maxAttempts := cfg.Retry.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+
+	s := &Scheduler{
+		started:     atomic.Bool{},
+		wm:          wm,
+		executor:    executor,
+		tm:          tm,
+		leader:      leader,
+		maxAttempts: maxAttempts,
+		lbName:      lbName,
+		failures:    newRingLog[FailureEvent](DefaultFailureLogSize),
+		events:      newEventLog(DefaultEventLogSize),
+		inflight:    newInflight(),
+	}
+	dispatch := s.dispatchWithRetry
+	if cfg.Dispatch.Mode == DispatchModeStream {
+		// Schedule checks the workers' room itself in direct mode
+		dispatch = s.dispatchQueued
+	}
+	s.dispatcher, err = newDispatcher(cfg, &redisCfg, tm, dispatch, &clients)
+	if err != nil {
+		return nil, err
+	}
+	s.cache, err = newResultCache(cfg, &redisCfg, &clients)
+	if err != nil {
+		return nil, err
+	}
+	s.clients = clients
+	s.supervisor = newSupervisor(s, newSupervisorCfg(cfg))
+	_, directDispatch := s.dispatcher.(*directDispatcher)
+	s.admission = newAdmission(s, admissionCfg, fairnessCfg, directDispatch)
+	_, journal := tm.store.(*JournalStore)
+	s.recovery = newRecovery(s, directDispatch && journal)
+	return s, nil
+}
+
+func newSupervisorCfg(cfg *config.Scheduler) SupervisorCfg {
+	timeouts := make(map[SubTaskType]time.Duration, len(cfg.Timeout.Execution))
+	for subType, seconds := range cfg.Timeout.Execution {
+		timeouts[SubTaskType(subType)] = time.Duration(seconds) * time.Second
+	}
+	return SupervisorCfg{
+		Interval:                time.Duration(cfg.Timeout.SupervisorInterval) * time.Second,
+		ExecutionTimeouts:       timeouts,
+		DefaultExecutionTimeout: time.Duration(cfg.Timeout.DefaultExecution) * time.Second,
+		BatchRetention:          time.Duration(cfg.Timeout.BatchRetention) * time.Second,
+	}
+}
+
+func newDispatcher(cfg *config.Scheduler, redisCfg *RedisConfig, tm *TaskManager, dispatch DispatchFunc, clients *[]io.Closer) (Dispatcher, error) {
+	switch cfg.Dispatch.Mode {
+	case DispatchModeStream:
+		client, err := NewRedisClient(redisCfg)
+		if err != nil {
+			return nil, err
+		}
+		*clients = append(*clients, client)
+		return NewStreamDispatcher(client, tm, dispatch, StreamDispatcherCfg{
+			Consumer:  cfg.LeaderElection.ReplicaId,
+			ClaimIdle: time.Duration(cfg.Dispatch.ClaimIdle) * time.Second,
+			BatchSize: int64(cfg.Dispatch.BatchSize),
+		}), nil
+	case DispatchModeDirect, "":
+		return NewDirectDispatcher(dispatch), nil
+	default:
+		return nil, fmt.Errorf("unsupported dispatch mode: %s", cfg.Dispatch.Mode)
+	}
+}
+
+func newLeaderElector(cfg *config.Scheduler, redisCfg *RedisConfig, clients *[]io.Closer) (LeaderElector, error) {
+	if !cfg.LeaderElection.Enabled {
+		return NewStandaloneElector(), nil
+	}
+
+	client, err := NewRedisClient(redisCfg)
+	if err != nil {
+		return nil, err
+	}
+	*clients = append(*clients, client)
+	return NewRedisLeaderElector(client, LeaderElectorCfg{
+		ReplicaId:     cfg.LeaderElection.ReplicaId,
+		LeaseTTL:      time.Duration(cfg.LeaderElection.LeaseTTL) * time.Second,
+		RenewInterval: time.Duration(cfg.LeaderElection.RenewInterval) * time.Second,
+	}), nil
 }
 
 func (s *Scheduler) RegisterWorker(worker Worker) error {
+	if local, ok := worker.(*LocalWorker); ok {
+		// local workers report states directly instead of calling the api
+		local.SetReporter(s.UpdateTaskState)
+	}
 	s.wm.AddWorker(worker)
 	return nil
 }
@@ -75,18 +239,127 @@ func (s *Scheduler) DeRegisterWorker(id WorkerId) error {
 }
 
 func (s *Scheduler) Start() error {
+	if !s.started.CompareAndSwap(false, true) {
+		return nil
+	}
+	err := s.leader.Start()
+	if err != nil {
+		return err
+	}
+	err = s.dispatcher.Start()
+	if err != nil {
+		return err
+	}
+	s.supervisor.start()
+	s.admission.start()
+	s.recovery.start()
 	return nil
 }
 
+// Schedule stores a task and dispatches it. The span joins the trace of the
+// task's traceparent. Tasks over an admission limit, without a worker or
+// scheduled once Shutdown began fail with an OverloadError. A task whose
+// cache key has an unexpired result is stored as DONE and not dispatched.
 func (s *Scheduler) Schedule(task Task) (TaskFuture, error) {
-	err := s.store.AddTask(task)
+	return s.scheduleTraced(task, true)
+}
+
+// ScheduleNoCache schedules a task without looking up the result cache, its
+// result replaces the cached one.
+func (s *Scheduler) ScheduleNoCache(task Task) (TaskFuture, error) {
+	return s.scheduleTraced(task, false)
+}
+
+// ResultCacheEnabled reports whether tasks with a cache key are served from
+// the result cache.
+func (s *Scheduler) ResultCacheEnabled() bool {
+	return s.cache.enabled()
+}
+
+func (s *Scheduler) scheduleTraced(task Task, lookup bool) (TaskFuture, error) {
+	if !s.inflight.enter() {
+		return nil, s.admission.overloaded(ErrSchedulerStopped)
+	}
+	defer s.inflight.leave()
+
+	_, span := tracing.Tracer().Start(tracing.ContextWithTraceParent(task.GetTraceParent()), "scheduler.Schedule",
+		trace.WithAttributes(taskAttributes(task)...))
+	var future TaskFuture
+	var err error
+	if cached := s.cache.lookup(task, lookup); cached != nil {
+		future, err = s.serveCached(task, cached)
+	} else {
+		future, err = s.admitAndSchedule(task)
+	}
+	tracing.EndSpan(span, err)
+	return future, err
+}
+
+// serveCached stores a task as DONE with the result of the task that
+// produced cached, it passes no admission limit as no worker runs it.
+func (s *Scheduler) serveCached(task Task, cached *CachedResult) (TaskFuture, error) {
+	task.SetState(TaskStateDone)
+	if err := s.tm.AddTask(task); err != nil {
+		return nil, err
+	}
+	recordTaskCreated(task)
+	s.recordEvent(task, TaskEventCreated, TaskStateDone, "served from the result of task "+cached.TaskId)
+	s.finishBatch(task)
+	slog.Info("task served from result cache", append(taskLogAttrs(task), "cached_from", cached.TaskId)...)
+	return NewTaskFuture(task), nil
+}
+
+// Admit checks whether count tasks of taskType would be admitted now, so a
+// batch is rejected as a whole. Schedule checks every task again.
+func (s *Scheduler) Admit(taskType TaskType, count int) error {
+	_, err := s.admission.admit(taskType, count)
+	return err
+}
+
+func (s *Scheduler) admitAndSchedule(task Task) (TaskFuture, error) {
+	hold, err := s.admission.admit(task.GetType(), 1)
 	if err != nil {
 		return nil, err
 	}
+	future, err := s.schedule(task, hold)
+	if errors.Is(err, ErrNoWorkerAvailable) {
+		return nil, s.admission.overloaded(err)
+	}
+	return future, err
+}
 
-	future, err := s.executor.Execute(task)
+// schedule stores a task and dispatches it, or with hold leaves it to the
+// admission to dispatch once the workers have room.
+func (s *Scheduler) schedule(task Task, hold bool) (TaskFuture, error) {
+	err := s.tm.AddTask(task)
+	if err != nil {
+		return nil, err
+	}
+	recordTaskCreated(task)
+	s.recordEvent(task, TaskEventCreated, TaskStateCreated, "")
+	slog.Info("task created", taskLogAttrs(task)...)
+
+	if hold && s.admission.hold {
+		s.admission.holdTask(task)
+		s.admission.releaseNow()
+		slog.Info("task held until the workers have room", taskLogAttrs(task)...)
+		return NewTaskFuture(task), nil
+	}
+
+	future, err := s.dispatcher.Dispatch(task)
 	if err != nil {
-		delErr := s.store.DelTask(task.GetId())
+		// a dead-lettered task stays for the admin to look at
+		if errors.Is(err, ErrTaskDeadLettered) {
+			return nil, err
+		}
+		// the batch reports the task as failed instead of losing it
+		if len(task.GetBatchId()) > 0 {
+			if failErr := s.failUndispatched(task, err); failErr != nil {
+				return nil, failErr
+			}
+			return nil, err
+		}
+		delErr := s.tm.DelTask(task.GetId())
 		if delErr != nil {
 			return nil, delErr
 		}
@@ -96,13 +369,113 @@ func (s *Scheduler) Schedule(task Task) (TaskFuture, error) {
 	return future, nil
 }
 
-func (s *Scheduler) Stop() error {
-	err := s.executor.Stop()
+// failUndispatched fails a task of a batch that could not be dispatched, so
+// it is not left PENDING with nobody to dispatch it. A dead-lettered task
+// already has its final state.
+func (s *Scheduler) failUndispatched(task Task, err error) error {
+	if errors.Is(err, ErrTaskDeadLettered) {
+		return nil
+	}
+	if recordErr := s.tm.AddTaskError(task.GetId(), TaskError{Message: err.Error(), Time: time.Now()}); recordErr != nil {
+		return recordErr
+	}
+	if stateErr := s.tm.UpdateTaskState(task.GetId(), TaskStateFailure); stateErr != nil {
+		return stateErr
+	}
+	s.finishBatch(task)
+	return nil
+}
+
+// retryUndispatched counts a failed dispatch of a held or recovered task as an
+// attempt, so an error that passes is tried again and one that persists
+// dead-letters the task. It reports whether the task should be dispatched
+// again, a task whose failure cannot be recorded is as well.
+func (s *Scheduler) retryUndispatched(task Task, err error) bool {
+	if errors.Is(err, ErrTaskDeadLettered) {
+		return false
+	}
+	requeue, failErr := s.failTask(task.GetId(), task.GetWorkerId(), err.Error())
+	if failErr != nil {
+		slog.Error("record dispatch failure error", "task_id", task.GetId(), "error", failErr)
+		return true
+	}
+	if !requeue {
+		slog.Warn("task dead-lettered", append(taskLogAttrs(task), "attempts", s.maxAttempts)...)
+	}
+	return requeue
+}
+
+// dispatchQueued dispatches a task taken from the stream. While the workers
+// have no room, no worker is available or other tasks are held, the task is
+// held as well and dispatched in turn with the tasks of the other users.
+func (s *Scheduler) dispatchQueued(task Task) (TaskFuture, error) {
+	if s.admission.heldCount() == 0 && s.admission.ready(task.GetType()) == nil {
+		future, err := s.dispatchWithRetry(task)
+		if !errors.Is(err, ErrNoWorkerAvailable) {
+			return future, err
+		}
+	}
+	s.admission.holdTask(task)
+	s.admission.releaseNow()
+	return nil, errTaskHeld
+}
+
+// dispatchWithRetry tries workers until one accepts the task or the task runs
+// out of attempts. Having no worker at all is not the fault of the task and
+// does not count as an attempt.
+func (s *Scheduler) dispatchWithRetry(task Task) (TaskFuture, error) {
+	for {
+		future, err := s.dispatchToWorker(task)
+		if err == nil || errors.Is(err, ErrNoWorkerAvailable) {
+			return future, err
+		}
+
+		requeue, failErr := s.failTask(task.GetId(), task.GetWorkerId(), err.Error())
+		if failErr != nil {
+			return nil, failErr
+		}
+		if !requeue {
+			return nil, fmt.Errorf("%w: %v", ErrTaskDeadLettered, err)
+		}
+	}
+}
+
+// dispatchToWorker sends a stored task to a worker and records which worker
+// runs it.
+func (s *Scheduler) dispatchToWorker(task Task) (TaskFuture, error) {
+	start := time.Now()
+	future, err := s.executor.Execute(task)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	metrics.TaskDispatchDuration.WithLabelValues(string(task.GetType())).Observe(time.Since(start).Seconds())
 
-	return s.wm.Stop()
+	err = s.tm.UpdateTaskWorker(task.GetId(), task.GetWorkerId(), task.GetWorkerTaskId())
+	if err != nil {
+		return nil, err
+	}
+	s.recordEvent(task, TaskEventDispatched, "", "")
+
+	return future, nil
+}
+
+// Stop shuts the scheduler down without a deadline, see Shutdown.
+func (s *Scheduler) Stop() error {
+	return s.Shutdown(context.Background())
+}
+
+// IsLeader reports whether this replica runs the singleton scheduler duties.
+func (s *Scheduler) IsLeader() bool {
+	return s.leader.IsLeader()
+}
+
+// DispatchBacklog returns the number of queued tasks per task type, it is
+// empty unless tasks are dispatched through streams.
+func (s *Scheduler) DispatchBacklog() (map[TaskType]int64, error) {
+	if sd, ok := s.dispatcher.(*StreamDispatcher); ok {
+		return sd.Backlog()
+	}
+	return map[TaskType]int64{}, nil
 }
 
 func (s *Scheduler) GetWorkers() []Worker {
@@ -110,35 +483,414 @@ func (s *Scheduler) GetWorkers() []Worker {
 }
 
 func (s *Scheduler) GetTaskStatus(taskId string) (*TaskResult, error) {
-	task, err := s.store.GetTask(taskId)
+	task, err := s.tm.GetTask(taskId)
 	if err != nil {
 		return nil, err
 	}
 
-	workerTaskResult, err := s.executor.GetTaskStatus(task.GetWorkerTaskId(), task.GetWorkerId())
+	ctx, span := tracing.Tracer().Start(tracing.ContextWithTraceParent(task.GetTraceParent()), "scheduler.GetTaskStatus",
+		trace.WithAttributes(taskAttributes(task)...))
+	result, err := s.getTaskStatus(ctx, task)
+	tracing.EndSpan(span, err)
+	return result, err
+}
+
+func (s *Scheduler) getTaskStatus(ctx context.Context, task Task) (*TaskResult, error) {
+	taskId := task.GetId()
+
+	// served from the result cache, no worker ran it
+	if task.GetState() == TaskStateDone && len(task.GetWorkerId()) == 0 && len(task.GetCacheKey()) > 0 {
+		s.releaseTask(task)
+		return s.cache.result(task), nil
+	}
+
+	// still queued, cancelled or dead-lettered, there is no worker to ask
+	if len(task.GetWorkerId()) == 0 || task.GetState() == TaskStateCancelled || task.GetState() == TaskStateDeadLetter {
+		return &TaskResult{
+			TaskId:  taskId,
+			Type:    string(task.GetType()),
+			SubType: string(task.GetSubType()),
+			Status:  task.GetState(),
+		}, nil
+	}
+
+	workerTaskResult, err := s.executor.GetTaskStatus(ctx, task.GetWorkerTaskId(), task.GetWorkerId())
 	if err != nil {
 		return nil, err
 	}
 
-	// need to delete task from task store if task has reached its end state
-	if workerTaskResult.TaskStatus == TaskStateDone ||
-		workerTaskResult.TaskStatus == TaskStateFailure ||
-		workerTaskResult.TaskStatus == TaskStateCancelled {
-		s.store.DelTask(taskId)
+	// the worker callback or another poll may have moved the task already,
+	// only the caller whose swap succeeds handles the new state
+	status := workerTaskResult.TaskStatus
+	changed := false
+	if status != task.GetState() {
+		err = s.tm.SwapTaskState(taskId, task.GetWorkerId(), task.GetState(), status)
+		if err != nil && !errors.Is(err, ErrInvalidStateTransition) && !errors.Is(err, ErrTaskStateChanged) {
+			slog.Error("update task state error", "task_id", taskId, "error", err)
+		}
+		changed = err == nil
+	}
+	if changed {
+		s.recordEvent(task, TaskEventState, status, "")
+	}
+
+	switch {
+	case status == TaskStateDone:
+		if changed {
+			recordTaskCompleted(task)
+			s.finishBatch(task)
+		}
+		s.cache.put(task, workerTaskResult.Data)
+		s.releaseTask(task)
+	case status == TaskStateFailure:
+		if changed {
+			status = s.handleTaskFailure(task, taskErrorMessage(workerTaskResult.Data))
+		}
+	case IsFinalState(status):
+		if changed {
+			s.finishBatch(task)
+		}
+		// need to delete task from task store if task has reached its end state
+		s.releaseTask(task)
 	}
 
 	return &TaskResult{
 		TaskId: taskId,
-		Status: workerTaskResult.TaskStatus,
+		Status: status,
 		Data:   workerTaskResult.Data,
 	}, nil
 }
 
-func (s *Scheduler) UpdateTaskState(taskId string, state string) error {
-	_, err := s.store.GetTask(taskId)
+// releaseTask drops a finished task. Tasks of a batch stay so the batch can
+// still report them, the supervisor deletes them with the finished batch.
+func (s *Scheduler) releaseTask(task Task) {
+	s.completeTask(task)
+	if len(task.GetBatchId()) > 0 {
+		return
+	}
+	if err := s.tm.DelTask(task.GetId()); err != nil {
+		slog.Error("delete finished task error", "task_id", task.GetId(), "error", err)
+	}
+}
+
+// completeTask tells the dispatcher a task will not run again.
+func (s *Scheduler) completeTask(task Task) {
+	if completer, ok := s.dispatcher.(taskCompleter); ok {
+		completer.Complete(task)
+	}
+}
+
+// CancelTask stops a task that has not finished yet. A dispatched task is
+// cancelled on its worker as well, the task stays cancelled even if the
+// worker cannot be reached.
+func (s *Scheduler) CancelTask(taskId string) error {
+	task, err := s.tm.GetTask(taskId)
+	if err != nil {
+		return err
+	}
+	// fails once the task finished, the store checks the state atomically
+	if err := s.tm.UpdateTaskState(taskId, TaskStateCancelled); err != nil {
+		return err
+	}
+	s.recordEvent(task, TaskEventCancelled, TaskStateCancelled, "")
+	slog.Info("task cancelled", taskLogAttrs(task)...)
+	s.completeTask(task)
+	s.finishBatch(task)
+
+	if len(task.GetWorkerId()) > 0 {
+		if err := s.executor.CancelTask(task.GetWorkerTaskId(), task.GetWorkerId()); err != nil {
+			slog.Warn("cancel task on worker error", append(taskLogAttrs(task), "worker_id", task.GetWorkerId(), "error", err)...)
+		}
+	}
+	return nil
+}
+
+// ListBatch returns the tasks of a batch, oldest first. Tasks a worker runs
+// are polled first like GetTaskStatus does.
+func (s *Scheduler) ListBatch(batchId string) ([]Task, error) {
+	tasks, err := s.batchTasks(batchId)
+	if err != nil {
+		return nil, err
+	}
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("%w, batch id: %s", ErrBatchNotFound, batchId)
+	}
+
+	polled := false
+	for _, task := range tasks {
+		if len(task.GetWorkerId()) == 0 || IsFinalState(task.GetState()) {
+			continue
+		}
+		if _, err := s.GetTaskStatus(task.GetId()); err != nil {
+			slog.Warn("poll batch task error", append(taskLogAttrs(task), "batch_id", batchId, "error", err)...)
+		}
+		polled = true
+	}
+	if !polled {
+		return tasks, nil
+	}
+	return s.batchTasks(batchId)
+}
+
+// batchTasks reads the tasks of a batch through its index.
+func (s *Scheduler) batchTasks(batchId string) ([]Task, error) {
+	ids, err := s.tm.ListBatchTaskIds(batchId)
+	if err != nil {
+		return nil, err
+	}
+	return s.tm.GetTasks(ids)
+}
+
+// batchEnded reports whether no task of a batch runs any more, dead-lettered
+// ones only run again when requeued.
+func batchEnded(tasks []Task) bool {
+	for _, task := range tasks {
+		if !IsFinalState(task.GetState()) && task.GetState() != TaskStateDeadLetter {
+			return false
+		}
+	}
+	return true
+}
+
+// finishBatch records the batch of a task that just ended as finished once
+// none of its tasks runs any more, the supervisor deletes it after the batch
+// retention.
+func (s *Scheduler) finishBatch(task Task) {
+	batchId := task.GetBatchId()
+	if len(batchId) == 0 {
+		return
+	}
+	tasks, err := s.batchTasks(batchId)
+	if err != nil {
+		slog.Error("list batch tasks error", "batch_id", batchId, "error", err)
+		return
+	}
+	if !batchEnded(tasks) {
+		return
+	}
+	if err := s.tm.FinishBatch(batchId, time.Now()); err != nil {
+		slog.Error("finish batch error", "batch_id", batchId, "error", err)
+	}
+}
+
+// UpdateTaskState stores a state reported by a worker. message describes the
+// error of a failed task. A report of another worker than the one running
+// the task is stale and rejected, workers that do not send their id are not
+// checked.
+func (s *Scheduler) UpdateTaskState(taskId string, workerId WorkerId, state string, message string) error {
+	if !IsWorkerReportedState(TaskState(state)) {
+		return fmt.Errorf("%w: workers cannot set %s", ErrInvalidStateTransition, state)
+	}
+
+	task, err := s.tm.GetTask(taskId)
+	if err != nil {
+		return err
+	}
+	if len(workerId) > 0 && workerId != task.GetWorkerId() {
+		return fmt.Errorf("%w, task id: %s, worker id: %s", ErrStaleTaskReport, taskId, workerId)
+	}
+	// a repeated report
+	if TaskState(state) == task.GetState() {
+		return nil
+	}
+
+	err = s.tm.SwapTaskState(taskId, task.GetWorkerId(), task.GetState(), TaskState(state))
+	if errors.Is(err, ErrTaskStateChanged) {
+		// a poll of the task got there first and handled the state
+		slog.Info("task state report superseded", append(taskLogAttrs(task), "worker_id", task.GetWorkerId(), "state", state)...)
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	s.recordEvent(task, TaskEventState, TaskState(state), message)
+	slog.Info("task state reported", append(taskLogAttrs(task), "worker_id", task.GetWorkerId(), "state", state)...)
 
-	return s.store.UpdateTaskState(taskId, state)
+	switch state {
+	case TaskStateDone:
+		recordTaskCompleted(task)
+		s.completeTask(task)
+		s.finishBatch(task)
+	case TaskStateCancelled:
+		s.completeTask(task)
+		s.finishBatch(task)
+	case TaskStateFailure:
+		s.handleTaskFailure(task, message)
+	}
+	return nil
+}
+
+// handleTaskFailure retries a task a worker failed or dead-letters it, and
+// returns the state the task ends up in.
+func (s *Scheduler) handleTaskFailure(task Task, message string) TaskState {
+	requeue, err := s.failTask(task.GetId(), task.GetWorkerId(), message)
+	if err != nil {
+		slog.Error("record task failure error", "task_id", task.GetId(), "error", err)
+		return TaskStateFailure
+	}
+	if !requeue {
+		slog.Warn("task dead-lettered", append(taskLogAttrs(task), "attempts", s.maxAttempts)...)
+		return TaskStateDeadLetter
+	}
+
+	if err := s.redispatch(task.GetId()); err != nil {
+		slog.Error("retry task error", "task_id", task.GetId(), "error", err)
+		if errors.Is(err, ErrTaskDeadLettered) {
+			return TaskStateDeadLetter
+		}
+	}
+	return TaskStateCreated
+}
+
+// failTask records a failed attempt. The task goes back to PENDING while it
+// has attempts left and to DEAD_LETTER otherwise, failTask reports whether it
+// should be dispatched again.
+func (s *Scheduler) failTask(taskId string, workerId WorkerId, message string) (bool, error) {
+	err := s.tm.AddTaskError(taskId, TaskError{
+		WorkerId: workerId,
+		Message:  message,
+		Time:     time.Now(),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	task, err := s.tm.GetTask(taskId)
+	if err != nil {
+		return false, err
+	}
+	recordTaskFailed(task)
+	deadLettered := task.GetAttempts() >= s.maxAttempts
+	s.recordFailure(task, workerId, message, deadLettered)
+	if deadLettered {
+		s.completeTask(task)
+		if err := s.tm.UpdateTaskState(taskId, TaskStateDeadLetter); err != nil {
+			return false, err
+		}
+		// no worker runs it any more, polls report DEAD_LETTER
+		if err := s.tm.UpdateTaskWorker(taskId, "", ""); err != nil {
+			return false, err
+		}
+		s.finishBatch(task)
+		return false, nil
+	}
+
+	err = s.tm.UpdateTaskState(taskId, TaskStateCreated)
+	if err != nil {
+		return false, err
+	}
+	return true, s.tm.UpdateTaskWorker(taskId, "", "")
+}
+
+// recordFailure remembers a failed attempt, a dead-lettered task gets its
+// final state right after.
+func (s *Scheduler) recordFailure(task Task, workerId WorkerId, message string, deadLettered bool) {
+	now := time.Now()
+	s.failures.add(FailureEvent{
+		TaskId:       task.GetId(),
+		Type:         task.GetType(),
+		SubType:      task.GetSubType(),
+		WorkerId:     workerId,
+		Message:      message,
+		DeadLettered: deadLettered,
+		Time:         now,
+	})
+	kind, state := TaskEventFailed, TaskState(TaskStateCreated)
+	if deadLettered {
+		kind, state = TaskEventDeadLettered, TaskStateDeadLetter
+	}
+	s.events.add(TaskEvent{
+		TaskId:   task.GetId(),
+		Type:     task.GetType(),
+		SubType:  task.GetSubType(),
+		WorkerId: workerId,
+		Kind:     kind,
+		State:    state,
+		Message:  message,
+		Time:     now,
+	})
+}
+
+func (s *Scheduler) recordEvent(task Task, kind TaskEventKind, state TaskState, message string) {
+	s.events.add(TaskEvent{
+		TaskId:   task.GetId(),
+		Type:     task.GetType(),
+		SubType:  task.GetSubType(),
+		WorkerId: task.GetWorkerId(),
+		Kind:     kind,
+		State:    state,
+		Message:  message,
+		Time:     time.Now(),
+	})
+}
+
+func (s *Scheduler) redispatch(taskId string) error {
+	task, err := s.tm.GetTask(taskId)
+	if err != nil {
+		return err
+	}
+	_, err = s.dispatcher.Dispatch(task)
+	return err
+}
+
+func taskErrorMessage(data interface{}) string {
+	if data == nil {
+		return ""
+	}
+	if message, ok := data.(string); ok {
+		return message
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Sprint(data)
+	}
+	return string(b)
+}
+
+// GetTask returns a stored task with its error history.
+func (s *Scheduler) GetTask(taskId string) (Task, error) {
+	return s.tm.GetTask(taskId)
+}
+
+// ListDeadLetters returns dead-lettered tasks, oldest first. limit 0 returns
+// all of them.
+func (s *Scheduler) ListDeadLetters(limit int) ([]Task, error) {
+	return s.tm.ListTasks(TaskFilter{
+		State: TaskStateDeadLetter,
+		Limit: limit,
+	})
+}
+
+// RequeueTask gives a dead-lettered task a fresh set of attempts and
+// dispatches it again. A task that cannot be dispatched goes back to the
+// dead-letter store.
+func (s *Scheduler) RequeueTask(taskId string) error {
+	task, err := s.tm.GetTask(taskId)
+	if err != nil {
+		return err
+	}
+	if task.GetState() != TaskStateDeadLetter {
+		return fmt.Errorf("%w, task id: %s, state: %s", ErrTaskNotDeadLettered, taskId, task.GetState())
+	}
+
+	if err := s.tm.UpdateTaskState(taskId, TaskStateCreated); err != nil {
+		return err
+	}
+	if err := s.tm.ResetTaskAttempts(taskId); err != nil {
+		return err
+	}
+	if err := s.tm.UpdateTaskWorker(taskId, "", ""); err != nil {
+		return err
+	}
+	s.recordEvent(task, TaskEventRequeued, TaskStateCreated, "")
+
+	err = s.redispatch(taskId)
+	if err != nil && !errors.Is(err, ErrTaskDeadLettered) {
+		if stateErr := s.tm.UpdateTaskState(taskId, TaskStateDeadLetter); stateErr != nil {
+			slog.Error("move task back to dead-letter error", "task_id", taskId, "error", stateErr)
+		} else {
+			s.finishBatch(task)
+		}
+	}
+	return err
 }
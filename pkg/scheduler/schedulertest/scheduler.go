@@ -0,0 +1,115 @@
+package schedulertest
+
+import (
+	"go-web/pkg/config"
+	"go-web/pkg/scheduler"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	// WaitTimeout bounds how long the wait helpers poll
+	WaitTimeout = 5 * time.Second
+	// PollInterval is the interval of the wait helpers
+	PollInterval = 10 * time.Millisecond
+)
+
+// NewScheduler starts a scheduler with in-memory stores and direct dispatch
+// unless cfg says otherwise, it is stopped when the test ends. A nil cfg is
+// the zero config.
+func NewScheduler(t testing.TB, cfg *config.Scheduler) *scheduler.Scheduler {
+	t.Helper()
+	if cfg == nil {
+		cfg = &config.Scheduler{}
+	}
+	if len(cfg.WorkerConfig.WorkerStore) == 0 {
+		withMemory := *cfg
+		withMemory.WorkerConfig.WorkerStore = scheduler.WorkerStoreTypeMemory
+		cfg = &withMemory
+	}
+	s, err := scheduler.NewScheduler(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Stop() })
+	return s
+}
+
+// Register registers fake workers with s and deregisters them when the test
+// ends, so a shared scheduler keeps no dead workers.
+func Register(t testing.TB, s *scheduler.Scheduler, workers ...*FakeWorker) {
+	t.Helper()
+	for _, w := range workers {
+		if err := s.RegisterWorker(w.Worker()); err != nil {
+			t.Fatal(err)
+		}
+		id := w.GetId()
+		t.Cleanup(func() { s.DeRegisterWorker(id) })
+	}
+}
+
+// WaitTaskState polls the status of a task until it reaches state and returns
+// the last result, the test fails if it does not in WaitTimeout.
+func WaitTaskState(t testing.TB, s *scheduler.Scheduler, taskId string, state scheduler.TaskState) *scheduler.TaskResult {
+	t.Helper()
+	var result *scheduler.TaskResult
+	ok := assert.Eventually(t, func() bool {
+		got, err := s.GetTaskStatus(taskId)
+		if err != nil {
+			return false
+		}
+		result = got
+		return got.Status == state
+	}, WaitTimeout, PollInterval, "task %s did not reach %s", taskId, state)
+	if !ok {
+		t.FailNow()
+	}
+	return result
+}
+
+// AssertTaskState checks the stored state of a task without polling the
+// worker.
+func AssertTaskState(t testing.TB, s *scheduler.Scheduler, taskId string, state scheduler.TaskState) bool {
+	t.Helper()
+	task, err := s.GetTask(taskId)
+	if !assert.NoError(t, err) {
+		return false
+	}
+	return assert.Equal(t, state, task.GetState(), "state of task %s", taskId)
+}
+
+// AssertSubmitted waits until the worker received n tasks.
+func AssertSubmitted(t testing.TB, w *FakeWorker, n int) bool {
+	t.Helper()
+	return assert.Eventually(t, func() bool {
+		return len(w.Submissions()) >= n
+	}, WaitTimeout, PollInterval, "worker %s did not receive %d tasks", w.GetId(), n)
+}
+
+// AssertCancelled waits until go-web cancelled the task on the worker.
+func AssertCancelled(t testing.TB, w *FakeWorker, taskId string) bool {
+	t.Helper()
+	return assert.Eventually(t, func() bool {
+		for _, submission := range w.Submissions() {
+			if submission.TaskId == taskId && submission.Cancelled {
+				return true
+			}
+		}
+		return false
+	}, WaitTimeout, PollInterval, "task %s was not cancelled on worker %s", taskId, w.GetId())
+}
+
+// Shared returns the scheduler the routers use, built from the global config
+// with an in-memory worker store unless the config names one.
+func Shared() *scheduler.Scheduler {
+	cfg := config.GetScheduler()
+	if len(cfg.WorkerConfig.WorkerStore) == 0 {
+		cfg.WorkerConfig.WorkerStore = scheduler.WorkerStoreTypeMemory
+	}
+	return scheduler.GetScheduler(cfg)
+}
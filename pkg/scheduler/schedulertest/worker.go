@@ -0,0 +1,320 @@
+// Package schedulertest provides fake workers and an in-memory scheduler for
+// tests that schedule tasks without a live Redis or real workers:
+//
+//	s := schedulertest.NewScheduler(t, nil)
+//	w := schedulertest.NewFakeWorker(t, "w1")
+//	w.SetResult(map[string]string{"image": "f1.png"})
+//	schedulertest.Register(t, s, w)
+//
+//	task := ... // schedule a task
+//	result := schedulertest.WaitTaskState(t, s, task.GetId(), scheduler.TaskStateDone)
+package schedulertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-web/pkg/scheduler"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Behavior decides what a FakeWorker does with a task it receives.
+type Behavior string
+
+const (
+	// Accept takes the task, it is DONE with the result of the worker
+	Accept Behavior = "accept"
+	// Fail takes the task, it is FAILURE with the error of the worker
+	Fail Behavior = "fail"
+	// Reject refuses the task on submit
+	Reject Behavior = "reject"
+	// Hang takes the task and keeps it RUNNING, polls return the progress of
+	// the worker, until it is released or cancelled
+	Hang Behavior = "hang"
+	// Unhealthy answers every request with 503
+	Unhealthy Behavior = "unhealthy"
+)
+
+// Submission is a task the worker received on POST /task.
+type Submission struct {
+	// TaskId is the go-web task id
+	TaskId       string
+	WorkerTaskId string
+	Type         scheduler.TaskType
+	SubType      scheduler.SubTaskType
+	Detail       json.RawMessage
+	// ArtifactPrefix is where the task would upload its outputs
+	ArtifactPrefix string
+	Header         http.Header
+	Behavior       Behavior
+	Cancelled      bool
+}
+
+// FakeWorker serves the worker protocol on an httptest server. Tasks follow
+// the scripted behaviors in order, then the default behavior.
+type FakeWorker struct {
+	id     scheduler.WorkerId
+	server *httptest.Server
+
+	mu          sync.Mutex
+	behavior    Behavior
+	script      []Behavior
+	result      interface{}
+	artifacts   []string
+	errMsg      string
+	progress    interface{}
+	tasks       map[string]*Submission
+	submissions []*Submission
+	cancelled   []string
+	lastHeader  http.Header
+}
+
+func NewFakeWorker(t testing.TB, id scheduler.WorkerId) *FakeWorker {
+	w := &FakeWorker{
+		id:       id,
+		behavior: Accept,
+		errMsg:   "fake worker failure",
+		tasks:    map[string]*Submission{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /task", w.submitTask)
+	mux.HandleFunc("GET /task/{id}", w.getTask)
+	mux.HandleFunc("DELETE /task/{id}", w.cancelTask)
+	mux.HandleFunc("GET /executor/status", w.executorStatus)
+	w.server = httptest.NewServer(w.unhealthy(mux))
+	t.Cleanup(w.server.Close)
+	return w
+}
+
+func (w *FakeWorker) GetId() scheduler.WorkerId {
+	return w.id
+}
+
+// Addr is the host:port the worker registers with.
+func (w *FakeWorker) Addr() string {
+	return strings.TrimPrefix(w.server.URL, "http://")
+}
+
+// Worker is the scheduler side of the fake worker.
+func (w *FakeWorker) Worker() scheduler.Worker {
+	return scheduler.NewWorker(w.id, w.Addr())
+}
+
+// SetBehavior sets the behavior of tasks without a scripted one. Unhealthy
+// applies to every request at once.
+func (w *FakeWorker) SetBehavior(behavior Behavior) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.behavior = behavior
+}
+
+// Script queues behaviors for the next tasks, one per task.
+func (w *FakeWorker) Script(behaviors ...Behavior) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.script = append(w.script, behaviors...)
+}
+
+// SetResult sets the data of DONE tasks.
+func (w *FakeWorker) SetResult(result interface{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.result = result
+}
+
+// SetArtifacts makes DONE tasks report the named outputs under their
+// artifact prefix instead of the result.
+func (w *FakeWorker) SetArtifacts(names ...string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.artifacts = names
+}
+
+// SetError sets the error message of failed tasks.
+func (w *FakeWorker) SetError(message string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.errMsg = message
+}
+
+// SetProgress sets the data of RUNNING tasks.
+func (w *FakeWorker) SetProgress(progress interface{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.progress = progress
+}
+
+// Release lets hanging tasks finish, they are DONE on the next poll.
+func (w *FakeWorker) Release() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, task := range w.tasks {
+		if task.Behavior == Hang && !task.Cancelled {
+			task.Behavior = Accept
+		}
+	}
+}
+
+// Submissions returns the tasks received so far, rejected ones included.
+func (w *FakeWorker) Submissions() []Submission {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	submissions := make([]Submission, 0, len(w.submissions))
+	for _, submission := range w.submissions {
+		submissions = append(submissions, *submission)
+	}
+	return submissions
+}
+
+// Cancelled returns the worker task ids go-web cancelled.
+func (w *FakeWorker) Cancelled() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.cancelled...)
+}
+
+// LastHeader returns a header of the last request go-web sent.
+func (w *FakeWorker) LastHeader(key string) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastHeader.Get(key)
+}
+
+func (w *FakeWorker) unhealthy(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		w.mu.Lock()
+		w.lastHeader = r.Header.Clone()
+		unhealthy := w.behavior == Unhealthy
+		w.mu.Unlock()
+		if unhealthy {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+func (w *FakeWorker) submitTask(rw http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TaskId         string          `json:"task_id"`
+		TaskType       string          `json:"task_type"`
+		TaskSubType    string          `json:"task_sub_type"`
+		TaskDetail     json.RawMessage `json:"task_detail"`
+		ArtifactPrefix string          `json:"artifact_prefix"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.mu.Lock()
+	behavior := w.behavior
+	if len(w.script) > 0 {
+		behavior = w.script[0]
+		w.script = w.script[1:]
+	}
+	submission := &Submission{
+		TaskId:         req.TaskId,
+		Type:           scheduler.TaskType(req.TaskType),
+		SubType:        scheduler.SubTaskType(req.TaskSubType),
+		Detail:         req.TaskDetail,
+		ArtifactPrefix: req.ArtifactPrefix,
+		Header:         r.Header.Clone(),
+		Behavior:       behavior,
+	}
+	w.submissions = append(w.submissions, submission)
+	switch behavior {
+	case Reject:
+		w.mu.Unlock()
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	case Unhealthy:
+		w.mu.Unlock()
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	submission.WorkerTaskId = fmt.Sprintf("%s-%d", w.id, len(w.submissions))
+	w.tasks[submission.WorkerTaskId] = submission
+	w.mu.Unlock()
+
+	writeJSON(rw, scheduler.TaskSubmitDto{TaskId: submission.WorkerTaskId})
+}
+
+func (w *FakeWorker) getTask(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	task, ok := w.tasks[r.PathValue("id")]
+	if !ok {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	result := scheduler.WorkerTaskResult{TaskId: task.WorkerTaskId}
+	switch {
+	case task.Cancelled:
+		result.TaskStatus = scheduler.TaskStateCancelled
+	case task.Behavior == Accept:
+		result.TaskStatus = scheduler.TaskStateDone
+		result.Data = w.result
+		if len(w.artifacts) > 0 {
+			result.Data = w.artifactResult(task)
+		}
+	case task.Behavior == Fail:
+		result.TaskStatus = scheduler.TaskStateFailure
+		result.Data = w.errMsg
+	default:
+		result.TaskStatus = scheduler.TaskStateRunning
+		result.Data = w.progress
+	}
+	writeJSON(rw, result)
+}
+
+func (w *FakeWorker) artifactResult(task *Submission) scheduler.ArtifactResult {
+	var result scheduler.ArtifactResult
+	for _, name := range w.artifacts {
+		result.Artifacts = append(result.Artifacts, scheduler.Artifact{
+			Key:  task.ArtifactPrefix + name,
+			Name: name,
+		})
+	}
+	return result
+}
+
+func (w *FakeWorker) cancelTask(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	task, ok := w.tasks[r.PathValue("id")]
+	if !ok {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+	task.Cancelled = true
+	w.cancelled = append(w.cancelled, task.WorkerTaskId)
+}
+
+func (w *FakeWorker) executorStatus(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	status := scheduler.StatusCheckDto{IsHealthy: true}
+	for _, task := range w.tasks {
+		switch {
+		case task.Cancelled:
+			status.CancelledTasks++
+		case task.Behavior == Accept:
+			status.CompletedTasks++
+		case task.Behavior == Fail:
+			status.FailedTasks++
+		default:
+			status.ActiveTasks++
+		}
+	}
+	writeJSON(rw, scheduler.ExecutorStatusDto{Data: status})
+}
+
+func writeJSON(rw http.ResponseWriter, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(v)
+}
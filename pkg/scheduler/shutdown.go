@@ -0,0 +1,170 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Shutdown stops the scheduler: it rejects new tasks, waits until ctx is done
+// for the tasks being scheduled and the dispatches in flight, stops the
+// background loops and closes the stores and redis clients.
+//
+// Tasks that were not dispatched stay where they are queued, the task store
+// and, in stream mode, their stream, so the next start or another replica
+// picks them up. Tasks held by the admission stay PENDING without a worker in
+// the task store, with the journal store the next start holds them again.
+// The journal store writes a snapshot, the memory store loses them. Stores are closed even when ctx
+// expires, Shutdown then returns the ctx error.
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	if !s.stopped.CompareAndSwap(false, true) {
+		return nil
+	}
+	start := time.Now()
+	var errs []error
+
+	if err := s.inflight.close(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("wait for tasks being scheduled: %w", err))
+	}
+	if s.started.CompareAndSwap(true, false) {
+		// a stuck loop does not keep Shutdown past ctx, the loops still stop
+		if err := waitCtx(ctx, noErr(s.supervisor.stop)); err != nil {
+			errs = append(errs, fmt.Errorf("stop supervisor: %w", err))
+		}
+		if err := waitCtx(ctx, noErr(s.admission.stop)); err != nil {
+			errs = append(errs, fmt.Errorf("stop admission: %w", err))
+		}
+		if err := waitCtx(ctx, noErr(s.recovery.stop)); err != nil {
+			errs = append(errs, fmt.Errorf("stop recovery: %w", err))
+		}
+	}
+	// stream consumers finish the dispatch they are in
+	if err := waitCtx(ctx, s.dispatcher.Stop); err != nil {
+		errs = append(errs, fmt.Errorf("stop dispatcher: %w", err))
+	}
+	if err := s.executor.Stop(); err != nil {
+		errs = append(errs, fmt.Errorf("stop executor: %w", err))
+	}
+	if err := s.wm.Stop(); err != nil {
+		errs = append(errs, fmt.Errorf("stop worker manager: %w", err))
+	}
+	// the lease is released so another replica takes over right away
+	if err := s.leader.Stop(); err != nil {
+		errs = append(errs, fmt.Errorf("stop leader elector: %w", err))
+	}
+
+	s.warnLostTasks()
+	if err := s.tm.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("close task store: %w", err))
+	}
+	for i := len(s.clients) - 1; i >= 0; i-- {
+		if err := s.clients[i].Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close redis client: %w", err))
+		}
+	}
+
+	err := errors.Join(errs...)
+	if err != nil {
+		slog.Error("scheduler stopped with errors", "duration", time.Since(start), "error", err)
+	} else {
+		slog.Info("scheduler stopped", "duration", time.Since(start))
+	}
+	return err
+}
+
+// warnLostTasks reports the unfinished tasks the memory store cannot keep.
+func (s *Scheduler) warnLostTasks() {
+	if _, ok := s.tm.store.(*InMemStore); !ok {
+		return
+	}
+	tasks, err := s.tm.ListTasks(TaskFilter{})
+	if err != nil {
+		return
+	}
+	lost := 0
+	for _, task := range tasks {
+		if task.GetState() == TaskStateCreated || task.GetState() == TaskStateRunning {
+			lost++
+		}
+	}
+	if lost > 0 {
+		slog.Warn("unfinished tasks are lost with the memory task store", "count", lost)
+	}
+}
+
+// waitCtx runs stop and gives up waiting for it once ctx is done.
+func waitCtx(ctx context.Context, stop func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- stop()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func noErr(stop func()) func() error {
+	return func() error {
+		stop()
+		return nil
+	}
+}
+
+// inflight counts the calls in progress. Once closed it rejects new calls and
+// lets close wait for the running ones.
+type inflight struct {
+	mu     sync.Mutex
+	closed bool
+	n      int
+	idle   chan struct{}
+}
+
+func newInflight() *inflight {
+	return &inflight{idle: make(chan struct{})}
+}
+
+// enter counts a call, it returns false once closed.
+func (f *inflight) enter() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return false
+	}
+	f.n++
+	return true
+}
+
+func (f *inflight) leave() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.n--
+	if f.closed && f.n == 0 {
+		close(f.idle)
+	}
+}
+
+// close rejects new calls and waits until the running ones left or ctx is
+// done.
+func (f *inflight) close(ctx context.Context) error {
+	f.mu.Lock()
+	if !f.closed {
+		f.closed = true
+		if f.n == 0 {
+			close(f.idle)
+		}
+	}
+	f.mu.Unlock()
+
+	select {
+	case <-f.idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
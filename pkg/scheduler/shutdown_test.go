@@ -0,0 +1,136 @@
+package scheduler_test
+
+import (
+	"context"
+	"encoding/json"
+	"go-web/pkg/config"
+	"go-web/pkg/scheduler"
+	"go-web/pkg/scheduler/schedulertest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newSlowWorker registers a worker that accepts tasks once release is closed,
+// entered receives a value when a task arrives.
+func newSlowWorker(t *testing.T, s *scheduler.Scheduler) (entered chan struct{}, release chan struct{}) {
+	entered = make(chan struct{}, 1)
+	release = make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+		json.NewEncoder(rw).Encode(scheduler.TaskSubmitDto{TaskId: "slow-1"})
+	}))
+	t.Cleanup(server.Close)
+	assert.NoError(t, s.RegisterWorker(scheduler.NewWorker("slow", strings.TrimPrefix(server.URL, "http://"))))
+	return entered, release
+}
+
+func TestShutdown_ShouldRejectTasks_WhenStopped(t *testing.T) {
+	s := schedulertest.NewScheduler(t, nil)
+
+	assert.NoError(t, s.Shutdown(context.Background()))
+	_, err := s.Schedule(newDeadLetterTask())
+	assert.ErrorIs(t, err, scheduler.ErrSchedulerStopped)
+	assert.NoError(t, s.Shutdown(context.Background()))
+}
+
+func TestShutdown_ShouldWaitForDispatch_WhenTaskBeingScheduled(t *testing.T) {
+	s := schedulertest.NewScheduler(t, nil)
+	entered, release := newSlowWorker(t, s)
+
+	scheduled := make(chan error, 1)
+	go func() {
+		_, err := s.Schedule(newDeadLetterTask())
+		scheduled <- err
+	}()
+	<-entered
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- s.Shutdown(context.Background())
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("shutdown returned before the dispatch finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	assert.NoError(t, <-scheduled)
+	assert.NoError(t, <-stopped)
+}
+
+func TestShutdown_ShouldGiveUp_WhenDeadlinePasses(t *testing.T) {
+	s := schedulertest.NewScheduler(t, nil)
+	entered, release := newSlowWorker(t, s)
+	defer close(release)
+
+	go s.Schedule(newDeadLetterTask())
+	<-entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+}
+
+func TestShutdown_ShouldGiveUp_WhenHeldTaskDispatchStuck(t *testing.T) {
+	worker := newFakeWorker(t)
+	s := newTestScheduler(t, &config.Scheduler{
+		Admission: config.Admission{MaxActive: 1, Degraded: true},
+	}, worker)
+	running := scheduleRunningTask(t, s)
+	_, err := s.Schedule(newDeadLetterTask())
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.DeRegisterWorker(worker.GetId()))
+	entered, release := newSlowWorker(t, s)
+	defer close(release)
+	assert.NoError(t, s.CancelTask(running.GetId()))
+	<-entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+}
+
+func TestShutdown_ShouldKeepQueuedTasks_WhenJournalConfigured(t *testing.T) {
+	cfg := &config.Scheduler{
+		TaskConfig: config.TaskConfig{Journal: config.Journal{Dir: t.TempDir()}},
+	}
+	s := newTestScheduler(t, cfg, newFakeWorker(t))
+	task := newDeadLetterTask()
+	_, err := s.Schedule(task)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Shutdown(context.Background()))
+
+	restarted := schedulertest.NewScheduler(t, cfg)
+	got, err := restarted.GetTask(task.GetId())
+	if assert.NoError(t, err) {
+		assert.Equal(t, scheduler.TaskState(scheduler.TaskStateCreated), got.GetState())
+		assert.Equal(t, scheduler.WorkerId("w1"), got.GetWorkerId())
+	}
+}
+
+func TestWorkerManager_ShouldNotBlock_WhenStoppedTwice(t *testing.T) {
+	lb, err := scheduler.NewLB(scheduler.DefaultLoadBalancer)
+	assert.NoError(t, err)
+	wm, err := scheduler.NewWorkerManager(lb, scheduler.WorkerManagerCfg{StoreType: scheduler.WorkerStoreTypeMemory})
+	assert.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		wm.Close()
+		assert.NoError(t, wm.Stop())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stopping the worker manager twice blocked")
+	}
+}
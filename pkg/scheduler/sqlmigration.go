@@ -0,0 +1,232 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// sqlMigration is one versioned step of the task store schema. Statements may
+// use {{timestamp}} and {{autoid}}, they are replaced with the column types of
+// the dialect.
+type sqlMigration struct {
+	version     int
+	description string
+	statements  []string
+}
+
+// migrations are applied in order and never edited once released, add a new
+// version to change the schema.
+var sqlMigrations = []sqlMigration{
+	{
+		version:     1,
+		description: "create tasks",
+		statements: []string{
+			`CREATE TABLE tasks (
+				id VARCHAR(64) NOT NULL PRIMARY KEY,
+				state VARCHAR(32) NOT NULL,
+				user_id VARCHAR(64) NOT NULL DEFAULT '',
+				type VARCHAR(32) NOT NULL,
+				sub_type VARCHAR(64) NOT NULL,
+				priority INTEGER NOT NULL DEFAULT 0,
+				worker_id VARCHAR(128) NOT NULL DEFAULT '',
+				worker_task_id VARCHAR(128) NOT NULL DEFAULT '',
+				user_def TEXT,
+				created_at {{timestamp}} NOT NULL,
+				updated_at {{timestamp}} NOT NULL,
+				deleted_at {{timestamp}} NULL
+			)`,
+			`CREATE INDEX idx_tasks_state ON tasks (state)`,
+			`CREATE INDEX idx_tasks_user_id ON tasks (user_id)`,
+			`CREATE INDEX idx_tasks_type ON tasks (type, sub_type)`,
+			`CREATE INDEX idx_tasks_created_at ON tasks (created_at)`,
+		},
+	},
+	{
+		version:     2,
+		description: "create task events for auditing",
+		statements: []string{
+			`CREATE TABLE task_events (
+				id {{autoid}},
+				task_id VARCHAR(64) NOT NULL,
+				event VARCHAR(32) NOT NULL,
+				from_state VARCHAR(32) NOT NULL DEFAULT '',
+				to_state VARCHAR(32) NOT NULL DEFAULT '',
+				detail TEXT,
+				created_at {{timestamp}} NOT NULL
+			)`,
+			`CREATE INDEX idx_task_events_task_id ON task_events (task_id)`,
+		},
+	},
+	{
+		version:     3,
+		description: "add task attempts and error history",
+		statements: []string{
+			`ALTER TABLE tasks ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE tasks ADD COLUMN errors TEXT`,
+		},
+	},
+	{
+		version:     4,
+		description: "add task queue deadline and dispatch time",
+		statements: []string{
+			`ALTER TABLE tasks ADD COLUMN deadline {{timestamp}} NULL`,
+			`ALTER TABLE tasks ADD COLUMN dispatched_at {{timestamp}} NULL`,
+		},
+	},
+	{
+		version:     5,
+		description: "add task trace parent",
+		statements: []string{
+			`ALTER TABLE tasks ADD COLUMN trace_parent VARCHAR(64) NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version:     6,
+		description: "add task request id",
+		statements: []string{
+			`ALTER TABLE tasks ADD COLUMN request_id VARCHAR(128) NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version:     7,
+		description: "add task batch id",
+		statements: []string{
+			`ALTER TABLE tasks ADD COLUMN batch_id VARCHAR(64) NOT NULL DEFAULT ''`,
+			`CREATE INDEX idx_tasks_batch_id ON tasks (batch_id)`,
+		},
+	},
+	{
+		version:     8,
+		description: "add task cache key",
+		statements: []string{
+			`ALTER TABLE tasks ADD COLUMN cache_key VARCHAR(64) NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version:     9,
+		description: "index task queue deadline and dispatch time",
+		statements: []string{
+			`CREATE INDEX idx_tasks_deadline ON tasks (state, deadline)`,
+			`CREATE INDEX idx_tasks_dispatched_at ON tasks (state, dispatched_at)`,
+		},
+	},
+	{
+		version:     10,
+		description: "create finished task batches",
+		statements: []string{
+			`CREATE TABLE task_batches (
+				id VARCHAR(64) NOT NULL PRIMARY KEY,
+				finished_at {{timestamp}} NOT NULL
+			)`,
+			`CREATE INDEX idx_task_batches_finished_at ON task_batches (finished_at)`,
+		},
+	},
+}
+
+func (d sqlDialect) ddl(stmt string) string {
+	return strings.NewReplacer("{{timestamp}}", d.timestampType, "{{autoid}}", d.autoIdType).Replace(stmt)
+}
+
+// migrate brings the schema up to the latest version. Replicas starting at
+// the same time take turns on an advisory lock, MySQL commits DDL right away
+// so its transactions would not keep them apart. Every version runs in its
+// own transaction together with its schema_migrations row, where the
+// database has no advisory lock (sqlite) the loser of a race fails on the
+// primary key and goes on once it sees the version applied.
+func (s *SqlTaskStore) migrate(ctx context.Context) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if len(s.dialect.migrationLock) > 0 {
+		var locked sql.NullInt64
+		if err := conn.QueryRowContext(ctx, s.dialect.migrationLock, sqlMigrationLockKey).Scan(&locked); err != nil {
+			return fmt.Errorf("take migration lock error: %w", err)
+		}
+		// pg_advisory_lock returns void, GET_LOCK 0 on timeout
+		if locked.Valid && locked.Int64 != 1 {
+			return errors.New("take migration lock error: timed out")
+		}
+		defer func() {
+			var released sql.NullInt64
+			if err := conn.QueryRowContext(context.Background(), s.dialect.migrationUnlock, sqlMigrationLockKey).Scan(&released); err != nil {
+				slog.Warn("release migration lock error", "error", err)
+			}
+		}()
+	}
+
+	_, err = conn.ExecContext(ctx, s.dialect.ddl(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER NOT NULL PRIMARY KEY,
+		description VARCHAR(255) NOT NULL,
+		applied_at {{timestamp}} NOT NULL
+	)`))
+	if err != nil {
+		return fmt.Errorf("create schema_migrations error: %w", err)
+	}
+
+	current, err := schemaVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range sqlMigrations {
+		if m.version <= current {
+			continue
+		}
+		if err := s.applyMigration(ctx, conn, m); err != nil {
+			if applied, verErr := schemaVersion(ctx, conn); verErr == nil && applied >= m.version {
+				slog.Info("task store schema migrated by another replica", "version", m.version)
+				continue
+			}
+			return fmt.Errorf("apply migration %d (%s) error: %w", m.version, m.description, err)
+		}
+		slog.Info("task store schema migrated", "version", m.version, "description", m.description)
+	}
+	return nil
+}
+
+func (s *SqlTaskStore) applyMigration(ctx context.Context, conn *sql.Conn, m sqlMigration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range m.statements {
+		if _, err := tx.ExecContext(ctx, s.dialect.ddl(stmt)); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, ?, ?)`),
+		m.version, m.description, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SchemaVersion returns the latest migration applied to the database.
+func (s *SqlTaskStore) SchemaVersion(ctx context.Context) (int, error) {
+	return schemaVersion(ctx, s.db)
+}
+
+type sqlQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func schemaVersion(ctx context.Context, q sqlQueryer) (int, error) {
+	var version sql.NullInt64
+	err := q.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
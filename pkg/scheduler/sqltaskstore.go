@@ -0,0 +1,718 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	SqlDialectSqlite   = "sqlite"
+	SqlDialectPostgres = "postgres"
+	SqlDialectMysql    = "mysql"
+)
+
+const (
+	taskEventCreated = "created"
+	taskEventState   = "state"
+	taskEventWorker  = "worker"
+	taskEventDeleted = "deleted"
+	taskEventError   = "error"
+	taskEventReset   = "reset_attempts"
+)
+
+type SqlConfig struct {
+	Dialect         string
+	DSN             string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+type sqlDialect struct {
+	name           string
+	driver         string
+	timestampType  string
+	autoIdType     string
+	numberedParams bool
+	lockForUpdate  bool
+	// migrationLock and migrationUnlock take and release a lock held by the
+	// connection, empty where the database locks the schema itself
+	migrationLock   string
+	migrationUnlock string
+	// experimental dialects are not covered by the tests
+	experimental bool
+}
+
+// sqlMigrationLockKey names the advisory lock replicas take to migrate.
+const sqlMigrationLockKey = 7290415
+
+var sqlDialects = map[string]sqlDialect{
+	SqlDialectSqlite: {
+		name:          SqlDialectSqlite,
+		driver:        "sqlite",
+		timestampType: "TIMESTAMP",
+		autoIdType:    "INTEGER PRIMARY KEY AUTOINCREMENT",
+	},
+	SqlDialectPostgres: {
+		name:            SqlDialectPostgres,
+		driver:          "pgx",
+		timestampType:   "TIMESTAMP",
+		autoIdType:      "BIGSERIAL PRIMARY KEY",
+		numberedParams:  true,
+		lockForUpdate:   true,
+		migrationLock:   `SELECT pg_advisory_lock($1)`,
+		migrationUnlock: `SELECT pg_advisory_unlock($1)`,
+		experimental:    true,
+	},
+	SqlDialectMysql: {
+		name:          SqlDialectMysql,
+		driver:        "mysql",
+		timestampType: "DATETIME(6)",
+		autoIdType:    "BIGINT AUTO_INCREMENT PRIMARY KEY",
+		lockForUpdate: true,
+		// waits up to 60 seconds, the result is 1 once the lock is taken
+		migrationLock:   `SELECT GET_LOCK(CONCAT('go-web-migrations-', ?), 60)`,
+		migrationUnlock: `SELECT RELEASE_LOCK(CONCAT('go-web-migrations-', ?))`,
+		experimental:    true,
+	},
+}
+
+// rebind turns the ? placeholders of a query into the style of the dialect.
+func (d sqlDialect) rebind(query string) string {
+	if !d.numberedParams {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// SqlTaskStore keeps tasks in a relational database. Deleted tasks are only
+// marked as deleted and every change is recorded in task_events, so the full
+// history of a task stays available for auditing.
+type SqlTaskStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+func NewSqlTaskStore(cfg *SqlConfig) (*SqlTaskStore, error) {
+	dialect, ok := sqlDialects[cfg.Dialect]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sql dialect: %s", cfg.Dialect)
+	}
+
+	dsn := cfg.DSN
+	if dialect.name == SqlDialectMysql {
+		mysqlCfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return nil, err
+		}
+		// timestamps are scanned into time.Time
+		mysqlCfg.ParseTime = true
+		mysqlCfg.Loc = time.UTC
+		dsn = mysqlCfg.FormatDSN()
+	}
+
+	if dialect.experimental {
+		slog.Warn("sql dialect is experimental, only sqlite is tested", "dialect", dialect.name)
+	}
+	db, err := sql.Open(dialect.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if dialect.name == SqlDialectSqlite {
+		// sqlite allows a single writer, queue writers in the pool instead of failing with SQLITE_BUSY
+		db.SetMaxOpenConns(1)
+	} else if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+
+	store := &SqlTaskStore{
+		db:      db,
+		dialect: dialect,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := store.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (s *SqlTaskStore) AddTask(task Task) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	userDef, err := json.Marshal(task.GetUserDef())
+	if err != nil {
+		return err
+	}
+	taskErrors, err := json.Marshal(task.GetErrors())
+	if err != nil {
+		return err
+	}
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+		_, err := tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO tasks
+			(id, state, user_id, type, sub_type, priority, worker_id, worker_task_id, user_def, attempts, errors, deadline, dispatched_at, trace_parent, request_id, batch_id, cache_key, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+			task.GetId(), string(task.GetState()), task.GetUserId(), string(task.GetType()), string(task.GetSubType()),
+			int(task.GetPriority()), string(task.GetWorkerId()), task.GetWorkerTaskId(), string(userDef),
+			task.GetAttempts(), string(taskErrors), nullTime(task.GetDeadline()), nullTime(task.GetDispatchedAt()),
+			task.GetTraceParent(), task.GetRequestId(), task.GetBatchId(), task.GetCacheKey(), task.GetCreatedAt().UTC(), now)
+		if err != nil {
+			return err
+		}
+		return s.addEvent(ctx, tx, task.GetId(), taskEventCreated, "", string(task.GetState()), "")
+	})
+}
+
+const sqlTaskColumns = `id, state, user_id, type, sub_type, priority, worker_id, worker_task_id, user_def, attempts, errors, deadline, dispatched_at, trace_parent, request_id, batch_id, cache_key, created_at`
+
+func (s *SqlTaskStore) GetTask(id string) (Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	row := s.db.QueryRowContext(ctx, s.dialect.rebind(`SELECT `+sqlTaskColumns+` FROM tasks WHERE id = ? AND deleted_at IS NULL`), id)
+	task, err := scanTask(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, taskNotFound(id)
+	}
+	return task, err
+}
+
+func (s *SqlTaskStore) GetTasks(ids []string) ([]Task, error) {
+	if len(ids) == 0 {
+		return []Task{}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(`SELECT `+sqlTaskColumns+` FROM tasks WHERE id IN (`+placeholders+`) AND deleted_at IS NULL`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byId := make(map[string]Task, len(ids))
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		byId[task.GetId()] = task
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// keep the order of ids like the other stores do
+	tasks := make([]Task, 0, len(byId))
+	for _, id := range ids {
+		if task, ok := byId[id]; ok {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTask(row rowScanner) (Task, error) {
+	var (
+		id, state, userId, taskType, subType string
+		workerId, workerTaskId               string
+		traceParent, requestId, batchId      string
+		cacheKey                             string
+		priority, attempts                   int
+		userDef, errorsJson                  sql.NullString
+		deadline, dispatchedAt               sql.NullTime
+		createdAt                            time.Time
+	)
+	err := row.Scan(&id, &state, &userId, &taskType, &subType, &priority, &workerId, &workerTaskId, &userDef, &attempts, &errorsJson,
+		&deadline, &dispatchedAt, &traceParent, &requestId, &batchId, &cacheKey, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+
+	var def interface{}
+	if userDef.Valid && len(userDef.String) > 0 {
+		if err := json.Unmarshal([]byte(userDef.String), &def); err != nil {
+			return nil, err
+		}
+	}
+	taskErrors, err := unmarshalTaskErrors(errorsJson)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTaskBuilder().
+		SetId(id).
+		SetState(TaskState(state)).
+		SetUserId(userId).
+		SetType(TaskType(taskType)).
+		SetSubType(SubTaskType(subType)).
+		SetPriority(TaskPriority(priority)).
+		SetWorkerId(WorkerId(workerId)).
+		SetWorkerTaskId(workerTaskId).
+		SetUserDef(def).
+		SetAttempts(attempts).
+		SetErrors(taskErrors).
+		SetDeadline(deadline.Time).
+		SetDispatchedAt(dispatchedAt.Time).
+		SetTraceParent(traceParent).
+		SetRequestId(requestId).
+		SetBatchId(batchId).
+		SetCacheKey(cacheKey).
+		SetCreatedAt(createdAt).
+		Build(), nil
+}
+
+// nullTime stores the zero time as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
+func unmarshalTaskErrors(data sql.NullString) ([]TaskError, error) {
+	var taskErrors []TaskError
+	if data.Valid && len(data.String) > 0 {
+		if err := json.Unmarshal([]byte(data.String), &taskErrors); err != nil {
+			return nil, err
+		}
+	}
+	return taskErrors, nil
+}
+
+func (s *SqlTaskStore) DelTask(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+		result, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE tasks SET deleted_at = ?, updated_at = ? WHERE id = ? AND deleted_at IS NULL`),
+			now, now, id)
+		if err != nil {
+			return err
+		}
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			return err
+		}
+		return s.addEvent(ctx, tx, id, taskEventDeleted, "", "", "")
+	})
+}
+
+func (s *SqlTaskStore) UpdateTaskState(taskId string, state string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		current, err := s.lockTaskState(ctx, tx, taskId)
+		if err != nil {
+			return err
+		}
+		if !CanTransition(TaskState(current), TaskState(state)) {
+			return invalidTransition(taskId, TaskState(current), TaskState(state))
+		}
+
+		_, err = tx.ExecContext(ctx, s.dialect.rebind(`UPDATE tasks SET state = ?, updated_at = ? WHERE id = ?`),
+			state, time.Now().UTC(), taskId)
+		if err != nil {
+			return err
+		}
+		return s.addEvent(ctx, tx, taskId, taskEventState, current, state, "")
+	})
+}
+
+func (s *SqlTaskStore) SwapTaskState(taskId string, workerId WorkerId, from string, to string) error {
+	if !CanTransition(TaskState(from), TaskState(to)) {
+		return invalidTransition(taskId, TaskState(from), TaskState(to))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := s.lockTaskState(ctx, tx, taskId); err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE tasks SET state = ?, updated_at = ? WHERE id = ? AND state = ? AND worker_id = ?`),
+			to, time.Now().UTC(), taskId, from, string(workerId))
+		if err != nil {
+			return err
+		}
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			if err != nil {
+				return err
+			}
+			return stateChanged(taskId, TaskState(from))
+		}
+		return s.addEvent(ctx, tx, taskId, taskEventState, from, to, "")
+	})
+}
+
+func (s *SqlTaskStore) UpdateTaskWorker(taskId string, workerId WorkerId, workerTaskId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		current, err := s.lockTaskState(ctx, tx, taskId)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, s.dialect.rebind(`UPDATE tasks SET worker_id = ?, worker_task_id = ?, dispatched_at = ?, updated_at = ? WHERE id = ?`),
+			string(workerId), workerTaskId, nullTime(dispatchTime(workerId)), time.Now().UTC(), taskId)
+		if err != nil {
+			return err
+		}
+		return s.addEvent(ctx, tx, taskId, taskEventWorker, current, current, string(workerId)+"/"+workerTaskId)
+	})
+}
+
+func (s *SqlTaskStore) AddTaskError(taskId string, taskErr TaskError) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		current, err := s.lockTaskState(ctx, tx, taskId)
+		if err != nil {
+			return err
+		}
+
+		var errorsJson sql.NullString
+		err = tx.QueryRowContext(ctx, s.dialect.rebind(`SELECT errors FROM tasks WHERE id = ?`), taskId).Scan(&errorsJson)
+		if err != nil {
+			return err
+		}
+		taskErrors, err := unmarshalTaskErrors(errorsJson)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(append(taskErrors, taskErr))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, s.dialect.rebind(`UPDATE tasks SET errors = ?, attempts = attempts + 1, updated_at = ? WHERE id = ?`),
+			string(data), time.Now().UTC(), taskId)
+		if err != nil {
+			return err
+		}
+		return s.addEvent(ctx, tx, taskId, taskEventError, current, current, taskErr.Message)
+	})
+}
+
+func (s *SqlTaskStore) ResetTaskAttempts(taskId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		current, err := s.lockTaskState(ctx, tx, taskId)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, s.dialect.rebind(`UPDATE tasks SET attempts = 0, updated_at = ? WHERE id = ?`),
+			time.Now().UTC(), taskId)
+		if err != nil {
+			return err
+		}
+		return s.addEvent(ctx, tx, taskId, taskEventReset, current, current, "")
+	})
+}
+
+func (s *SqlTaskStore) ListTasks(filter TaskFilter) ([]Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	query := `SELECT ` + sqlTaskColumns + ` FROM tasks WHERE deleted_at IS NULL`
+	args := []interface{}{}
+	if len(filter.State) > 0 {
+		query += ` AND state = ?`
+		args = append(args, string(filter.State))
+	}
+	if len(filter.BatchId) > 0 {
+		query += ` AND batch_id = ?`
+		args = append(args, filter.BatchId)
+	}
+	query += ` ORDER BY created_at`
+	if filter.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := make([]Task, 0)
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+// CountTasks counts in the database, the state index narrows the rows to the
+// unfinished tasks.
+func (s *SqlTaskStore) CountTasks() (map[TaskType]TaskCounts, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(`SELECT type,
+			SUM(CASE WHEN state = ? AND worker_id = '' THEN 1 ELSE 0 END),
+			SUM(CASE WHEN worker_id <> '' THEN 1 ELSE 0 END)
+		FROM tasks WHERE deleted_at IS NULL AND state IN (?, ?) GROUP BY type`),
+		TaskStateCreated, TaskStateCreated, TaskStateRunning)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[TaskType]TaskCounts)
+	for rows.Next() {
+		var taskType string
+		var count TaskCounts
+		if err := rows.Scan(&taskType, &count.Queued, &count.Dispatched); err != nil {
+			return nil, err
+		}
+		if count.Queued > 0 || count.Dispatched > 0 {
+			counts[TaskType(taskType)] = count
+		}
+	}
+	return counts, rows.Err()
+}
+
+func (s *SqlTaskStore) ListQueuedTasks(deadlineBefore time.Time, limit int) ([]Task, error) {
+	return s.listTasks(`state = ? AND worker_id = '' AND deadline IS NOT NULL AND deadline < ? ORDER BY deadline`,
+		limit, TaskStateCreated, deadlineBefore.UTC())
+}
+
+func (s *SqlTaskStore) ListDispatchedTasks(dispatchedBefore time.Time, limit int) ([]Task, error) {
+	return s.listTasks(`state IN (?, ?) AND worker_id <> '' AND dispatched_at IS NOT NULL AND dispatched_at < ? ORDER BY dispatched_at`,
+		limit, TaskStateCreated, TaskStateRunning, dispatchedBefore.UTC())
+}
+
+// listTasks selects the live tasks matching where, which ends with its
+// ORDER BY.
+func (s *SqlTaskStore) listTasks(where string, limit int, args ...interface{}) ([]Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	query := `SELECT ` + sqlTaskColumns + ` FROM tasks WHERE deleted_at IS NULL AND ` + where
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := make([]Task, 0)
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+// ListBatchTaskIds reads the batch_id index of the tasks table.
+func (s *SqlTaskStore) ListBatchTaskIds(batchId string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(`SELECT id FROM tasks WHERE batch_id = ? AND deleted_at IS NULL ORDER BY created_at`), batchId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *SqlTaskStore) FinishBatch(batchId string, finishedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	update := func() (int64, error) {
+		result, err := s.db.ExecContext(ctx, s.dialect.rebind(`UPDATE task_batches SET finished_at = ? WHERE id = ?`),
+			finishedAt.UTC(), batchId)
+		if err != nil {
+			return 0, err
+		}
+		return result.RowsAffected()
+	}
+	if affected, err := update(); err != nil || affected > 0 {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, s.dialect.rebind(`INSERT INTO task_batches (id, finished_at) VALUES (?, ?)`),
+		batchId, finishedAt.UTC())
+	if err != nil {
+		// another replica inserted the batch first
+		_, err = update()
+	}
+	return err
+}
+
+func (s *SqlTaskStore) ListFinishedBatches(finishedBefore time.Time, limit int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	query := `SELECT id FROM task_batches WHERE finished_at < ? ORDER BY finished_at`
+	args := []interface{}{finishedBefore.UTC()}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	batchIds := make([]string, 0)
+	for rows.Next() {
+		var batchId string
+		if err := rows.Scan(&batchId); err != nil {
+			return nil, err
+		}
+		batchIds = append(batchIds, batchId)
+	}
+	return batchIds, rows.Err()
+}
+
+// DelBatch soft deletes the tasks of the batch like DelTask does.
+func (s *SqlTaskStore) DelBatch(batchId string) error {
+	ids, err := s.ListBatchTaskIds(batchId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+		for _, id := range ids {
+			result, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE tasks SET deleted_at = ?, updated_at = ? WHERE id = ? AND deleted_at IS NULL`),
+				now, now, id)
+			if err != nil {
+				return err
+			}
+			affected, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if affected == 0 {
+				continue
+			}
+			if err := s.addEvent(ctx, tx, id, taskEventDeleted, "", "", ""); err != nil {
+				return err
+			}
+		}
+		_, err := tx.ExecContext(ctx, s.dialect.rebind(`DELETE FROM task_batches WHERE id = ?`), batchId)
+		return err
+	})
+}
+
+func (s *SqlTaskStore) Close() error {
+	return s.db.Close()
+}
+
+// lockTaskState reads the state of a live task, locking its row until the
+// transaction ends where the dialect supports it. sqlite serializes writers
+// on its own.
+func (s *SqlTaskStore) lockTaskState(ctx context.Context, tx *sql.Tx, taskId string) (string, error) {
+	query := `SELECT state FROM tasks WHERE id = ? AND deleted_at IS NULL`
+	if s.dialect.lockForUpdate {
+		query += ` FOR UPDATE`
+	}
+
+	var state string
+	err := tx.QueryRowContext(ctx, s.dialect.rebind(query), taskId).Scan(&state)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", taskNotFound(taskId)
+		}
+		return "", err
+	}
+	return state, nil
+}
+
+func (s *SqlTaskStore) addEvent(ctx context.Context, tx *sql.Tx, taskId, event, from, to, detail string) error {
+	_, err := tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO task_events (task_id, event, from_state, to_state, detail, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`),
+		taskId, event, from, to, detail, time.Now().UTC())
+	return err
+}
+
+func (s *SqlTaskStore) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,109 @@
+package scheduler_test
+
+import (
+	"context"
+	"database/sql"
+	"go-web/pkg/scheduler"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSqlTaskStore(t *testing.T, path string) *scheduler.SqlTaskStore {
+	store, err := scheduler.NewSqlTaskStore(&scheduler.SqlConfig{
+		Dialect: scheduler.SqlDialectSqlite,
+		DSN:     "file:" + path + "?_pragma=busy_timeout(5000)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestNewSqlTaskStore_ShouldMigrateOnce_WhenOpenedTwice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.db")
+	first := newTestSqlTaskStore(t, path)
+	version, err := first.SchemaVersion(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 10, version)
+	first.Close()
+
+	second := newTestSqlTaskStore(t, path)
+	version, err = second.SchemaVersion(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 10, version)
+}
+
+func TestNewSqlTaskStore_ShouldMigrate_WhenReplicasStartTogether(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.db")
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store, err := scheduler.NewSqlTaskStore(&scheduler.SqlConfig{
+				Dialect: scheduler.SqlDialectSqlite,
+				DSN:     "file:" + path + "?_pragma=busy_timeout(5000)",
+			})
+			if err == nil {
+				store.Close()
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	store := newTestSqlTaskStore(t, path)
+	version, err := store.SchemaVersion(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 10, version)
+}
+
+func TestSqlTaskStore_ShouldKeepHistory_WhenTaskDeleted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.db")
+	store := newTestSqlTaskStore(t, path)
+	task := scheduler.NewTaskBuilder().
+		SetType(scheduler.TaskTypePdf).
+		SetSubType(scheduler.SubTaskTypePdf2Img).
+		SetUserId("u1").
+		Build()
+
+	assert.NoError(t, store.AddTask(task))
+	assert.NoError(t, store.UpdateTaskState(task.GetId(), scheduler.TaskStateRunning))
+	assert.NoError(t, store.UpdateTaskState(task.GetId(), scheduler.TaskStateDone))
+	assert.NoError(t, store.DelTask(task.GetId()))
+	store.Close()
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var state, userId string
+	err = db.QueryRow(`SELECT state, user_id FROM tasks WHERE id = ?`, task.GetId()).Scan(&state, &userId)
+	assert.NoError(t, err)
+	assert.Equal(t, scheduler.TaskStateDone, state)
+	assert.Equal(t, "u1", userId)
+
+	rows, err := db.Query(`SELECT event, to_state FROM task_events WHERE task_id = ? ORDER BY id`, task.GetId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var events []string
+	for rows.Next() {
+		var event, to string
+		assert.NoError(t, rows.Scan(&event, &to))
+		events = append(events, event+":"+to)
+	}
+	assert.Equal(t, []string{"created:PENDING", "state:RUNNING", "state:DONE", "deleted:"}, events)
+}
@@ -0,0 +1,389 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// every task type has its own stream, RedisStreamKeyPrefix + {task type}
+	RedisStreamKeyPrefix = "ktools:stream:"
+	// consumer group shared by all go-web replicas
+	RedisStreamGroup = "go-web"
+)
+
+type StreamDispatcherCfg struct {
+	// Consumer names this replica in the consumer group, the host name by
+	// default so a restarted replica takes its old name again
+	Consumer string
+	// TaskTypes gets one stream and one consumer goroutine each
+	TaskTypes []TaskType
+	// ClaimIdle is how long a message stays unacknowledged before another
+	// consumer claims it
+	ClaimIdle time.Duration
+	// BatchSize is the max number of messages read or claimed at once
+	BatchSize int64
+	// Block is how long a read waits for new messages
+	Block time.Duration
+}
+
+// StreamDispatcher queues tasks in a redis stream per task type. Consumers of
+// the go-web group send them to workers and acknowledge a message only once
+// its task finished, so a crash anywhere before that leaves the message
+// pending until some consumer claims it again. A claimed message of a task a
+// worker still runs stays pending, the tasks workers accepted are kept in a
+// hash next to the stream. Acked messages are deleted, the length of a stream
+// minus its accepted tasks is its backlog.
+type StreamDispatcher struct {
+	client   redis.UniversalClient
+	tm       *TaskManager
+	dispatch DispatchFunc
+	cfg      StreamDispatcherCfg
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func NewStreamDispatcher(client redis.UniversalClient, tm *TaskManager, dispatch DispatchFunc, cfg StreamDispatcherCfg) *StreamDispatcher {
+	if len(cfg.Consumer) == 0 {
+		cfg.Consumer = defaultConsumer()
+	}
+	if len(cfg.TaskTypes) == 0 {
+		cfg.TaskTypes = TaskTypes()
+	}
+	if cfg.ClaimIdle <= 0 {
+		cfg.ClaimIdle = time.Minute
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 10
+	}
+	if cfg.Block <= 0 {
+		cfg.Block = 2 * time.Second
+	}
+	return &StreamDispatcher{
+		client:   client,
+		tm:       tm,
+		dispatch: dispatch,
+		cfg:      cfg,
+	}
+}
+
+// RedisStreamKey is the stream the tasks of a type are queued in. The hash tag
+// keeps a stream in one slot in cluster mode.
+func RedisStreamKey(taskType TaskType) string {
+	return RedisStreamKeyPrefix + "{" + string(taskType) + "}"
+}
+
+// redisStreamAcceptedKey maps the ids of the tasks workers accepted to their
+// message in the stream of taskType, it shares the slot of the stream.
+func redisStreamAcceptedKey(taskType TaskType) string {
+	return RedisStreamKey(taskType) + ":accepted"
+}
+
+func defaultConsumer() string {
+	if host, err := os.Hostname(); err == nil && len(host) > 0 {
+		return host
+	}
+	return newReplicaId()
+}
+
+func (d *StreamDispatcher) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	for _, taskType := range d.cfg.TaskTypes {
+		err := d.client.XGroupCreateMkStream(ctx, RedisStreamKey(taskType), RedisStreamGroup, "0").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			cancel()
+			return err
+		}
+		d.removeIdleConsumers(ctx, RedisStreamKey(taskType))
+	}
+
+	d.cancel = cancel
+	for _, taskType := range d.cfg.TaskTypes {
+		d.wg.Add(1)
+		go d.consume(ctx, RedisStreamKey(taskType))
+	}
+	return nil
+}
+
+func (d *StreamDispatcher) Stop() error {
+	if d.cancel == nil {
+		return nil
+	}
+	d.cancel()
+	d.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	for _, taskType := range d.cfg.TaskTypes {
+		d.removeConsumer(ctx, RedisStreamKey(taskType), d.cfg.Consumer)
+	}
+	return nil
+}
+
+// removeIdleConsumers drops the consumers replicas left behind that have
+// nothing pending and were idle past ClaimIdle.
+func (d *StreamDispatcher) removeIdleConsumers(ctx context.Context, stream string) {
+	consumers, err := d.client.XInfoConsumers(ctx, stream, RedisStreamGroup).Result()
+	if err != nil {
+		slog.Warn("list stream consumers error", "stream", stream, "error", err)
+		return
+	}
+	for _, consumer := range consumers {
+		if consumer.Name != d.cfg.Consumer && consumer.Pending == 0 && consumer.Idle >= d.cfg.ClaimIdle {
+			d.removeConsumer(ctx, stream, consumer.Name)
+		}
+	}
+}
+
+// removeConsumer deletes a consumer without pending messages, a consumer with
+// pending messages stays until another consumer claimed them, deleting it
+// would drop them.
+func (d *StreamDispatcher) removeConsumer(ctx context.Context, stream string, consumer string) {
+	pending, err := d.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   stream,
+		Group:    RedisStreamGroup,
+		Start:    "-",
+		End:      "+",
+		Count:    1,
+		Consumer: consumer,
+	}).Result()
+	if (err != nil && !errors.Is(err, redis.Nil)) || len(pending) > 0 {
+		return
+	}
+	if err := d.client.XGroupDelConsumer(ctx, stream, RedisStreamGroup, consumer).Err(); err != nil {
+		slog.Warn("delete stream consumer error", "stream", stream, "consumer", consumer, "error", err)
+	}
+}
+
+// Dispatch queues a task. The message of an earlier attempt of the task is
+// acknowledged, the task is dispatched again.
+func (d *StreamDispatcher) Dispatch(task Task) (TaskFuture, error) {
+	d.Complete(task)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	// the task itself is in the task store, the payload is only for workers
+	// that consume the stream on their own
+	userDef, err := json.Marshal(task.GetUserDef())
+	if err != nil {
+		return nil, err
+	}
+	err = d.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: RedisStreamKey(task.GetType()),
+		Values: map[string]interface{}{
+			"task_id":  task.GetId(),
+			"type":     string(task.GetType()),
+			"sub_type": string(task.GetSubType()),
+			"user_def": string(userDef),
+		},
+	}).Err()
+	if err != nil {
+		return nil, err
+	}
+	return NewTaskFuture(task), nil
+}
+
+// Complete acknowledges the message of a task that will not run again.
+func (d *StreamDispatcher) Complete(task Task) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	accepted := redisStreamAcceptedKey(task.GetType())
+	id, err := d.client.HGet(ctx, accepted, task.GetId()).Result()
+	if errors.Is(err, redis.Nil) {
+		return
+	}
+	if err != nil {
+		slog.Error("load accepted task message error", "task_id", task.GetId(), "error", err)
+		return
+	}
+	d.ack(RedisStreamKey(task.GetType()), id, task.GetId())
+}
+
+// Backlog returns the number of tasks per task type no worker accepted yet.
+func (d *StreamDispatcher) Backlog() (map[TaskType]int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	lengths := make([]*redis.IntCmd, len(d.cfg.TaskTypes))
+	accepted := make([]*redis.IntCmd, len(d.cfg.TaskTypes))
+	_, err := d.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, taskType := range d.cfg.TaskTypes {
+			lengths[i] = pipe.XLen(ctx, RedisStreamKey(taskType))
+			accepted[i] = pipe.HLen(ctx, redisStreamAcceptedKey(taskType))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	backlog := make(map[TaskType]int64, len(d.cfg.TaskTypes))
+	for i, taskType := range d.cfg.TaskTypes {
+		backlog[taskType] = max(lengths[i].Val()-accepted[i].Val(), 0)
+	}
+	return backlog, nil
+}
+
+func (d *StreamDispatcher) consume(ctx context.Context, stream string) {
+	defer d.wg.Done()
+
+	lastClaim := time.Time{}
+	for ctx.Err() == nil {
+		if time.Since(lastClaim) >= d.cfg.ClaimIdle/2 {
+			d.claim(ctx, stream)
+			lastClaim = time.Now()
+		}
+
+		streams, err := d.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    RedisStreamGroup,
+			Consumer: d.cfg.Consumer,
+			Streams:  []string{stream, ">"},
+			Count:    d.cfg.BatchSize,
+			Block:    d.cfg.Block,
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) || ctx.Err() != nil {
+				continue
+			}
+			slog.Error("read task stream error", "stream", stream, "error", err)
+			d.sleep(ctx, time.Second)
+			continue
+		}
+		for _, s := range streams {
+			for _, msg := range s.Messages {
+				d.handle(stream, msg)
+			}
+		}
+	}
+}
+
+// claim takes over messages no consumer acknowledged within ClaimIdle, because
+// their consumer died, no worker accepted the task yet or the task still runs.
+func (d *StreamDispatcher) claim(ctx context.Context, stream string) {
+	start := "0-0"
+	for {
+		msgs, next, err := d.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    RedisStreamGroup,
+			Consumer: d.cfg.Consumer,
+			MinIdle:  d.cfg.ClaimIdle,
+			Start:    start,
+			Count:    d.cfg.BatchSize,
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("claim task stream error", "stream", stream, "error", err)
+			}
+			return
+		}
+		for _, msg := range msgs {
+			d.handle(stream, msg)
+		}
+		if next == "0-0" || len(next) == 0 {
+			return
+		}
+		start = next
+	}
+}
+
+func (d *StreamDispatcher) handle(stream string, msg redis.XMessage) {
+	taskId, _ := msg.Values["task_id"].(string)
+	task, err := d.tm.GetTask(taskId)
+	if err != nil {
+		if errors.Is(err, ErrTaskNotFound) {
+			d.ack(stream, msg.ID, taskId)
+			return
+		}
+		slog.Error("load queued task error", "task_id", taskId, "error", err)
+		return
+	}
+
+	// finished while the message was pending, or dead-lettered until an
+	// admin requeues it with a new message
+	if IsFinalState(task.GetState()) || task.GetState() == TaskStateDeadLetter {
+		d.ack(stream, msg.ID, taskId)
+		return
+	}
+	// a redelivered message of a task some worker already accepted
+	if len(task.GetWorkerId()) > 0 {
+		d.accept(task, msg.ID)
+		return
+	}
+	if task.GetState() != TaskStateCreated {
+		d.ack(stream, msg.ID, taskId)
+		return
+	}
+
+	_, err = d.dispatch(task)
+	if errors.Is(err, errTaskHeld) {
+		slog.Debug("queued task held", "task_id", taskId, "request_id", task.GetRequestId())
+		return
+	}
+	if err != nil {
+		slog.Warn("dispatch queued task error", "task_id", taskId, "request_id", task.GetRequestId(), "error", err)
+		// otherwise it stays pending and is claimed again after ClaimIdle
+		if errors.Is(err, ErrTaskDeadLettered) {
+			d.ack(stream, msg.ID, taskId)
+		}
+		return
+	}
+	d.accept(task, msg.ID)
+}
+
+// accept records that a worker runs the task of a message, the message stays
+// pending until Complete. Another message than the recorded one belongs to
+// another attempt and is acknowledged.
+func (d *StreamDispatcher) accept(task Task, id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	accepted := redisStreamAcceptedKey(task.GetType())
+	current, err := d.client.HGet(ctx, accepted, task.GetId()).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		slog.Error("load accepted task message error", "task_id", task.GetId(), "error", err)
+		return
+	}
+	if len(current) > 0 && current != id {
+		d.ack(RedisStreamKey(task.GetType()), id, "")
+		return
+	}
+	if err := d.client.HSet(ctx, accepted, task.GetId(), id).Err(); err != nil {
+		slog.Error("record accepted task message error", "task_id", task.GetId(), "error", err)
+	}
+}
+
+// ack also runs while stopping, a finished task must not be sent again. The
+// accepted entry of taskId is dropped with the message.
+func (d *StreamDispatcher) ack(stream string, id string, taskId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	_, err := d.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, stream, RedisStreamGroup, id)
+		pipe.XDel(ctx, stream, id)
+		if len(taskId) > 0 {
+			pipe.HDel(ctx, stream+":accepted", taskId)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("ack task stream message error", "stream", stream, "message_id", id, "error", err)
+	}
+}
+
+func (d *StreamDispatcher) sleep(ctx context.Context, duration time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(duration):
+	}
+}
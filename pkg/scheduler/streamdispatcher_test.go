@@ -0,0 +1,158 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"go-web/pkg/scheduler"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeDispatch struct {
+	tm       *scheduler.TaskManager
+	mu       sync.Mutex
+	failures int
+	calls    []string
+}
+
+func (f *fakeDispatch) dispatch(task scheduler.Task) (scheduler.TaskFuture, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, task.GetId())
+	if f.failures > 0 {
+		f.failures--
+		return nil, errors.New("no worker available")
+	}
+	return scheduler.NewTaskFuture(task), f.tm.UpdateTaskWorker(task.GetId(), "w1", "wt1")
+}
+
+func (f *fakeDispatch) callCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.calls)
+}
+
+func newTestStreamDispatcher(t *testing.T, failures int) (*scheduler.StreamDispatcher, *scheduler.TaskManager, *fakeDispatch) {
+	dispatcher, tm, fake, _ := newTestStreamDispatcherClient(t, failures)
+	return dispatcher, tm, fake
+}
+
+func newTestStreamDispatcherClient(t *testing.T, failures int) (*scheduler.StreamDispatcher, *scheduler.TaskManager, *fakeDispatch, *redis.Client) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	tm, err := scheduler.NewTaskManager(scheduler.TaskManagerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeDispatch{tm: tm, failures: failures}
+	dispatcher := scheduler.NewStreamDispatcher(client, tm, fake.dispatch, scheduler.StreamDispatcherCfg{
+		Consumer:  "c1",
+		TaskTypes: []scheduler.TaskType{scheduler.TaskTypePdf},
+		ClaimIdle: 200 * time.Millisecond,
+		Block:     50 * time.Millisecond,
+	})
+	if err := dispatcher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dispatcher.Stop() })
+	return dispatcher, tm, fake, client
+}
+
+func queueTask(t *testing.T, dispatcher *scheduler.StreamDispatcher, tm *scheduler.TaskManager) scheduler.Task {
+	task := scheduler.NewTaskBuilder().
+		SetType(scheduler.TaskTypePdf).
+		SetSubType(scheduler.SubTaskTypePdf2Img).
+		Build()
+	assert.NoError(t, tm.AddTask(task))
+	_, err := dispatcher.Dispatch(task)
+	assert.NoError(t, err)
+	return task
+}
+
+func TestStreamDispatcher_ShouldDispatchAndAck_WhenTaskQueued(t *testing.T) {
+	dispatcher, tm, fake := newTestStreamDispatcher(t, 0)
+	task := queueTask(t, dispatcher, tm)
+
+	assert.Eventually(t, func() bool { return fake.callCount() == 1 }, 2*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		backlog, err := dispatcher.Backlog()
+		return err == nil && backlog[scheduler.TaskTypePdf] == 0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	got, err := tm.GetTask(task.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, scheduler.WorkerId("w1"), got.GetWorkerId())
+}
+
+func TestStreamDispatcher_ShouldRedeliver_WhenDispatchFailed(t *testing.T) {
+	dispatcher, tm, fake := newTestStreamDispatcher(t, 1)
+	queueTask(t, dispatcher, tm)
+
+	assert.Eventually(t, func() bool { return fake.callCount() == 2 }, 3*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		backlog, err := dispatcher.Backlog()
+		return err == nil && backlog[scheduler.TaskTypePdf] == 0
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestStreamDispatcher_ShouldSkipDispatch_WhenTaskAlreadyOnWorker(t *testing.T) {
+	dispatcher, tm, fake := newTestStreamDispatcher(t, 0)
+	task := scheduler.NewTaskBuilder().
+		SetType(scheduler.TaskTypePdf).
+		SetSubType(scheduler.SubTaskTypePdf2Img).
+		SetWorkerId("w1").
+		Build()
+	assert.NoError(t, tm.AddTask(task))
+	_, err := dispatcher.Dispatch(task)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		backlog, err := dispatcher.Backlog()
+		return err == nil && backlog[scheduler.TaskTypePdf] == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, fake.callCount())
+}
+
+func TestStreamDispatcher_ShouldAckOnlyOnComplete_WhenWorkerAccepted(t *testing.T) {
+	dispatcher, tm, fake, client := newTestStreamDispatcherClient(t, 0)
+	task := queueTask(t, dispatcher, tm)
+	stream := scheduler.RedisStreamKey(scheduler.TaskTypePdf)
+
+	assert.Eventually(t, func() bool { return fake.callCount() == 1 }, 2*time.Second, 10*time.Millisecond)
+	// claimed again while the task runs, it is neither dispatched nor acked
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, 1, fake.callCount())
+	assert.Equal(t, int64(1), client.XLen(context.Background(), stream).Val())
+	backlog, err := dispatcher.Backlog()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), backlog[scheduler.TaskTypePdf])
+
+	dispatcher.Complete(task)
+	assert.Equal(t, int64(0), client.XLen(context.Background(), stream).Val())
+	pending, err := client.XPending(context.Background(), stream, scheduler.RedisStreamGroup).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), pending.Count)
+}
+
+func TestStreamDispatcher_ShouldRemoveConsumer_WhenStoppedWithoutPending(t *testing.T) {
+	dispatcher, tm, fake, client := newTestStreamDispatcherClient(t, 0)
+	stream := scheduler.RedisStreamKey(scheduler.TaskTypePdf)
+	task := queueTask(t, dispatcher, tm)
+	assert.Eventually(t, func() bool { return fake.callCount() == 1 }, 2*time.Second, 10*time.Millisecond)
+	consumers, err := client.XInfoConsumers(context.Background(), stream, scheduler.RedisStreamGroup).Result()
+	assert.NoError(t, err)
+	assert.Len(t, consumers, 1)
+	dispatcher.Complete(task)
+
+	assert.NoError(t, dispatcher.Stop())
+	consumers, err = client.XInfoConsumers(context.Background(), stream, scheduler.RedisStreamGroup).Result()
+	assert.NoError(t, err)
+	assert.Empty(t, consumers)
+}
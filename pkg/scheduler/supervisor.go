@@ -0,0 +1,189 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	DefaultSupervisorInterval = 10 * time.Second
+	DefaultBatchRetention     = 24 * time.Hour
+)
+
+type SupervisorCfg struct {
+	// Interval is how often queued and running tasks are checked
+	Interval time.Duration
+	// ExecutionTimeouts bounds how long a worker may hold a task of a sub type
+	ExecutionTimeouts map[SubTaskType]time.Duration
+	// DefaultExecutionTimeout applies to the sub types without their own
+	// timeout, 0 lets them run forever
+	DefaultExecutionTimeout time.Duration
+	// BatchRetention is how long a finished batch can still be read before
+	// it is deleted with its tasks
+	BatchRetention time.Duration
+}
+
+// supervisor fails tasks that waited past their queue deadline or ran longer
+// than their sub type allows, and deletes finished batches once their
+// retention is over. It runs on the leader only.
+type supervisor struct {
+	s       *Scheduler
+	cfg     SupervisorCfg
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newSupervisor(s *Scheduler, cfg SupervisorCfg) *supervisor {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultSupervisorInterval
+	}
+	if cfg.BatchRetention <= 0 {
+		cfg.BatchRetention = DefaultBatchRetention
+	}
+	return &supervisor{
+		s:       s,
+		cfg:     cfg,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+func (sv *supervisor) start() {
+	go func() {
+		defer close(sv.stopped)
+		runAsLeader(sv.s.leader, sv.cfg.Interval, sv.done, sv.check)
+	}()
+}
+
+func (sv *supervisor) stop() {
+	close(sv.done)
+	<-sv.stopped
+}
+
+func (sv *supervisor) executionTimeout(subType SubTaskType) time.Duration {
+	if timeout, ok := sv.cfg.ExecutionTimeouts[subType]; ok {
+		return timeout
+	}
+	return sv.cfg.DefaultExecutionTimeout
+}
+
+// minExecutionTimeout is the shortest execution timeout, 0 if no sub type
+// has one.
+func (sv *supervisor) minExecutionTimeout() time.Duration {
+	shortest := sv.cfg.DefaultExecutionTimeout
+	for _, timeout := range sv.cfg.ExecutionTimeouts {
+		if timeout > 0 && (shortest <= 0 || timeout < shortest) {
+			shortest = timeout
+		}
+	}
+	return shortest
+}
+
+// check reads only the overdue tasks from the deadline and dispatch time
+// indexes of the store.
+func (sv *supervisor) check() {
+	now := time.Now()
+	sv.deleteBatches(now)
+	expired, err := sv.s.tm.ListQueuedTasks(now, 0)
+	if err != nil {
+		slog.Error("list expired tasks error", "error", err)
+	}
+	for _, task := range expired {
+		sv.expire(task)
+	}
+
+	shortest := sv.minExecutionTimeout()
+	if shortest <= 0 {
+		return
+	}
+	dispatched, err := sv.s.tm.ListDispatchedTasks(now.Add(-shortest), 0)
+	if err != nil {
+		slog.Error("list overdue tasks error", "error", err)
+		return
+	}
+	for _, task := range dispatched {
+		timeout := sv.executionTimeout(task.GetSubType())
+		if timeout > 0 && now.Sub(task.GetDispatchedAt()) > timeout {
+			sv.timeout(task, timeout)
+		}
+	}
+}
+
+// deleteBatches deletes the batches that finished longer than the retention
+// ago. A batch with a task requeued since is skipped, it is recorded again
+// once the task ends.
+func (sv *supervisor) deleteBatches(now time.Time) {
+	batchIds, err := sv.s.tm.ListFinishedBatches(now.Add(-sv.cfg.BatchRetention), 0)
+	if err != nil {
+		slog.Error("list finished batches error", "error", err)
+		return
+	}
+	for _, batchId := range batchIds {
+		tasks, err := sv.s.batchTasks(batchId)
+		if err != nil {
+			slog.Error("list batch tasks error", "batch_id", batchId, "error", err)
+			continue
+		}
+		if !batchEnded(tasks) {
+			continue
+		}
+		if err := sv.s.tm.DelBatch(batchId); err != nil {
+			slog.Error("delete finished batch error", "batch_id", batchId, "error", err)
+			continue
+		}
+		slog.Info("finished batch deleted", "batch_id", batchId, "tasks", len(tasks))
+	}
+}
+
+// expire fails a task nobody picked up before its deadline. It is not
+// retried, the deadline has passed for every further attempt too.
+func (sv *supervisor) expire(task Task) {
+	if !sv.markFailure(task) {
+		return
+	}
+	recordTaskFailed(task)
+	err := sv.s.tm.AddTaskError(task.GetId(), TaskError{
+		Message: "queue deadline exceeded",
+		Time:    time.Now(),
+	})
+	if err != nil {
+		slog.Error("record task timeout error", "task_id", task.GetId(), "error", err)
+	}
+	sv.s.finishBatch(task)
+	slog.Warn("task missed its queue deadline", taskLogAttrs(task)...)
+}
+
+// timeout fails a task its worker held too long, asks the worker to give up
+// on it and retries it on another attempt.
+func (sv *supervisor) timeout(task Task, timeout time.Duration) {
+	if !sv.markFailure(task) {
+		return
+	}
+	if err := sv.s.executor.CancelTask(task.GetWorkerTaskId(), task.GetWorkerId()); err != nil {
+		slog.Warn("cancel timed out task error", append(taskLogAttrs(task), "worker_id", task.GetWorkerId(), "error", err)...)
+	}
+	sv.s.handleTaskFailure(task, fmt.Sprintf("execution timeout after %s", timeout))
+}
+
+// markFailure moves the task to FAILURE unless it changed since it was
+// listed, a worker report or a retry may have got there first.
+func (sv *supervisor) markFailure(task Task) bool {
+	current, err := sv.s.tm.GetTask(task.GetId())
+	if err != nil {
+		return false
+	}
+	if current.GetWorkerTaskId() != task.GetWorkerTaskId() {
+		return false
+	}
+
+	err = sv.s.tm.SwapTaskState(task.GetId(), task.GetWorkerId(), task.GetState(), TaskStateFailure)
+	if err != nil {
+		if !errors.Is(err, ErrTaskStateChanged) {
+			slog.Error("fail overdue task error", "task_id", task.GetId(), "error", err)
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package scheduler_test
+
+import (
+	"errors"
+	"go-web/pkg/config"
+	"go-web/pkg/scheduler"
+	"go-web/pkg/scheduler/schedulertest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisor_ShouldCancelAndRetryTask_WhenExecutionTimedOut(t *testing.T) {
+	worker := newFakeWorker(t)
+	s := newTestScheduler(t, &config.Scheduler{
+		Retry: config.Retry{MaxAttempts: 2},
+		Timeout: config.Timeout{
+			SupervisorInterval: 1,
+			Execution:          map[string]int{string(scheduler.SubTaskTypePdf2Img): 1},
+		},
+	}, worker)
+	assert.NoError(t, s.Start())
+
+	task := newDeadLetterTask()
+	_, err := s.Schedule(task)
+	assert.NoError(t, err)
+
+	schedulertest.AssertCancelled(t, worker, task.GetId())
+	schedulertest.AssertSubmitted(t, worker, 2)
+
+	got, err := s.GetTask(task.GetId())
+	assert.NoError(t, err)
+	if assert.NotEmpty(t, got.GetErrors()) {
+		assert.True(t, strings.HasPrefix(got.GetErrors()[0].Message, "execution timeout"))
+		assert.Equal(t, scheduler.WorkerId("w1"), got.GetErrors()[0].WorkerId)
+	}
+}
+
+func TestSupervisor_ShouldDeleteBatch_WhenRetentionPassed(t *testing.T) {
+	worker := newFakeWorker(t)
+	worker.SetBehavior(schedulertest.Reject)
+	s := newTestScheduler(t, &config.Scheduler{
+		Retry:   config.Retry{MaxAttempts: 1},
+		Timeout: config.Timeout{SupervisorInterval: 1, BatchRetention: 1},
+	}, worker)
+	assert.NoError(t, s.Start())
+
+	for i := 0; i < 2; i++ {
+		task := scheduler.NewTaskBuilder().
+			SetType(scheduler.TaskTypePdf).
+			SetSubType(scheduler.SubTaskTypePdf2Img).
+			SetUserDef(map[string]interface{}{"file_id": "f1"}).
+			SetBatchId("b1").
+			Build()
+		_, err := s.Schedule(task)
+		assert.ErrorIs(t, err, scheduler.ErrTaskDeadLettered)
+	}
+	tasks, err := s.ListBatch("b1")
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 2)
+
+	assert.Eventually(t, func() bool {
+		_, err := s.ListBatch("b1")
+		return errors.Is(err, scheduler.ErrBatchNotFound)
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestSupervisor_ShouldFailTask_WhenQueueDeadlinePassed(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s := newTestScheduler(t, &config.Scheduler{
+		Dispatch: config.Dispatch{Mode: scheduler.DispatchModeStream},
+		Redis: config.RedisStore{
+			ClusterMode: scheduler.RedisClusterModeStandalone,
+			Addrs:       []string{mr.Addr()},
+		},
+		Timeout: config.Timeout{SupervisorInterval: 1},
+	}, nil)
+	assert.NoError(t, s.Start())
+
+	// no worker registered, the task waits in the stream
+	task := scheduler.NewTaskBuilder().
+		SetType(scheduler.TaskTypePdf).
+		SetSubType(scheduler.SubTaskTypePdf2Img).
+		SetDeadline(time.Now().Add(100 * time.Millisecond)).
+		Build()
+	_, err := s.Schedule(task)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		result, err := s.GetTaskStatus(task.GetId())
+		return err == nil && result.Status == scheduler.TaskStateFailure
+	}, 5*time.Second, 50*time.Millisecond)
+
+	got, err := s.GetTask(task.GetId())
+	assert.NoError(t, err)
+	if assert.Len(t, got.GetErrors(), 1) {
+		assert.Equal(t, "queue deadline exceeded", got.GetErrors()[0].Message)
+	}
+}
@@ -15,6 +15,8 @@ const (
 	TaskStateDone      = "DONE"
 	TaskStateFailure   = "FAILURE"
 	TaskStateCancelled = "CANCELLED" // not support for now
+	// a task that failed on every attempt, it waits for an admin to requeue it
+	TaskStateDeadLetter = "DEAD_LETTER"
 )
 
 type TaskPriority int
@@ -28,16 +30,48 @@ const (
 type Task interface {
 	GetId() string
 	GetState() TaskState
+	SetState(state TaskState)
 	GetType() TaskType
 	SetSubType() SubTaskType
 	GetSubType() SubTaskType
 	GetCreatedAt() time.Time
 	GetPriority() TaskPriority
+	GetUserId() string
 	GetUserDef() interface{}
 	GetWorkerId() WorkerId
 	SetWorkerId(workerId WorkerId)
 	GetWorkerTaskId() string
 	SetWorkerTaskId(string)
+	// GetAttempts is the number of failed attempts since the task was created
+	// or last requeued by an admin
+	GetAttempts() int
+	SetAttempts(attempts int)
+	// GetErrors is the full error history of the task, oldest first
+	GetErrors() []TaskError
+	AddError(taskErr TaskError)
+	// GetDeadline is when a task still waiting for a worker fails, zero
+	// means it waits forever
+	GetDeadline() time.Time
+	// GetDispatchedAt is when a worker accepted the task, zero while queued
+	GetDispatchedAt() time.Time
+	SetDispatchedAt(t time.Time)
+	// GetTraceParent is the W3C traceparent of the request that created the
+	// task, empty if it was not traced
+	GetTraceParent() string
+	// GetRequestId is the X-Request-ID of the request that created the task
+	GetRequestId() string
+	// GetBatchId is the batch the task belongs to, empty for single tasks
+	GetBatchId() string
+	// GetCacheKey is the result cache key of the task, empty if its result is
+	// not cached
+	GetCacheKey() string
+}
+
+// TaskError is one failed attempt of a task.
+type TaskError struct {
+	WorkerId WorkerId  `json:"worker_id"`
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
 }
 
 type TaskFuture interface {
@@ -53,6 +87,9 @@ type TaskResult struct {
 	SubType string
 	Status  TaskState
 	Data    interface{}
+	// CachedFrom is the task whose result a task served from the result
+	// cache returns, the artifacts are under its prefix
+	CachedFrom string
 }
 
 type WorkerTaskResult struct {
@@ -70,7 +107,16 @@ type taskimpl struct {
 	subType      SubTaskType
 	createdAt    time.Time
 	priority     TaskPriority
+	userId       string
 	userdef      interface{}
+	attempts     int
+	errors       []TaskError
+	deadline     time.Time
+	dispatchedAt time.Time
+	traceParent  string
+	requestId    string
+	batchId      string
+	cacheKey     string
 }
 
 type taskfutureimpl struct {
@@ -117,6 +163,10 @@ func (t *taskimpl) GetState() TaskState {
 	return t.state
 }
 
+func (t *taskimpl) SetState(state TaskState) {
+	t.state = state
+}
+
 func (t *taskimpl) GetType() TaskType {
 	return t.taskType
 }
@@ -137,6 +187,10 @@ func (t *taskimpl) GetPriority() TaskPriority {
 	return t.priority
 }
 
+func (t *taskimpl) GetUserId() string {
+	return t.userId
+}
+
 func (t *taskimpl) GetUserDef() interface{} {
 	return t.userdef
 }
@@ -149,6 +203,50 @@ func (t *taskimpl) GetWorkerId() WorkerId {
 	return t.workerId
 }
 
+func (t *taskimpl) GetAttempts() int {
+	return t.attempts
+}
+
+func (t *taskimpl) SetAttempts(attempts int) {
+	t.attempts = attempts
+}
+
+func (t *taskimpl) GetErrors() []TaskError {
+	return t.errors
+}
+
+func (t *taskimpl) AddError(taskErr TaskError) {
+	t.errors = append(t.errors, taskErr)
+}
+
+func (t *taskimpl) GetDeadline() time.Time {
+	return t.deadline
+}
+
+func (t *taskimpl) GetDispatchedAt() time.Time {
+	return t.dispatchedAt
+}
+
+func (t *taskimpl) SetDispatchedAt(dispatchedAt time.Time) {
+	t.dispatchedAt = dispatchedAt
+}
+
+func (t *taskimpl) GetTraceParent() string {
+	return t.traceParent
+}
+
+func (t *taskimpl) GetRequestId() string {
+	return t.requestId
+}
+
+func (t *taskimpl) GetBatchId() string {
+	return t.batchId
+}
+
+func (t *taskimpl) GetCacheKey() string {
+	return t.cacheKey
+}
+
 func (t *taskfutureimpl) GetTask() Task {
 	return nil
 }
@@ -169,6 +267,11 @@ type TaskBuilder struct {
 	task *taskimpl
 }
 
+func (b *TaskBuilder) SetId(id string) *TaskBuilder {
+	b.task.id = id
+	return b
+}
+
 func (b *TaskBuilder) SetWorkerTaskId(workerTaskId string) *TaskBuilder {
 	b.task.workerTaskId = workerTaskId
 	return b
@@ -199,6 +302,11 @@ func (b *TaskBuilder) SetPriority(priority TaskPriority) *TaskBuilder {
 	return b
 }
 
+func (b *TaskBuilder) SetUserId(userId string) *TaskBuilder {
+	b.task.userId = userId
+	return b
+}
+
 func (b *TaskBuilder) SetUserDef(userDef interface{}) *TaskBuilder {
 	b.task.userdef = userDef
 	return b
@@ -209,10 +317,74 @@ func (b *TaskBuilder) SetCreatedAt(t time.Time) *TaskBuilder {
 	return b
 }
 
+func (b *TaskBuilder) SetAttempts(attempts int) *TaskBuilder {
+	b.task.attempts = attempts
+	return b
+}
+
+func (b *TaskBuilder) SetErrors(errors []TaskError) *TaskBuilder {
+	b.task.errors = errors
+	return b
+}
+
+func (b *TaskBuilder) SetDeadline(deadline time.Time) *TaskBuilder {
+	b.task.deadline = deadline
+	return b
+}
+
+func (b *TaskBuilder) SetDispatchedAt(dispatchedAt time.Time) *TaskBuilder {
+	b.task.dispatchedAt = dispatchedAt
+	return b
+}
+
+func (b *TaskBuilder) SetTraceParent(traceParent string) *TaskBuilder {
+	b.task.traceParent = traceParent
+	return b
+}
+
+func (b *TaskBuilder) SetRequestId(requestId string) *TaskBuilder {
+	b.task.requestId = requestId
+	return b
+}
+
+func (b *TaskBuilder) SetBatchId(batchId string) *TaskBuilder {
+	b.task.batchId = batchId
+	return b
+}
+
+func (b *TaskBuilder) SetCacheKey(cacheKey string) *TaskBuilder {
+	b.task.cacheKey = cacheKey
+	return b
+}
+
 func (b *TaskBuilder) Build() Task {
 	return b.task
 }
 
+// cloneTask copies a task so that stores never share it with their callers.
+func cloneTask(task Task) Task {
+	return NewTaskBuilder().
+		SetId(task.GetId()).
+		SetWorkerTaskId(task.GetWorkerTaskId()).
+		SetWorkerId(task.GetWorkerId()).
+		SetState(task.GetState()).
+		SetType(task.GetType()).
+		SetSubType(task.GetSubType()).
+		SetPriority(task.GetPriority()).
+		SetUserId(task.GetUserId()).
+		SetUserDef(task.GetUserDef()).
+		SetCreatedAt(task.GetCreatedAt()).
+		SetAttempts(task.GetAttempts()).
+		SetErrors(append([]TaskError(nil), task.GetErrors()...)).
+		SetDeadline(task.GetDeadline()).
+		SetDispatchedAt(task.GetDispatchedAt()).
+		SetTraceParent(task.GetTraceParent()).
+		SetRequestId(task.GetRequestId()).
+		SetBatchId(task.GetBatchId()).
+		SetCacheKey(task.GetCacheKey()).
+		Build()
+}
+
 func NewTaskBuilder() *TaskBuilder {
 	return &TaskBuilder{
 		task: &taskimpl{
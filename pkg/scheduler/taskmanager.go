@@ -1,30 +1,66 @@
 package scheduler
 
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	TaskStoreTypeMemory = "memory"
+	TaskStoreTypeRedis  = "redis"
+	TaskStoreTypeSql    = "sql"
+)
+
 type TaskManagerConfig struct {
 	StoreType   string
 	RedisConfig RedisConfig
+	SqlConfig   SqlConfig
+	// JournalConfig makes the memory store durable when its Dir is set
+	JournalConfig JournalConfig
 }
 
+// TaskManager owns task persistence, every read and write of task state goes
+// through it.
 type TaskManager struct {
 	store TaskStore
 }
 
-func NewTaskManager(tmCfg TaskManagerConfig) *TaskManager {
-	var store TaskStore
-	var err error
-	if tmCfg.StoreType == "redis" {
-		store, err = NewRedisTaskStore(&tmCfg.RedisConfig)
-		if err != nil {
-			panic(err)
-		}
-	} else {
-		store = NewInMemStore()
+func NewTaskManager(tmCfg TaskManagerConfig) (*TaskManager, error) {
+	store, err := newTaskStore(tmCfg)
+	if err != nil {
+		return nil, err
 	}
 	return &TaskManager{
 		store: store,
+	}, nil
+}
+
+func newTaskStore(tmCfg TaskManagerConfig) (TaskStore, error) {
+	switch tmCfg.StoreType {
+	case TaskStoreTypeRedis:
+		return NewRedisTaskStore(&tmCfg.RedisConfig)
+	case TaskStoreTypeSql:
+		return NewSqlTaskStore(&tmCfg.SqlConfig)
+	case TaskStoreTypeMemory, "":
+		if len(tmCfg.JournalConfig.Dir) > 0 {
+			return NewJournalStore(tmCfg.JournalConfig)
+		}
+		return NewInMemStore(), nil
+	default:
+		return nil, fmt.Errorf("unsupported task store type: %s", tmCfg.StoreType)
 	}
 }
 
+// Close closes the task store, stores without connections or files have
+// nothing to close.
+func (tm *TaskManager) Close() error {
+	if closer, ok := tm.store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 func (tm *TaskManager) AddTask(task Task) error {
 	return tm.store.AddTask(task)
 }
@@ -33,6 +69,62 @@ func (tm *TaskManager) GetTask(id string) (Task, error) {
 	return tm.store.GetTask(id)
 }
 
+func (tm *TaskManager) GetTasks(ids []string) ([]Task, error) {
+	return tm.store.GetTasks(ids)
+}
+
 func (tm *TaskManager) DelTask(id string) error {
 	return tm.store.DelTask(id)
 }
+
+func (tm *TaskManager) UpdateTaskState(id string, state TaskState) error {
+	return tm.store.UpdateTaskState(id, string(state))
+}
+
+func (tm *TaskManager) SwapTaskState(id string, workerId WorkerId, from TaskState, to TaskState) error {
+	return tm.store.SwapTaskState(id, workerId, string(from), string(to))
+}
+
+func (tm *TaskManager) CountTasks() (map[TaskType]TaskCounts, error) {
+	return tm.store.CountTasks()
+}
+
+func (tm *TaskManager) ListQueuedTasks(deadlineBefore time.Time, limit int) ([]Task, error) {
+	return tm.store.ListQueuedTasks(deadlineBefore, limit)
+}
+
+func (tm *TaskManager) ListDispatchedTasks(dispatchedBefore time.Time, limit int) ([]Task, error) {
+	return tm.store.ListDispatchedTasks(dispatchedBefore, limit)
+}
+
+func (tm *TaskManager) ListBatchTaskIds(batchId string) ([]string, error) {
+	return tm.store.ListBatchTaskIds(batchId)
+}
+
+func (tm *TaskManager) FinishBatch(batchId string, finishedAt time.Time) error {
+	return tm.store.FinishBatch(batchId, finishedAt)
+}
+
+func (tm *TaskManager) ListFinishedBatches(finishedBefore time.Time, limit int) ([]string, error) {
+	return tm.store.ListFinishedBatches(finishedBefore, limit)
+}
+
+func (tm *TaskManager) DelBatch(batchId string) error {
+	return tm.store.DelBatch(batchId)
+}
+
+func (tm *TaskManager) UpdateTaskWorker(id string, workerId WorkerId, workerTaskId string) error {
+	return tm.store.UpdateTaskWorker(id, workerId, workerTaskId)
+}
+
+func (tm *TaskManager) AddTaskError(id string, taskErr TaskError) error {
+	return tm.store.AddTaskError(id, taskErr)
+}
+
+func (tm *TaskManager) ResetTaskAttempts(id string) error {
+	return tm.store.ResetTaskAttempts(id)
+}
+
+func (tm *TaskManager) ListTasks(filter TaskFilter) ([]Task, error) {
+	return tm.store.ListTasks(filter)
+}
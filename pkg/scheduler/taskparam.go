@@ -1,7 +1,355 @@
 package scheduler
 
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-playground/validator/v10"
+)
+
 // pdf
 type PdfPerPageSplitterParam struct {
-	FileId       string `json:"file_id"`
-	PagesPerFile int    `json:"pages_per_file"`
+	FileId       string `json:"file_id" validate:"required"`
+	PagesPerFile int    `json:"pages_per_file" validate:"required,min=1"`
+}
+
+type Pdf2ImgParam struct {
+	FileId string `json:"file_id" validate:"required"`
+	Dpi    int    `json:"dpi" validate:"omitempty,min=72,max=600"`
+	Format string `json:"format" validate:"omitempty,oneof=png jpeg"`
+}
+
+type Pdf2CsvParam struct {
+	FileId string `json:"file_id" validate:"required"`
+}
+
+var ErrInvalidTaskParams = errors.New("task params are invalid")
+
+// ParamError is a parameter that failed validation, Field is its json path.
+type ParamError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ParamValidationError lists every invalid parameter of a task.
+type ParamValidationError struct {
+	SubType SubTaskType
+	Errors  []ParamError
+}
+
+func (e *ParamValidationError) Error() string {
+	fields := make([]string, len(e.Errors))
+	for i, paramErr := range e.Errors {
+		fields[i] = paramErr.Field + " " + paramErr.Message
+	}
+	return fmt.Sprintf("%s: %s params: %s", ErrInvalidTaskParams, e.SubType, strings.Join(fields, ", "))
+}
+
+func (e *ParamValidationError) Unwrap() error {
+	return ErrInvalidTaskParams
+}
+
+// ParamField declares a parameter of a sub type, Type is a json type: string,
+// integer, number, boolean, array or object. Validate holds validator tags
+// like required,min=1.
+type ParamField struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Validate    string `json:"validate,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+var paramTypes = map[string]bool{
+	"string": true, "integer": true, "number": true, "boolean": true, "array": true, "object": true,
+}
+
+var (
+	paramMu sync.RWMutex
+	// params of a sub type are declared either as struct or as fields
+	paramFields = map[SubTaskType][]ParamField{}
+	paramSchema = map[SubTaskType]reflect.Type{
+		SubTaskTypePdf2Img:     reflect.TypeOf(Pdf2ImgParam{}),
+		SubTaskTypePdf2Csv:     reflect.TypeOf(Pdf2CsvParam{}),
+		SubTaskTypePdfSplitter: reflect.TypeOf(PdfPerPageSplitterParam{}),
+	}
+	paramValidator = newParamValidator()
+)
+
+func newParamValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	// report the json names, they are what clients sent
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}
+
+// RegisterParams sets the params struct of a sub type, params is a struct
+// value with json and validate tags. Sub types without a struct pass their
+// params to workers unchecked.
+func RegisterParams(subType SubTaskType, params interface{}) {
+	t := reflect.TypeOf(params)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("params of %s must be a struct, got %s", subType, t))
+	}
+
+	paramMu.Lock()
+	defer paramMu.Unlock()
+	paramSchema[subType] = t
+	delete(paramFields, subType)
+}
+
+func registerParamFields(subType SubTaskType, fields []ParamField) {
+	paramMu.Lock()
+	defer paramMu.Unlock()
+	paramFields[subType] = fields
+	delete(paramSchema, subType)
+}
+
+// checkParamFields rejects unknown types and validate tags, the validator
+// panics on tags it does not know or cannot apply to the type.
+func checkParamFields(fields []ParamField) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("invalid validate tag: %v", p)
+		}
+	}()
+	names := map[string]bool{}
+	for _, field := range fields {
+		if len(field.Name) == 0 || names[field.Name] {
+			return fmt.Errorf("param name %q is empty or duplicated", field.Name)
+		}
+		names[field.Name] = true
+		if !paramTypes[field.Type] {
+			return fmt.Errorf("param %s has unknown type %q", field.Name, field.Type)
+		}
+		zero, _ := paramValue(field.Type, nil)
+		_ = paramValidator.Var(zero, field.Validate)
+	}
+	return nil
+}
+
+// ParamFields describes the params of a sub type, struct params are described
+// by their json names, kinds and validate tags.
+func ParamFields(subType SubTaskType) []ParamField {
+	paramMu.RLock()
+	defer paramMu.RUnlock()
+	if fields, ok := paramFields[subType]; ok {
+		return append([]ParamField(nil), fields...)
+	}
+	t, ok := paramSchema[subType]
+	if !ok {
+		return nil
+	}
+
+	fields := make([]ParamField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		name, _, _ := strings.Cut(structField.Tag.Get("json"), ",")
+		if !structField.IsExported() || name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = structField.Name
+		}
+		fields = append(fields, ParamField{
+			Name:     name,
+			Type:     jsonType(structField.Type.Kind()),
+			Validate: structField.Tag.Get("validate"),
+		})
+	}
+	return fields
+}
+
+func jsonType(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// ValidateParams checks params against the struct or the declared fields of
+// the sub type. Unknown
+// fields, values of the wrong type and failed validate tags are returned as a
+// *ParamValidationError.
+func ValidateParams(subType SubTaskType, params interface{}) error {
+	paramMu.RLock()
+	t, ok := paramSchema[subType]
+	fields, declared := paramFields[subType]
+	paramMu.RUnlock()
+	if !ok && !declared {
+		return nil
+	}
+
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	if declared {
+		return validateParamFields(subType, fields, data)
+	}
+
+	v := reflect.New(t)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v.Interface()); err != nil {
+		return &ParamValidationError{SubType: subType, Errors: []ParamError{decodeParamError(err)}}
+	}
+
+	err = paramValidator.Struct(v.Interface())
+	var fieldErrs validator.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		return err
+	}
+	paramErrs := make([]ParamError, len(fieldErrs))
+	for i, fieldErr := range fieldErrs {
+		// the namespace starts with the struct name
+		_, field, _ := strings.Cut(fieldErr.Namespace(), ".")
+		paramErrs[i] = ParamError{Field: field, Message: paramErrorMessage(fieldErr)}
+	}
+	return &ParamValidationError{SubType: subType, Errors: paramErrs}
+}
+
+func validateParamFields(subType SubTaskType, fields []ParamField, data []byte) error {
+	var params map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&params); err != nil {
+		return &ParamValidationError{SubType: subType, Errors: []ParamError{{Message: "must be an object"}}}
+	}
+
+	var paramErrs []ParamError
+	known := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		known[field.Name] = true
+		value, ok := paramValue(field.Type, params[field.Name])
+		if !ok {
+			paramErrs = append(paramErrs, ParamError{Field: field.Name, Message: "must be " + field.Type})
+			continue
+		}
+		err := paramValidator.Var(value, field.Validate)
+		var fieldErrs validator.ValidationErrors
+		if errors.As(err, &fieldErrs) {
+			paramErrs = append(paramErrs, ParamError{Field: field.Name, Message: paramErrorMessage(fieldErrs[0])})
+		}
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		if !known[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		paramErrs = append(paramErrs, ParamError{Field: name, Message: "is unknown"})
+	}
+
+	if len(paramErrs) == 0 {
+		return nil
+	}
+	return &ParamValidationError{SubType: subType, Errors: paramErrs}
+}
+
+// paramValue converts a decoded value for the validator, a missing value is
+// the zero value of the type so only required rejects it.
+func paramValue(paramType string, value interface{}) (interface{}, bool) {
+	switch paramType {
+	case "string":
+		if value == nil {
+			return "", true
+		}
+		v, ok := value.(string)
+		return v, ok
+	case "integer":
+		if value == nil {
+			return int64(0), true
+		}
+		n, ok := value.(json.Number)
+		if !ok {
+			return nil, false
+		}
+		v, err := n.Int64()
+		return v, err == nil
+	case "number":
+		if value == nil {
+			return float64(0), true
+		}
+		n, ok := value.(json.Number)
+		if !ok {
+			return nil, false
+		}
+		v, err := n.Float64()
+		return v, err == nil
+	case "boolean":
+		if value == nil {
+			return false, true
+		}
+		v, ok := value.(bool)
+		return v, ok
+	case "array":
+		if value == nil {
+			return []interface{}(nil), true
+		}
+		v, ok := value.([]interface{})
+		return v, ok
+	default:
+		if value == nil {
+			return map[string]interface{}(nil), true
+		}
+		v, ok := value.(map[string]interface{})
+		return v, ok
+	}
+}
+
+func decodeParamError(err error) ParamError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return ParamError{Field: typeErr.Field, Message: "must be " + typeErr.Type.Kind().String()}
+	}
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return ParamError{Field: strings.Trim(field, `"`), Message: "is unknown"}
+	}
+	return ParamError{Message: err.Error()}
+}
+
+func paramErrorMessage(fieldErr validator.FieldError) string {
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "min":
+		return "must be at least " + fieldErr.Param()
+	case "max":
+		return "must be at most " + fieldErr.Param()
+	case "oneof":
+		return "must be one of " + fieldErr.Param()
+	default:
+		return "failed on " + fieldErr.Tag()
+	}
 }
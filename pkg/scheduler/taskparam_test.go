@@ -0,0 +1,52 @@
+package scheduler_test
+
+import (
+	"go-web/pkg/scheduler"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func paramErrors(t *testing.T, err error) []scheduler.ParamError {
+	var paramErr *scheduler.ParamValidationError
+	if !assert.ErrorAs(t, err, &paramErr) {
+		return nil
+	}
+	assert.ErrorIs(t, err, scheduler.ErrInvalidTaskParams)
+	return paramErr.Errors
+}
+
+func TestValidateParams_ShouldPass_WhenParamsMatchSchema(t *testing.T) {
+	err := scheduler.ValidateParams(scheduler.SubTaskTypePdfSplitter, map[string]interface{}{"file_id": "f1", "pages_per_file": 2})
+	assert.NoError(t, err)
+}
+
+func TestValidateParams_ShouldReturnFieldErrors_WhenTagsFail(t *testing.T) {
+	err := scheduler.ValidateParams(scheduler.SubTaskTypePdfSplitter, map[string]interface{}{"pages_per_file": -1})
+	assert.ElementsMatch(t, []scheduler.ParamError{
+		{Field: "file_id", Message: "is required"},
+		{Field: "pages_per_file", Message: "must be at least 1"},
+	}, paramErrors(t, err))
+
+	err = scheduler.ValidateParams(scheduler.SubTaskTypePdf2Img, nil)
+	assert.Equal(t, []scheduler.ParamError{{Field: "file_id", Message: "is required"}}, paramErrors(t, err))
+}
+
+func TestValidateParams_ShouldRejectParams_WhenFieldUnknownOrMistyped(t *testing.T) {
+	err := scheduler.ValidateParams(scheduler.SubTaskTypePdf2Img, map[string]interface{}{"file_id": "f1", "dpl": 300})
+	assert.Equal(t, []scheduler.ParamError{{Field: "dpl", Message: "is unknown"}}, paramErrors(t, err))
+
+	err = scheduler.ValidateParams(scheduler.SubTaskTypePdf2Img, map[string]interface{}{"file_id": "f1", "dpi": "high"})
+	assert.Equal(t, []scheduler.ParamError{{Field: "dpi", Message: "must be int"}}, paramErrors(t, err))
+}
+
+func TestValidateParams_ShouldPassAnything_WhenSubTypeHasNoSchema(t *testing.T) {
+	assert.NoError(t, scheduler.ValidateParams(scheduler.SubTaskTypePdfMerger, map[string]interface{}{"anything": true}))
+
+	type mergerParam struct {
+		FileIds []string `json:"file_ids" validate:"required,min=2,dive,required"`
+	}
+	scheduler.RegisterParams("pdfmerger-test", mergerParam{})
+	err := scheduler.ValidateParams("pdfmerger-test", map[string]interface{}{"file_ids": []string{"f1"}})
+	assert.Equal(t, []scheduler.ParamError{{Field: "file_ids", Message: "must be at least 2"}}, paramErrors(t, err))
+}
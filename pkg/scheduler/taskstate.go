@@ -1,3 +1,52 @@
 package scheduler
 
 // task状态
+// PENDING -> RUNNING -> DONE | FAILURE | CANCELLED. A worker may report the
+// final state before we have seen RUNNING. A failed task is retried (back to
+// PENDING) until it runs out of attempts and moves to DEAD_LETTER, where it
+// stays until an admin requeues it. DONE and CANCELLED never change again.
+var taskStateTransitions = map[TaskState][]TaskState{
+	TaskStateCreated:    {TaskStateRunning, TaskStateDone, TaskStateFailure, TaskStateCancelled, TaskStateDeadLetter},
+	TaskStateRunning:    {TaskStateDone, TaskStateFailure, TaskStateCancelled},
+	TaskStateFailure:    {TaskStateCreated, TaskStateDeadLetter},
+	TaskStateDeadLetter: {TaskStateCreated},
+}
+
+// CanTransition reports whether a task may move from one state to another.
+// Writing the current state again is allowed, so repeated reports are harmless.
+func CanTransition(from, to TaskState) bool {
+	if from == to {
+		return true
+	}
+	for _, next := range taskStateTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFinalState reports whether a task in this state has finished. A FAILURE
+// is final for the attempt, the scheduler decides whether the task is retried.
+func IsFinalState(state TaskState) bool {
+	return state == TaskStateDone || state == TaskStateFailure || state == TaskStateCancelled
+}
+
+// IsWorkerReportedState reports whether a worker may move a task to this
+// state, the other states are only set by the scheduler.
+func IsWorkerReportedState(state TaskState) bool {
+	return state == TaskStateRunning || IsFinalState(state)
+}
+
+// statesBefore returns the states a task may be in to move to the given state.
+func statesBefore(to TaskState) []TaskState {
+	states := []TaskState{to}
+	for from, nexts := range taskStateTransitions {
+		for _, next := range nexts {
+			if next == to {
+				states = append(states, from)
+			}
+		}
+	}
+	return states
+}
@@ -0,0 +1,33 @@
+package scheduler_test
+
+import (
+	"go-web/pkg/scheduler"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to scheduler.TaskState
+		expected bool
+	}{
+		{scheduler.TaskStateCreated, scheduler.TaskStateRunning, true},
+		{scheduler.TaskStateCreated, scheduler.TaskStateDone, true},
+		{scheduler.TaskStateRunning, scheduler.TaskStateFailure, true},
+		{scheduler.TaskStateRunning, scheduler.TaskStateRunning, true},
+		{scheduler.TaskStateRunning, scheduler.TaskStateCreated, false},
+		{scheduler.TaskStateDone, scheduler.TaskStateRunning, false},
+		{scheduler.TaskStateCancelled, scheduler.TaskStateDone, false},
+		{scheduler.TaskStateCreated, "UNKNOWN", false},
+		{scheduler.TaskStateFailure, scheduler.TaskStateCreated, true},
+		{scheduler.TaskStateFailure, scheduler.TaskStateDeadLetter, true},
+		{scheduler.TaskStateFailure, scheduler.TaskStateRunning, false},
+		{scheduler.TaskStateRunning, scheduler.TaskStateDeadLetter, false},
+		{scheduler.TaskStateDeadLetter, scheduler.TaskStateCreated, true},
+		{scheduler.TaskStateDeadLetter, scheduler.TaskStateDone, false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, scheduler.CanTransition(test.from, test.to), "%s -> %s", test.from, test.to)
+	}
+}
@@ -1,200 +1,408 @@
 package scheduler
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
-
-	"github.com/redis/go-redis/v9"
 )
 
+// TaskFilter selects the tasks ListTasks returns.
+type TaskFilter struct {
+	// State selects tasks in a state, empty selects every state
+	State TaskState
+	// BatchId selects the tasks of a batch
+	BatchId string
+	// Limit caps the number of tasks, 0 returns all of them
+	Limit int
+}
+
+// TaskCounts are the unfinished tasks of a type.
+type TaskCounts struct {
+	// Queued tasks wait for a worker
+	Queued int
+	// Dispatched tasks were handed to a worker and did not finish yet
+	Dispatched int
+}
+
+// taskStage is where an unfinished task is, the stores index tasks by it.
+type taskStage int
+
 const (
-	RedisTaskKey = "ktools:task"
+	taskStageNone taskStage = iota
+	taskStageQueued
+	taskStageDispatched
 )
 
+func stageOf(state TaskState, workerId WorkerId) taskStage {
+	switch {
+	case state == TaskStateCreated && len(workerId) == 0:
+		return taskStageQueued
+	case (state == TaskStateCreated || state == TaskStateRunning) && len(workerId) > 0:
+		return taskStageDispatched
+	}
+	return taskStageNone
+}
+
+func (f TaskFilter) match(state TaskState, batchId string) bool {
+	return (len(f.State) == 0 || state == f.State) && (len(f.BatchId) == 0 || batchId == f.BatchId)
+}
+
 type TaskStore interface {
 	AddTask(task Task) error
 	GetTask(id string) (Task, error)
+	// GetTasks returns the tasks that exist among ids, missing ones are skipped.
+	GetTasks(ids []string) ([]Task, error)
 	DelTask(id string) error
+	// UpdateTaskState moves a task to state, failing with
+	// ErrInvalidStateTransition when CanTransition does not allow it. The
+	// check and the write happen atomically.
 	UpdateTaskState(taskId string, state string) error
+	// SwapTaskState moves a task from state from to state to, only while it
+	// is still in from and run by workerId. It fails with ErrTaskStateChanged
+	// otherwise, so of two callers seeing the same state only one moves the
+	// task on.
+	SwapTaskState(taskId string, workerId WorkerId, from string, to string) error
+	// UpdateTaskWorker records which worker runs the task and the id the
+	// worker assigned to it. It sets the dispatch time of the task to now, or
+	// clears it when workerId is empty.
+	UpdateTaskWorker(taskId string, workerId WorkerId, workerTaskId string) error
+	// AddTaskError appends a failed attempt to the error history of the task
+	// and counts it as an attempt.
+	AddTaskError(taskId string, taskErr TaskError) error
+	// ResetTaskAttempts starts counting attempts from zero again, the error
+	// history is kept.
+	ResetTaskAttempts(taskId string) error
+	// ListTasks returns the tasks matching filter, oldest first.
+	ListTasks(filter TaskFilter) ([]Task, error)
+	// CountTasks counts the queued and dispatched tasks per type. The stores
+	// index them on every change, no task is read.
+	CountTasks() (map[TaskType]TaskCounts, error)
+	// ListQueuedTasks returns the tasks waiting for a worker whose queue
+	// deadline is before deadlineBefore, earliest deadline first. limit 0
+	// returns all of them.
+	ListQueuedTasks(deadlineBefore time.Time, limit int) ([]Task, error)
+	// ListDispatchedTasks returns the unfinished tasks dispatched to a worker
+	// before dispatchedBefore, earliest first. limit 0 returns all of them.
+	ListDispatchedTasks(dispatchedBefore time.Time, limit int) ([]Task, error)
+	// ListBatchTaskIds returns the ids of the tasks of a batch, oldest first.
+	// The stores index them when the task is added.
+	ListBatchTaskIds(batchId string) ([]string, error)
+	// FinishBatch records when the last task of a batch finished, a batch
+	// finishing again after a requeue moves its time.
+	FinishBatch(batchId string, finishedAt time.Time) error
+	// ListFinishedBatches returns the batches that finished before
+	// finishedBefore, earliest first. limit 0 returns all of them.
+	ListFinishedBatches(finishedBefore time.Time, limit int) ([]string, error)
+	// DelBatch deletes the tasks of a batch and its index.
+	DelBatch(batchId string) error
 }
 
+// InMemStore keeps tasks in process memory. It is safe for concurrent use and
+// hands out copies, so callers cannot change stored tasks behind its back.
 type InMemStore struct {
+	mu    sync.RWMutex
 	tasks map[string]Task
-}
-
-type RedisTaskStore struct {
-	client redis.UniversalClient
+	// stages indexes the unfinished tasks by id
+	stages map[string]Task
+	// batches indexes the task ids of a batch
+	batches map[string][]string
+	// finished holds the finish time of the batches whose tasks all ended
+	finished map[string]time.Time
 }
 
 func NewInMemStore() *InMemStore {
 	return &InMemStore{
-		tasks: make(map[string]Task),
+		tasks:    make(map[string]Task),
+		stages:   make(map[string]Task),
+		batches:  make(map[string][]string),
+		finished: make(map[string]time.Time),
 	}
 }
 
 func (s *InMemStore) AddTask(task Task) error {
-	s.tasks[task.GetId()] = task
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := cloneTask(task)
+	if _, exists := s.tasks[task.GetId()]; !exists && len(task.GetBatchId()) > 0 {
+		s.batches[task.GetBatchId()] = append(s.batches[task.GetBatchId()], task.GetId())
+	}
+	s.tasks[task.GetId()] = stored
+	s.index(stored)
 	return nil
 }
 
+// index keeps task in the stage index while it is unfinished, s.mu must be
+// held.
+func (s *InMemStore) index(task Task) {
+	if stageOf(task.GetState(), task.GetWorkerId()) == taskStageNone {
+		delete(s.stages, task.GetId())
+		return
+	}
+	s.stages[task.GetId()] = task
+}
+
+// reindex rebuilds the stage and batch indexes after the tasks were
+// replaced.
+func (s *InMemStore) reindex() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stages = make(map[string]Task)
+	s.batches = make(map[string][]string)
+	for _, task := range s.tasks {
+		s.index(task)
+		if len(task.GetBatchId()) > 0 {
+			s.batches[task.GetBatchId()] = append(s.batches[task.GetBatchId()], task.GetId())
+		}
+	}
+}
+
 func (s *InMemStore) GetTask(id string) (Task, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if task, exists := s.tasks[id]; exists {
-		return task, nil
+		return cloneTask(task), nil
 	}
 
-	return nil, fmt.Errorf("task not found, task id: %s", id)
+	return nil, taskNotFound(id)
+}
+
+func (s *InMemStore) GetTasks(ids []string) ([]Task, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tasks := make([]Task, 0, len(ids))
+	for _, id := range ids {
+		if task, exists := s.tasks[id]; exists {
+			tasks = append(tasks, cloneTask(task))
+		}
+	}
+	return tasks, nil
 }
 
 func (s *InMemStore) DelTask(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	delete(s.tasks, id)
+	delete(s.stages, id)
 	return nil
 }
 
 func (s *InMemStore) UpdateTaskState(taskId string, state string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, exists := s.tasks[taskId]
+	if !exists {
+		return taskNotFound(taskId)
+	}
+	if !CanTransition(task.GetState(), TaskState(state)) {
+		return invalidTransition(taskId, task.GetState(), TaskState(state))
+	}
+
+	task.SetState(TaskState(state))
+	s.index(task)
+	return nil
+}
+
+func (s *InMemStore) SwapTaskState(taskId string, workerId WorkerId, from string, to string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, exists := s.tasks[taskId]
+	if !exists {
+		return taskNotFound(taskId)
+	}
+	if task.GetState() != TaskState(from) || task.GetWorkerId() != workerId {
+		return stateChanged(taskId, TaskState(from))
+	}
+	if !CanTransition(TaskState(from), TaskState(to)) {
+		return invalidTransition(taskId, TaskState(from), TaskState(to))
+	}
+
+	task.SetState(TaskState(to))
+	s.index(task)
+	return nil
+}
+
+func (s *InMemStore) UpdateTaskWorker(taskId string, workerId WorkerId, workerTaskId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, exists := s.tasks[taskId]
+	if !exists {
+		return taskNotFound(taskId)
+	}
+
+	task.SetWorkerId(workerId)
+	task.SetWorkerTaskId(workerTaskId)
+	task.SetDispatchedAt(dispatchTime(workerId))
+	s.index(task)
+	return nil
+}
+
+func (s *InMemStore) AddTaskError(taskId string, taskErr TaskError) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, exists := s.tasks[taskId]
+	if !exists {
+		return taskNotFound(taskId)
+	}
+
+	task.AddError(taskErr)
+	task.SetAttempts(task.GetAttempts() + 1)
 	return nil
 }
-func NewRedisTaskStore(redisCfg *RedisConfig) (*RedisTaskStore, error) {
-	var client redis.UniversalClient
-	if redisCfg.ClusterMode == RedisClusterModeStandalone {
-		client = redis.NewClient(&redis.Options{
-			Addr:     redisCfg.Addrs[0],
-			Password: redisCfg.Password,
-			DB:       redisCfg.DB,
-		})
-	} else if redisCfg.ClusterMode == RedisClusterModeCluster {
-		client = redis.NewClusterClient(&redis.ClusterOptions{
-			Addrs:    redisCfg.Addrs,
-			Password: redisCfg.Password,
-		})
-	} else if redisCfg.ClusterMode == RedisClusterModeSentinel {
-		client = redis.NewFailoverClient(&redis.FailoverOptions{
-			MasterName:    redisCfg.Addrs[0],
-			SentinelAddrs: redisCfg.Addrs[1:],
-			Password:      redisCfg.Password,
-			DB:            redisCfg.DB,
-		})
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	_, err := client.Ping(ctx).Result()
-	if err != nil {
-		return nil, err
-	}
-	return &RedisTaskStore{
-		client: client,
-	}, nil
-}
-
-type TaskRedisDto struct {
-	State     string      `json:"task_state"`
-	Type      string      `json:"type"`
-	SubType   string      `json:"sub_type"`
-	Priority  int         `json:"priority"` // not supported for now
-	WorkerId  string      `json:"worker_id"`
-	CreatedAt time.Time   `json:"created_at"`
-	UpdatedAt time.Time   `json:"updated_at"`
-	UserDef   interface{} `json:"user_def"`
-}
-
-func (s *RedisTaskStore) AddTask(task Task) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-
-	_, err := s.client.Ping(ctx).Result()
-	if err != nil {
-		return err
-	}
-
-	taskDto := &TaskRedisDto{
-		State:     string(task.GetState()),
-		Type:      string(task.GetType()),
-		SubType:   string(task.GetSubType()),
-		Priority:  int(task.GetPriority()),
-		WorkerId:  string(task.GetWorkerId()),
-		UserDef:   task.GetUserDef(),
-		CreatedAt: task.GetCreatedAt(),
-	}
-
-	pushed, err := s.client.HSet(ctx, RedisTaskKey, task.GetId(), taskDto).Result()
-	if err != nil || pushed == 0 {
-		return err
+
+func (s *InMemStore) ResetTaskAttempts(taskId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, exists := s.tasks[taskId]
+	if !exists {
+		return taskNotFound(taskId)
 	}
 
+	task.SetAttempts(0)
 	return nil
 }
 
-func (s *RedisTaskStore) GetTask(id string) (Task, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-	_, err := s.client.Ping(ctx).Result()
-	if err != nil {
-		return nil, err
+func (s *InMemStore) ListTasks(filter TaskFilter) ([]Task, error) {
+	s.mu.RLock()
+	tasks := make([]Task, 0)
+	for _, task := range s.tasks {
+		if filter.match(task.GetState(), task.GetBatchId()) {
+			tasks = append(tasks, cloneTask(task))
+		}
 	}
+	s.mu.RUnlock()
 
-	taskStr, err := s.client.HGet(ctx, RedisTaskKey, id).Result()
-	if err != nil {
-		return nil, err
+	sortTasksByCreation(tasks)
+	if filter.Limit > 0 && len(tasks) > filter.Limit {
+		tasks = tasks[:filter.Limit]
 	}
+	return tasks, nil
+}
 
-	taskRedisDto := &TaskRedisDto{}
-	err = json.Unmarshal([]byte(taskStr), taskRedisDto)
-	if err != nil {
-		return nil, err
+func (s *InMemStore) CountTasks() (map[TaskType]TaskCounts, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	counts := make(map[TaskType]TaskCounts)
+	for _, task := range s.stages {
+		count := counts[task.GetType()]
+		if stageOf(task.GetState(), task.GetWorkerId()) == taskStageQueued {
+			count.Queued++
+		} else {
+			count.Dispatched++
+		}
+		counts[task.GetType()] = count
 	}
+	return counts, nil
+}
+
+func (s *InMemStore) ListQueuedTasks(deadlineBefore time.Time, limit int) ([]Task, error) {
+	return s.listStage(taskStageQueued, func(task Task) time.Time { return task.GetDeadline() }, deadlineBefore, limit), nil
+}
 
-	taskBuilder := NewTaskBuilder()
-	task := taskBuilder.
-		SetWorkerTaskId(taskRedisDto.WorkerId).
-		SetState(TaskState(taskRedisDto.State)).
-		SetType(TaskType(taskRedisDto.Type)).
-		SetSubType(SubTaskType(taskRedisDto.SubType)).
-		SetPriority(TaskPriority(taskRedisDto.Priority)).
-		SetWorkerId(WorkerId(taskRedisDto.WorkerId)).
-		SetUserDef(taskRedisDto.UserDef).
-		Build()
+func (s *InMemStore) ListDispatchedTasks(dispatchedBefore time.Time, limit int) ([]Task, error) {
+	return s.listStage(taskStageDispatched, func(task Task) time.Time { return task.GetDispatchedAt() }, dispatchedBefore, limit), nil
+}
+
+// listStage returns the indexed tasks of stage whose time is set and before
+// before, earliest first.
+func (s *InMemStore) listStage(stage taskStage, at func(Task) time.Time, before time.Time, limit int) []Task {
+	s.mu.RLock()
+	tasks := make([]Task, 0)
+	for _, task := range s.stages {
+		if stageOf(task.GetState(), task.GetWorkerId()) != stage || at(task).IsZero() || !at(task).Before(before) {
+			continue
+		}
+		tasks = append(tasks, cloneTask(task))
+	}
+	s.mu.RUnlock()
 
-	return task, nil
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return at(tasks[i]).Before(at(tasks[j]))
+	})
+	if limit > 0 && len(tasks) > limit {
+		tasks = tasks[:limit]
+	}
+	return tasks
 }
 
-func (s *RedisTaskStore) DelTask(id string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-	_, err := s.client.Ping(ctx).Result()
-	if err != nil {
-		return err
+func (s *InMemStore) ListBatchTaskIds(batchId string) ([]string, error) {
+	s.mu.RLock()
+	tasks := make([]Task, 0, len(s.batches[batchId]))
+	for _, id := range s.batches[batchId] {
+		if task, exists := s.tasks[id]; exists {
+			tasks = append(tasks, task)
+		}
 	}
+	sortTasksByCreation(tasks)
+	s.mu.RUnlock()
 
-	_, err = s.client.HDel(ctx, RedisTaskKey, id).Result()
-	if err != nil {
-		return err
+	ids := make([]string, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.GetId()
 	}
+	return ids, nil
+}
 
+func (s *InMemStore) FinishBatch(batchId string, finishedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finished[batchId] = finishedAt
 	return nil
 }
 
-func (s *RedisTaskStore) UpdateTaskState(taskId string, state string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-	_, err := s.client.Ping(ctx).Result()
-	if err != nil {
-		return err
+func (s *InMemStore) ListFinishedBatches(finishedBefore time.Time, limit int) ([]string, error) {
+	s.mu.RLock()
+	batchIds := make([]string, 0)
+	for batchId, finishedAt := range s.finished {
+		if finishedAt.Before(finishedBefore) {
+			batchIds = append(batchIds, batchId)
+		}
+	}
+	sort.SliceStable(batchIds, func(i, j int) bool {
+		return s.finished[batchIds[i]].Before(s.finished[batchIds[j]])
+	})
+	s.mu.RUnlock()
+
+	if limit > 0 && len(batchIds) > limit {
+		batchIds = batchIds[:limit]
 	}
+	return batchIds, nil
+}
 
-	taskStr, err := s.client.HGet(ctx, RedisTaskKey, taskId).Result()
-	if err != nil {
-		return err
+func (s *InMemStore) DelBatch(batchId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range s.batches[batchId] {
+		delete(s.tasks, id)
+		delete(s.stages, id)
 	}
+	delete(s.batches, batchId)
+	delete(s.finished, batchId)
+	return nil
+}
 
-	taskRedisDto := &TaskRedisDto{}
-	err = json.Unmarshal([]byte(taskStr), taskRedisDto)
-	if err != nil {
-		return err
+// dispatchTime is the dispatch time UpdateTaskWorker records.
+func dispatchTime(workerId WorkerId) time.Time {
+	if len(workerId) == 0 {
+		return time.Time{}
 	}
+	return time.Now()
+}
+
+func sortTasksByCreation(tasks []Task) {
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return tasks[i].GetCreatedAt().Before(tasks[j].GetCreatedAt())
+	})
+}
+
+func taskNotFound(id string) error {
+	return fmt.Errorf("%w, task id: %s", ErrTaskNotFound, id)
+}
 
-	taskRedisDto.UpdatedAt = time.Now()
+func stateChanged(id string, from TaskState) error {
+	return fmt.Errorf("%w from %s, task id: %s", ErrTaskStateChanged, from, id)
+}
 
-	return s.client.HSet(ctx, RedisTaskKey, taskId, taskRedisDto).Err()
+func invalidTransition(id string, from, to TaskState) error {
+	return fmt.Errorf("%w from %s to %s, task id: %s", ErrInvalidStateTransition, from, to, id)
 }
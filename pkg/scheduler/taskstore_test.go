@@ -0,0 +1,496 @@
+package scheduler_test
+
+import (
+	"fmt"
+	"go-web/pkg/scheduler"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// testTaskStore is the conformance suite every TaskStore implementation runs.
+func testTaskStore(t *testing.T, newStore func(t *testing.T) scheduler.TaskStore) {
+	newTask := func() scheduler.Task {
+		return scheduler.NewTaskBuilder().
+			SetType(scheduler.TaskTypePdf).
+			SetSubType(scheduler.SubTaskTypePdf2Img).
+			SetPriority(scheduler.TaskPriorityHigh).
+			SetUserId("u1").
+			SetCreatedAt(time.Now().Truncate(time.Millisecond)).
+			SetDeadline(time.Now().Add(time.Hour).Truncate(time.Millisecond)).
+			SetUserDef(map[string]interface{}{"file_id": "f1"}).
+			SetTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01").
+			SetRequestId("r1").
+			SetBatchId("b1").
+			Build()
+	}
+
+	t.Run("GetTask_ShouldReturnTask_WhenTaskAdded", func(t *testing.T) {
+		store := newStore(t)
+		task := newTask()
+		assert.NoError(t, store.AddTask(task))
+
+		got, err := store.GetTask(task.GetId())
+		assert.NoError(t, err)
+		assert.Equal(t, task.GetId(), got.GetId())
+		assert.Equal(t, task.GetState(), got.GetState())
+		assert.Equal(t, task.GetType(), got.GetType())
+		assert.Equal(t, task.GetSubType(), got.GetSubType())
+		assert.Equal(t, task.GetPriority(), got.GetPriority())
+		assert.Equal(t, task.GetUserId(), got.GetUserId())
+		assert.True(t, task.GetCreatedAt().Equal(got.GetCreatedAt()))
+		assert.True(t, task.GetDeadline().Equal(got.GetDeadline()))
+		assert.True(t, got.GetDispatchedAt().IsZero())
+		assert.Equal(t, task.GetTraceParent(), got.GetTraceParent())
+		assert.Equal(t, task.GetRequestId(), got.GetRequestId())
+		assert.Equal(t, task.GetBatchId(), got.GetBatchId())
+		assert.Equal(t, task.GetUserDef(), got.GetUserDef())
+	})
+
+	t.Run("GetTask_ShouldReturnErrTaskNotFound_WhenTaskMissing", func(t *testing.T) {
+		store := newStore(t)
+		_, err := store.GetTask("missing")
+		assert.ErrorIs(t, err, scheduler.ErrTaskNotFound)
+	})
+
+	t.Run("GetTask_ShouldNotSeeCallerChanges_WhenTaskChangedAfterAdd", func(t *testing.T) {
+		store := newStore(t)
+		task := newTask()
+		assert.NoError(t, store.AddTask(task))
+
+		task.SetWorkerId("w1")
+		got, err := store.GetTask(task.GetId())
+		assert.NoError(t, err)
+		assert.Equal(t, scheduler.WorkerId(""), got.GetWorkerId())
+	})
+
+	t.Run("DelTask_ShouldRemoveTask_WhenTaskExists", func(t *testing.T) {
+		store := newStore(t)
+		task := newTask()
+		assert.NoError(t, store.AddTask(task))
+
+		assert.NoError(t, store.DelTask(task.GetId()))
+		_, err := store.GetTask(task.GetId())
+		assert.ErrorIs(t, err, scheduler.ErrTaskNotFound)
+	})
+
+	t.Run("UpdateTaskState_ShouldPersistState_WhenTaskExists", func(t *testing.T) {
+		store := newStore(t)
+		task := newTask()
+		assert.NoError(t, store.AddTask(task))
+
+		assert.NoError(t, store.UpdateTaskState(task.GetId(), scheduler.TaskStateRunning))
+		got, err := store.GetTask(task.GetId())
+		assert.NoError(t, err)
+		assert.Equal(t, scheduler.TaskState(scheduler.TaskStateRunning), got.GetState())
+	})
+
+	t.Run("UpdateTaskState_ShouldReturnErrTaskNotFound_WhenTaskMissing", func(t *testing.T) {
+		store := newStore(t)
+		err := store.UpdateTaskState("missing", scheduler.TaskStateRunning)
+		assert.ErrorIs(t, err, scheduler.ErrTaskNotFound)
+	})
+
+	t.Run("UpdateTaskState_ShouldReturnErrInvalidStateTransition_WhenTaskFinished", func(t *testing.T) {
+		store := newStore(t)
+		task := newTask()
+		assert.NoError(t, store.AddTask(task))
+		assert.NoError(t, store.UpdateTaskState(task.GetId(), scheduler.TaskStateDone))
+
+		err := store.UpdateTaskState(task.GetId(), scheduler.TaskStateRunning)
+		assert.ErrorIs(t, err, scheduler.ErrInvalidStateTransition)
+		assert.NoError(t, store.UpdateTaskState(task.GetId(), scheduler.TaskStateDone))
+		got, err := store.GetTask(task.GetId())
+		assert.NoError(t, err)
+		assert.Equal(t, scheduler.TaskState(scheduler.TaskStateDone), got.GetState())
+	})
+
+	t.Run("UpdateTaskState_ShouldKeepFinalState_WhenUpdatedConcurrently", func(t *testing.T) {
+		store := newStore(t)
+		task := newTask()
+		assert.NoError(t, store.AddTask(task))
+		assert.NoError(t, store.UpdateTaskState(task.GetId(), scheduler.TaskStateRunning))
+
+		// a worker callback reports DONE while the poller still sees RUNNING
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				state := scheduler.TaskStateRunning
+				if i%2 == 0 {
+					state = scheduler.TaskStateDone
+				}
+				err := store.UpdateTaskState(task.GetId(), state)
+				if err != nil {
+					assert.ErrorIs(t, err, scheduler.ErrInvalidStateTransition)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		got, err := store.GetTask(task.GetId())
+		assert.NoError(t, err)
+		assert.Equal(t, scheduler.TaskState(scheduler.TaskStateDone), got.GetState())
+	})
+
+	t.Run("SwapTaskState_ShouldMoveTaskOnce_WhenSwappedConcurrently", func(t *testing.T) {
+		store := newStore(t)
+		task := newTask()
+		assert.NoError(t, store.AddTask(task))
+		assert.NoError(t, store.UpdateTaskWorker(task.GetId(), "w1", "wt1"))
+		assert.NoError(t, store.UpdateTaskState(task.GetId(), scheduler.TaskStateRunning))
+
+		// a worker callback and a poll both see the task fail
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		swapped := 0
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := store.SwapTaskState(task.GetId(), "w1", scheduler.TaskStateRunning, scheduler.TaskStateFailure)
+				if err != nil {
+					assert.ErrorIs(t, err, scheduler.ErrTaskStateChanged)
+					return
+				}
+				mu.Lock()
+				swapped++
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 1, swapped)
+
+		err := store.SwapTaskState(task.GetId(), "w2", scheduler.TaskStateFailure, scheduler.TaskStateCreated)
+		assert.ErrorIs(t, err, scheduler.ErrTaskStateChanged)
+		err = store.SwapTaskState(task.GetId(), "w1", scheduler.TaskStateFailure, scheduler.TaskStateDone)
+		assert.ErrorIs(t, err, scheduler.ErrInvalidStateTransition)
+		err = store.SwapTaskState("missing", "w1", scheduler.TaskStateFailure, scheduler.TaskStateCreated)
+		assert.ErrorIs(t, err, scheduler.ErrTaskNotFound)
+	})
+
+	t.Run("CountTasks_ShouldCountQueuedAndDispatched_WhenTasksChange", func(t *testing.T) {
+		store := newStore(t)
+		queued, dispatched, running, done, deleted := newTask(), newTask(), newTask(), newTask(), newTask()
+		for _, task := range []scheduler.Task{queued, dispatched, running, done, deleted} {
+			assert.NoError(t, store.AddTask(task))
+		}
+		assert.NoError(t, store.UpdateTaskWorker(dispatched.GetId(), "w1", "wt1"))
+		assert.NoError(t, store.UpdateTaskWorker(running.GetId(), "w1", "wt2"))
+		assert.NoError(t, store.UpdateTaskState(running.GetId(), scheduler.TaskStateRunning))
+		assert.NoError(t, store.UpdateTaskState(done.GetId(), scheduler.TaskStateDone))
+		assert.NoError(t, store.DelTask(deleted.GetId()))
+
+		counts, err := store.CountTasks()
+		assert.NoError(t, err)
+		assert.Equal(t, map[scheduler.TaskType]scheduler.TaskCounts{
+			scheduler.TaskTypePdf: {Queued: 1, Dispatched: 2},
+		}, counts)
+
+		// a failed attempt goes back to the queue
+		assert.NoError(t, store.SwapTaskState(running.GetId(), "w1", scheduler.TaskStateRunning, scheduler.TaskStateFailure))
+		assert.NoError(t, store.UpdateTaskState(running.GetId(), scheduler.TaskStateCreated))
+		assert.NoError(t, store.UpdateTaskWorker(running.GetId(), "", ""))
+		counts, err = store.CountTasks()
+		assert.NoError(t, err)
+		assert.Equal(t, scheduler.TaskCounts{Queued: 2, Dispatched: 1}, counts[scheduler.TaskTypePdf])
+	})
+
+	t.Run("CountTasks_ShouldKeepIndexes_WhenTaskIdsLookLikeIndexKeys", func(t *testing.T) {
+		store := newStore(t)
+		for _, id := range []string{"index", "queued:pdf", "dispatched:pdf"} {
+			task := newTask()
+			assert.NoError(t, store.AddTask(scheduler.NewTaskBuilder().
+				SetId(id).
+				SetType(task.GetType()).
+				SetSubType(task.GetSubType()).
+				SetCreatedAt(task.GetCreatedAt()).
+				Build()))
+		}
+		assert.NoError(t, store.UpdateTaskWorker("index", "w1", "wt1"))
+		assert.NoError(t, store.DelTask("queued:pdf"))
+
+		counts, err := store.CountTasks()
+		assert.NoError(t, err)
+		assert.Equal(t, scheduler.TaskCounts{Queued: 1, Dispatched: 1}, counts[scheduler.TaskTypePdf])
+		tasks, err := store.ListTasks(scheduler.TaskFilter{})
+		assert.NoError(t, err)
+		assert.Len(t, tasks, 2)
+	})
+
+	t.Run("ListQueuedTasks_ShouldReturnExpiredTasks_WhenDeadlinePassed", func(t *testing.T) {
+		store := newStore(t)
+		withDeadline := func(deadline time.Time) scheduler.Task {
+			return scheduler.NewTaskBuilder().
+				SetType(scheduler.TaskTypePdf).
+				SetSubType(scheduler.SubTaskTypePdf2Img).
+				SetCreatedAt(time.Now().Truncate(time.Millisecond)).
+				SetDeadline(deadline.Truncate(time.Millisecond)).
+				Build()
+		}
+		later, earlier := withDeadline(time.Now().Add(-time.Minute)), withDeadline(time.Now().Add(-time.Hour))
+		noDeadline, dispatched := withDeadline(time.Time{}), withDeadline(time.Now().Add(-time.Hour))
+		for _, task := range []scheduler.Task{later, earlier, noDeadline, dispatched, newTask()} {
+			assert.NoError(t, store.AddTask(task))
+		}
+		assert.NoError(t, store.UpdateTaskWorker(dispatched.GetId(), "w1", "wt1"))
+
+		tasks, err := store.ListQueuedTasks(time.Now(), 0)
+		assert.NoError(t, err)
+		if assert.Len(t, tasks, 2) {
+			assert.Equal(t, earlier.GetId(), tasks[0].GetId())
+			assert.Equal(t, later.GetId(), tasks[1].GetId())
+		}
+		tasks, err = store.ListQueuedTasks(time.Now(), 1)
+		assert.NoError(t, err)
+		assert.Len(t, tasks, 1)
+	})
+
+	t.Run("ListDispatchedTasks_ShouldReturnUnfinishedTasks_WhenDispatchedBefore", func(t *testing.T) {
+		store := newStore(t)
+		running, done, queued := newTask(), newTask(), newTask()
+		for _, task := range []scheduler.Task{running, done, queued} {
+			assert.NoError(t, store.AddTask(task))
+		}
+		assert.NoError(t, store.UpdateTaskWorker(running.GetId(), "w1", "wt1"))
+		assert.NoError(t, store.UpdateTaskState(running.GetId(), scheduler.TaskStateRunning))
+		assert.NoError(t, store.UpdateTaskWorker(done.GetId(), "w1", "wt2"))
+		assert.NoError(t, store.UpdateTaskState(done.GetId(), scheduler.TaskStateDone))
+
+		tasks, err := store.ListDispatchedTasks(time.Now().Add(time.Second), 0)
+		assert.NoError(t, err)
+		if assert.Len(t, tasks, 1) {
+			assert.Equal(t, running.GetId(), tasks[0].GetId())
+		}
+		tasks, err = store.ListDispatchedTasks(time.Now().Add(-time.Minute), 0)
+		assert.NoError(t, err)
+		assert.Empty(t, tasks)
+	})
+
+	t.Run("GetTasks_ShouldReturnExistingTasksInOrder_WhenSomeMissing", func(t *testing.T) {
+		store := newStore(t)
+		first, second := newTask(), newTask()
+		assert.NoError(t, store.AddTask(first))
+		assert.NoError(t, store.AddTask(second))
+
+		tasks, err := store.GetTasks([]string{second.GetId(), "missing", first.GetId()})
+		assert.NoError(t, err)
+		if assert.Len(t, tasks, 2) {
+			assert.Equal(t, second.GetId(), tasks[0].GetId())
+			assert.Equal(t, first.GetId(), tasks[1].GetId())
+		}
+	})
+
+	t.Run("UpdateTaskWorker_ShouldPersistWorker_WhenTaskExists", func(t *testing.T) {
+		store := newStore(t)
+		task := newTask()
+		assert.NoError(t, store.AddTask(task))
+
+		assert.NoError(t, store.UpdateTaskWorker(task.GetId(), "w1", "wt1"))
+		got, err := store.GetTask(task.GetId())
+		assert.NoError(t, err)
+		assert.Equal(t, scheduler.WorkerId("w1"), got.GetWorkerId())
+		assert.Equal(t, "wt1", got.GetWorkerTaskId())
+		assert.WithinDuration(t, time.Now(), got.GetDispatchedAt(), time.Minute)
+
+		assert.NoError(t, store.UpdateTaskWorker(task.GetId(), "", ""))
+		got, err = store.GetTask(task.GetId())
+		assert.NoError(t, err)
+		assert.True(t, got.GetDispatchedAt().IsZero())
+	})
+
+	t.Run("AddTaskError_ShouldRecordHistoryAndAttempts_WhenTaskFails", func(t *testing.T) {
+		store := newStore(t)
+		task := newTask()
+		assert.NoError(t, store.AddTask(task))
+
+		now := time.Now().Truncate(time.Millisecond)
+		assert.NoError(t, store.AddTaskError(task.GetId(), scheduler.TaskError{WorkerId: "w1", Message: "bad pdf", Time: now}))
+		assert.NoError(t, store.AddTaskError(task.GetId(), scheduler.TaskError{WorkerId: "w2", Message: "timeout", Time: now}))
+		assert.NoError(t, store.ResetTaskAttempts(task.GetId()))
+		assert.NoError(t, store.AddTaskError(task.GetId(), scheduler.TaskError{WorkerId: "w1", Message: "bad pdf", Time: now}))
+
+		got, err := store.GetTask(task.GetId())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, got.GetAttempts())
+		if assert.Len(t, got.GetErrors(), 3) {
+			assert.Equal(t, scheduler.WorkerId("w2"), got.GetErrors()[1].WorkerId)
+			assert.Equal(t, "timeout", got.GetErrors()[1].Message)
+			assert.True(t, now.Equal(got.GetErrors()[1].Time))
+		}
+		assert.ErrorIs(t, store.AddTaskError("missing", scheduler.TaskError{}), scheduler.ErrTaskNotFound)
+		assert.ErrorIs(t, store.ResetTaskAttempts("missing"), scheduler.ErrTaskNotFound)
+	})
+
+	t.Run("ListTasks_ShouldReturnTasksInStateOldestFirst_WhenFiltered", func(t *testing.T) {
+		store := newStore(t)
+		created := time.Now().Truncate(time.Millisecond)
+		ids := make([]string, 0)
+		for i := 0; i < 3; i++ {
+			task := scheduler.NewTaskBuilder().
+				SetType(scheduler.TaskTypePdf).
+				SetSubType(scheduler.SubTaskTypePdf2Img).
+				SetCreatedAt(created.Add(time.Duration(2-i) * time.Second)).
+				Build()
+			assert.NoError(t, store.AddTask(task))
+			assert.NoError(t, store.UpdateTaskState(task.GetId(), scheduler.TaskStateDeadLetter))
+			ids = append(ids, task.GetId())
+		}
+		assert.NoError(t, store.AddTask(newTask()))
+
+		tasks, err := store.ListTasks(scheduler.TaskFilter{State: scheduler.TaskStateDeadLetter})
+		assert.NoError(t, err)
+		if assert.Len(t, tasks, 3) {
+			assert.Equal(t, ids[2], tasks[0].GetId())
+			assert.Equal(t, ids[0], tasks[2].GetId())
+		}
+
+		tasks, err = store.ListTasks(scheduler.TaskFilter{State: scheduler.TaskStateDeadLetter, Limit: 2})
+		assert.NoError(t, err)
+		assert.Len(t, tasks, 2)
+	})
+
+	t.Run("ListTasks_ShouldReturnBatchInAnyState_WhenFilteredByBatch", func(t *testing.T) {
+		store := newStore(t)
+		first := newTask()
+		second := newTask()
+		assert.NoError(t, store.AddTask(first))
+		assert.NoError(t, store.AddTask(second))
+		assert.NoError(t, store.UpdateTaskState(second.GetId(), scheduler.TaskStateDone))
+		assert.NoError(t, store.AddTask(scheduler.NewTaskBuilder().
+			SetType(scheduler.TaskTypePdf).
+			SetSubType(scheduler.SubTaskTypePdf2Img).
+			SetBatchId("b2").
+			Build()))
+
+		tasks, err := store.ListTasks(scheduler.TaskFilter{BatchId: "b1"})
+		assert.NoError(t, err)
+		assert.Len(t, tasks, 2)
+	})
+
+	t.Run("ListBatchTaskIds_ShouldReturnBatchOldestFirst_WhenTasksAdded", func(t *testing.T) {
+		store := newStore(t)
+		withCreatedAt := func(batchId string, createdAt time.Time) scheduler.Task {
+			return scheduler.NewTaskBuilder().
+				SetType(scheduler.TaskTypePdf).
+				SetSubType(scheduler.SubTaskTypePdf2Img).
+				SetCreatedAt(createdAt.Truncate(time.Millisecond)).
+				SetBatchId(batchId).
+				Build()
+		}
+		later, earlier := withCreatedAt("b1", time.Now()), withCreatedAt("b1", time.Now().Add(-time.Minute))
+		for _, task := range []scheduler.Task{later, earlier, withCreatedAt("b2", time.Now())} {
+			assert.NoError(t, store.AddTask(task))
+		}
+
+		ids, err := store.ListBatchTaskIds("b1")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{earlier.GetId(), later.GetId()}, ids)
+	})
+
+	t.Run("DelBatch_ShouldDeleteTasksAndFinishedBatch_WhenBatchFinished", func(t *testing.T) {
+		store := newStore(t)
+		first, second := newTask(), newTask()
+		assert.NoError(t, store.AddTask(first))
+		assert.NoError(t, store.AddTask(second))
+		finishedAt := time.Now().Add(-time.Hour)
+		assert.NoError(t, store.FinishBatch("b1", finishedAt))
+		assert.NoError(t, store.FinishBatch("b2", time.Now()))
+
+		batchIds, err := store.ListFinishedBatches(time.Now().Add(-time.Minute), 0)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"b1"}, batchIds)
+
+		assert.NoError(t, store.DelBatch("b1"))
+		_, err = store.GetTask(first.GetId())
+		assert.ErrorIs(t, err, scheduler.ErrTaskNotFound)
+		ids, err := store.ListBatchTaskIds("b1")
+		assert.NoError(t, err)
+		assert.Empty(t, ids)
+		batchIds, err = store.ListFinishedBatches(time.Now().Add(time.Minute), 0)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"b2"}, batchIds)
+	})
+
+	t.Run("TaskStore_ShouldStayConsistent_WhenAccessedConcurrently", func(t *testing.T) {
+		store := newStore(t)
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				task := newTask()
+				assert.NoError(t, store.AddTask(task))
+				assert.NoError(t, store.UpdateTaskWorker(task.GetId(), scheduler.WorkerId(fmt.Sprint(i)), "wt"))
+				assert.NoError(t, store.UpdateTaskState(task.GetId(), scheduler.TaskStateRunning))
+				got, err := store.GetTask(task.GetId())
+				assert.NoError(t, err)
+				assert.Equal(t, scheduler.WorkerId(fmt.Sprint(i)), got.GetWorkerId())
+				assert.NoError(t, store.DelTask(task.GetId()))
+			}(i)
+		}
+		wg.Wait()
+	})
+}
+
+func TestInMemStore(t *testing.T) {
+	testTaskStore(t, func(t *testing.T) scheduler.TaskStore {
+		return scheduler.NewInMemStore()
+	})
+}
+
+func TestRedisTaskStore(t *testing.T) {
+	testTaskStore(t, func(t *testing.T) scheduler.TaskStore {
+		mr := miniredis.RunT(t)
+		store, err := scheduler.NewRedisTaskStore(&scheduler.RedisConfig{
+			ClusterMode: scheduler.RedisClusterModeStandalone,
+			Addrs:       []string{mr.Addr()},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
+
+func TestNewRedisTaskStore_ShouldMigrateTasks_WhenStoredInLegacyHash(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.HSet(scheduler.RedisLegacyTaskKey,
+		"t1", `{"task_state":"PENDING","type":"pdf","sub_type":"pdf2img","worker_id":"","created_at":"2024-01-02T03:04:05Z","user_def":{"file_id":"f1"}}`,
+		"t2", `{"task_state":"RUNNING","type":"pdf","sub_type":"pdf2img","worker_id":"w1","created_at":"2024-01-02T03:04:06Z"}`,
+		"t3", `not json`)
+
+	store, err := scheduler.NewRedisTaskStore(&scheduler.RedisConfig{
+		ClusterMode: scheduler.RedisClusterModeStandalone,
+		Addrs:       []string{mr.Addr()},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer store.Close()
+
+	task, err := store.GetTask("t1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, scheduler.TaskState(scheduler.TaskStateCreated), task.GetState())
+		assert.Equal(t, map[string]interface{}{"file_id": "f1"}, task.GetUserDef())
+		assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).UnixMilli(), task.GetCreatedAt().UnixMilli())
+	}
+	counts, err := store.CountTasks()
+	assert.NoError(t, err)
+	assert.Equal(t, scheduler.TaskCounts{Queued: 1, Dispatched: 1}, counts[scheduler.TaskTypePdf])
+	// the broken task is left for an operator
+	legacy, err := mr.HKeys(scheduler.RedisLegacyTaskKey)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"t3"}, legacy)
+}
+
+func TestSqlTaskStore(t *testing.T) {
+	testTaskStore(t, func(t *testing.T) scheduler.TaskStore {
+		return newTestSqlTaskStore(t, filepath.Join(t.TempDir(), "tasks.db"))
+	})
+}
@@ -1,5 +1,14 @@
 package scheduler
 
+import (
+	"errors"
+	"fmt"
+	"go-web/pkg/config"
+	"reflect"
+	"sort"
+	"sync"
+)
+
 type TaskType string
 type SubTaskType string
 
@@ -20,30 +29,281 @@ const (
 	SubTaskTypePdfWatermarkAdder   SubTaskType = "pdfwatermarkadder"
 )
 
-var validTaskSubTypeMapping = map[TaskType][]SubTaskType{
-	TaskTypePdf: {
-		SubTaskTypePdf2Csv,
-		SubTaskTypePdf2Img,
-		SubTaskTypePdfSplitter,
-		SubTaskTypePdfMerger,
-		SubTaskTypePdfRotator,
-		SubTaskTypePdfWatermarkRemover,
-		SubTaskTypePdfWatermarkAdder,
-	},
-	TaskTypeCsv: {
-		SubTaskTypePdf2Csv,
-	},
+var (
+	ErrUnknownTaskType = errors.New("unknown task type")
+	// ErrSubTypeRedefined rejects a worker declaring a sub type that is
+	// declared otherwise already
+	ErrSubTypeRedefined = errors.New("sub type is declared otherwise")
+)
+
+// TaskTypeDef declares a task type and the sub types it accepts, names are
+// what users see.
+type TaskTypeDef struct {
+	Type     TaskType         `json:"type"`
+	Name     string           `json:"name"`
+	SubTypes []SubTaskTypeDef `json:"sub_types"`
+}
+
+type SubTaskTypeDef struct {
+	SubType SubTaskType `json:"sub_type"`
+	Name    string      `json:"name"`
+	// Params is the schema of the params, declared params replace the
+	// struct registered with RegisterParams
+	Params []ParamField `json:"params,omitempty"`
+}
+
+var (
+	typeMu       sync.RWMutex
+	taskTypeDefs = map[TaskType]*TaskTypeDef{
+		TaskTypePdf: {
+			Type: TaskTypePdf,
+			Name: "PDF",
+			SubTypes: []SubTaskTypeDef{
+				{SubType: SubTaskTypePdf2Csv, Name: "PDF to CSV"},
+				{SubType: SubTaskTypePdf2Img, Name: "PDF to image"},
+				{SubType: SubTaskTypePdfSplitter, Name: "Split PDF"},
+				{SubType: SubTaskTypePdfMerger, Name: "Merge PDF"},
+				{SubType: SubTaskTypePdfRotator, Name: "Rotate PDF"},
+				{SubType: SubTaskTypePdfWatermarkRemover, Name: "Remove watermark"},
+				{SubType: SubTaskTypePdfWatermarkAdder, Name: "Add watermark"},
+			},
+		},
+		TaskTypeCsv:   {Type: TaskTypeCsv, Name: "CSV"},
+		TaskTypeImage: {Type: TaskTypeImage, Name: "Image"},
+	}
+	// workerSubTypes holds the sub types workers added, as first declared
+	workerSubTypes = map[SubTaskType]workerSubType{}
+)
+
+type workerSubType struct {
+	taskType TaskType
+	def      SubTaskTypeDef
 }
 
 func IsValidTask(taskType TaskType, subTaskType SubTaskType) bool {
-	validSubTypes, ok := validTaskSubTypeMapping[taskType]
+	typeMu.RLock()
+	defer typeMu.RUnlock()
+	def, ok := taskTypeDefs[taskType]
 	if !ok {
 		return false
 	}
-	for _, validSubType := range validSubTypes {
-		if subTaskType == validSubType {
+	for _, subTypeDef := range def.SubTypes {
+		if subTaskType == subTypeDef.SubType {
 			return true
 		}
 	}
 	return false
 }
+
+// TaskTypes returns every declared task type, sorted by name. Streams are
+// created for the types declared when the scheduler starts.
+func TaskTypes() []TaskType {
+	typeMu.RLock()
+	defer typeMu.RUnlock()
+	types := make([]TaskType, 0, len(taskTypeDefs))
+	for taskType := range taskTypeDefs {
+		types = append(types, taskType)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return types[i] < types[j]
+	})
+	return types
+}
+
+// TaskTypeDefs returns the task types that accept tasks, sorted by type.
+// Sub types without declared params describe their registered struct.
+func TaskTypeDefs() []TaskTypeDef {
+	typeMu.RLock()
+	defs := make([]TaskTypeDef, 0, len(taskTypeDefs))
+	for _, def := range taskTypeDefs {
+		if len(def.SubTypes) == 0 {
+			continue
+		}
+		defCopy := *def
+		defCopy.SubTypes = append([]SubTaskTypeDef(nil), def.SubTypes...)
+		defs = append(defs, defCopy)
+	}
+	typeMu.RUnlock()
+
+	for _, def := range defs {
+		for i := range def.SubTypes {
+			def.SubTypes[i].Params = ParamFields(def.SubTypes[i].SubType)
+		}
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		return defs[i].Type < defs[j].Type
+	})
+	return defs
+}
+
+// RegisterTaskType declares a task type or adds sub types to it, a sub type
+// declared again replaces the former declaration.
+func RegisterTaskType(def TaskTypeDef) error {
+	return registerTaskType(def)
+}
+
+// RegisterSubTypes adds sub types workers declared. Workers cannot add task
+// types, those are declared in the config so every replica knows them, and
+// cannot change sub types: a sub type declared in the code, the config or by
+// another worker is only accepted as declared there. The sub types are only
+// known to the replicas the registrations and heartbeats of the worker
+// reached, sub types every replica needs belong in the config.
+func RegisterSubTypes(defs []TaskTypeDef) error {
+	for _, def := range defs {
+		if err := checkTaskType(def); err != nil {
+			return err
+		}
+	}
+
+	typeMu.Lock()
+	defer typeMu.Unlock()
+	added := map[SubTaskType]workerSubType{}
+	for _, def := range defs {
+		if _, ok := taskTypeDefs[def.Type]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownTaskType, def.Type)
+		}
+		for _, subTypeDef := range def.SubTypes {
+			declared := workerSubType{taskType: def.Type, def: subTypeDef}
+			if err := checkWorkerSubType(declared, added); err != nil {
+				return err
+			}
+			added[subTypeDef.SubType] = declared
+		}
+	}
+
+	for subType, declared := range added {
+		if _, ok := workerSubTypes[subType]; ok || hasSubType(subType) {
+			continue
+		}
+		workerSubTypes[subType] = declared
+		if len(declared.def.Params) > 0 {
+			registerParamFields(subType, declared.def.Params)
+		}
+		registered := taskTypeDefs[declared.taskType]
+		registered.SubTypes = append(registered.SubTypes, SubTaskTypeDef{SubType: subType, Name: declared.def.Name})
+	}
+	return nil
+}
+
+// checkWorkerSubType fails when a sub type a worker declared differs from an
+// earlier declaration. A sub type of the code or the config is only matched
+// by its task type, workers may leave out its name and params.
+func checkWorkerSubType(declared workerSubType, added map[SubTaskType]workerSubType) error {
+	subType := declared.def.SubType
+	earlier, ok := added[subType]
+	if !ok {
+		earlier, ok = workerSubTypes[subType]
+	}
+	if ok {
+		if earlier.taskType != declared.taskType || !reflect.DeepEqual(earlier.def, declared.def) {
+			return fmt.Errorf("%w: %s", ErrSubTypeRedefined, subType)
+		}
+		return nil
+	}
+
+	for taskType, def := range taskTypeDefs {
+		for _, subTypeDef := range def.SubTypes {
+			if subTypeDef.SubType != subType {
+				continue
+			}
+			sameName := len(declared.def.Name) == 0 || declared.def.Name == subTypeDef.Name
+			if taskType != declared.taskType || !sameName || len(declared.def.Params) > 0 {
+				return fmt.Errorf("%w: %s", ErrSubTypeRedefined, subType)
+			}
+		}
+	}
+	return nil
+}
+
+func hasSubType(subType SubTaskType) bool {
+	for _, def := range taskTypeDefs {
+		for _, subTypeDef := range def.SubTypes {
+			if subTypeDef.SubType == subType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func checkTaskType(def TaskTypeDef) error {
+	if len(def.Type) == 0 {
+		return fmt.Errorf("%w: type is empty", ErrInvalidTask)
+	}
+	for _, subTypeDef := range def.SubTypes {
+		if len(subTypeDef.SubType) == 0 {
+			return fmt.Errorf("%w: sub type of %s is empty", ErrInvalidTask, def.Type)
+		}
+		if err := checkParamFields(subTypeDef.Params); err != nil {
+			return fmt.Errorf("params of %s: %w", subTypeDef.SubType, err)
+		}
+	}
+	return nil
+}
+
+func registerTaskType(def TaskTypeDef) error {
+	if err := checkTaskType(def); err != nil {
+		return err
+	}
+
+	typeMu.Lock()
+	defer typeMu.Unlock()
+	registered, ok := taskTypeDefs[def.Type]
+	if !ok {
+		registered = &TaskTypeDef{Type: def.Type}
+		taskTypeDefs[def.Type] = registered
+	}
+	if len(def.Name) > 0 {
+		registered.Name = def.Name
+	}
+	for _, subTypeDef := range def.SubTypes {
+		if len(subTypeDef.Params) > 0 {
+			registerParamFields(subTypeDef.SubType, subTypeDef.Params)
+		}
+		subTypeDef.Params = nil
+		replaced := false
+		for i := range registered.SubTypes {
+			if registered.SubTypes[i].SubType == subTypeDef.SubType {
+				registered.SubTypes[i] = subTypeDef
+				replaced = true
+			}
+		}
+		if !replaced {
+			registered.SubTypes = append(registered.SubTypes, subTypeDef)
+		}
+	}
+	return nil
+}
+
+// LoadTaskTypes declares the task types of the config.
+func LoadTaskTypes(cfgs []config.TaskType) error {
+	for _, cfg := range cfgs {
+		if err := RegisterTaskType(taskTypeDef(cfg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckTaskTypes reports the first invalid task type of the config without
+// declaring any of them.
+func CheckTaskTypes(cfgs []config.TaskType) error {
+	for _, cfg := range cfgs {
+		if err := checkTaskType(taskTypeDef(cfg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func taskTypeDef(cfg config.TaskType) TaskTypeDef {
+	def := TaskTypeDef{Type: TaskType(cfg.Type), Name: cfg.Name}
+	for _, subTypeCfg := range cfg.SubTypes {
+		subTypeDef := SubTaskTypeDef{SubType: SubTaskType(subTypeCfg.SubType), Name: subTypeCfg.Name}
+		for _, param := range subTypeCfg.Params {
+			subTypeDef.Params = append(subTypeDef.Params, ParamField(param))
+		}
+		def.SubTypes = append(def.SubTypes, subTypeDef)
+	}
+	return def
+}
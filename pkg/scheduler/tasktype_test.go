@@ -1,6 +1,7 @@
 package scheduler_test
 
 import (
+	"go-web/pkg/config"
 	"go-web/pkg/scheduler"
 	"testing"
 
@@ -18,7 +19,7 @@ func TestIsValidTask(t *testing.T) {
 		expectedValid bool
 	}{
 		{scheduler.TaskTypePdf, scheduler.SubTaskTypePdf2Csv, true},
-		{scheduler.TaskTypeCsv, scheduler.SubTaskTypePdf2Csv, true},
+		{scheduler.TaskTypeCsv, scheduler.SubTaskTypePdf2Csv, false},
 		{scheduler.TaskTypePdf, scheduler.SubTaskTypePdf2Img, true},
 		{scheduler.TaskTypePdf, scheduler.SubTaskTypePdfSplitter, true},
 		{scheduler.TaskTypeCsv, scheduler.SubTaskTypePdf2Img, false},
@@ -29,3 +30,87 @@ func TestIsValidTask(t *testing.T) {
 		assert.Equal(t, test.expectedValid, actualValid)
 	}
 }
+
+func TestLoadTaskTypes_ShouldDeclareSubTypes_WhenConfigured(t *testing.T) {
+	err := scheduler.LoadTaskTypes([]config.TaskType{{
+		Type: "test-image",
+		Name: "Image",
+		SubTypes: []config.SubTaskType{{
+			SubType: "test-img2webp",
+			Name:    "Image to WebP",
+			Params: []config.TaskParam{
+				{Name: "file_id", Type: "string", Validate: "required"},
+				{Name: "quality", Type: "integer", Validate: "omitempty,min=1,max=100"},
+			},
+		}},
+	}})
+	assert.NoError(t, err)
+	assert.True(t, scheduler.IsValidTask("test-image", "test-img2webp"))
+	assert.Contains(t, scheduler.TaskTypes(), scheduler.TaskType("test-image"))
+
+	assert.NoError(t, scheduler.ValidateParams("test-img2webp", map[string]interface{}{"file_id": "f1", "quality": 80}))
+	err = scheduler.ValidateParams("test-img2webp", map[string]interface{}{"quality": 101.5, "lossless": true})
+	assert.Equal(t, []scheduler.ParamError{
+		{Field: "file_id", Message: "is required"},
+		{Field: "quality", Message: "must be integer"},
+		{Field: "lossless", Message: "is unknown"},
+	}, paramErrors(t, err))
+}
+
+func TestLoadTaskTypes_ShouldFail_WhenParamInvalid(t *testing.T) {
+	subType := func(param config.TaskParam) []config.TaskType {
+		return []config.TaskType{{Type: "test-invalid", SubTypes: []config.SubTaskType{{SubType: "test-invalid-sub", Params: []config.TaskParam{param}}}}}
+	}
+	assert.Error(t, scheduler.LoadTaskTypes(subType(config.TaskParam{Name: "dpi", Type: "float"})))
+	assert.Error(t, scheduler.LoadTaskTypes(subType(config.TaskParam{Name: "dpi", Type: "integer", Validate: "mni=1"})))
+	assert.False(t, scheduler.IsValidTask("test-invalid", "test-invalid-sub"))
+}
+
+func TestRegisterSubTypes_ShouldRejectTaskType_WhenNotDeclared(t *testing.T) {
+	err := scheduler.RegisterSubTypes([]scheduler.TaskTypeDef{{Type: "test-video", SubTypes: []scheduler.SubTaskTypeDef{{SubType: "test-mp42gif"}}}})
+	assert.ErrorIs(t, err, scheduler.ErrUnknownTaskType)
+
+	err = scheduler.RegisterSubTypes([]scheduler.TaskTypeDef{{Type: scheduler.TaskTypeCsv, SubTypes: []scheduler.SubTaskTypeDef{{SubType: "test-csvsplitter", Name: "Split CSV"}}}})
+	assert.NoError(t, err)
+	assert.True(t, scheduler.IsValidTask(scheduler.TaskTypeCsv, "test-csvsplitter"))
+}
+
+func TestRegisterSubTypes_ShouldRejectSubType_WhenDeclaredOtherwise(t *testing.T) {
+	params := []scheduler.ParamField{{Name: "file_id", Type: "string", Validate: "required"}}
+	declare := func(taskType scheduler.TaskType, subTypeDef scheduler.SubTaskTypeDef) error {
+		return scheduler.RegisterSubTypes([]scheduler.TaskTypeDef{{Type: taskType, SubTypes: []scheduler.SubTaskTypeDef{subTypeDef}}})
+	}
+
+	assert.NoError(t, declare(scheduler.TaskTypePdf, scheduler.SubTaskTypeDef{SubType: scheduler.SubTaskTypePdf2Img}))
+	err := declare(scheduler.TaskTypePdf, scheduler.SubTaskTypeDef{SubType: scheduler.SubTaskTypePdf2Img, Params: params})
+	assert.ErrorIs(t, err, scheduler.ErrSubTypeRedefined)
+	err = declare(scheduler.TaskTypeCsv, scheduler.SubTaskTypeDef{SubType: scheduler.SubTaskTypePdf2Csv})
+	assert.ErrorIs(t, err, scheduler.ErrSubTypeRedefined)
+	assert.Error(t, scheduler.ValidateParams(scheduler.SubTaskTypePdf2Img, map[string]interface{}{"file_id": "f1", "dpi": 1}))
+
+	declared := scheduler.SubTaskTypeDef{SubType: "test-csv2xlsx", Name: "CSV to Excel", Params: params}
+	assert.NoError(t, declare(scheduler.TaskTypeCsv, declared))
+	assert.NoError(t, declare(scheduler.TaskTypeCsv, declared))
+	declared.Params = nil
+	assert.ErrorIs(t, declare(scheduler.TaskTypeCsv, declared), scheduler.ErrSubTypeRedefined)
+	assert.Error(t, scheduler.ValidateParams("test-csv2xlsx", map[string]interface{}{}))
+}
+
+func TestTaskTypeDefs_ShouldDescribeStructParams_WhenSubTypeHasStruct(t *testing.T) {
+	for _, def := range scheduler.TaskTypeDefs() {
+		assert.NotEqual(t, scheduler.TaskTypeImage, def.Type, "types without sub types are not listed")
+		if def.Type != scheduler.TaskTypePdf {
+			continue
+		}
+		for _, subTypeDef := range def.SubTypes {
+			if subTypeDef.SubType == scheduler.SubTaskTypePdfSplitter {
+				assert.Equal(t, []scheduler.ParamField{
+					{Name: "file_id", Type: "string", Validate: "required"},
+					{Name: "pages_per_file", Type: "integer", Validate: "required,min=1"},
+				}, subTypeDef.Params)
+				return
+			}
+		}
+	}
+	t.Fatal("pdfsplitter is not listed")
+}
@@ -2,12 +2,19 @@ package scheduler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"go-web/pkg/http"
+	"go-web/pkg/logger"
+	"go-web/pkg/metrics"
+	"go-web/pkg/tracing"
 	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type WorkerStatus struct {
@@ -28,7 +35,12 @@ type Worker interface {
 	GetAddr() string
 	Exec(task Task) (TaskFuture, error)
 	CheckStatus() error
-	GetTaskStatus(taskId string) (*WorkerTaskResult, error)
+	// GetTaskStatus asks the worker for a task, the trace context of ctx is
+	// passed on
+	GetTaskStatus(ctx context.Context, taskId string) (*WorkerTaskResult, error)
+	// Cancel asks the worker to stop a task, taskId is the id the worker
+	// assigned to it
+	Cancel(taskId string) error
 	GetLastHeartbeat() time.Time
 	Heartbeat(time.Time)
 	Status() WorkerStatus
@@ -50,7 +62,7 @@ type workimpl struct {
 	taskapitable  map[TaskType]string
 	errCounter    atomic.Int32
 	isHealty      atomic.Bool
-	heartbeattime time.Time
+	heartbeattime atomic.Int64
 	workerStatus  *WorkerStatus
 }
 
@@ -82,13 +94,28 @@ type taskdispath struct {
 	TaskType    string      `json:"task_type"`
 	TaskSubType string      `json:"task_sub_type"`
 	TaskDetail  interface{} `json:"task_detail"`
+	// ArtifactPrefix is where the worker uploads the outputs of the task
+	ArtifactPrefix string `json:"artifact_prefix"`
 }
 
+// Exec sends a task to the worker in a client span of the task's trace, the
+// worker gets the span as traceparent header.
 func (w *workimpl) Exec(task Task) (TaskFuture, error) {
+	ctx, span := tracing.Tracer().Start(tracing.ContextWithTraceParent(task.GetTraceParent()), "worker.Exec",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(taskAttributes(task)...),
+		trace.WithAttributes(attribute.String("worker.id", string(w.id))))
+	future, err := w.exec(ctx, task)
+	tracing.EndSpan(span, err)
+	return future, err
+}
+
+func (w *workimpl) exec(ctx context.Context, task Task) (TaskFuture, error) {
 	task_dispath := &taskdispath{TaskId: task.GetId(),
-		TaskType:    string(task.GetType()),
-		TaskSubType: string(task.GetSubType()),
-		TaskDetail:  task.GetUserDef(),
+		TaskType:       string(task.GetType()),
+		TaskSubType:    string(task.GetSubType()),
+		TaskDetail:     task.GetUserDef(),
+		ArtifactPrefix: ArtifactPrefix(task.GetId()),
 	}
 	taskjson, err := json.Marshal(task_dispath)
 	if err != nil {
@@ -98,6 +125,10 @@ func (w *workimpl) Exec(task Task) (TaskFuture, error) {
 	headers := map[string]string{
 		"Content-Type": "application/json",
 	}
+	tracing.InjectHeaders(ctx, headers)
+	if len(task.GetRequestId()) > 0 {
+		headers[logger.RequestIdHeader] = task.GetRequestId()
+	}
 	url := fmt.Sprintf("http://%s/task", w.addr)
 	resp, status, err := w.httpclient.Post(url, bytes.NewReader(taskjson), headers)
 	if status == 200 && err == nil {
@@ -109,9 +140,9 @@ func (w *workimpl) Exec(task Task) (TaskFuture, error) {
 		task.SetWorkerTaskId(taskSubmitResponse.TaskId)
 		return NewTaskFuture(task), nil
 	} else {
+		w.clientError("exec")
 		return nil, errors.New("dispath task error")
 	}
-	return nil, errors.New("no api found")
 }
 
 func (w *workimpl) Status() WorkerStatus {
@@ -146,6 +177,7 @@ func (w *workimpl) CheckStatus() error {
 			return err
 		}
 
+		w.workerStatus.IsHealthy = executorStatus.Data.IsHealthy
 		w.workerStatus.ActiveTasks = executorStatus.Data.ActiveTasks
 		w.workerStatus.CompletedTasks = executorStatus.Data.CompletedTasks
 		w.workerStatus.FailedTasks = executorStatus.Data.FailedTasks
@@ -153,6 +185,7 @@ func (w *workimpl) CheckStatus() error {
 
 		return nil
 	}
+	w.clientError("check_status")
 	return errors.New("check status error")
 }
 
@@ -160,8 +193,10 @@ func (w *workimpl) GetAddr() string {
 	return w.addr
 }
 
-func (w *workimpl) GetTaskStatus(taskId string) (*WorkerTaskResult, error) {
-	resp, status, err := w.httpclient.Get("http://"+w.addr+"/task/"+taskId, nil)
+func (w *workimpl) GetTaskStatus(ctx context.Context, taskId string) (*WorkerTaskResult, error) {
+	headers := map[string]string{}
+	tracing.InjectHeaders(ctx, headers)
+	resp, status, err := w.httpclient.Get("http://"+w.addr+"/task/"+taskId, headers)
 	if status == 200 && err == nil {
 		taskStatus := &WorkerTaskResult{}
 		err := json.Unmarshal(resp, taskStatus)
@@ -171,15 +206,33 @@ func (w *workimpl) GetTaskStatus(taskId string) (*WorkerTaskResult, error) {
 
 		return taskStatus, nil
 	}
+	w.clientError("task_status")
 	return nil, errors.New("check task status error")
 }
 
+func (w *workimpl) Cancel(taskId string) error {
+	_, status, err := w.httpclient.Delete("http://"+w.addr+"/task/"+taskId, nil)
+	if err != nil {
+		w.clientError("cancel")
+		return err
+	}
+	if status != 200 {
+		w.clientError("cancel")
+		return fmt.Errorf("cancel task error, status: %d", status)
+	}
+	return nil
+}
+
+func (w *workimpl) clientError(operation string) {
+	metrics.WorkerClientErrors.WithLabelValues(string(w.id), operation).Inc()
+}
+
 func (w *workimpl) Heartbeat(ht time.Time) {
-	w.heartbeattime = ht
+	w.heartbeattime.Store(ht.UnixNano())
 }
 
 func (w *workimpl) GetLastHeartbeat() time.Time {
-	return w.heartbeattime
+	return time.Unix(0, w.heartbeattime.Load())
 }
 
 // not support now
@@ -1,61 +1,116 @@
 package scheduler
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
 	"time"
 )
 
 type WorkerManager struct {
 	workers     WorkerStore
 	lb          LoadBalancer
+	leader      LeaderElector
 	timer       *time.Ticker
 	healthTimer *time.Ticker
-	done        chan bool
+	done        chan struct{}
+	stopped     chan struct{}
+	stopOnce    sync.Once
+
+	// local workers run in this process, they never go to the shared store
+	// since other replicas cannot reach them
+	localMu sync.RWMutex
+	locals  map[WorkerId]*LocalWorker
 }
 
 type WorkerManagerCfg struct {
 	RedisConfig
+	StoreType string
+	Leader    LeaderElector
 }
 
-func NewWorkerManager(lb LoadBalancer, wmCfg WorkerManagerCfg) *WorkerManager {
-	store, err := NewRedisWorkerStore(&wmCfg.RedisConfig)
+func NewWorkerManager(lb LoadBalancer, wmCfg WorkerManagerCfg) (*WorkerManager, error) {
+	store, err := newWorkerStore(wmCfg)
 	if err != nil {
-		log.Fatal("create worker store error.", err)
+		return nil, fmt.Errorf("create worker store error: %w", err)
+	}
+	if wmCfg.Leader == nil {
+		wmCfg.Leader = NewStandaloneElector()
 	}
 	ww := &WorkerManager{
 		lb:          lb,
+		leader:      wmCfg.Leader,
 		workers:     store,
 		timer:       time.NewTicker(5 * time.Second),
 		healthTimer: time.NewTicker(5 * time.Second),
-		done:        make(chan bool),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+		locals:      map[WorkerId]*LocalWorker{},
 	}
 
 	go func() {
+		defer close(ww.stopped)
 		ww.evictWorker()
 	}()
 
-	return ww
+	return ww, nil
+}
+
+func newWorkerStore(wmCfg WorkerManagerCfg) (WorkerStore, error) {
+	switch wmCfg.StoreType {
+	case WorkerStoreTypeRedis, "":
+		// replicas share their workers unless memory is asked for explicitly
+		return NewRedisWorkerStore(&wmCfg.RedisConfig)
+	case WorkerStoreTypeMemory:
+		return NewInMemWorkerStore(WorkerHeartbeatTTL), nil
+	default:
+		return nil, fmt.Errorf("unsupported worker store type: %s", wmCfg.StoreType)
+	}
 }
 
 func (ww *WorkerManager) AddWorker(worker Worker) {
+	if local, ok := worker.(*LocalWorker); ok {
+		ww.localMu.Lock()
+		ww.locals[local.GetId()] = local
+		ww.localMu.Unlock()
+		return
+	}
 	err := ww.workers.AddWorker(worker)
 	if err != nil {
-		log.Printf("add worker error %v.", err)
+		slog.Error("add worker error", "worker_id", worker.GetId(), "error", err)
 	}
 	err = ww.workers.Heartbeat(worker)
 	if err != nil {
-		log.Printf("add worker error %v.", err)
+		slog.Error("add worker heartbeat error", "worker_id", worker.GetId(), "error", err)
 	}
 }
 
 func (ww *WorkerManager) DelWorker(id WorkerId) {
+	ww.localMu.Lock()
+	local, ok := ww.locals[id]
+	delete(ww.locals, id)
+	ww.localMu.Unlock()
+	if ok {
+		local.Stop()
+		// the drain mark of local workers is kept in the store as well
+		ww.workers.SetDraining(id, false)
+		return
+	}
 	ww.workers.DelWorker(id)
 }
 
+func (ww *WorkerManager) getLocalWorker(id WorkerId) (*LocalWorker, bool) {
+	ww.localMu.RLock()
+	defer ww.localMu.RUnlock()
+	local, ok := ww.locals[id]
+	return local, ok
+}
+
 func (ww *WorkerManager) GetWorkers() []Worker {
 	workerIds, err := ww.workers.GetWorkerIds()
 	if err != nil {
-		return nil
+		workerIds = nil
 	}
 
 	workers := make([]Worker, 0, len(workerIds))
@@ -68,17 +123,37 @@ func (ww *WorkerManager) GetWorkers() []Worker {
 		workers = append(workers, worker)
 	}
 
+	ww.localMu.RLock()
+	for _, local := range ww.locals {
+		workers = append(workers, local)
+	}
+	ww.localMu.RUnlock()
+
 	return workers
 }
 
-func (ww *WorkerManager) SelectWorker() Worker {
+// SelectWorker picks a worker for a task of the sub type. Local workers are
+// only candidates for the sub types they have a handler for.
+func (ww *WorkerManager) SelectWorker(subType SubTaskType) Worker {
 	workerIds, err := ww.workers.GetWorkerIds()
 	if err != nil {
+		workerIds = nil
+	}
+
+	ww.localMu.RLock()
+	for id, local := range ww.locals {
+		if local.CanHandle(subType) {
+			workerIds = append(workerIds, id)
+		}
+	}
+	ww.localMu.RUnlock()
+	workerIds = ww.withoutDraining(workerIds)
+	if len(workerIds) == 0 {
 		return nil
 	}
 
 	workerId := ww.lb.Select(workerIds)
-	worker, err := ww.workers.GetWorker(workerId)
+	worker, err := ww.GetWorker(workerId)
 	if err != nil {
 		return nil
 	}
@@ -86,7 +161,50 @@ func (ww *WorkerManager) SelectWorker() Worker {
 	return worker
 }
 
+// withoutDraining drops the draining workers, a worker is kept when the drain
+// marks cannot be read so tasks still get dispatched.
+func (ww *WorkerManager) withoutDraining(workerIds []WorkerId) []WorkerId {
+	draining, err := ww.DrainingWorkers()
+	if err != nil {
+		slog.Error("get draining workers error", "error", err)
+		return workerIds
+	}
+	if len(draining) == 0 {
+		return workerIds
+	}
+	selectable := make([]WorkerId, 0, len(workerIds))
+	for _, id := range workerIds {
+		if !draining[id] {
+			selectable = append(selectable, id)
+		}
+	}
+	return selectable
+}
+
+// SetDraining marks a registered worker as draining or takes the mark off.
+func (ww *WorkerManager) SetDraining(id WorkerId, draining bool) error {
+	if _, err := ww.GetWorker(id); err != nil {
+		return err
+	}
+	return ww.workers.SetDraining(id, draining)
+}
+
+func (ww *WorkerManager) DrainingWorkers() (map[WorkerId]bool, error) {
+	workerIds, err := ww.workers.GetDrainingIds()
+	if err != nil {
+		return nil, err
+	}
+	draining := make(map[WorkerId]bool, len(workerIds))
+	for _, id := range workerIds {
+		draining[id] = true
+	}
+	return draining, nil
+}
+
 func (ww *WorkerManager) GetWorker(workerId WorkerId) (Worker, error) {
+	if local, ok := ww.getLocalWorker(workerId); ok {
+		return local, nil
+	}
 	return ww.workers.GetWorker(workerId)
 }
 
@@ -94,27 +212,39 @@ func (ww *WorkerManager) Heartbeat(worker Worker) error {
 	return ww.workers.Heartbeat(worker)
 }
 
+// Close stops the worker manager like Stop does.
 func (ww *WorkerManager) Close() {
-	ww.timer.Stop()
-	ww.healthTimer.Stop()
-	ww.done <- true
+	ww.Stop()
 }
+
 func (ww *WorkerManager) evictWorker() {
 	for {
 		select {
 		case <-ww.done:
 			return
 		case <-ww.healthTimer.C:
-			println("health check timer")
+			// only the leader evicts workers, other replicas would race on the store
+			if !ww.leader.IsLeader() {
+				continue
+			}
+			token := ww.leader.FencingToken()
 			// get worker id list from worker store
 			if workerIds, err := ww.workers.GetWorkerIds(); err == nil {
 				for _, workerId := range workerIds {
-					if worker, err := ww.workers.GetWorker(workerId); err == nil {
-						if lastHeartbeatTime := worker.GetLastHeartbeat(); time.Duration(time.Since(lastHeartbeatTime).Seconds()) > time.Second*300 {
-							log.Println("worker", workerId, "is not healthy, evict it")
-							if err := ww.workers.DelWorker(workerId); err != nil {
-								log.Println("failed to delete worker", workerId, "from worker store")
-							}
+					worker, err := ww.workers.GetWorker(workerId)
+					if err == nil && time.Since(worker.GetLastHeartbeat()) <= WorkerHeartbeatTTL {
+						continue
+					}
+					// the worker info has expired or the worker stopped sending heartbeats
+					if err == nil || errors.Is(err, ErrWorkerNotFound) {
+						slog.Warn("worker is not healthy, evict it", "worker_id", workerId)
+						err := ww.workers.EvictWorker(workerId, token)
+						if errors.Is(err, ErrStaleFencingToken) {
+							slog.Warn("replica is no longer the leader, stop evicting workers", "fencing_token", token)
+							break
+						}
+						if err != nil {
+							slog.Error("delete worker from worker store error", "worker_id", workerId, "error", err)
 						}
 					}
 				}
@@ -126,9 +256,22 @@ func (ww *WorkerManager) evictWorker() {
 	}
 }
 
+// Stop ends the eviction loop, stops the local workers and closes the worker
+// store. Calling it again does nothing.
 func (ww *WorkerManager) Stop() error {
-	ww.done <- true
-	defer ww.timer.Stop()
-	defer ww.healthTimer.Stop()
-	return nil
+	var err error
+	ww.stopOnce.Do(func() {
+		close(ww.done)
+		<-ww.stopped
+		ww.timer.Stop()
+		ww.healthTimer.Stop()
+
+		ww.localMu.RLock()
+		for _, local := range ww.locals {
+			local.Stop()
+		}
+		ww.localMu.RUnlock()
+		err = ww.workers.Close()
+	})
+	return err
 }
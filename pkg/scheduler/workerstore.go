@@ -6,7 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"hash/fnv"
-	"log"
+	"log/slog"
+	"sort"
 	"sync"
 	"time"
 
@@ -26,6 +27,13 @@ type WorkerStore interface {
 	GetWorker(id WorkerId) (Worker, error)
 	GetWorkerIds() ([]WorkerId, error)
 	Heartbeat(worker Worker) error
+	// EvictWorker deletes a worker for the leader holding fencingToken, it
+	// fails with ErrStaleFencingToken once a newer leader evicted a worker
+	EvictWorker(id WorkerId, fencingToken int64) error
+	// SetDraining marks a worker that takes no new tasks, the mark survives
+	// heartbeats and goes away with the worker
+	SetDraining(id WorkerId, draining bool) error
+	GetDrainingIds() ([]WorkerId, error)
 	Close() error
 }
 
@@ -51,52 +59,67 @@ type RedisWorkerStore struct {
 	client      redis.UniversalClient
 }
 
+// InMemWorkerStore keeps workers in process memory, it is meant for single
+// node setups. A worker expires when it has not sent a heartbeat within ttl.
 type InMemWorkerStore struct {
-	workers *sync.Map
+	workers  *sync.Map
+	draining *sync.Map
+	ttl      time.Duration
+}
+
+type inMemWorkerEntry struct {
+	worker    Worker
+	expiresAt time.Time
 }
 
 const (
-	WORKER_LIST_KEY = "ktools:worker:set"
-	WORKER_INFO_KEY = "ktools:worker:info:"
+	WORKER_LIST_KEY     = "ktools:worker:set"
+	WORKER_INFO_KEY     = "ktools:worker:info:"
+	WORKER_DRAINING_KEY = "ktools:worker:draining"
+	// WORKER_FENCE_KEY is the highest fencing token that evicted a worker, its
+	// hash tag puts it in the slot of WORKER_LIST_KEY
+	WORKER_FENCE_KEY = "{ktools:worker:set}:fence"
 )
 
-func NewInMemWorkerStore() WorkerStore {
+// evict a worker unless a newer leader evicted one already
+var evictWorkerScript = redis.NewScript(`
+local last = tonumber(redis.call('GET', KEYS[2]) or '0')
+if tonumber(ARGV[1]) < last then
+	return 0
+end
+redis.call('SET', KEYS[2], ARGV[1])
+redis.call('ZREM', KEYS[1], ARGV[2])
+return 1
+`)
+
+const (
+	WorkerStoreTypeMemory = "memory"
+	WorkerStoreTypeRedis  = "redis"
+)
+
+// WorkerHeartbeatTTL is how long a worker stays registered without a heartbeat.
+const WorkerHeartbeatTTL = 300 * time.Second
+
+func NewInMemWorkerStore(ttl time.Duration) WorkerStore {
+	if ttl <= 0 {
+		ttl = WorkerHeartbeatTTL
+	}
 	return &InMemWorkerStore{
-		workers: &sync.Map{},
+		workers:  &sync.Map{},
+		draining: &sync.Map{},
+		ttl:      ttl,
 	}
 }
 
 func NewRedisWorkerStore(cfg *RedisConfig) (WorkerStore, error) {
-	var client redis.UniversalClient
-	if cfg.ClusterMode == RedisClusterModeStandalone {
-		client = redis.NewClient(&redis.Options{
-			Addr:     cfg.Addrs[0],
-			Password: cfg.Password,
-			DB:       cfg.DB,
-		})
-	} else if cfg.ClusterMode == RedisClusterModeCluster {
-		client = redis.NewClusterClient(&redis.ClusterOptions{
-			Addrs:    cfg.Addrs,
-			Password: cfg.Password,
-		})
-	} else if cfg.ClusterMode == RedisClusterModeSentinel {
-		client = redis.NewFailoverClient(&redis.FailoverOptions{
-			MasterName:    cfg.Addrs[0],
-			SentinelAddrs: cfg.Addrs[1:],
-			Password:      cfg.Password,
-			DB:            cfg.DB,
-		})
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	_, err := client.Ping(ctx).Result()
+	client, err := NewRedisClient(cfg)
 	if err != nil {
 		return nil, err
 	}
 
 	return &RedisWorkerStore{
-		client: client,
+		redisConfig: cfg,
+		client:      client,
 	}, nil
 }
 
@@ -114,32 +137,32 @@ func (rws *RedisWorkerStore) AddWorker(worker Worker) error {
 
 	_, err := rws.client.Ping(ctx).Result()
 	if err != nil {
-		log.Printf("error on checking redis connection: %v", err)
+		slog.Error("check redis connection error", "error", err)
 		return err
 	}
 
 	wokerInfoKey := WORKER_INFO_KEY + string(worker.GetId())
 	exists, err := rws.client.Exists(ctx, wokerInfoKey).Result()
 	if err != nil {
-		log.Printf("error on checking if worker info exists: %v", err)
+		slog.Error("check worker info exists error", "worker_id", worker.GetId(), "error", err)
 		return err
 	}
 	if exists == 0 {
 		err = rws.doAddWorker(ctx, worker, wokerInfoKey)
 		if err != nil {
-			log.Printf("error on adding worker info: %v", err)
+			slog.Error("add worker info error", "worker_id", worker.GetId(), "error", err)
 			return err
 		}
 	} else {
-		log.Printf("worker info already exists， %v", worker.GetId())
+		slog.Debug("worker info already exists", "worker_id", worker.GetId())
 		setted, err := rws.client.Expire(ctx, wokerInfoKey, 30000000*time.Second).Result()
 		if err != nil {
-			log.Printf("error on setting worker info: %v", err)
+			slog.Error("set worker info expiration error", "worker_id", worker.GetId(), "error", err)
 			return err
 		}
 
 		if !setted {
-			log.Printf("worker info already set")
+			slog.Warn("worker info already set", "worker_id", worker.GetId())
 			return errors.New("worker info already set")
 		}
 	}
@@ -161,11 +184,11 @@ func (rws *RedisWorkerStore) doAddWorker(ctx context.Context, worker Worker, wok
 	}
 	pushed, err := rws.client.ZAdd(ctx, WORKER_LIST_KEY, z).Result()
 	if err != nil {
-		log.Printf("error on pushing elements to the list: %v", err)
+		slog.Error("add worker to list error", "worker_id", worker.GetId(), "error", err)
 		return err
 	}
 
-	log.Printf("Number of elements after pushed:%v \n", pushed)
+	slog.Debug("worker added to list", "worker_id", worker.GetId(), "added", pushed)
 
 	workerInfo := &RedisWorkerInfo{
 		Id:           worker.GetId(),
@@ -174,14 +197,14 @@ func (rws *RedisWorkerStore) doAddWorker(ctx context.Context, worker Worker, wok
 	}
 	workerJson, err := json.Marshal(workerInfo)
 	if err != nil {
-		log.Printf("error on marshaling worker info: %v", err)
+		slog.Error("marshal worker info error", "worker_id", worker.GetId(), "error", err)
 		return err
 	}
-	log.Printf("worker info: %v", string(workerJson))
+	slog.Debug("worker info", "worker_id", worker.GetId(), "addr", worker.GetAddr())
 
 	err = rws.client.Set(ctx, wokerInfoKey, workerJson, 30000000*time.Second).Err()
 	if err != nil {
-		log.Printf("error on setting worker info: %v", err)
+		slog.Error("set worker info error", "worker_id", worker.GetId(), "error", err)
 		return err
 	}
 
@@ -193,10 +216,28 @@ func (rws *RedisWorkerStore) DelWorker(id WorkerId) error {
 	defer cancel()
 	_, err := rws.client.ZRem(ctx, WORKER_LIST_KEY, string(id)).Result()
 	if err != nil {
-		log.Printf("error on deleting worker info: %v", err)
+		slog.Error("delete worker info error", "worker_id", id, "error", err)
+		return err
+	}
+	_, _ = rws.client.Del(ctx, WORKER_INFO_KEY+string(id)).Result()
+	_, _ = rws.client.SRem(ctx, WORKER_DRAINING_KEY, string(id)).Result()
+	return nil
+}
+
+func (rws *RedisWorkerStore) EvictWorker(id WorkerId, fencingToken int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	evicted, err := evictWorkerScript.Run(ctx, rws.client, []string{WORKER_LIST_KEY, WORKER_FENCE_KEY},
+		fencingToken, string(id)).Int()
+	if err != nil {
 		return err
 	}
+	if evicted == 0 {
+		return ErrStaleFencingToken
+	}
+	// the worker cannot be selected any more, the rest is cleanup
 	_, _ = rws.client.Del(ctx, WORKER_INFO_KEY+string(id)).Result()
+	_, _ = rws.client.SRem(ctx, WORKER_DRAINING_KEY, string(id)).Result()
 	return nil
 }
 
@@ -208,10 +249,15 @@ func (rws *RedisWorkerStore) GetWorker(id WorkerId) (Worker, error) {
 		var workerInfo RedisWorkerInfo
 		err := json.Unmarshal([]byte(stringCmd.Val()), &workerInfo)
 		if err != nil {
-			log.Printf("error on unmarshaling worker info: %v", err)
+			slog.Error("unmarshal worker info error", "worker_id", id, "error", err)
 			return nil, err
 		}
-		return NewWorker(workerInfo.Id, workerInfo.Addr), nil
+		worker := NewWorker(workerInfo.Id, workerInfo.Addr)
+		worker.Heartbeat(workerInfo.LastPingTime)
+		return worker, nil
+	}
+	if errors.Is(stringCmd.Err(), redis.Nil) {
+		return nil, ErrWorkerNotFound
 	}
 	return nil, stringCmd.Err()
 }
@@ -254,7 +300,40 @@ func (rws *RedisWorkerStore) Heartbeat(worker Worker) error {
 		}
 	}
 
-	return rws.client.Expire(ctx, workerKey, 300*time.Second).Err()
+	workerInfo := &RedisWorkerInfo{
+		Id:           worker.GetId(),
+		Addr:         worker.GetAddr(),
+		LastPingTime: time.Now(),
+	}
+	workerJson, err := json.Marshal(workerInfo)
+	if err != nil {
+		return err
+	}
+
+	return rws.client.Set(ctx, workerKey, workerJson, WorkerHeartbeatTTL).Err()
+}
+
+func (rws *RedisWorkerStore) SetDraining(id WorkerId, draining bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if draining {
+		return rws.client.SAdd(ctx, WORKER_DRAINING_KEY, string(id)).Err()
+	}
+	return rws.client.SRem(ctx, WORKER_DRAINING_KEY, string(id)).Err()
+}
+
+func (rws *RedisWorkerStore) GetDrainingIds() ([]WorkerId, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	members, err := rws.client.SMembers(ctx, WORKER_DRAINING_KEY).Result()
+	if err != nil {
+		return nil, err
+	}
+	workerIds := make([]WorkerId, len(members))
+	for i, member := range members {
+		workerIds[i] = WorkerId(member)
+	}
+	return workerIds, nil
 }
 
 func (rws *RedisWorkerStore) Close() error {
@@ -262,27 +341,89 @@ func (rws *RedisWorkerStore) Close() error {
 }
 
 func (rws *InMemWorkerStore) AddWorker(worker Worker) error {
-
-	return nil
+	return rws.Heartbeat(worker)
 }
 
 func (rws *InMemWorkerStore) DelWorker(id WorkerId) error {
-
+	rws.workers.Delete(id)
+	rws.draining.Delete(id)
 	return nil
 }
 
+// EvictWorker needs no fencing, the store belongs to a single replica.
+func (rws *InMemWorkerStore) EvictWorker(id WorkerId, fencingToken int64) error {
+	return rws.DelWorker(id)
+}
+
 func (rws *InMemWorkerStore) GetWorker(id WorkerId) (Worker, error) {
-	return nil, nil
+	value, ok := rws.workers.Load(id)
+	if !ok {
+		return nil, ErrWorkerNotFound
+	}
+
+	entry := value.(*inMemWorkerEntry)
+	if time.Now().After(entry.expiresAt) {
+		rws.workers.CompareAndDelete(id, entry)
+		return nil, ErrWorkerNotFound
+	}
+	return entry.worker, nil
 }
 
 func (rws *InMemWorkerStore) GetWorkerIds() ([]WorkerId, error) {
-	return nil, nil
+	now := time.Now()
+	workerIds := make([]WorkerId, 0)
+	rws.workers.Range(func(key, value any) bool {
+		entry := value.(*inMemWorkerEntry)
+		if now.After(entry.expiresAt) {
+			rws.workers.CompareAndDelete(key, entry)
+			return true
+		}
+		workerIds = append(workerIds, key.(WorkerId))
+		return true
+	})
+
+	// keep a stable order like the redis sorted set does, the load balancer relies on it
+	sort.Slice(workerIds, func(i, j int) bool { return workerIds[i] < workerIds[j] })
+	return workerIds, nil
 }
 
 func (rws *InMemWorkerStore) Heartbeat(worker Worker) error {
+	now := time.Now()
+	entry := &inMemWorkerEntry{
+		worker:    worker,
+		expiresAt: now.Add(rws.ttl),
+	}
+
+	// keep the registered instance so its status survives heartbeats
+	if value, ok := rws.workers.Load(worker.GetId()); ok {
+		existing := value.(*inMemWorkerEntry)
+		if existing.worker.GetAddr() == worker.GetAddr() {
+			entry.worker = existing.worker
+		}
+	}
+	entry.worker.Heartbeat(now)
+	rws.workers.Store(worker.GetId(), entry)
+	return nil
+}
+
+func (rws *InMemWorkerStore) SetDraining(id WorkerId, draining bool) error {
+	if draining {
+		rws.draining.Store(id, true)
+	} else {
+		rws.draining.Delete(id)
+	}
 	return nil
 }
 
+func (rws *InMemWorkerStore) GetDrainingIds() ([]WorkerId, error) {
+	workerIds := make([]WorkerId, 0)
+	rws.draining.Range(func(key, value any) bool {
+		workerIds = append(workerIds, key.(WorkerId))
+		return true
+	})
+	return workerIds, nil
+}
+
 func (rws *InMemWorkerStore) Close() error {
 	return nil
 }
@@ -3,55 +3,50 @@ package scheduler_test
 import (
 	"go-web/pkg/scheduler"
 	"testing"
+	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/stretchr/testify/assert"
 )
 
-var (
-	cfg = &scheduler.RedisConfig{
+func newRedisWorkerStore(t *testing.T) scheduler.WorkerStore {
+	mr := miniredis.RunT(t)
+	store, err := scheduler.NewRedisWorkerStore(&scheduler.RedisConfig{
 		ClusterMode: scheduler.RedisClusterModeStandalone,
-		Addrs:       []string{":6379"},
-	}
-)
-
-func TestAddWorker_ShouldAddedSuccess_WhenConnectRedisSuccess(t *testing.T) {
-	store, err := scheduler.NewRedisWorkerStore(cfg)
+		Addrs:       []string{mr.Addr()},
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
+	return store
+}
 
-	err = store.AddWorker(scheduler.NewWorker("1", "127.0.0.1:8080"))
+func TestAddWorker_ShouldAddedSuccess_WhenConnectRedisSuccess(t *testing.T) {
+	store := newRedisWorkerStore(t)
+
+	err := store.AddWorker(scheduler.NewWorker("1", "127.0.0.1:8080"))
 
 	assert.True(t, err == nil)
 }
 
 func TestAddWorker_ShouldUpdateExpiration_WhenTheWorkerExistsed(t *testing.T) {
-	store, err := scheduler.NewRedisWorkerStore(cfg)
-	if err != nil {
-		t.Fatal(err)
-	}
+	store := newRedisWorkerStore(t)
 	store.AddWorker(scheduler.NewWorker("1", "127.0.0.1:8080"))
 
-	err = store.AddWorker(scheduler.NewWorker("1", "127.0.0.1:8080"))
+	err := store.AddWorker(scheduler.NewWorker("1", "127.0.0.1:8080"))
 	assert.True(t, err == nil)
 }
 
 func TestDelWorker_ShouldSuccess_WhenTheWorkerExistsed(t *testing.T) {
-	store, err := scheduler.NewRedisWorkerStore(cfg)
-	if err != nil {
-		t.Fatal(err)
-	}
+	store := newRedisWorkerStore(t)
 	store.AddWorker(scheduler.NewWorker("1", "127.0.0.1:8080"))
 
-	err = store.DelWorker("1")
+	err := store.DelWorker("1")
 	assert.True(t, err == nil)
 }
 
 func TestGetWokrerIds_ShouldReturnWorkerIdList_WhenWorkerExisting(t *testing.T) {
-	store, err := scheduler.NewRedisWorkerStore(cfg)
-	if err != nil {
-		t.Fatal(err)
-	}
+	store := newRedisWorkerStore(t)
 	store.AddWorker(scheduler.NewWorker("1", "127.0.0.1:8080"))
 	ids, err := store.GetWorkerIds()
 	assert.True(t, err == nil)
@@ -59,11 +54,75 @@ func TestGetWokrerIds_ShouldReturnWorkerIdList_WhenWorkerExisting(t *testing.T)
 }
 
 func TestHeartbeat_ShouldSuccess_WhenWorkerExisting(t *testing.T) {
-	store, err := scheduler.NewRedisWorkerStore(cfg)
-	if err != nil {
-		t.Fatal(err)
-	}
+	store := newRedisWorkerStore(t)
 	store.AddWorker(scheduler.NewWorker("1", "127.0.0.1:8080"))
-	err = store.Heartbeat(scheduler.NewWorker("1", "127.0.0.1"))
+	err := store.Heartbeat(scheduler.NewWorker("1", "127.0.0.1"))
 	assert.True(t, err == nil)
 }
+
+func TestInMemWorkerStore_ShouldReturnWorker_WhenWorkerAdded(t *testing.T) {
+	store := scheduler.NewInMemWorkerStore(time.Minute)
+	err := store.AddWorker(scheduler.NewWorker("2", "127.0.0.1:8081"))
+	assert.NoError(t, err)
+	err = store.AddWorker(scheduler.NewWorker("1", "127.0.0.1:8080"))
+	assert.NoError(t, err)
+
+	ids, err := store.GetWorkerIds()
+	assert.NoError(t, err)
+	assert.Equal(t, []scheduler.WorkerId{"1", "2"}, ids)
+
+	worker, err := store.GetWorker("1")
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:8080", worker.GetAddr())
+	assert.WithinDuration(t, time.Now(), worker.GetLastHeartbeat(), time.Second)
+}
+
+func TestInMemWorkerStore_ShouldExpireWorker_WhenHeartbeatMissed(t *testing.T) {
+	store := scheduler.NewInMemWorkerStore(50 * time.Millisecond)
+	store.AddWorker(scheduler.NewWorker("1", "127.0.0.1:8080"))
+	store.AddWorker(scheduler.NewWorker("2", "127.0.0.1:8081"))
+
+	time.Sleep(30 * time.Millisecond)
+	store.Heartbeat(scheduler.NewWorker("2", "127.0.0.1:8081"))
+	time.Sleep(30 * time.Millisecond)
+
+	ids, err := store.GetWorkerIds()
+	assert.NoError(t, err)
+	assert.Equal(t, []scheduler.WorkerId{"2"}, ids)
+	_, err = store.GetWorker("1")
+	assert.ErrorIs(t, err, scheduler.ErrWorkerNotFound)
+}
+
+func TestInMemWorkerStore_ShouldKeepWorkerInstance_WhenHeartbeatReceived(t *testing.T) {
+	store := scheduler.NewInMemWorkerStore(time.Minute)
+	worker := scheduler.NewWorker("1", "127.0.0.1:8080")
+	store.AddWorker(worker)
+
+	store.Heartbeat(scheduler.NewWorker("1", "127.0.0.1:8080"))
+
+	got, err := store.GetWorker("1")
+	assert.NoError(t, err)
+	assert.Same(t, worker, got)
+}
+
+func TestInMemWorkerStore_ShouldRemoveWorker_WhenWorkerDeleted(t *testing.T) {
+	store := scheduler.NewInMemWorkerStore(time.Minute)
+	store.AddWorker(scheduler.NewWorker("1", "127.0.0.1:8080"))
+
+	assert.NoError(t, store.DelWorker("1"))
+	ids, err := store.GetWorkerIds()
+	assert.NoError(t, err)
+	assert.Empty(t, ids)
+}
+
+func TestEvictWorker_ShouldFail_WhenNewerLeaderEvicted(t *testing.T) {
+	store := newRedisWorkerStore(t)
+	store.AddWorker(scheduler.NewWorker("1", "127.0.0.1:8080"))
+	store.AddWorker(scheduler.NewWorker("2", "127.0.0.1:8081"))
+
+	assert.NoError(t, store.EvictWorker("1", 2))
+	assert.ErrorIs(t, store.EvictWorker("2", 1), scheduler.ErrStaleFencingToken)
+	ids, err := store.GetWorkerIds()
+	assert.NoError(t, err)
+	assert.Equal(t, []scheduler.WorkerId{"2"}, ids)
+}
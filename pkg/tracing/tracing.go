@@ -0,0 +1,188 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go-web/pkg/config"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+	ExporterOtlp   = "otlp"
+
+	TraceParentHeader = "traceparent"
+
+	tracerName = "go-web"
+)
+
+var propagator = propagation.TraceContext{}
+
+func init() {
+	// traceparent is passed on even while no exporter is configured
+	otel.SetTextMapPropagator(propagator)
+}
+
+// Init installs the tracer provider of the configured exporter. The returned
+// function flushes pending spans and must be called on shutdown.
+func Init(cfg *config.Tracing) (func(context.Context) error, error) {
+	exporter, closeFile, err := newExporter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	serviceName := cfg.ServiceName
+	if len(serviceName) == 0 {
+		serviceName = tracerName
+	}
+	ratio := cfg.SampleRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closeFile != nil {
+			closeFile()
+		}
+		return err
+	}, nil
+}
+
+func newExporter(cfg *config.Tracing) (sdktrace.SpanExporter, func(), error) {
+	switch cfg.Exporter {
+	case ExporterNone, "":
+		return nil, nil, nil
+	case ExporterStdout:
+		exporter, err := stdouttrace.New()
+		return exporter, nil, err
+	case ExporterFile:
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("open trace file error: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return exporter, func() { f.Close() }, nil
+	case ExporterOtlp:
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err := otlptracehttp.New(context.Background(), opts...)
+		return exporter, nil, err
+	default:
+		return nil, nil, fmt.Errorf("unsupported tracing exporter: %s", cfg.Exporter)
+	}
+}
+
+// Tracer returns the go-web tracer of the installed provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// GinMiddleware continues the trace of an incoming traceparent header, or
+// starts a new one, in a server span named after the route.
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		if len(route) == 0 {
+			route = "unmatched"
+		}
+		ctx, span := Tracer().Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.HTTPRoute(route),
+			))
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, "")
+		}
+	}
+}
+
+// TraceParent returns the traceparent of the span in ctx, empty if there is
+// none.
+func TraceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier.Get(TraceParentHeader)
+}
+
+// ContextWithTraceParent returns a context whose remote parent span is
+// traceParent, so spans of a task join the trace of the request that created
+// it.
+func ContextWithTraceParent(traceParent string) context.Context {
+	if len(traceParent) == 0 {
+		return context.Background()
+	}
+	return propagator.Extract(context.Background(), propagation.MapCarrier{TraceParentHeader: traceParent})
+}
+
+// InjectHeaders adds the trace context of ctx to outgoing request headers.
+func InjectHeaders(ctx context.Context, headers map[string]string) {
+	carrier := propagation.MapCarrier(headers)
+	propagator.Inject(ctx, carrier)
+}
+
+// TraceId returns the trace id of a traceparent, empty if it is invalid.
+func TraceId(traceParent string) string {
+	spanCtx := trace.SpanContextFromContext(ContextWithTraceParent(traceParent))
+	if !spanCtx.HasTraceID() {
+		return ""
+	}
+	return spanCtx.TraceID().String()
+}
+
+// EndSpan records err on the span and ends it.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TaskAttributes are set on every span about a task.
+func TaskAttributes(taskId, taskType, subType string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("task.id", taskId),
+		attribute.String("task.type", taskType),
+		attribute.String("task.sub_type", subType),
+	}
+}
@@ -0,0 +1,34 @@
+package tracing_test
+
+import (
+	"context"
+	"go-web/pkg/tracing"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGinMiddleware_ShouldContinueTrace_WhenTraceParentSent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(tracing.GinMiddleware())
+	var got string
+	r.GET("/ping", func(c *gin.Context) {
+		got = tracing.TraceParent(c.Request.Context())
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	req.Header.Set(tracing.TraceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", tracing.TraceId(got))
+}
+
+func TestTraceParent_ShouldBeEmpty_WhenContextHasNoSpan(t *testing.T) {
+	assert.Empty(t, tracing.TraceParent(context.Background()))
+	assert.Empty(t, tracing.TraceId(""))
+	assert.Empty(t, tracing.TraceId("not-a-traceparent"))
+}
@@ -0,0 +1,64 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"go-web/pkg/scheduler"
+)
+
+// Task is a task go-web dispatched to this worker.
+type Task struct {
+	// Id is the go-web task id, progress and results are reported for it
+	Id      string
+	Type    scheduler.TaskType
+	SubType scheduler.SubTaskType
+	// Detail holds the parameters of the task as sent by go-web
+	Detail json.RawMessage
+	// RequestId is the X-Request-ID of the request that created the task
+	RequestId string
+	// ArtifactPrefix is where outputs are uploaded in the object store of
+	// go-web
+	ArtifactPrefix string
+}
+
+// Bind decodes the parameters of the task into v.
+func (t *Task) Bind(v interface{}) error {
+	return json.Unmarshal(t.Detail, v)
+}
+
+// Artifact describes an output uploaded under the ArtifactPrefix of the task.
+// Handlers return them in a scheduler.ArtifactResult, go-web hands out
+// download urls for them.
+func (t *Task) Artifact(name, contentType string, size int64) scheduler.Artifact {
+	return scheduler.Artifact{
+		Key:         t.ArtifactPrefix + name,
+		Name:        name,
+		ContentType: contentType,
+		Size:        size,
+	}
+}
+
+// Reporter lets a handler publish progress while it runs, go-web sees it when
+// it polls the task.
+type Reporter interface {
+	Progress(percent int, message string)
+}
+
+// Handler runs tasks of one sub type. The returned value is the result go-web
+// reads once the task is done, a returned error fails the task. ctx is
+// cancelled when go-web cancels the task or the worker shuts down.
+type Handler interface {
+	Handle(ctx context.Context, task *Task, reporter Reporter) (interface{}, error)
+}
+
+type HandlerFunc func(ctx context.Context, task *Task, reporter Reporter) (interface{}, error)
+
+func (f HandlerFunc) Handle(ctx context.Context, task *Task, reporter Reporter) (interface{}, error) {
+	return f(ctx, task, reporter)
+}
+
+// Progress is the data of a task that is still running.
+type Progress struct {
+	Percent int    `json:"percent"`
+	Message string `json:"message,omitempty"`
+}
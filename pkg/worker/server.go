@@ -0,0 +1,262 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-web/pkg/logger"
+	"go-web/pkg/scheduler"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// dispatchRequest is what go-web sends on POST /task.
+type dispatchRequest struct {
+	TaskId         string          `json:"task_id"`
+	TaskType       string          `json:"task_type"`
+	TaskSubType    string          `json:"task_sub_type"`
+	TaskDetail     json.RawMessage `json:"task_detail"`
+	ArtifactPrefix string          `json:"artifact_prefix"`
+}
+
+type executorStatus struct {
+	IsHealthy      bool `json:"is_healthy"`
+	ActiveTasks    int  `json:"active_tasks"`
+	CompletedTasks int  `json:"completed_tasks"`
+	FailedTasks    int  `json:"failed_tasks"`
+	CancelledTasks int  `json:"cancelled_tasks"`
+}
+
+// taskEntry is a task this worker accepted, it is kept until ResultTTL after
+// it ended so go-web can read the result.
+type taskEntry struct {
+	id   string
+	task *Task
+
+	mu         sync.Mutex
+	state      scheduler.TaskState
+	progress   Progress
+	result     interface{}
+	errMsg     string
+	cancel     context.CancelFunc
+	cancelled  bool
+	finishedAt time.Time
+}
+
+func (e *taskEntry) Progress(percent int, message string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.progress = Progress{Percent: percent, Message: message}
+}
+
+func (e *taskEntry) snapshot() scheduler.WorkerTaskResult {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	result := scheduler.WorkerTaskResult{
+		TaskId:     e.id,
+		TaskStatus: e.state,
+	}
+	switch e.state {
+	case scheduler.TaskStateDone:
+		result.Data = e.result
+	case scheduler.TaskStateFailure:
+		result.Data = e.errMsg
+	case scheduler.TaskStateCreated, scheduler.TaskStateRunning:
+		result.Data = e.progress
+	}
+	return result
+}
+
+func (w *Worker) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /task", w.submitTask)
+	mux.HandleFunc("GET /task/{id}", w.getTask)
+	mux.HandleFunc("DELETE /task/{id}", w.cancelTask)
+	mux.HandleFunc("GET /executor/status", w.executorStatus)
+	return mux
+}
+
+func (w *Worker) submitTask(rw http.ResponseWriter, r *http.Request) {
+	if w.draining.Load() {
+		http.Error(rw, "worker is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req dispatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.TaskId) == 0 {
+		http.Error(rw, "invalid task", http.StatusBadRequest)
+		return
+	}
+	handler, ok := w.handlers[scheduler.SubTaskType(req.TaskSubType)]
+	if !ok {
+		http.Error(rw, "unsupported sub type: "+req.TaskSubType, http.StatusBadRequest)
+		return
+	}
+
+	entry := &taskEntry{
+		id: strings.ReplaceAll(uuid.New().String(), "-", ""),
+		task: &Task{
+			Id:             req.TaskId,
+			Type:           scheduler.TaskType(req.TaskType),
+			SubType:        scheduler.SubTaskType(req.TaskSubType),
+			Detail:         req.TaskDetail,
+			RequestId:      r.Header.Get(logger.RequestIdHeader),
+			ArtifactPrefix: req.ArtifactPrefix,
+		},
+		state: scheduler.TaskStateCreated,
+	}
+	// the task outlives the request, it only keeps its trace and request id
+	ctx := propagation.TraceContext{}.Extract(context.Background(), propagation.HeaderCarrier(r.Header))
+	if len(entry.task.RequestId) > 0 {
+		ctx = logger.WithRequestId(ctx, entry.task.RequestId)
+	}
+	ctx, entry.cancel = context.WithCancel(ctx)
+
+	w.mu.Lock()
+	w.tasks[entry.id] = entry
+	w.mu.Unlock()
+
+	w.running.Add(1)
+	go w.run(ctx, entry, handler)
+
+	writeJSON(rw, scheduler.TaskSubmitDto{TaskId: entry.id})
+}
+
+func (w *Worker) getTask(rw http.ResponseWriter, r *http.Request) {
+	entry, ok := w.getEntry(r.PathValue("id"))
+	if !ok {
+		http.Error(rw, "task not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(rw, entry.snapshot())
+}
+
+// cancelTask stops a task go-web gave up on. go-web already changed the state
+// of the task, so the cancellation is not reported back.
+func (w *Worker) cancelTask(rw http.ResponseWriter, r *http.Request) {
+	entry, ok := w.getEntry(r.PathValue("id"))
+	if !ok {
+		http.Error(rw, "task not found", http.StatusNotFound)
+		return
+	}
+	entry.mu.Lock()
+	entry.cancelled = true
+	entry.mu.Unlock()
+	entry.cancel()
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (w *Worker) executorStatus(rw http.ResponseWriter, r *http.Request) {
+	status := executorStatus{
+		IsHealthy:      !w.draining.Load(),
+		ActiveTasks:    int(w.active.Load()),
+		CompletedTasks: int(w.completed.Load()),
+		FailedTasks:    int(w.failed.Load()),
+		CancelledTasks: int(w.cancelled.Load()),
+	}
+	writeJSON(rw, map[string]interface{}{"data": status})
+}
+
+func (w *Worker) getEntry(id string) (*taskEntry, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	entry, ok := w.tasks[id]
+	return entry, ok
+}
+
+// run waits for a free slot, runs the handler and reports the outcome.
+func (w *Worker) run(ctx context.Context, entry *taskEntry, handler Handler) {
+	defer w.running.Done()
+	defer entry.cancel()
+
+	log := slog.With("task_id", entry.task.Id, "worker_task_id", entry.id, "sub_type", entry.task.SubType)
+
+	select {
+	case w.slots <- struct{}{}:
+		defer func() { <-w.slots }()
+	case <-ctx.Done():
+		w.finish(ctx, log, entry, nil, ctx.Err())
+		return
+	}
+
+	entry.mu.Lock()
+	entry.state = scheduler.TaskStateRunning
+	entry.mu.Unlock()
+	w.active.Add(1)
+	defer w.active.Add(-1)
+	w.report(ctx, entry.task.Id, scheduler.TaskStateRunning, "")
+
+	result, err := w.handle(ctx, entry, handler)
+	w.finish(ctx, log, entry, result, err)
+}
+
+func (w *Worker) handle(ctx context.Context, entry *taskEntry, handler Handler) (result interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("handler panicked: %v", p)
+			slog.ErrorContext(ctx, "task handler panicked", "task_id", entry.task.Id, "panic", p)
+		}
+	}()
+	return handler.Handle(ctx, entry.task, entry)
+}
+
+func (w *Worker) finish(ctx context.Context, log *slog.Logger, entry *taskEntry, result interface{}, err error) {
+	entry.mu.Lock()
+	cancelled := entry.cancelled
+	entry.finishedAt = time.Now()
+	switch {
+	case cancelled:
+		entry.state = scheduler.TaskStateCancelled
+	case err != nil:
+		entry.state = scheduler.TaskStateFailure
+		entry.errMsg = err.Error()
+	default:
+		entry.state = scheduler.TaskStateDone
+		entry.result = result
+	}
+	state := entry.state
+	entry.mu.Unlock()
+
+	switch state {
+	case scheduler.TaskStateCancelled:
+		w.cancelled.Add(1)
+		log.InfoContext(ctx, "task cancelled by go-web")
+		return
+	case scheduler.TaskStateFailure:
+		w.failed.Add(1)
+		log.WarnContext(ctx, "task failed", "error", err)
+		w.report(context.WithoutCancel(ctx), entry.task.Id, state, err.Error())
+	default:
+		w.completed.Add(1)
+		log.InfoContext(ctx, "task done")
+		w.report(context.WithoutCancel(ctx), entry.task.Id, state, "")
+	}
+}
+
+// evictFinished drops tasks that ended more than ResultTTL ago.
+func (w *Worker) evictFinished() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for id, entry := range w.tasks {
+		entry.mu.Lock()
+		expired := !entry.finishedAt.IsZero() && time.Since(entry.finishedAt) > w.cfg.ResultTTL
+		entry.mu.Unlock()
+		if expired {
+			delete(w.tasks, id)
+		}
+	}
+}
+
+func writeJSON(rw http.ResponseWriter, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		slog.Error("write response error", "error", err)
+	}
+}
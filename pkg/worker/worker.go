@@ -0,0 +1,290 @@
+// Package worker implements the go-web worker protocol, a worker only provides
+// a Handler per sub task type:
+//
+//	w := worker.New(worker.Config{
+//		Addr:   ":9000",
+//		Server: "http://go-web:8080",
+//	})
+//	w.HandleFunc(scheduler.SubTaskTypePdf2Img, func(ctx context.Context, task *worker.Task, r worker.Reporter) (interface{}, error) {
+//		var params struct{ FileId string `json:"file_id"` }
+//		if err := task.Bind(&params); err != nil {
+//			return nil, err
+//		}
+//		r.Progress(50, "converting")
+//		return convert(ctx, params.FileId)
+//	})
+//	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+//	defer stop()
+//	log.Fatal(w.Run(ctx))
+//
+// The worker registers itself with go-web, sends heartbeats, reports RUNNING,
+// DONE and FAILURE for every task and deregisters on shutdown.
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-web/pkg/scheduler"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultHeartbeatInterval = 30 * time.Second
+	DefaultResultTTL         = time.Hour
+	DefaultShutdownTimeout   = 30 * time.Second
+
+	reportAttempts = 3
+)
+
+type Config struct {
+	// Id names the worker in go-web, defaults to the host name plus a random
+	// suffix
+	Id string
+	// Addr is the address the worker listens on, e.g. ":9000"
+	Addr string
+	// AdvertiseAddr is the host:port go-web reaches the worker at, defaults
+	// to Addr
+	AdvertiseAddr string
+	// Server is the base url of go-web, e.g. "http://go-web:8080"
+	Server string
+	// HeartbeatInterval must stay well below the heartbeat TTL of go-web
+	HeartbeatInterval time.Duration
+	// Concurrency is the number of tasks run at once, further tasks wait.
+	// Defaults to the number of CPUs
+	Concurrency int
+	// ResultTTL is how long results stay readable after a task ended
+	ResultTTL time.Duration
+	// ShutdownTimeout is how long running tasks may finish on shutdown before
+	// they are cancelled and reported as failed
+	ShutdownTimeout time.Duration
+	// Client calls go-web, defaults to a client with a 10s timeout
+	Client *http.Client
+	// TaskTypes declares the sub types of the handlers with display names and
+	// params, go-web offers them under task types it already knows
+	TaskTypes []scheduler.TaskTypeDef
+}
+
+// Worker runs tasks go-web dispatches to it.
+type Worker struct {
+	cfg      Config
+	handlers map[scheduler.SubTaskType]Handler
+	slots    chan struct{}
+
+	mu    sync.Mutex
+	tasks map[string]*taskEntry
+
+	running  sync.WaitGroup
+	draining atomic.Bool
+
+	active    atomic.Int32
+	completed atomic.Int32
+	failed    atomic.Int32
+	cancelled atomic.Int32
+}
+
+func New(cfg Config) *Worker {
+	if len(cfg.Id) == 0 {
+		hostname, _ := os.Hostname()
+		cfg.Id = hostname + "-" + strings.ReplaceAll(uuid.New().String(), "-", "")[:8]
+	}
+	if len(cfg.AdvertiseAddr) == 0 {
+		cfg.AdvertiseAddr = cfg.Addr
+	}
+	cfg.Server = strings.TrimSuffix(cfg.Server, "/")
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = runtime.NumCPU()
+	}
+	if cfg.ResultTTL <= 0 {
+		cfg.ResultTTL = DefaultResultTTL
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Worker{
+		cfg:      cfg,
+		handlers: map[scheduler.SubTaskType]Handler{},
+		slots:    make(chan struct{}, cfg.Concurrency),
+		tasks:    map[string]*taskEntry{},
+	}
+}
+
+// Handle registers the handler of a sub type, it must be called before Run.
+func (w *Worker) Handle(subType scheduler.SubTaskType, handler Handler) {
+	w.handlers[subType] = handler
+}
+
+func (w *Worker) HandleFunc(subType scheduler.SubTaskType, handler HandlerFunc) {
+	w.Handle(subType, handler)
+}
+
+func (w *Worker) GetId() string {
+	return w.cfg.Id
+}
+
+// Run serves the worker protocol until ctx is done, then deregisters, lets
+// running tasks finish within ShutdownTimeout and stops the server.
+func (w *Worker) Run(ctx context.Context) error {
+	if len(w.handlers) == 0 {
+		return errors.New("no task handler registered")
+	}
+	listener, err := net.Listen("tcp", w.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: w.routes()}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	if err := w.register(ctx); err != nil {
+		// the heartbeat registers again
+		slog.Error("register worker error", "worker_id", w.cfg.Id, "error", err)
+	}
+
+	ticker := time.NewTicker(w.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case err := <-serveErr:
+			return err
+		case <-ticker.C:
+			if err := w.register(ctx); err != nil {
+				slog.Error("worker heartbeat error", "worker_id", w.cfg.Id, "error", err)
+			}
+			w.evictFinished()
+		}
+	}
+
+	return w.shutdown(server)
+}
+
+func (w *Worker) shutdown(server *http.Server) error {
+	w.draining.Store(true)
+	ctx, cancel := context.WithTimeout(context.Background(), w.cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := w.deregister(ctx); err != nil {
+		slog.Error("deregister worker error", "worker_id", w.cfg.Id, "error", err)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		w.running.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		slog.Warn("shutdown timeout, cancelling running tasks", "worker_id", w.cfg.Id)
+		w.mu.Lock()
+		for _, entry := range w.tasks {
+			entry.cancel()
+		}
+		w.mu.Unlock()
+		<-drained
+	}
+
+	// results and failures are reported already, the server stops last so
+	// go-web can still poll until then
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	return server.Shutdown(shutdownCtx)
+}
+
+type registerCmd struct {
+	Id        string                  `json:"id"`
+	Addr      string                  `json:"addr"`
+	TaskTypes []scheduler.TaskTypeDef `json:"task_types,omitempty"`
+}
+
+type taskUpdateCmd struct {
+	WorkerId   string `json:"worker_id"`
+	TaskState  string `json:"task_state"`
+	UpdateTime string `json:"update_time"`
+	Error      string `json:"error"`
+}
+
+// register also serves as heartbeat, go-web refreshes a known worker.
+func (w *Worker) register(ctx context.Context) error {
+	return w.call(ctx, http.MethodPost, "/schedule/worker", registerCmd{
+		Id:        w.cfg.Id,
+		Addr:      w.cfg.AdvertiseAddr,
+		TaskTypes: w.cfg.TaskTypes,
+	})
+}
+
+func (w *Worker) deregister(ctx context.Context) error {
+	return w.call(ctx, http.MethodDelete, "/schedule/worker/"+w.cfg.Id, nil)
+}
+
+// report tells go-web about a new state of a task, it retries a few times
+// since a lost DONE leaves the task running until it times out.
+func (w *Worker) report(ctx context.Context, taskId string, state scheduler.TaskState, message string) {
+	cmd := taskUpdateCmd{
+		WorkerId:   w.cfg.Id,
+		TaskState:  string(state),
+		UpdateTime: time.Now().Format(time.RFC3339),
+		Error:      message,
+	}
+	var err error
+	for attempt := 0; attempt < reportAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 500 * time.Millisecond)
+		}
+		if err = w.call(ctx, http.MethodPut, "/schedule/task/"+taskId, cmd); err == nil {
+			return
+		}
+	}
+	slog.ErrorContext(ctx, "report task state error", "task_id", taskId, "state", state, "error", err)
+}
+
+func (w *Worker) call(ctx context.Context, method string, path string, body interface{}) error {
+	var reader *bytes.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, w.cfg.Server+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: status %d", method, path, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package worker_test
+
+import (
+	"context"
+	"errors"
+	"go-web/pkg/scheduler"
+	"go-web/pkg/scheduler/schedulertest"
+	"go-web/pkg/worker"
+	"go-web/schedule"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// startWorker runs a worker against a go-web that serves the schedule api
+// with the in-memory stores.
+func startWorker(t *testing.T, handler worker.HandlerFunc) (*scheduler.Scheduler, *worker.Worker) {
+	s := schedulertest.Shared()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	schedule.InitRouter(r.Group("/"))
+	goweb := httptest.NewServer(r)
+	t.Cleanup(goweb.Close)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	w := worker.New(worker.Config{
+		Addr:              addr,
+		Server:            goweb.URL,
+		HeartbeatInterval: 100 * time.Millisecond,
+		ShutdownTimeout:   time.Second,
+	})
+	w.HandleFunc(scheduler.SubTaskTypePdf2Img, handler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+	})
+
+	assert.Eventually(t, func() bool {
+		for _, registered := range s.GetWorkers() {
+			if string(registered.GetId()) == w.GetId() {
+				return true
+			}
+		}
+		return false
+	}, 2*time.Second, 20*time.Millisecond)
+	t.Cleanup(func() { s.DeRegisterWorker(scheduler.WorkerId(w.GetId())) })
+	return s, w
+}
+
+func newTask() scheduler.Task {
+	return scheduler.NewTaskBuilder().
+		SetType(scheduler.TaskTypePdf).
+		SetSubType(scheduler.SubTaskTypePdf2Img).
+		SetUserDef(map[string]interface{}{"file_id": "f1"}).
+		Build()
+}
+
+func TestWorker_ShouldReportResult_WhenHandlerSucceeds(t *testing.T) {
+	s, _ := startWorker(t, func(ctx context.Context, task *worker.Task, r worker.Reporter) (interface{}, error) {
+		var params struct {
+			FileId string `json:"file_id"`
+		}
+		if err := task.Bind(&params); err != nil {
+			return nil, err
+		}
+		r.Progress(50, "converting")
+		return map[string]string{"image": params.FileId + ".png"}, nil
+	})
+
+	task := newTask()
+	_, err := s.Schedule(task)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		got, err := s.GetTask(task.GetId())
+		return err == nil && got.GetState() == scheduler.TaskStateDone
+	}, 2*time.Second, 20*time.Millisecond)
+
+	result, err := s.GetTaskStatus(task.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, scheduler.TaskState(scheduler.TaskStateDone), result.Status)
+	assert.Equal(t, map[string]interface{}{"image": "f1.png"}, result.Data)
+}
+
+func TestWorker_ShouldReportArtifacts_WhenHandlerUploadedOutputs(t *testing.T) {
+	s, _ := startWorker(t, func(ctx context.Context, task *worker.Task, r worker.Reporter) (interface{}, error) {
+		return scheduler.ArtifactResult{Artifacts: []scheduler.Artifact{
+			task.Artifact("page-1.png", "image/png", 42),
+		}}, nil
+	})
+
+	task := newTask()
+	_, err := s.Schedule(task)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		got, err := s.GetTask(task.GetId())
+		return err == nil && got.GetState() == scheduler.TaskStateDone
+	}, 2*time.Second, 20*time.Millisecond)
+
+	result, err := s.GetTaskStatus(task.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, []scheduler.Artifact{{
+		Key:         scheduler.ArtifactPrefix(task.GetId()) + "page-1.png",
+		Name:        "page-1.png",
+		ContentType: "image/png",
+		Size:        42,
+	}}, scheduler.TaskArtifacts(result))
+}
+
+func TestWorker_ShouldReportFailure_WhenHandlerFails(t *testing.T) {
+	s, _ := startWorker(t, func(ctx context.Context, task *worker.Task, r worker.Reporter) (interface{}, error) {
+		return nil, errors.New("corrupt pdf")
+	})
+
+	task := newTask()
+	_, err := s.Schedule(task)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		got, err := s.GetTask(task.GetId())
+		return err == nil && len(got.GetErrors()) > 0
+	}, 2*time.Second, 20*time.Millisecond)
+
+	got, err := s.GetTask(task.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, "corrupt pdf", got.GetErrors()[0].Message)
+}
+
+func TestWorker_ShouldStopHandler_WhenTaskCancelled(t *testing.T) {
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	s, w := startWorker(t, func(ctx context.Context, task *worker.Task, r worker.Reporter) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		close(stopped)
+		return nil, ctx.Err()
+	})
+
+	task := newTask()
+	_, err := s.Schedule(task)
+	assert.NoError(t, err)
+	<-started
+
+	got, err := s.GetTask(task.GetId())
+	assert.NoError(t, err)
+	for _, registered := range s.GetWorkers() {
+		if string(registered.GetId()) == w.GetId() {
+			assert.NoError(t, registered.Cancel(got.GetWorkerTaskId()))
+		}
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler was not cancelled")
+	}
+}
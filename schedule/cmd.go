@@ -1,11 +1,21 @@
 package schedule
 
+import "go-web/pkg/scheduler"
+
 type RegisterWorkerCmd struct {
 	Id   string `json:"id"`
 	Addr string `json:"addr"`
+	// TaskTypes declares sub types the worker runs, only under task types
+	// go-web knows
+	TaskTypes []scheduler.TaskTypeDef `json:"task_types"`
 }
 
 type TaskUpdateCmd struct {
+	// WorkerId is the reporting worker, reports of a worker that no longer
+	// runs the task are rejected
+	WorkerId   string `json:"worker_id"`
 	TaskState  string `json:"task_state"`
 	UpdateTime string `json:"update_time"`
+	// Error describes why the task failed, it is kept in the error history
+	Error string `json:"error"`
 }
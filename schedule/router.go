@@ -34,7 +34,7 @@ func (sr *ScheduleRouter) RegisterWorker(c *gin.Context) {
 		return
 	}
 
-	err = sr.ss.RegisterWorker(scheduler.WorkerId(cmd.Id), cmd.Addr)
+	err = sr.ss.RegisterWorker(scheduler.WorkerId(cmd.Id), cmd.Addr, cmd.TaskTypes)
 	if err != nil {
 		if errors.Is(err, ErrInvalidWorkerAddress) {
 			global.RequestError(c, global.NewEntity("", err.Error(), nil))
@@ -80,6 +80,15 @@ func (sr *ScheduleRouter) UpdateTaskState(c *gin.Context) {
 		return
 	}
 
-	sr.ss.UpdateTaskState(id, cmd.TaskState)
+	err = sr.ss.UpdateTaskState(id, cmd.WorkerId, cmd.TaskState, cmd.Error)
+	if err != nil {
+		if errors.Is(err, scheduler.ErrInvalidStateTransition) || errors.Is(err, scheduler.ErrTaskNotFound) ||
+			errors.Is(err, scheduler.ErrStaleTaskReport) {
+			global.RequestError(c, global.NewEntity("", err.Error(), nil))
+			return
+		}
+		global.InternalServerError(c, global.NewEntity("", err.Error(), nil))
+		return
+	}
 	global.SuccessNoData(c)
 }
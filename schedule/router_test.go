@@ -3,6 +3,7 @@ package schedule_test
 import (
 	"errors"
 	"go-web/pkg/config"
+	"go-web/pkg/scheduler/schedulertest"
 	"go-web/schedule"
 	"net/http"
 	"net/http/httptest"
@@ -15,6 +16,7 @@ import (
 
 func setupRouter() *gin.Engine {
 	loadConfig()
+	schedulertest.Shared()
 	r := gin.Default()
 	public := r.Group("/")
 	schedule.InitRouter(public)
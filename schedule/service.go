@@ -5,14 +5,15 @@ import (
 	"go-web/pkg/scheduler"
 	"net/url"
 	"strconv"
+	"strings"
 )
 
 type ScheduleService interface {
-	RegisterWorker(workerId scheduler.WorkerId, addr string) error
+	RegisterWorker(workerId scheduler.WorkerId, addr string, taskTypes []scheduler.TaskTypeDef) error
 	GetWorkerList() []*WorkerListDto
 	DeRegisterWorker(id string) error
 	GetTaskStaus(taskid string) (*TaskResultDto, error)
-	UpdateTaskState(taskid string, state string) error
+	UpdateTaskState(taskid string, workerId string, state string, message string) error
 }
 
 type scheduleimpl struct {
@@ -26,32 +27,44 @@ func NewScheduleService() ScheduleService {
 	}
 }
 
-func (s *scheduleimpl) RegisterWorker(workerId scheduler.WorkerId, addr string) error {
-	if !s.isValidUrl(addr) {
+// RegisterWorker registers a worker or refreshes its heartbeat. addr is
+// host:port or http://host:port, the sub types the worker declares become
+// available for conversion.
+func (s *scheduleimpl) RegisterWorker(workerId scheduler.WorkerId, addr string, taskTypes []scheduler.TaskTypeDef) error {
+	host, ok := s.workerHost(addr)
+	if !ok {
 		return ErrInvalidWorkerAddress
 	}
-	_ = s.scheduler.RegisterWorker(scheduler.NewWorker(workerId, addr))
+	if err := scheduler.RegisterSubTypes(taskTypes); err != nil {
+		return err
+	}
+	_ = s.scheduler.RegisterWorker(scheduler.NewWorker(workerId, host))
 	return nil
 }
 
-func (s *scheduleimpl) isValidUrl(urlStr string) bool {
-	u, err := url.Parse(urlStr)
-	if err != nil {
-		return false
+// workerHost returns the host:port of a worker address, workers are always
+// called over http.
+func (s *scheduleimpl) workerHost(addr string) (string, bool) {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil || u.Scheme != "http" {
+		return "", false
 	}
 
 	host := u.Hostname()
 	port := u.Port()
 	if host == "" || port == "" {
-		return false
+		return "", false
 	}
 
 	p, err := strconv.Atoi(port)
-	if err != nil {
-		return false
+	if err != nil || p <= 0 || p > 65535 {
+		return "", false
 	}
 
-	return p >= 0 && p <= 65535
+	return u.Host, true
 }
 
 func (s *scheduleimpl) GetWorkerList() []*WorkerListDto {
@@ -81,6 +94,6 @@ func (s *scheduleimpl) GetTaskStaus(taskId string) (*TaskResultDto, error) {
 	return &TaskResultDto{taskResult.TaskId, taskResult.Data}, nil
 }
 
-func (s *scheduleimpl) UpdateTaskState(taskId string, state string) error {
-	return s.scheduler.UpdateTaskState(taskId, state)
+func (s *scheduleimpl) UpdateTaskState(taskId string, workerId string, state string, message string) error {
+	return s.scheduler.UpdateTaskState(taskId, scheduler.WorkerId(workerId), state, message)
 }